@@ -4,13 +4,18 @@ go 1.13
 
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/fxamacker/cbor/v2 v2.2.0
+	github.com/go-asn1-ber/asn1-ber v1.5.1
 	github.com/go-kit/kit v0.10.0
+	github.com/go-ldap/ldap/v3 v3.2.4
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/gorilla/mux v1.8.0
 	github.com/jinzhu/gorm v1.9.16
 	github.com/magiconair/properties v1.8.1
 	github.com/pkg/errors v0.8.1
 	github.com/spf13/viper v1.7.1
 	go.uber.org/zap v1.13.0
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
 	gotest.tools v2.2.0+incompatible
 )
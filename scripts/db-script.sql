@@ -3,10 +3,257 @@ use test_user_service;
 create table if not exists users
 (
     id     int primary key auto_increment,
-    name   varchar(255),
+    -- name, email and phone hold envelopes when encrypted, found by their blind indexes (*_bidx)
+    name   varchar(2048),
     status ENUM ('ACTIVE', 'INACTIVE') NOT NULL DEFAULT 'ACTIVE',
     gender ENUM ('FEMALE','MALE'),
-    unique (name)
+    role   ENUM ('USER', 'SUPPORT', 'ADMIN') NOT NULL DEFAULT 'USER',
+    email  varchar(2048),
+    email_verified_at datetime,
+    phone  varchar(255),
+    name_bidx   char(64),
+    email_bidx  char(64),
+    phone_bidx  char(64),
+    -- oldest key version sealing the encrypted columns, null while one is in plaintext
+    key_version int,
+    unique (name(255)),
+    unique (email(255)),
+    unique (name_bidx),
+    unique (email_bidx),
+    index (key_version)
+);
+
+create table if not exists api_keys
+(
+    id          int primary key auto_increment,
+    name        varchar(255) not null,
+    prefix      varchar(16)  not null,
+    key_hash    char(64)     not null,
+    permissions varchar(1024) not null default '',
+    daily_quota int          not null default 0,
+    expires_at  datetime,
+    revoked_at  datetime,
+    created_at  datetime     not null,
+    -- principal who created the key, e.g. USER:1
+    created_by  varchar(255) not null default '',
+    unique (prefix)
+);
+
+create table if not exists api_key_usages
+(
+    api_key_id int         not null,
+    day        varchar(10) not null,
+    count      int         not null default 0,
+    primary key (api_key_id, day),
+    foreign key (api_key_id) references api_keys (id)
+);
+
+create table if not exists credentials
+(
+    user_id       int primary key,
+    password_hash varchar(255) not null,
+    updated_at    datetime     not null,
+    foreign key (user_id) references users (id)
+);
+
+create table if not exists sessions
+(
+    id           varchar(32)  primary key,
+    user_id      int          not null,
+    device       varchar(64)  not null default '',
+    ip           varchar(45)  not null default '',
+    user_agent   varchar(512) not null default '',
+    created_at   datetime     not null,
+    last_used_at datetime     not null,
+    expires_at   datetime     not null,
+    revoked_at   datetime,
+    index (user_id),
+    foreign key (user_id) references users (id)
+);
+
+create table if not exists login_failures
+(
+    scope           ENUM ('ACCOUNT', 'IP') NOT NULL,
+    subject         varchar(64) not null,
+    failures        int         not null default 0,
+    last_failure_at datetime    not null,
+    locked_until    datetime,
+    primary key (scope, subject)
+);
+
+create table if not exists lockout_events
+(
+    id           bigint primary key auto_increment,
+    scope        ENUM ('ACCOUNT', 'IP') NOT NULL,
+    subject      varchar(64)  not null,
+    event        ENUM ('LOCKED', 'UNLOCKED') NOT NULL,
+    actor        varchar(255) not null default '',
+    locked_until datetime,
+    created_at   datetime     not null,
+    index (scope, subject)
+);
+
+create table if not exists password_reset_tokens
+(
+    token_hash char(64) primary key,
+    user_id    int      not null,
+    expires_at datetime not null,
+    used_at    datetime,
+    created_at datetime not null,
+    index (user_id),
+    foreign key (user_id) references users (id)
+);
+
+create table if not exists email_verification_tokens
+(
+    token_hash char(64)     primary key,
+    user_id    int          not null,
+    email      varchar(255) not null,
+    expires_at datetime     not null,
+    used_at    datetime,
+    created_at datetime     not null,
+    index (user_id),
+    foreign key (user_id) references users (id)
+);
+
+create table if not exists oidc_clients
+(
+    id            varchar(64) primary key,
+    name          varchar(255)  not null,
+    secret_hash   char(64)      not null default '',
+    redirect_uris varchar(2048) not null,
+    created_at    datetime      not null
+);
+
+create table if not exists oidc_signing_keys
+(
+    id          varchar(32) primary key,
+    algorithm   varchar(16) not null,
+    private_key text        not null,
+    active      boolean     not null default false,
+    created_at  datetime    not null,
+    retired_at  datetime
+);
+
+create table if not exists oidc_authorization_codes
+(
+    code_hash      char(64) primary key,
+    client_id      varchar(64)   not null,
+    user_id        int           not null,
+    redirect_uri   varchar(1024) not null,
+    scope          varchar(255)  not null,
+    nonce          varchar(255)  not null default '',
+    code_challenge varchar(128)  not null,
+    expires_at     datetime      not null,
+    used_at        datetime,
+    foreign key (client_id) references oidc_clients (id),
+    foreign key (user_id) references users (id)
+);
+
+create table if not exists mfa_factors
+(
+    user_id      int primary key,
+    secret       varchar(255) not null,
+    last_step    bigint       not null default 0,
+    confirmed_at datetime,
+    created_at   datetime     not null,
+    foreign key (user_id) references users (id)
+);
+
+create table if not exists mfa_recovery_codes
+(
+    id        int primary key auto_increment,
+    user_id   int      not null,
+    code_hash char(64) not null,
+    used_at   datetime,
+    index (user_id),
+    foreign key (user_id) references users (id)
+);
+
+create table if not exists passkeys
+(
+    id            int primary key auto_increment,
+    user_id       int           not null,
+    name          varchar(255)  not null,
+    credential_id varchar(1400) not null,
+    public_key    blob          not null,
+    sign_count    int unsigned  not null default 0,
+    transports    varchar(255)  not null default '',
+    created_at    datetime      not null,
+    last_used_at  datetime,
+    unique (credential_id(255)),
+    index (user_id),
+    foreign key (user_id) references users (id)
+);
+
+create table if not exists passkey_challenges
+(
+    challenge  varchar(64) primary key,
+    ceremony   varchar(32) not null,
+    user_id    int,
+    expires_at datetime    not null
+);
+
+create table if not exists ldap_users
+(
+    user_id        int          primary key,
+    dn             varchar(512) not null,
+    synced_at      datetime     not null,
+    deactivated_at datetime,
+    unique (dn),
+    foreign key (user_id) references users (id)
+);
+
+create table if not exists impersonations
+(
+    id         int primary key auto_increment,
+    admin_id   int          not null,
+    user_id    int          not null,
+    session_id varchar(64)  not null,
+    reason     varchar(512) not null,
+    created_at datetime     not null,
+    expires_at datetime     not null,
+    ended_at   datetime,
+    index (admin_id),
+    foreign key (user_id) references users (id)
+);
+
+create table if not exists audit_records
+(
+    id         int primary key auto_increment,
+    actor      varchar(255)  not null,
+    principal  varchar(255)  not null,
+    action     varchar(64)   not null,
+    detail     varchar(1024) not null,
+    ip         varchar(64)   not null default '',
+    created_at datetime      not null,
+    index (actor),
+    index (principal)
+);
+
+create table if not exists erasures
+(
+    id           int primary key auto_increment,
+    user_id      int          not null,
+    requested_by varchar(255) not null default '',
+    requested_at datetime     not null,
+    scheduled_at datetime     not null,
+    canceled_at  datetime,
+    erased_at    datetime,
+    index (scheduled_at),
+    foreign key (user_id) references users (id)
+);
+
+create table if not exists idempotency_keys
+(
+    idempotency_key char(64)   primary key,
+    fingerprint     char(64)   not null,
+    status          int        not null default 0,
+    header          text       not null,
+    body            mediumblob,
+    created_at      datetime   not null,
+    expires_at      datetime   not null,
+    index (expires_at)
 );
 
 truncate table users;
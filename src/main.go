@@ -1,20 +1,35 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"github.com/go-kit/kit/metrics/expvar"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"net/http"
 	"os"
 	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
 	"user-service/src/service/impl"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
 	http2 "user-service/src/service/transport/http"
+	"user-service/src/service/util/encryption"
+	"user-service/src/service/util/idempotency"
+	"user-service/src/service/util/ldap"
 	"user-service/src/service/util/log"
+	"user-service/src/service/util/mail"
+	"user-service/src/service/util/password"
+	"user-service/src/service/util/pii"
+	"user-service/src/service/util/token"
+	"user-service/src/service/util/webauthn"
 )
 
 func main() {
@@ -27,26 +42,213 @@ func main() {
 	router := createRouter()
 	logger := createLogger()
 
-	db, err := createDb()
+	db, err := createDb(logger)
 	if err != nil {
 		exitCode = -1
 		logger.Error("create db fail")
 		return
 	}
 
-	src, err := impl.NewServiceImpl(db, logger)
+	if db, err = encryptUsers(db); err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create user encryption fail: %v", err))
+		return
+	}
+
+	// `user-service reencrypt` seals the users with the current key of the keyring while the service keeps running
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		if err := reencryptUsers(db, logger, viper.GetInt("pii.reencrypt_batch"), viper.GetDuration("pii.reencrypt_pause")); err != nil {
+			exitCode = -1
+			logger.Error(fmt.Sprintf("reencrypt users fail: %v", err))
+		}
+		return
+	}
+
+	mailer, err := mail.NewMailerFromConfig(logger)
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create mailer fail: %v", err))
+		return
+	}
+
+	verificationSrc, err := impl.NewEmailVerificationServiceImpl(db, logger, mailer, impl.EmailVerificationConfig{
+		TTL:            viper.GetDuration("email_verification.ttl"),
+		Link:           viper.GetString("email_verification.link"),
+		ResendInterval: viper.GetDuration("email_verification.resend_interval"),
+		ResendLimit:    viper.GetInt("email_verification.resend_limit"),
+		ResendWindow:   viper.GetDuration("email_verification.resend_window"),
+	})
+	if err != nil {
+		exitCode = -1
+		logger.Error("create email verification service fail")
+		return
+	}
+
+	src, err := impl.NewServiceImpl(db, logger, verificationSrc)
 	if err != nil {
 		exitCode = -1
 		logger.Error("create service fail")
 		return
 	}
 
-	http2.RegisterService(src, router)
+	apiKeySrc, err := impl.NewAPIKeyServiceImpl(db, logger)
+	if err != nil {
+		exitCode = -1
+		logger.Error("create api key service fail")
+		return
+	}
+
+	mfaSrc, err := createMFAService(db, logger)
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create mfa service fail: %v", err))
+		return
+	}
+
+	tokens, err := token.IssuerFromConfig()
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create token issuer fail: %v", err))
+		return
+	}
+
+	sessionSrc, err := impl.NewSessionServiceImpl(db, logger, tokens.TTL(token.TypeRefresh))
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create session service fail: %v", err))
+		return
+	}
+
+	lockoutSrc, err := createLockoutService(db, logger)
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create lockout service fail: %v", err))
+		return
+	}
+
+	var ldapSrc service.LDAPService
+	if viper.GetBool("ldap.enabled") {
+		if ldapSrc, err = createLDAPService(db, logger, sessionSrc); err != nil {
+			exitCode = -1
+			logger.Error(fmt.Sprintf("create ldap service fail: %v", err))
+			return
+		}
+	}
+	var ldapLogin service.LDAPService
+	if ldapSrc != nil && viper.GetBool("ldap.login") {
+		ldapLogin = ldapSrc
+	}
+
+	authSrc, err := createAuthService(db, logger, tokens, mfaSrc, lockoutSrc, sessionSrc, ldapLogin, mailer)
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create auth service fail: %v", err))
+		return
+	}
+
+	passkeySrc, err := impl.NewPasskeyServiceImpl(db, logger, tokens, sessionSrc, impl.PasskeyConfig{
+		WebAuthn: webauthn.Config{
+			RPID:    viper.GetString("webauthn.rp_id"),
+			RPName:  viper.GetString("webauthn.rp_name"),
+			Origins: viper.GetStringSlice("webauthn.origins"),
+		},
+		ChallengeTTL: viper.GetDuration("webauthn.challenge_ttl"),
+	})
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create passkey service fail: %v", err))
+		return
+	}
+
+	auditSrc, err := impl.NewAuditServiceImpl(db, logger)
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create audit service fail: %v", err))
+		return
+	}
+
+	impersonationSrc, err := impl.NewImpersonationServiceImpl(db, logger, tokens, sessionSrc, auditSrc, impl.ImpersonationConfig{
+		TTL: viper.GetDuration("impersonation.ttl"),
+	})
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create impersonation service fail: %v", err))
+		return
+	}
+
+	privacySrc, err := impl.NewPrivacyServiceImpl(db, logger, auditSrc, impl.PrivacyConfig{
+		GracePeriod: viper.GetDuration("erasure.grace_period"),
+	})
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create privacy service fail: %v", err))
+		return
+	}
+
+	idempotencyStore, err := idempotency.NewStoreFromConfig(db)
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create idempotency store fail: %v", err))
+		return
+	}
+	go runIdempotencyPurge(idempotencyStore, logger, viper.GetDuration("idempotency.purge_interval"))
+
+	authn := transport.Authenticator{APIKeys: apiKeySrc, Tokens: authSrc, Audit: auditSrc}
+	// retries of user creations sent with an Idempotency-Key get the response to the first request
+	idempotent := http2.Idempotency(idempotencyStore, http2.IdempotencyConfig{
+		TTL:          viper.GetDuration("idempotency.ttl"),
+		AnonymousTTL: viper.GetDuration("idempotency.anonymous_ttl"),
+		LockTimeout:  viper.GetDuration("idempotency.lock_timeout"),
+	}, authn, logger)
+	http2.RegisterService(src, authn, auth.FieldPolicyFromConfig(), idempotent, router)
+	http2.RegisterEmailVerificationService(verificationSrc, authn, router)
+	http2.RegisterAPIKeyService(apiKeySrc, authn, router)
+	http2.RegisterAuthService(authSrc, authn, router)
+	http2.RegisterMFAService(mfaSrc, authn, router)
+	http2.RegisterLockoutService(lockoutSrc, authn, router)
+	http2.RegisterSessionService(sessionSrc, authn, router)
+	http2.RegisterImpersonationService(impersonationSrc, authn, router)
+	http2.RegisterPrivacyService(privacySrc, authn, router)
+	go runErasures(privacySrc, logger, viper.GetDuration("erasure.interval"))
+	http2.RegisterMetrics(authn, router)
+	if ldapSrc != nil {
+		http2.RegisterLDAPService(ldapSrc, authn, router)
+		go runLDAPSync(ldapSrc, logger, viper.GetDuration("ldap.sync_interval"))
+	}
+	http2.RegisterPasskeyService(passkeySrc, authn, router)
+
+	oidcSrc, err := impl.NewOIDCServiceImpl(db, logger, impl.OIDCConfig{
+		Issuer:         viper.GetString("oidc.issuer"),
+		CodeTTL:        viper.GetDuration("oidc.code_ttl"),
+		AccessTokenTTL: viper.GetDuration("oidc.access_token_ttl"),
+		IDTokenTTL:     viper.GetDuration("oidc.id_token_ttl"),
+		KeyRetention:   viper.GetDuration("oidc.key_retention"),
+	})
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create oidc service fail: %v", err))
+		return
+	}
+	http2.RegisterOIDCService(oidcSrc, authn, router)
+
+	scimSrc, err := impl.NewSCIMServiceImpl(src, logger, impl.SCIMConfig{
+		BaseURL:    viper.GetString("scim.base_url"),
+		MaxResults: viper.GetInt("paging_max_size"),
+		Policy:     auth.FieldPolicyFromConfig(),
+	})
+	if err != nil {
+		exitCode = -1
+		logger.Error(fmt.Sprintf("create scim service fail: %v", err))
+		return
+	}
+	http2.RegisterSCIMService(scimSrc, authn, router)
 
 	{
 		logger.Info("service started")
 		httpAddr := ":" + viper.GetString("http_server.port")
-		srv := http2.NewServer(router, logger, httpAddr)
+		// panics are answered with a 500, logged with the request id and counted in /debug/vars
+		recovery := transport.Recovery{Log: logger, Panics: expvar.NewCounter("panics")}
+		srv := http2.NewServer(http2.RequestID(http2.Recover(recovery)(router)), logger, httpAddr)
 		logger.Info(fmt.Sprintf("service stopped status %s", srv.Start().Error()))
 	}
 
@@ -77,7 +279,156 @@ func createLogger() *log.Logger {
 	return log.NewLogger(logger)
 }
 
-func createDb() (*gorm.DB, error) {
+// placeholderMFAKey was shipped as mfa.encryption_key by earlier configs, it protects no secret.
+const placeholderMFAKey = "Y2hhbmdlLW1lLXRvLTMyLXJhbmRvbS1ieXRlcyEhISE="
+
+func createMFAService(db *gorm.DB, logger *log.Logger) (service.MFAService, error) {
+	key := viper.GetString("mfa.encryption_key")
+	if len(key) == 0 || key == placeholderMFAKey {
+		return nil, errors.New("mfa.encryption_key must be set to base64 of 32 random bytes")
+	}
+	box, err := encryption.NewAESGCMFromBase64(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var requiredRoles []model.Role
+	for _, role := range viper.GetStringSlice("mfa.required_roles") {
+		requiredRoles = append(requiredRoles, model.Role(role))
+	}
+
+	return impl.NewMFAServiceImpl(db, logger, box, impl.MFAConfig{
+		Issuer:        viper.GetString("mfa.issuer"),
+		RequiredRoles: requiredRoles,
+		Skew:          viper.GetInt64("mfa.skew"),
+		RecoveryCodes: viper.GetInt("mfa.recovery_codes"),
+	})
+}
+
+func createLockoutService(db *gorm.DB, logger *log.Logger) (service.LockoutService, error) {
+	return impl.NewLockoutServiceImpl(db, logger, impl.LockoutConfig{
+		Window:   viper.GetDuration("lockout.window"),
+		Delay:    viper.GetDuration("lockout.delay"),
+		MaxDelay: viper.GetDuration("lockout.max_delay"),
+		Account: impl.LockoutPolicy{
+			Threshold: viper.GetInt("lockout.account.threshold"),
+			Duration:  viper.GetDuration("lockout.account.duration"),
+		},
+		IP: impl.LockoutPolicy{
+			Threshold: viper.GetInt("lockout.ip.threshold"),
+			Duration:  viper.GetDuration("lockout.ip.duration"),
+		},
+	}, impl.LockoutMetrics{
+		Failures:     expvar.NewCounter("login_failures"),
+		Throttled:    expvar.NewCounter("login_throttled"),
+		AccountLocks: expvar.NewCounter("lockout_account_locks"),
+		IPLocks:      expvar.NewCounter("lockout_ip_locks"),
+		Unlocks:      expvar.NewCounter("lockout_unlocks"),
+	})
+}
+
+func createAuthService(db *gorm.DB, logger *log.Logger, tokens *token.Issuer, mfa service.MFAService, lockout service.LockoutService,
+	sessions service.SessionService, ldapLogin service.LDAPService, mailer mail.Mailer) (service.AuthService, error) {
+	hasher, err := password.NewHasher(viper.GetString("password.hasher"))
+	if err != nil {
+		return nil, err
+	}
+
+	return impl.NewAuthServiceImpl(db, logger, hasher, password.PolicyFromConfig(), tokens, mfa, lockout, sessions, ldapLogin, mailer, impl.PasswordResetConfig{
+		TTL:  viper.GetDuration("password_reset.ttl"),
+		Link: viper.GetString("password_reset.link"),
+	})
+}
+
+func createLDAPService(db *gorm.DB, logger *log.Logger, sessions service.SessionService) (service.LDAPService, error) {
+	directory := ldap.NewClient(ldap.Config{
+		URL:          viper.GetString("ldap.url"),
+		BindDN:       viper.GetString("ldap.bind_dn"),
+		BindPassword: viper.GetString("ldap.bind_password"),
+		StartTLS:     viper.GetBool("ldap.start_tls"),
+		Timeout:      viper.GetDuration("ldap.timeout"),
+	})
+
+	return impl.NewLDAPServiceImpl(db, logger, directory, sessions, impl.LDAPConfig{
+		BaseDN:     viper.GetString("ldap.base_dn"),
+		UserFilter: viper.GetString("ldap.user_filter"),
+		Attributes: viper.GetStringMapString("ldap.attributes"),
+	})
+}
+
+// runLDAPSync syncs the users at every interval, a zero interval leaves the sync to POST /ldap/sync.
+func runLDAPSync(s service.LDAPService, logger *log.Logger, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		if _, err := s.Sync(context.Background(), service.LDAPSyncRequest{}); err != nil {
+			logger.Error(fmt.Sprintf("scheduled ldap sync fail: %v", err))
+		}
+	}
+}
+
+func runErasures(s service.PrivacyService, logger *log.Logger, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		res, err := s.RunErasures(context.Background(), service.RunErasuresRequest{})
+		if err != nil {
+			logger.Error(fmt.Sprintf("scheduled erasures fail: %v", err))
+		} else if len(res.Failed) > 0 {
+			logger.Error(fmt.Sprintf("scheduled erasures fail for users %v", res.Failed))
+		}
+	}
+}
+
+// runIdempotencyPurge deletes the expired responses of idempotent requests at every interval.
+func runIdempotencyPurge(store idempotency.Store, logger *log.Logger, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		if err := store.Purge(context.Background()); err != nil {
+			logger.Error(fmt.Sprintf("scheduled idempotency purge fail: %v", err))
+		}
+	}
+}
+
+// encryptUsers registers the encryption of the configured columns of users, they stay in plaintext without keyring.
+func encryptUsers(db *gorm.DB) (*gorm.DB, error) {
+	path := viper.GetString("pii.keyring_file")
+	if len(path) == 0 {
+		return db, nil
+	}
+	keyring, err := encryption.LoadKeyring(path)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := pii.NewCodec(keyring, viper.GetStringSlice("pii.columns"))
+	if err != nil {
+		return nil, err
+	}
+	return pii.Register(db, codec), nil
+}
+
+func reencryptUsers(db *gorm.DB, logger *log.Logger, batch int, pause time.Duration) error {
+	total := 0
+	for {
+		sealed, err := pii.Reencrypt(db, batch)
+		if err != nil {
+			return err
+		}
+		if sealed == 0 {
+			logger.Info(fmt.Sprintf("reencrypted %d users", total))
+			return nil
+		}
+		total += sealed
+		logger.Info(fmt.Sprintf("reencrypted %d users so far", total))
+		time.Sleep(pause)
+	}
+}
+
+func createDb(logger *log.Logger) (*gorm.DB, error) {
 	db, err := sql.Open("mysql", viper.GetString("mysql.uri"))
 	if err != nil {
 		return nil, err
@@ -87,6 +438,8 @@ func createDb() (*gorm.DB, error) {
 	if err != nil {
 		return nil, err
 	}
+	// the SQL logged in debug mode masks the values of PII columns
+	gormDB.SetLogger(log.NewSQLLogger(logger, log.PIIColumns(model.User{}, model.EmailVerificationToken{})))
 
 	return gormDB, nil
 }
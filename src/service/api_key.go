@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"time"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+)
+
+type CreateAPIKeyRequest struct {
+	Name        string            `json:"name"`
+	Permissions model.Permissions `json:"permissions"`
+	DailyQuota  int               `json:"daily_quota"`
+	ExpiresAt   *time.Time        `json:"expires_at"`
+}
+
+type ListAPIKeysRequest struct {
+	IncludeRevoked bool
+}
+
+type RotateAPIKeyRequest struct {
+	KeyID model.APIKeyID
+}
+
+type RevokeAPIKeyRequest struct {
+	KeyID model.APIKeyID
+}
+
+type GetAPIKeyUsageRequest struct {
+	KeyID model.APIKeyID
+	Days  int
+}
+
+type AuthenticateAPIKeyRequest struct {
+	Key string
+}
+
+type APIKeyResponse struct {
+	APIKey model.APIKey `json:"api_key"`
+	// Key is the plain secret, it is only returned when a key is created or rotated.
+	Key string `json:"key,omitempty"`
+}
+
+type APIKeysResponse struct {
+	APIKeys []model.APIKey `json:"api_keys"`
+}
+
+type APIKeyUsageResponse struct {
+	KeyID      model.APIKeyID      `json:"api_key_id"`
+	DailyQuota int                 `json:"daily_quota"`
+	Remaining  int                 `json:"remaining"`
+	Usages     []model.APIKeyUsage `json:"usages"`
+}
+
+type APIKeyService interface {
+	CreateAPIKey(ctx context.Context, request CreateAPIKeyRequest) (*APIKeyResponse, error)
+	ListAPIKeys(ctx context.Context, request ListAPIKeysRequest) (*APIKeysResponse, error)
+	RotateAPIKey(ctx context.Context, request RotateAPIKeyRequest) (*APIKeyResponse, error)
+	RevokeAPIKey(ctx context.Context, request RevokeAPIKeyRequest) (*APIKeyResponse, error)
+	GetAPIKeyUsage(ctx context.Context, request GetAPIKeyUsageRequest) (*APIKeyUsageResponse, error)
+	Authenticate(ctx context.Context, request AuthenticateAPIKeyRequest) (*auth.Principal, error)
+}
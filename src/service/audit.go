@@ -0,0 +1,13 @@
+package service
+
+import "context"
+
+// RecordAuditRequest is recorded with the real and effective principals of the context.
+type RecordAuditRequest struct {
+	Action string
+	Detail string
+}
+
+type AuditService interface {
+	Record(ctx context.Context, request RecordAuditRequest) (*EmptyResponse, error)
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+)
+
+type LoginRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type SetPasswordRequest struct {
+	UserID          model.UserID `json:"-"`
+	Password        string       `json:"password"`
+	CurrentPassword string       `json:"current_password"`
+}
+
+func (r SetPasswordRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type AuthenticateTokenRequest struct {
+	Token string
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type,omitempty"`
+	ExpiresIn    int    `json:"expires_in"`
+	// MFAToken is returned instead of the other tokens when a second factor is needed, see LoginMFA.
+	MFAToken    string `json:"mfa_token,omitempty"`
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	// MFAEnrollmentRequired means the tokens can only be used to enroll a second factor.
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type EmptyResponse struct{}
+
+type AuthService interface {
+	Login(ctx context.Context, request LoginRequest) (*TokenResponse, error)
+	LoginMFA(ctx context.Context, request LoginMFARequest) (*TokenResponse, error)
+	RefreshToken(ctx context.Context, request RefreshTokenRequest) (*TokenResponse, error)
+	SetPassword(ctx context.Context, request SetPasswordRequest) (*EmptyResponse, error)
+	// ForgotPassword answers the same whether the account exists or not.
+	ForgotPassword(ctx context.Context, request ForgotPasswordRequest) (*EmptyResponse, error)
+	ResetPassword(ctx context.Context, request ResetPasswordRequest) (*EmptyResponse, error)
+	Authenticate(ctx context.Context, request AuthenticateTokenRequest) (*auth.Principal, error)
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"user-service/src/service/model"
+)
+
+type PrincipalType string
+
+const (
+	PrincipalAPIKey PrincipalType = "API_KEY"
+	PrincipalUser   PrincipalType = "USER"
+)
+
+const (
+	PermissionUsersRead      = "users:read"
+	PermissionUsersWrite     = "users:write"
+	PermissionAPIKeysManage  = "api_keys:manage"
+	PermissionOIDCManage     = "oidc:manage"
+	PermissionRolesManage    = "roles:manage"
+	PermissionLockoutManage  = "lockout:manage"
+	PermissionSessionsManage = "sessions:manage"
+	PermissionLDAPManage     = "ldap:manage"
+	PermissionImpersonate    = "users:impersonate"
+	PermissionPrivacyManage  = "privacy:manage"
+	// PermissionCredentialsManage lets the principal set the password and second factors of other users
+	PermissionCredentialsManage = "credentials:manage"
+	// PermissionMetricsRead lets the principal read the counters served by /debug/vars
+	PermissionMetricsRead = "metrics:read"
+)
+
+type Principal struct {
+	Type        PrincipalType
+	ID          string
+	Role        model.Role
+	Permissions []string
+	// MFAEnrollmentOnly is set for users whose role requires MFA but who haven't enrolled yet,
+	// they can only access their own MFA enrollment.
+	MFAEnrollmentOnly bool
+	// SessionID is set for users authenticated with an access token.
+	SessionID string
+	// Impersonator is the admin acting as this user, the real principal of the request. It is nil unless
+	// the request carries an impersonation token.
+	Impersonator *Principal
+}
+
+func (p Principal) HasPermission(permission string) bool {
+	for _, perm := range p.Permissions {
+		if perm == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// String identifies the principal in logs and audit records, e.g. USER:7 or USER:7 (impersonated by USER:1).
+func (p Principal) String() string {
+	s := string(p.Type) + ":" + p.ID
+	if p.Impersonator != nil {
+		s += " (impersonated by " + p.Impersonator.String() + ")"
+	}
+	return s
+}
+
+func (p Principal) IsUser(id model.UserID) bool {
+	return p.Type == PrincipalUser && p.ID == strconv.Itoa(int(id))
+}
+
+type principalKey struct{}
+
+type principalHolderKey struct{}
+
+type apiKeyKey struct{}
+
+type bearerTokenKey struct{}
+
+type clientIPKey struct{}
+
+type userAgentKey struct{}
+
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	if holder, ok := ctx.Value(principalHolderKey{}).(**Principal); ok {
+		*holder = p
+	}
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// WithPrincipalHolder makes the principal later put in a derived context visible from ctx too, e.g. the http
+// encoders read the principal resolved by the endpoint they encode the response of.
+func WithPrincipalHolder(ctx context.Context) context.Context {
+	return context.WithValue(ctx, principalHolderKey{}, new(*Principal))
+}
+
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	if !ok {
+		if holder, held := ctx.Value(principalHolderKey{}).(**Principal); held {
+			p = *holder
+		}
+	}
+	return p, p != nil
+}
+
+// WithAPIKey stores the raw key sent by the client, it is resolved to a Principal by the transport layer.
+func WithAPIKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, key)
+}
+
+func APIKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(apiKeyKey{}).(string)
+	return key
+}
+
+func WithBearerToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, bearerTokenKey{}, token)
+}
+
+func BearerTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(bearerTokenKey{}).(string)
+	return token
+}
+
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+func WithUserAgent(ctx context.Context, userAgent string) context.Context {
+	return context.WithValue(ctx, userAgentKey{}, userAgent)
+}
+
+func UserAgentFromContext(ctx context.Context) string {
+	userAgent, _ := ctx.Value(userAgentKey{}).(string)
+	return userAgent
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"github.com/spf13/viper"
+	"strings"
+	"user-service/src/service/model"
+)
+
+// AudienceSelf in a field rule stands for the user the field belongs to.
+const AudienceSelf = "self"
+
+// FieldPolicy decides which fields of users each principal can read and write, fields are named as in JSON.
+// A rule lists the roles allowed, AudienceSelf and API_KEY for API keys; fields without a rule are open to
+// every principal reaching the endpoint, anonymous principals only get those.
+type FieldPolicy struct {
+	Read  map[string][]string
+	Write map[string][]string
+}
+
+func FieldPolicyFromConfig() FieldPolicy {
+	return FieldPolicy{
+		Read:  viper.GetStringMapStringSlice("visibility.read"),
+		Write: viper.GetStringMapStringSlice("visibility.write"),
+	}
+}
+
+func (p FieldPolicy) CanRead(principal *Principal, owner model.UserID, field string) bool {
+	return allowed(p.Read, principal, owner, field)
+}
+
+// CanWrite is asked with owner 0 for users being created, AudienceSelf doesn't apply to them.
+func (p FieldPolicy) CanWrite(principal *Principal, owner model.UserID, field string) bool {
+	return allowed(p.Write, principal, owner, field)
+}
+
+func allowed(rules map[string][]string, principal *Principal, owner model.UserID, field string) bool {
+	audiences, ok := rules[field]
+	if !ok {
+		return true
+	}
+	if principal == nil {
+		return false
+	}
+	for _, audience := range audiences {
+		switch {
+		case strings.EqualFold(audience, AudienceSelf):
+			if owner != 0 && principal.IsUser(owner) {
+				return true
+			}
+		case strings.EqualFold(audience, string(PrincipalAPIKey)):
+			if principal.Type == PrincipalAPIKey {
+				return true
+			}
+		case principal.Type == PrincipalUser && strings.EqualFold(audience, string(principal.Role)):
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"context"
+	"user-service/src/service/model"
+)
+
+type SendEmailVerificationRequest struct {
+	UserID model.UserID
+}
+
+func (r SendEmailVerificationRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type EmailVerificationService interface {
+	// SendEmailVerification mails a verification link to the current email of the user, it is rate limited.
+	SendEmailVerification(ctx context.Context, request SendEmailVerificationRequest) (*EmptyResponse, error)
+	VerifyEmail(ctx context.Context, request VerifyEmailRequest) (*EmptyResponse, error)
+}
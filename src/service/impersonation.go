@@ -0,0 +1,29 @@
+package service
+
+import (
+	"context"
+	"user-service/src/service/model"
+)
+
+type ImpersonateRequest struct {
+	UserID model.UserID `json:"-"`
+	// Reason is required, it is kept in the audit records
+	Reason string `json:"reason"`
+}
+
+// ImpersonationResponse holds an access token of the user, there is no refresh token.
+type ImpersonationResponse struct {
+	Impersonation model.Impersonation `json:"impersonation"`
+	AccessToken   string              `json:"access_token"`
+	ExpiresIn     int                 `json:"expires_in"`
+}
+
+type EndImpersonationRequest struct {
+	ImpersonationID model.ImpersonationID
+}
+
+type ImpersonationService interface {
+	// Impersonate lets the admin of the context act as a user, other admins can't be impersonated.
+	Impersonate(ctx context.Context, request ImpersonateRequest) (*ImpersonationResponse, error)
+	EndImpersonation(ctx context.Context, request EndImpersonationRequest) (*EmptyResponse, error)
+}
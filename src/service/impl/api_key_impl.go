@@ -0,0 +1,322 @@
+package impl
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strconv"
+	"strings"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	log2 "user-service/src/service/util/log"
+)
+
+const (
+	apiKeyPrefix     = "usk"
+	apiKeyDayFmt     = "2006-01-02"
+	maxUsageDays     = 31
+	defaultUsageDays = 7
+)
+
+var knownPermissions = []string{
+	auth.PermissionUsersRead,
+	auth.PermissionUsersWrite,
+	auth.PermissionAPIKeysManage,
+	auth.PermissionOIDCManage,
+	auth.PermissionRolesManage,
+	auth.PermissionLockoutManage,
+	auth.PermissionSessionsManage,
+	auth.PermissionLDAPManage,
+	auth.PermissionImpersonate,
+	auth.PermissionPrivacyManage,
+	auth.PermissionCredentialsManage,
+	auth.PermissionMetricsRead,
+}
+
+type apiKeyServiceImpl struct {
+	db  *gorm.DB
+	log *log2.Logger
+	now func() time.Time
+}
+
+func NewAPIKeyServiceImpl(db *gorm.DB, log *log2.Logger) (service.APIKeyService, error) {
+	src := apiKeyServiceImpl{
+		db:  db,
+		log: log,
+		now: time.Now,
+	}
+
+	return src, nil
+}
+
+// CreateAPIKey only grants permissions the caller holds, a key can't be used to escalate its creator's privileges.
+func (s apiKeyServiceImpl) CreateAPIKey(ctx context.Context, request service.CreateAPIKeyRequest) (*service.APIKeyResponse, error) {
+	if len(request.Name) == 0 {
+		return nil, transport.Error{Msg: "name of api key is required", Code: transport.ErrorCodeInvalidParameter}
+	}
+	if request.DailyQuota < 0 {
+		return nil, transport.Error{Msg: "daily_quota must not be negative", Code: transport.ErrorCodeInvalidParameter}
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(s.now()) {
+		return nil, transport.Error{Msg: "expires_at must be in the future", Code: transport.ErrorCodeInvalidParameter}
+	}
+	for _, perm := range request.Permissions {
+		if !isKnownPermission(perm) {
+			return nil, transport.Error{Msg: fmt.Sprintf("unknown permission %s", perm), Code: transport.ErrorCodeInvalidParameter}
+		}
+	}
+	var createdBy string
+	if principal, ok := auth.FromContext(ctx); ok {
+		for _, perm := range request.Permissions {
+			if !principal.HasPermission(perm) {
+				return nil, transport.Error{Msg: fmt.Sprintf("%s can't grant permission %s it doesn't hold", principal, perm), Code: transport.ErrorCodePermissionDenied}
+			}
+		}
+		createdBy = principal.String()
+	}
+
+	prefix, secret, hash, err := generateAPIKey()
+	if err != nil {
+		msg := fmt.Sprintf("can not generate api key: %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	key := model.APIKey{
+		Name:        request.Name,
+		Prefix:      prefix,
+		KeyHash:     hash,
+		Permissions: request.Permissions,
+		DailyQuota:  request.DailyQuota,
+		ExpiresAt:   request.ExpiresAt,
+		CreatedAt:   s.now(),
+		CreatedBy:   createdBy,
+	}
+	if err := s.db.Omit("id").Create(&key).Error; err != nil {
+		msg := fmt.Sprintf("can not create api key %s: %v", request.Name, err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	return &service.APIKeyResponse{APIKey: key, Key: secret}, nil
+}
+
+func (s apiKeyServiceImpl) ListAPIKeys(_ context.Context, request service.ListAPIKeysRequest) (*service.APIKeysResponse, error) {
+	keys := []model.APIKey{}
+	db := s.db.Order("id asc")
+	if !request.IncludeRevoked {
+		db = db.Where("revoked_at IS NULL")
+	}
+	if err := db.Find(&keys).Error; err != nil {
+		msg := fmt.Sprintf("error when getting api keys from db %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	return &service.APIKeysResponse{APIKeys: keys}, nil
+}
+
+func (s apiKeyServiceImpl) RotateAPIKey(ctx context.Context, request service.RotateAPIKeyRequest) (*service.APIKeyResponse, error) {
+	key, err := s.getAPIKey(ctx, request.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.IsRevoked() {
+		return nil, transport.Error{Msg: fmt.Sprintf("api key %d is revoked", request.KeyID), Code: transport.ErrorCodeInvalidParameter}
+	}
+
+	prefix, secret, hash, err := generateAPIKey()
+	if err != nil {
+		msg := fmt.Sprintf("can not generate api key: %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	ret := s.db.Model(key).Updates(map[string]interface{}{"prefix": prefix, "key_hash": hash})
+	if err := ret.Error; err != nil {
+		msg := fmt.Sprintf("can't rotate api key: %d", request.KeyID)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	key.Prefix = prefix
+	key.KeyHash = hash
+	return &service.APIKeyResponse{APIKey: *key, Key: secret}, nil
+}
+
+func (s apiKeyServiceImpl) RevokeAPIKey(ctx context.Context, request service.RevokeAPIKeyRequest) (*service.APIKeyResponse, error) {
+	key, err := s.getAPIKey(ctx, request.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.IsRevoked() {
+		return &service.APIKeyResponse{APIKey: *key}, nil
+	}
+
+	now := s.now()
+	if err := s.db.Model(key).Update("revoked_at", now).Error; err != nil {
+		msg := fmt.Sprintf("can't revoke api key: %d", request.KeyID)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	key.RevokedAt = &now
+	return &service.APIKeyResponse{APIKey: *key}, nil
+}
+
+func (s apiKeyServiceImpl) GetAPIKeyUsage(ctx context.Context, request service.GetAPIKeyUsageRequest) (*service.APIKeyUsageResponse, error) {
+	key, err := s.getAPIKey(ctx, request.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	days := request.Days
+	if days <= 0 {
+		days = defaultUsageDays
+	}
+	if days > maxUsageDays {
+		days = maxUsageDays
+	}
+
+	now := s.now()
+	from := now.AddDate(0, 0, 1-days).Format(apiKeyDayFmt)
+	usages := []model.APIKeyUsage{}
+	if err := s.db.Where("api_key_id = ? AND day >= ?", key.ID, from).Order("day desc").Find(&usages).Error; err != nil {
+		msg := fmt.Sprintf("error when getting usage of api key %d: %v", key.ID, err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	remaining := -1
+	if key.DailyQuota > 0 {
+		remaining = key.DailyQuota
+		today := now.Format(apiKeyDayFmt)
+		for _, usage := range usages {
+			if usage.Day == today {
+				remaining -= usage.Count
+			}
+		}
+		if remaining < 0 {
+			remaining = 0
+		}
+	}
+
+	return &service.APIKeyUsageResponse{
+		KeyID:      key.ID,
+		DailyQuota: key.DailyQuota,
+		Remaining:  remaining,
+		Usages:     usages,
+	}, nil
+}
+
+func (s apiKeyServiceImpl) Authenticate(_ context.Context, request service.AuthenticateAPIKeyRequest) (*auth.Principal, error) {
+	prefix, ok := parseAPIKey(request.Key)
+	if !ok {
+		return nil, transport.Error{Msg: "invalid api key", Code: transport.ErrorCodeUnauthorized}
+	}
+
+	var key model.APIKey
+	if err := s.db.Where("prefix = ?", prefix).Find(&key).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, transport.Error{Msg: "invalid api key", Code: transport.ErrorCodeUnauthorized}
+		}
+		msg := fmt.Sprintf("error when get api key %s: %v", prefix, err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(request.Key)), []byte(key.KeyHash)) != 1 {
+		return nil, transport.Error{Msg: "invalid api key", Code: transport.ErrorCodeUnauthorized}
+	}
+	now := s.now()
+	if key.IsRevoked() || key.IsExpired(now) {
+		return nil, transport.Error{Msg: "api key is revoked or expired", Code: transport.ErrorCodeUnauthorized}
+	}
+
+	count, err := s.incrUsage(key.ID, now.Format(apiKeyDayFmt))
+	if err != nil {
+		msg := fmt.Sprintf("can't record usage of api key %d: %v", key.ID, err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+	if key.DailyQuota > 0 && count > key.DailyQuota {
+		return nil, transport.Error{
+			Msg:  fmt.Sprintf("daily quota of %d requests exceeded", key.DailyQuota),
+			Code: transport.ErrorCodeTooManyRequests,
+		}
+	}
+
+	return &auth.Principal{
+		Type:        auth.PrincipalAPIKey,
+		ID:          strconv.Itoa(int(key.ID)),
+		Permissions: key.Permissions,
+	}, nil
+}
+
+func (s apiKeyServiceImpl) getAPIKey(_ context.Context, id model.APIKeyID) (*model.APIKey, error) {
+	var key model.APIKey
+	if err := s.db.Where("id = ?", id).Find(&key).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			msg := fmt.Sprintf("not found api key %d", id)
+			s.log.Error(msg)
+			return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound}
+		}
+		msg := fmt.Sprintf("error when get api key %d: %v", id, err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+	return &key, nil
+}
+
+func (s apiKeyServiceImpl) incrUsage(id model.APIKeyID, day string) (int, error) {
+	var count int
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("INSERT INTO `api_key_usages` (`api_key_id`, `day`, `count`) VALUES (?, ?, 1) "+
+			"ON DUPLICATE KEY UPDATE `count` = `count` + 1", id, day).Error
+		if err != nil {
+			return err
+		}
+		return tx.Table("api_key_usages").Where("api_key_id = ? AND day = ?", id, day).
+			Select("count").Row().Scan(&count)
+	})
+	return count, err
+}
+
+func isKnownPermission(permission string) bool {
+	for _, perm := range knownPermissions {
+		if perm == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// generateAPIKey returns a key in format usk_<prefix>.<secret>, the prefix is used to look up the key
+// and only the hash of the whole key is stored.
+func generateAPIKey() (prefix string, key string, hash string, err error) {
+	b := make([]byte, 36)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(b[:4])
+	key = fmt.Sprintf("%s_%s.%s", apiKeyPrefix, prefix, base64.RawURLEncoding.EncodeToString(b[4:]))
+	return prefix, key, hashToken(key), nil
+}
+
+func parseAPIKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix+"_") {
+		return "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix+"_"), ".", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", false
+	}
+	return parts[0], true
+}
@@ -0,0 +1,144 @@
+package impl
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/assert"
+	"regexp"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/transport"
+)
+
+func initAPIKeyMock(now time.Time) (apiKeyServiceImpl, sqlmock.Sqlmock) {
+	s := initUserMock()
+	return apiKeyServiceImpl{
+		db:  s.svc.db,
+		log: s.svc.log,
+		now: func() time.Time { return now },
+	}, s.mock
+}
+
+func TestAPIKeyServiceImpl_Authenticate(t *testing.T) {
+	now := time.Date(2020, 10, 1, 8, 0, 0, 0, time.UTC)
+	svc, mock := initAPIKeyMock(now)
+	_, key, hash, _ := generateAPIKey()
+	prefix, _ := parseAPIKey(key)
+	columns := []string{"id", "name", "prefix", "key_hash", "permissions", "daily_quota", "expires_at", "revoked_at", "created_at"}
+
+	expectKey := func(quota int, revokedAt *time.Time) {
+		rows := mock.NewRows(columns).
+			AddRow(1, "partner", prefix, hash, "users:read", quota, nil, revokedAt, now)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `api_keys`  WHERE (prefix = ?)")).
+			WithArgs(prefix).
+			WillReturnRows(rows)
+	}
+	expectUsage := func(count int) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `api_key_usages`")).
+			WithArgs(1, "2020-10-01").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT count FROM `api_key_usages`")).
+			WillReturnRows(mock.NewRows([]string{"count"}).AddRow(count))
+		mock.ExpectCommit()
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		mockSetup func()
+		want      *auth.Principal
+		errCode   transport.ResponseCode
+	}{
+		{
+			name:      "malformed key",
+			key:       "not-a-key",
+			mockSetup: func() {},
+			errCode:   transport.ErrorCodeUnauthorized,
+		},
+		{
+			name: "wrong secret",
+			key:  key + "x",
+			mockSetup: func() {
+				expectKey(0, nil)
+			},
+			errCode: transport.ErrorCodeUnauthorized,
+		},
+		{
+			name: "revoked",
+			key:  key,
+			mockSetup: func() {
+				expectKey(0, &now)
+			},
+			errCode: transport.ErrorCodeUnauthorized,
+		},
+		{
+			name: "quota exceeded",
+			key:  key,
+			mockSetup: func() {
+				expectKey(10, nil)
+				expectUsage(11)
+			},
+			errCode: transport.ErrorCodeTooManyRequests,
+		},
+		{
+			name: "success",
+			key:  key,
+			mockSetup: func() {
+				expectKey(10, nil)
+				expectUsage(10)
+			},
+			want: &auth.Principal{Type: auth.PrincipalAPIKey, ID: "1", Permissions: []string{auth.PermissionUsersRead}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockSetup()
+			principal, err := svc.Authenticate(context.Background(), service.AuthenticateAPIKeyRequest{Key: tt.key})
+			if err != nil {
+				assert.Equal(t, err.(transport.Error).Code, tt.errCode)
+			} else {
+				assert.DeepEqual(t, principal, tt.want)
+			}
+			assert.NilError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAPIKeyServiceImpl_CreateAPIKey(t *testing.T) {
+	now := time.Date(2020, 10, 1, 8, 0, 0, 0, time.UTC)
+	svc, mock := initAPIKeyMock(now)
+	caller := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "1",
+		Permissions: []string{auth.PermissionUsersRead, auth.PermissionAPIKeysManage}})
+
+	// the caller can't grant what it doesn't hold
+	_, err := svc.CreateAPIKey(caller, service.CreateAPIKeyRequest{Name: "partner",
+		Permissions: []string{auth.PermissionUsersRead, auth.PermissionCredentialsManage}})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodePermissionDenied)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `api_keys`")).
+		WithArgs("partner", sqlmock.AnyArg(), sqlmock.AnyArg(), "users:read", 0, nil, nil, now, "USER:1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	res, err := svc.CreateAPIKey(caller, service.CreateAPIKeyRequest{Name: "partner", Permissions: []string{auth.PermissionUsersRead}})
+	assert.NilError(t, err)
+	assert.Equal(t, res.APIKey.CreatedBy, "USER:1")
+	assert.NilError(t, mock.ExpectationsWereMet())
+}
+
+func TestParseAPIKey(t *testing.T) {
+	prefix, key, hash, err := generateAPIKey()
+	assert.NilError(t, err)
+	assert.Equal(t, hash, hashToken(key))
+
+	got, ok := parseAPIKey(key)
+	assert.Assert(t, ok)
+	assert.Equal(t, got, prefix)
+
+	_, ok = parseAPIKey("usk_abc")
+	assert.Assert(t, !ok)
+}
@@ -0,0 +1,59 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"go.uber.org/zap"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	log2 "user-service/src/service/util/log"
+)
+
+type auditServiceImpl struct {
+	db  *gorm.DB
+	log *log2.Logger
+	now func() time.Time
+}
+
+func NewAuditServiceImpl(db *gorm.DB, log *log2.Logger) (service.AuditService, error) {
+	src := auditServiceImpl{
+		db:  db,
+		log: log,
+		now: time.Now,
+	}
+
+	return src, nil
+}
+
+func (s auditServiceImpl) Record(ctx context.Context, request service.RecordAuditRequest) (*service.EmptyResponse, error) {
+	record := model.AuditRecord{
+		Action:    request.Action,
+		Detail:    request.Detail,
+		IP:        auth.ClientIPFromContext(ctx),
+		CreatedAt: s.now(),
+	}
+	if principal, ok := auth.FromContext(ctx); ok {
+		record.Principal = string(principal.Type) + ":" + principal.ID
+		record.Actor = record.Principal
+		if principal.Impersonator != nil {
+			record.Actor = principal.Impersonator.String()
+		}
+	}
+
+	if err := s.db.Create(&record).Error; err != nil {
+		msg := fmt.Sprintf("can not record audit %s of %s: %v", request.Action, record.Actor, err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	s.log.Info("audit "+request.Action,
+		zap.String("actor", record.Actor),
+		zap.String("principal", record.Principal),
+		zap.String("detail", record.Detail),
+		zap.String("ip", record.IP))
+	return &service.EmptyResponse{}, nil
+}
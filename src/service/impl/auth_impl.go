@@ -0,0 +1,481 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/viper"
+	"strconv"
+	"strings"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	log2 "user-service/src/service/util/log"
+	"user-service/src/service/util/mail"
+	"user-service/src/service/util/password"
+	"user-service/src/service/util/pii"
+	"user-service/src/service/util/token"
+)
+
+// keys of the errors of AuthService
+const (
+	keyAuthInvalidCredentials    = "auth.invalid_credentials"
+	keyAuthLoginFailed           = "auth.login_failed"
+	keyAuthTokenFailed           = "auth.token_failed"
+	keyAuthPasswordCheckFailed   = "auth.password_check_failed"
+	keyAuthInvalidMFAToken       = "auth.invalid_mfa_token"
+	keyAuthInvalidMFACode        = "auth.invalid_mfa_code"
+	keyAuthInvalidRefreshToken   = "auth.invalid_refresh_token"
+	keyAuthInvalidAccessToken    = "auth.invalid_access_token"
+	keyAuthWeakPassword          = "auth.weak_password"
+	keyAuthIncorrectPassword     = "auth.incorrect_password"
+	keyAuthPasswordSaveFailed    = "auth.password_save_failed"
+	keyAuthUnknownUser           = "auth.unknown_user"
+	keyAuthInactiveUser          = "auth.inactive_user"
+	keyAuthCredentialGetFailed   = "auth.credential_get_failed"
+	keyAuthCredentialsOutOfReach = "auth.credentials_out_of_reach"
+)
+
+type authServiceImpl struct {
+	db       *gorm.DB
+	log      *log2.Logger
+	hasher   password.Hasher
+	policy   password.Policy
+	tokens   *token.Issuer
+	mfa      service.MFAService
+	lockout  service.LockoutService
+	sessions service.SessionService
+	// ldap is nil unless LDAP login is enabled
+	ldap   service.LDAPService
+	mailer mail.Mailer
+	reset  PasswordResetConfig
+	now    func() time.Time
+	// dummyHash is verified when the user doesn't exist, so a login takes the same time in both cases.
+	dummyHash string
+}
+
+func NewAuthServiceImpl(db *gorm.DB, log *log2.Logger, hasher password.Hasher, policy password.Policy, tokens *token.Issuer, mfa service.MFAService,
+	lockout service.LockoutService, sessions service.SessionService, ldap service.LDAPService, mailer mail.Mailer, reset PasswordResetConfig) (service.AuthService, error) {
+	dummyHash, err := hasher.Hash("dummy password")
+	if err != nil {
+		return nil, err
+	}
+
+	src := authServiceImpl{
+		db:        db,
+		log:       log,
+		hasher:    hasher,
+		policy:    policy,
+		tokens:    tokens,
+		mfa:       mfa,
+		lockout:   lockout,
+		sessions:  sessions,
+		ldap:      ldap,
+		mailer:    mailer,
+		reset:     reset,
+		now:       time.Now,
+		dummyHash: dummyHash,
+	}
+
+	return src, nil
+}
+
+func (s authServiceImpl) Login(ctx context.Context, request service.LoginRequest) (*service.TokenResponse, error) {
+	invalidErr := transport.Error{Msg: "invalid name or password", Code: transport.ErrorCodeUnauthorized, Key: keyAuthInvalidCredentials}
+	attempt := service.LoginAttemptRequest{IP: auth.ClientIPFromContext(ctx)}
+
+	var user model.User
+	if err := pii.Where(s.db, "name", request.Name).Find(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			if err := s.lockout.CheckLogin(ctx, attempt); err != nil {
+				return nil, err
+			}
+			_, _ = s.hasher.Verify(s.dummyHash, request.Password)
+			return nil, s.loginFailed(ctx, attempt, invalidErr)
+		}
+		e := transport.Error{Msg: "error when get user for login", Code: transport.ErrorCodeInternal, Key: keyAuthLoginFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	attempt.UserID = &user.ID
+	if err := s.lockout.CheckLogin(ctx, attempt); err != nil {
+		return nil, err
+	}
+
+	// inactive users get the error of a wrong password, telling them apart would tell the password is right
+	if user.Status != nil && *user.Status != model.StatusActive {
+		_, _ = s.hasher.Verify(s.dummyHash, request.Password)
+		return nil, s.loginFailed(ctx, attempt, invalidErr)
+	}
+	ok, err := s.checkPassword(ctx, user.ID, request.Password)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, s.loginFailed(ctx, attempt, invalidErr)
+	}
+
+	status, err := s.mfa.GetMFAStatus(ctx, service.GetMFAStatusRequest{UserID: user.ID})
+	if err != nil {
+		return nil, err
+	}
+	// the failures are kept until the second factor is verified too, otherwise knowing the password would
+	// allow guessing codes without limit
+	if status.Enrolled {
+		mfaToken, _, err := s.tokens.Issue(token.Claims{
+			StandardClaims: jwt.StandardClaims{Subject: strconv.Itoa(int(user.ID))},
+			Type:           token.TypeMFA,
+		})
+		if err != nil {
+			e := transport.Error{Msg: fmt.Sprintf("can't issue mfa token for user %d", user.ID), Code: transport.ErrorCodeInternal, Key: keyAuthTokenFailed, Params: userParams(user.ID)}.Wrap(err)
+			s.log.Error(e.Error())
+			return nil, e
+		}
+		return &service.TokenResponse{
+			MFAToken:    mfaToken,
+			MFARequired: true,
+			ExpiresIn:   int(s.tokens.TTL(token.TypeMFA).Seconds()),
+		}, nil
+	}
+
+	if err := s.lockout.RecordLoginSuccess(ctx, attempt); err != nil {
+		return nil, err
+	}
+	if status.Required {
+		return s.issueTokens(ctx, user, token.ScopeMFAEnroll)
+	}
+
+	return s.issueTokens(ctx, user, "")
+}
+
+// checkPassword binds the users synced from LDAP when LDAP login is enabled, other users have a local password.
+func (s authServiceImpl) checkPassword(ctx context.Context, userID model.UserID, password string) (bool, error) {
+	if s.ldap != nil {
+		res, err := s.ldap.Bind(ctx, service.LDAPBindRequest{UserID: userID, Password: password})
+		if err != nil {
+			return false, err
+		}
+		if res.Linked {
+			return res.Authenticated, nil
+		}
+	}
+
+	credential, err := s.getCredential(userID)
+	if err != nil {
+		return false, err
+	}
+	if credential == nil {
+		_, _ = s.hasher.Verify(s.dummyHash, password)
+		return false, nil
+	}
+
+	ok, err := s.hasher.Verify(credential.PasswordHash, password)
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can't verify password of user %d", userID), Code: transport.ErrorCodeInternal, Key: keyAuthPasswordCheckFailed, Params: userParams(userID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return false, e
+	}
+	if ok && s.hasher.NeedsRehash(credential.PasswordHash) {
+		if err := s.savePassword(userID, password); err != nil {
+			s.log.Warn(fmt.Sprintf("can't rehash password of user %d: %v", userID, err))
+		}
+	}
+	return ok, nil
+}
+
+func (s authServiceImpl) LoginMFA(ctx context.Context, request service.LoginMFARequest) (*service.TokenResponse, error) {
+	claims, err := s.tokens.Parse(request.MFAToken, token.TypeMFA)
+	if err != nil {
+		return nil, transport.Error{Msg: "invalid mfa token", Code: transport.ErrorCodeUnauthorized, Key: keyAuthInvalidMFAToken}
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, transport.Error{Msg: "invalid mfa token", Code: transport.ErrorCodeUnauthorized, Key: keyAuthInvalidMFAToken}
+	}
+
+	id := model.UserID(userID)
+	attempt := service.LoginAttemptRequest{UserID: &id, IP: auth.ClientIPFromContext(ctx)}
+	if err := s.lockout.CheckLogin(ctx, attempt); err != nil {
+		return nil, err
+	}
+
+	verified, err := s.mfa.VerifyMFA(ctx, service.VerifyMFARequest{
+		UserID:       id,
+		Code:         request.Code,
+		RecoveryCode: request.RecoveryCode,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !verified.Valid {
+		return nil, s.loginFailed(ctx, attempt, transport.Error{Msg: "invalid mfa code", Code: transport.ErrorCodeUnauthorized, Key: keyAuthInvalidMFACode})
+	}
+
+	user, err := s.getActiveUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.lockout.RecordLoginSuccess(ctx, attempt); err != nil {
+		return nil, err
+	}
+	return s.issueTokens(ctx, *user, "")
+}
+
+func (s authServiceImpl) RefreshToken(ctx context.Context, request service.RefreshTokenRequest) (*service.TokenResponse, error) {
+	claims, err := s.tokens.Parse(request.RefreshToken, token.TypeRefresh)
+	if err != nil {
+		return nil, transport.Error{Msg: "invalid refresh token", Code: transport.ErrorCodeUnauthorized, Key: keyAuthInvalidRefreshToken}
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, transport.Error{Msg: "invalid refresh token", Code: transport.ErrorCodeUnauthorized, Key: keyAuthInvalidRefreshToken}
+	}
+
+	err = s.sessions.UseSession(ctx, service.UseSessionRequest{SessionID: claims.Session, UserID: model.UserID(userID), Refresh: true})
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.getActiveUser(model.UserID(userID))
+	if err != nil {
+		return nil, err
+	}
+	// the scope is kept, refreshing must not lift the mfa enrollment restriction
+	return signSessionTokens(s.tokens, s.log, *user, claims.Scope, claims.Session)
+}
+
+func (s authServiceImpl) SetPassword(ctx context.Context, request service.SetPasswordRequest) (*service.EmptyResponse, error) {
+	if err := s.policy.Validate(request.Password); err != nil {
+		return nil, transport.Error{Msg: err.Error(), Code: transport.ErrorCodeInvalidParameter, Key: keyAuthWeakPassword, Params: map[string]interface{}{"reason": err.Error()}}
+	}
+
+	credential, err := s.getCredential(request.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkCredentialsTarget(ctx, s.db, request.UserID); err != nil {
+		return nil, err
+	}
+
+	// users changing their own password must prove they know the current one
+	if principal, ok := auth.FromContext(ctx); ok && principal.IsUser(request.UserID) && credential != nil {
+		ok, err := s.hasher.Verify(credential.PasswordHash, request.CurrentPassword)
+		if err != nil || !ok {
+			return nil, transport.Error{Msg: "current password is incorrect", Code: transport.ErrorCodePermissionDenied, Key: keyAuthIncorrectPassword}
+		}
+	}
+
+	if credential == nil {
+		var count int
+		if err := s.db.Model(&model.User{}).Where("id = ?", request.UserID).Count(&count).Error; err != nil {
+			e := transport.Error{Msg: fmt.Sprintf("error when get user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyUserGetFailed, Params: userParams(request.UserID)}.Wrap(err)
+			s.log.Error(e.Error())
+			return nil, e
+		}
+		if count == 0 {
+			msg := fmt.Sprintf("not found user %d", request.UserID)
+			s.log.Error(msg)
+			return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyUserNotFound, Params: userParams(request.UserID)}
+		}
+	}
+
+	if err := s.savePassword(request.UserID, request.Password); err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can't save password of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyAuthPasswordSaveFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	// the sessions opened with the old password end with it, but the one of users changing their own password
+	revoke := service.RevokeAllSessionsRequest{UserID: request.UserID}
+	if principal, ok := auth.FromContext(ctx); ok && principal.IsUser(request.UserID) {
+		revoke.Except = principal.SessionID
+	}
+	if _, err := s.sessions.RevokeAllSessions(ctx, revoke); err != nil {
+		return nil, err
+	}
+	return &service.EmptyResponse{}, nil
+}
+
+func (s authServiceImpl) Authenticate(ctx context.Context, request service.AuthenticateTokenRequest) (*auth.Principal, error) {
+	claims, err := s.tokens.Parse(request.Token, token.TypeAccess)
+	if err != nil {
+		return nil, transport.Error{Msg: "invalid access token", Code: transport.ErrorCodeUnauthorized, Key: keyAuthInvalidAccessToken}
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, transport.Error{Msg: "invalid access token", Code: transport.ErrorCodeUnauthorized, Key: keyAuthInvalidAccessToken}
+	}
+	if err := s.sessions.UseSession(ctx, service.UseSessionRequest{SessionID: claims.Session, UserID: model.UserID(userID)}); err != nil {
+		return nil, err
+	}
+	// the role and status are read at every request rather than trusted from the token, a demoted or deactivated
+	// user loses the access at once
+	user, err := s.getActiveUser(model.UserID(userID))
+	if err != nil {
+		return nil, err
+	}
+	var role model.Role
+	if user.Role != nil {
+		role = *user.Role
+	}
+	// an impersonation never gets more than the role the user had when it started
+	if claims.Scope == token.ScopeImpersonation && role.Rank() > model.Role(claims.Role).Rank() {
+		role = model.Role(claims.Role)
+	}
+
+	principal := &auth.Principal{
+		Type:      auth.PrincipalUser,
+		ID:        claims.Subject,
+		Role:      role,
+		SessionID: claims.Session,
+	}
+	if claims.Scope == token.ScopeMFAEnroll {
+		principal.MFAEnrollmentOnly = true
+	} else {
+		principal.Permissions = rolePermissions(principal.Role)
+	}
+	if claims.Scope == token.ScopeImpersonation && claims.Actor != nil {
+		principal.Impersonator = &auth.Principal{Type: auth.PrincipalUser, ID: claims.Actor.Subject}
+	}
+	return principal, nil
+}
+
+// loginFailed records the failure before returning err.
+func (s authServiceImpl) loginFailed(ctx context.Context, attempt service.LoginAttemptRequest, err error) error {
+	if recordErr := s.lockout.RecordLoginFailure(ctx, attempt); recordErr != nil {
+		return recordErr
+	}
+	return err
+}
+
+func (s authServiceImpl) getActiveUser(userID model.UserID) (*model.User, error) {
+	var user model.User
+	if err := s.db.Where("id = ?", userID).Find(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, transport.Error{Msg: "user doesn't exist", Code: transport.ErrorCodeUnauthorized, Key: keyAuthUnknownUser}
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get user %d", userID), Code: transport.ErrorCodeInternal, Key: keyUserGetFailed, Params: userParams(userID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if user.Status != nil && *user.Status != model.StatusActive {
+		return nil, transport.Error{Msg: "user is inactive", Code: transport.ErrorCodePermissionDenied, Key: keyAuthInactiveUser}
+	}
+	return &user, nil
+}
+
+func (s authServiceImpl) getCredential(userID model.UserID) (*model.Credential, error) {
+	var credential model.Credential
+	if err := s.db.Where("user_id = ?", userID).Find(&credential).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get credential of user %d", userID), Code: transport.ErrorCodeInternal, Key: keyAuthCredentialGetFailed, Params: userParams(userID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	return &credential, nil
+}
+
+func (s authServiceImpl) savePassword(userID model.UserID, plain string) error {
+	hash, err := s.hasher.Hash(plain)
+	if err != nil {
+		return err
+	}
+	return s.db.Save(&model.Credential{UserID: userID, PasswordHash: hash, UpdatedAt: s.now()}).Error
+}
+
+func (s authServiceImpl) issueTokens(ctx context.Context, user model.User, scope string) (*service.TokenResponse, error) {
+	return issueUserTokens(ctx, s.tokens, s.sessions, s.log, user, scope)
+}
+
+// issueUserTokens is shared by every way of logging in, each login starts a new session.
+func issueUserTokens(ctx context.Context, tokens *token.Issuer, sessions service.SessionService, log *log2.Logger, user model.User,
+	scope string) (*service.TokenResponse, error) {
+	session, err := sessions.CreateSession(ctx, service.CreateSessionRequest{UserID: user.ID})
+	if err != nil {
+		return nil, err
+	}
+	return signSessionTokens(tokens, log, user, scope, session.ID)
+}
+
+func signSessionTokens(tokens *token.Issuer, log *log2.Logger, user model.User, scope string, sessionID string) (*service.TokenResponse, error) {
+	claims := token.Claims{
+		StandardClaims: jwt.StandardClaims{Subject: strconv.Itoa(int(user.ID))},
+		Scope:          scope,
+		Session:        sessionID,
+	}
+	if user.Role != nil {
+		claims.Role = string(*user.Role)
+	}
+
+	claims.Type = token.TypeAccess
+	access, _, err := tokens.Issue(claims)
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can't issue access token for user %d", user.ID), Code: transport.ErrorCodeInternal, Key: keyAuthTokenFailed, Params: userParams(user.ID)}.Wrap(err)
+		log.Error(e.Error())
+		return nil, e
+	}
+	claims.Type = token.TypeRefresh
+	refresh, _, err := tokens.Issue(claims)
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can't issue refresh token for user %d", user.ID), Code: transport.ErrorCodeInternal, Key: keyAuthTokenFailed, Params: userParams(user.ID)}.Wrap(err)
+		log.Error(e.Error())
+		return nil, e
+	}
+
+	return &service.TokenResponse{
+		AccessToken:           access,
+		RefreshToken:          refresh,
+		TokenType:             "Bearer",
+		ExpiresIn:             int(tokens.TTL(token.TypeAccess).Seconds()),
+		MFAEnrollmentRequired: scope == token.ScopeMFAEnroll,
+	}, nil
+}
+
+// checkCredentialsTarget refuses to manage the credentials of users whose role is above the role of the caller,
+// users manage their own. API keys have no role, they rank as USER.
+func checkCredentialsTarget(ctx context.Context, db *gorm.DB, userID model.UserID) error {
+	principal, ok := auth.FromContext(ctx)
+	if !ok || principal.IsUser(userID) {
+		return nil
+	}
+	var user model.User
+	if err := db.Select("role").Where("id = ?", userID).First(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return transport.Error{Msg: fmt.Sprintf("not found user %d", userID), Code: transport.ErrorCodeNotFound, Key: keyUserNotFound, Params: userParams(userID)}
+		}
+		return transport.InternalError(fmt.Errorf("can't get role of user %d: %w", userID, err))
+	}
+	if outranks(user.Role, principal) {
+		return transport.Error{Msg: fmt.Sprintf("%s can't manage the credentials of user %d with role %s", principal, userID, *user.Role),
+			Code: transport.ErrorCodePermissionDenied, Key: keyAuthCredentialsOutOfReach, Params: map[string]interface{}{"id": userID, "role": *user.Role}}
+	}
+	return nil
+}
+
+// outranks tells whether role is above the role of the principal, API keys have no role, they rank as USER.
+func outranks(role *model.Role, principal *auth.Principal) bool {
+	if role == nil {
+		return false
+	}
+	rank := model.RoleUser.Rank()
+	if principal.Type == auth.PrincipalUser {
+		rank = principal.Role.Rank()
+	}
+	return role.Rank() > rank
+}
+
+// rolePermissions reads the permissions of a role from auth.role_permissions, users without role get USER's ones.
+func rolePermissions(role model.Role) []string {
+	if len(role) == 0 {
+		role = model.RoleUser
+	}
+	return viper.GetStringSlice("auth.role_permissions." + strings.ToLower(string(role)))
+}
@@ -0,0 +1,461 @@
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/assert"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/i18n"
+	"user-service/src/service/util/mail"
+	"user-service/src/service/util/password"
+	"user-service/src/service/util/token"
+)
+
+// fakeMFAService only answers what the login flow asks.
+type fakeMFAService struct {
+	service.MFAService
+	status service.MFAStatusResponse
+	valid  bool
+}
+
+func (f *fakeMFAService) GetMFAStatus(_ context.Context, _ service.GetMFAStatusRequest) (*service.MFAStatusResponse, error) {
+	return &f.status, nil
+}
+
+func (f *fakeMFAService) VerifyMFA(_ context.Context, _ service.VerifyMFARequest) (*service.VerifyMFAResponse, error) {
+	return &service.VerifyMFAResponse{Valid: f.valid}, nil
+}
+
+// fakeLockoutService counts the attempts reported by the login flow.
+type fakeLockoutService struct {
+	service.LockoutService
+	locked    error
+	failures  int
+	successes int
+}
+
+func (f *fakeLockoutService) CheckLogin(_ context.Context, _ service.LoginAttemptRequest) error {
+	return f.locked
+}
+
+func (f *fakeLockoutService) RecordLoginFailure(_ context.Context, _ service.LoginAttemptRequest) error {
+	f.failures++
+	return nil
+}
+
+func (f *fakeLockoutService) RecordLoginSuccess(_ context.Context, _ service.LoginAttemptRequest) error {
+	f.successes++
+	return nil
+}
+
+// fakeSessionService keeps the sessions in memory.
+type fakeSessionService struct {
+	service.SessionService
+	revoked map[string]bool
+	// revokedUsers are the users whose sessions were all revoked, but the sessions kept
+	revokedUsers []model.UserID
+	kept         []string
+}
+
+func (f *fakeSessionService) CreateSession(_ context.Context, request service.CreateSessionRequest) (*model.Session, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	f.revoked[id] = false
+	return &model.Session{ID: id, UserID: request.UserID}, nil
+}
+
+func (f *fakeSessionService) UseSession(_ context.Context, request service.UseSessionRequest) error {
+	if revoked, ok := f.revoked[request.SessionID]; !ok || revoked {
+		return errInvalidSession
+	}
+	return nil
+}
+
+func (f *fakeSessionService) RevokeSession(_ context.Context, request service.RevokeSessionRequest) (*service.EmptyResponse, error) {
+	if revoked, ok := f.revoked[request.SessionID]; !ok || revoked {
+		return nil, transport.Error{Msg: "not found session", Code: transport.ErrorCodeNotFound}
+	}
+	f.revoked[request.SessionID] = true
+	return &service.EmptyResponse{}, nil
+}
+
+func (f *fakeSessionService) RevokeAllSessions(_ context.Context, request service.RevokeAllSessionsRequest) (*service.RevokeSessionsResponse, error) {
+	f.revokedUsers = append(f.revokedUsers, request.UserID)
+	f.kept = append(f.kept, request.Except)
+	return &service.RevokeSessionsResponse{}, nil
+}
+
+// fakeMailer hands the messages to the test.
+type fakeMailer struct {
+	sent chan mail.Message
+}
+
+func (f fakeMailer) Send(_ context.Context, msg mail.Message) error {
+	f.sent <- msg
+	return nil
+}
+
+func initAuthMock(t *testing.T) (authServiceImpl, userMock, *fakeMFAService, string) {
+	s := initUserMock()
+	hasher, _ := password.NewHasher(password.AlgorithmBcrypt)
+	hash, err := hasher.Hash("Secret123")
+	assert.NilError(t, err)
+
+	mfa := &fakeMFAService{}
+	svc, err := NewAuthServiceImpl(s.svc.db, s.svc.log, hasher, password.Policy{MinLength: 8},
+		token.NewIssuer("test", []byte("0123456789abcdef0123456789abcdef"), time.Minute, time.Hour, time.Minute), mfa,
+		&fakeLockoutService{}, &fakeSessionService{revoked: map[string]bool{}}, nil, fakeMailer{sent: make(chan mail.Message, 1)}, PasswordResetConfig{TTL: time.Hour, Link: "https://app/reset?token={token}"})
+	assert.NilError(t, err)
+	return svc.(authServiceImpl), s, mfa, hash
+}
+
+type fakeLDAPService struct {
+	service.LDAPService
+	linked map[model.UserID]string
+}
+
+func (f fakeLDAPService) Bind(_ context.Context, request service.LDAPBindRequest) (*service.LDAPBindResponse, error) {
+	password, ok := f.linked[request.UserID]
+	return &service.LDAPBindResponse{Linked: ok, Authenticated: ok && password == request.Password}, nil
+}
+
+func TestAuthServiceImpl_Login_LDAP(t *testing.T) {
+	svc, s, _, _ := initAuthMock(t)
+	svc.ldap = fakeLDAPService{linked: map[model.UserID]string{1: "directory password"}}
+
+	expectUser := func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (name = ?)")).
+			WithArgs("ql").
+			WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(s.userData[0])...))
+	}
+
+	// the local password doesn't apply to users synced from the directory, so credentials aren't read
+	expectUser()
+	_, err := svc.Login(context.Background(), service.LoginRequest{Name: "ql", Password: "Secret123"})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodeUnauthorized)
+
+	expectUser()
+	res, err := svc.Login(context.Background(), service.LoginRequest{Name: "ql", Password: "directory password"})
+	assert.NilError(t, err)
+	assert.Assert(t, len(res.AccessToken) > 0)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestAuthServiceImpl_Login(t *testing.T) {
+	svc, s, mfa, hash := initAuthMock(t)
+	lockout := svc.lockout.(*fakeLockoutService)
+
+	expectUser := func(found bool) {
+		rows := s.mock.NewRows(s.userColumn)
+		if found {
+			rows.AddRow(structToDriverValueArray(s.userData[0])...)
+		}
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (name = ?)")).
+			WithArgs("ql").
+			WillReturnRows(rows)
+	}
+	expectCredential := func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `credentials`  WHERE (user_id = ?)")).
+			WillReturnRows(s.mock.NewRows([]string{"user_id", "password_hash", "updated_at"}).
+				AddRow(1, hash, time.Now()))
+	}
+
+	tests := []struct {
+		name      string
+		request   service.LoginRequest
+		mfa       service.MFAStatusResponse
+		locked    bool
+		mockSetup func()
+		errCode   transport.ResponseCode
+	}{
+		{
+			name:      "unknown user",
+			request:   service.LoginRequest{Name: "ql", Password: "Secret123"},
+			mockSetup: func() { expectUser(false) },
+			errCode:   transport.ErrorCodeUnauthorized,
+		},
+		{
+			name:    "wrong password",
+			request: service.LoginRequest{Name: "ql", Password: "secret123"},
+			mockSetup: func() {
+				expectUser(true)
+				expectCredential()
+			},
+			errCode: transport.ErrorCodeUnauthorized,
+		},
+		{
+			// the right password of an inactive user gets the error of a wrong one
+			name:    "inactive user",
+			request: service.LoginRequest{Name: "ql", Password: "Secret123"},
+			mockSetup: func() {
+				s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (name = ?)")).
+					WithArgs("ql").
+					WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(s.userData[2])...))
+			},
+			errCode: transport.ErrorCodeUnauthorized,
+		},
+		{
+			name:      "locked",
+			request:   service.LoginRequest{Name: "ql", Password: "Secret123"},
+			locked:    true,
+			mockSetup: func() { expectUser(true) },
+			errCode:   transport.ErrorCodeTooManyRequests,
+		},
+		{
+			name:    "success",
+			request: service.LoginRequest{Name: "ql", Password: "Secret123"},
+			mockSetup: func() {
+				expectUser(true)
+				expectCredential()
+			},
+		},
+		{
+			name:    "mfa enrolled",
+			request: service.LoginRequest{Name: "ql", Password: "Secret123"},
+			mfa:     service.MFAStatusResponse{Enrolled: true},
+			mockSetup: func() {
+				expectUser(true)
+				expectCredential()
+			},
+		},
+		{
+			name:    "mfa enrollment required",
+			request: service.LoginRequest{Name: "ql", Password: "Secret123"},
+			mfa:     service.MFAStatusResponse{Required: true},
+			mockSetup: func() {
+				expectUser(true)
+				expectCredential()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockSetup()
+			mfa.status = tt.mfa
+			*lockout = fakeLockoutService{}
+			if tt.locked {
+				lockout.locked = transport.Error{Msg: "locked", Code: transport.ErrorCodeTooManyRequests}
+			}
+			res, err := svc.Login(context.Background(), tt.request)
+			if err != nil {
+				assert.Equal(t, err.(transport.Error).Code, tt.errCode)
+				if tt.errCode == transport.ErrorCodeUnauthorized {
+					assert.Equal(t, err.(transport.Error).Msg, "invalid name or password")
+					assert.Equal(t, err.(transport.Error).Localize(i18n.Vietnamese).Msg, "tên hoặc mật khẩu không đúng")
+				}
+				// attempts rejected by the lockout aren't failures
+				expectedFailures := 1
+				if tt.locked {
+					expectedFailures = 0
+				}
+				assert.Equal(t, lockout.failures, expectedFailures)
+				assert.Equal(t, lockout.successes, 0)
+				return
+			}
+			assert.Equal(t, tt.errCode, transport.ResponseCode(0))
+			assert.NilError(t, s.mock.ExpectationsWereMet())
+			assert.Equal(t, lockout.failures, 0)
+			if tt.mfa.Enrolled {
+				// the failures are only reset once the second factor is verified
+				assert.Equal(t, lockout.successes, 0)
+				assert.Assert(t, res.MFARequired && len(res.AccessToken) == 0)
+				_, err := svc.tokens.Parse(res.MFAToken, token.TypeMFA)
+				assert.NilError(t, err)
+				return
+			}
+			assert.Equal(t, lockout.successes, 1)
+			claims, err := svc.tokens.Parse(res.AccessToken, token.TypeAccess)
+			assert.NilError(t, err)
+			assert.Equal(t, claims.Subject, "1")
+			assert.Equal(t, claims.Role, string(model.RoleUser))
+			assert.Equal(t, res.MFAEnrollmentRequired, tt.mfa.Required)
+			s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id = ?)")).
+				WithArgs(1).
+				WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(s.userData[0])...))
+			principal, err := svc.Authenticate(context.Background(), service.AuthenticateTokenRequest{Token: res.AccessToken})
+			assert.NilError(t, err)
+			assert.Equal(t, principal.MFAEnrollmentOnly, tt.mfa.Required)
+			assert.Equal(t, principal.SessionID, claims.Session)
+			_, err = svc.tokens.Parse(res.RefreshToken, token.TypeAccess)
+			assert.Assert(t, err != nil)
+
+			// tokens of a revoked session are rejected at once
+			svc.sessions.(*fakeSessionService).revoked[claims.Session] = true
+			_, err = svc.Authenticate(context.Background(), service.AuthenticateTokenRequest{Token: res.AccessToken})
+			assert.Error(t, err, errInvalidSession.Error())
+		})
+	}
+}
+
+func TestAuthServiceImpl_SetPassword(t *testing.T) {
+	svc, s, _, hash := initAuthMock(t)
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "1", SessionID: "s1"})
+	expectCredential := func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `credentials`  WHERE (user_id = ?)")).
+			WithArgs(1).
+			WillReturnRows(s.mock.NewRows([]string{"user_id", "password_hash", "updated_at"}).AddRow(1, hash, time.Now()))
+	}
+
+	expectCredential()
+	_, err := svc.SetPassword(ctx, service.SetPasswordRequest{UserID: 1, CurrentPassword: "wrong", Password: "NewSecret123"})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodePermissionDenied)
+
+	// the sessions opened with the old password are revoked, the user keeps the session changing it
+	expectCredential()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `credentials`")).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	_, err = svc.SetPassword(ctx, service.SetPasswordRequest{UserID: 1, CurrentPassword: "Secret123", Password: "NewSecret123"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, svc.sessions.(*fakeSessionService).revokedUsers, []model.UserID{1})
+	assert.DeepEqual(t, svc.sessions.(*fakeSessionService).kept, []string{"s1"})
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestCheckCredentialsTarget(t *testing.T) {
+	s := initUserMock()
+	expectRole := func(role model.Role) {
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT role FROM `users`  WHERE (id = ?) ORDER BY `users`.`id` ASC LIMIT 1")).
+			WithArgs(2).
+			WillReturnRows(s.mock.NewRows([]string{"role"}).AddRow(role))
+	}
+	principal := func(p auth.Principal) context.Context {
+		return auth.NewContext(context.Background(), &p)
+	}
+	support := principal(auth.Principal{Type: auth.PrincipalUser, ID: "1", Role: model.RoleSupport})
+
+	expectRole(model.RoleAdmin)
+	err := checkCredentialsTarget(support, s.svc.db, 2)
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodePermissionDenied)
+
+	expectRole(model.RoleSupport)
+	assert.NilError(t, checkCredentialsTarget(support, s.svc.db, 2))
+
+	// API keys rank as USER
+	expectRole(model.RoleSupport)
+	err = checkCredentialsTarget(principal(auth.Principal{Type: auth.PrincipalAPIKey, ID: "3"}), s.svc.db, 2)
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodePermissionDenied)
+
+	// users manage their own credentials, whatever their role
+	assert.NilError(t, checkCredentialsTarget(principal(auth.Principal{Type: auth.PrincipalUser, ID: "2"}), s.svc.db, 2))
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestAuthServiceImpl_ForgotPassword(t *testing.T) {
+	svc, s, _, _ := initAuthMock(t)
+	email := "ql@example.com"
+	user := s.userData[0]
+	user.Email = &email
+
+	// unknown addresses get the same answer and no mail
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (email = ?)")).
+		WithArgs("nobody@example.com").
+		WillReturnRows(s.mock.NewRows(s.userColumn))
+	_, err := svc.ForgotPassword(context.Background(), service.ForgotPasswordRequest{Email: "nobody@example.com"})
+	assert.NilError(t, err)
+
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (email = ?)")).
+		WithArgs(email).
+		WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(user)...))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `password_reset_tokens`")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `password_reset_tokens`")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	_, err = svc.ForgotPassword(context.Background(), service.ForgotPasswordRequest{Email: email})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+
+	msg := <-svc.mailer.(fakeMailer).sent
+	assert.Equal(t, msg.To, email)
+	assert.Assert(t, strings.Contains(msg.Body, "https://app/reset?token="))
+	assert.Equal(t, len(svc.mailer.(fakeMailer).sent), 0)
+}
+
+func TestAuthServiceImpl_ResetPassword(t *testing.T) {
+	svc, s, _, _ := initAuthMock(t)
+	tokenColumns := []string{"token_hash", "user_id", "expires_at", "used_at", "created_at"}
+	expectToken := func(expiresAt time.Time, usedAt *time.Time) {
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `password_reset_tokens`  WHERE (token_hash = ?)")).
+			WithArgs(hashToken("reset-token")).
+			WillReturnRows(s.mock.NewRows(tokenColumns).AddRow(hashToken("reset-token"), 1, expiresAt, usedAt, time.Now()))
+	}
+
+	tests := []struct {
+		name      string
+		request   service.ResetPasswordRequest
+		mockSetup func()
+		errCode   transport.ResponseCode
+	}{
+		{
+			name:      "weak password",
+			request:   service.ResetPasswordRequest{Token: "reset-token", Password: "short"},
+			mockSetup: func() {},
+			errCode:   transport.ErrorCodeInvalidParameter,
+		},
+		{
+			name:      "expired token",
+			request:   service.ResetPasswordRequest{Token: "reset-token", Password: "NewSecret123"},
+			mockSetup: func() { expectToken(time.Now().Add(-time.Minute), nil) },
+			errCode:   transport.ErrorCodeInvalidParameter,
+		},
+		{
+			name:    "token used concurrently",
+			request: service.ResetPasswordRequest{Token: "reset-token", Password: "NewSecret123"},
+			mockSetup: func() {
+				expectToken(time.Now().Add(time.Hour), nil)
+				s.mock.ExpectBegin()
+				s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `password_reset_tokens`")).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				s.mock.ExpectCommit()
+			},
+			errCode: transport.ErrorCodeInvalidParameter,
+		},
+		{
+			name:    "success",
+			request: service.ResetPasswordRequest{Token: "reset-token", Password: "NewSecret123"},
+			mockSetup: func() {
+				expectToken(time.Now().Add(time.Hour), nil)
+				s.mock.ExpectBegin()
+				s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `password_reset_tokens`")).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `credentials`")).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				s.mock.ExpectCommit()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mockSetup()
+			_, err := svc.ResetPassword(context.Background(), tt.request)
+			if tt.errCode != 0 {
+				assert.Equal(t, err.(transport.Error).Code, tt.errCode)
+			} else {
+				assert.NilError(t, err)
+				assert.DeepEqual(t, svc.sessions.(*fakeSessionService).revokedUsers, []model.UserID{1})
+			}
+			assert.NilError(t, s.mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUserResponse_NoPasswordHash(t *testing.T) {
+	s := initUserMock()
+	b, err := json.Marshal(service.UserResponse{User: s.userData[0]})
+	assert.NilError(t, err)
+	assert.Assert(t, !strings.Contains(string(b), "password"))
+}
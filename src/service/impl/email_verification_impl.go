@@ -0,0 +1,201 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"net/url"
+	"strings"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	log2 "user-service/src/service/util/log"
+	"user-service/src/service/util/mail"
+	"user-service/src/service/util/pii"
+)
+
+type EmailVerificationConfig struct {
+	TTL time.Duration
+	// Link is the page of the web app that confirms the address, {token} is replaced by the token.
+	Link string
+	// ResendInterval is the minimum time between two mails to a user.
+	ResendInterval time.Duration
+	// ResendLimit is the maximum number of mails to a user within ResendWindow.
+	ResendLimit  int
+	ResendWindow time.Duration
+}
+
+// keys of the errors of EmailVerificationService
+const (
+	keyEmailVerificationNoEmail         = "email_verification.no_email"
+	keyEmailVerificationAlreadyVerified = "email_verification.already_verified"
+	keyEmailVerificationSendFailed      = "email_verification.send_failed"
+	keyEmailVerificationResendLimit     = "email_verification.resend_limit"
+	keyEmailVerificationResendInterval  = "email_verification.resend_interval"
+	keyEmailVerificationInvalidToken    = "email_verification.invalid_token"
+	keyEmailVerificationVerifyFailed    = "email_verification.verify_failed"
+)
+
+type emailVerificationServiceImpl struct {
+	db     *gorm.DB
+	log    *log2.Logger
+	mailer mail.Mailer
+	config EmailVerificationConfig
+	now    func() time.Time
+}
+
+var errEmailChanged = errors.New("email changed")
+
+func NewEmailVerificationServiceImpl(db *gorm.DB, log *log2.Logger, mailer mail.Mailer, config EmailVerificationConfig) (service.EmailVerificationService, error) {
+	src := emailVerificationServiceImpl{
+		db:     db,
+		log:    log,
+		mailer: mailer,
+		config: config,
+		now:    time.Now,
+	}
+
+	return src, nil
+}
+
+func (s emailVerificationServiceImpl) SendEmailVerification(ctx context.Context, request service.SendEmailVerificationRequest) (*service.EmptyResponse, error) {
+	var user model.User
+	if err := s.db.Where("id = ?", request.UserID).Find(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			msg := fmt.Sprintf("not found user %d", request.UserID)
+			s.log.Error(msg)
+			return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyUserNotFound, Params: userParams(request.UserID)}
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyUserGetFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if user.Email == nil {
+		return nil, transport.Error{Msg: "user has no email", Code: transport.ErrorCodeInvalidParameter, Key: keyEmailVerificationNoEmail}
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil, transport.Error{Msg: "email is already verified", Code: transport.ErrorCodeInvalidParameter, Key: keyEmailVerificationAlreadyVerified}
+	}
+
+	now := s.now()
+	if err := s.checkResendLimit(user.ID, now); err != nil {
+		return nil, err
+	}
+
+	verificationToken, err := randomToken(32)
+	if err != nil {
+		e := transport.Error{Msg: "can not generate email verification token", Code: transport.ErrorCodeInternal, Key: keyEmailVerificationSendFailed, Params: userParams(user.ID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	err = s.db.Create(&model.EmailVerificationToken{
+		TokenHash: hashToken(verificationToken),
+		UserID:    user.ID,
+		Email:     *user.Email,
+		ExpiresAt: now.Add(s.config.TTL),
+		CreatedAt: now,
+	}).Error
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not save email verification token of user %d", user.ID), Code: transport.ErrorCodeInternal, Key: keyEmailVerificationSendFailed, Params: userParams(user.ID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	err = s.mailer.Send(ctx, mail.Message{
+		To:      *user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hello %s,\n\nOpen this link to confirm your email address, it expires in %s:\n%s\n",
+			user.Name, s.config.TTL, strings.Replace(s.config.Link, "{token}", url.QueryEscape(verificationToken), -1)),
+	})
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not send verification mail to user %d", user.ID), Code: transport.ErrorCodeInternal, Key: keyEmailVerificationSendFailed, Params: userParams(user.ID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	s.log.Info(fmt.Sprintf("email verification sent to user %d", user.ID))
+	return &service.EmptyResponse{}, nil
+}
+
+func (s emailVerificationServiceImpl) VerifyEmail(_ context.Context, request service.VerifyEmailRequest) (*service.EmptyResponse, error) {
+	invalidErr := transport.Error{Msg: "invalid or expired email verification token", Code: transport.ErrorCodeInvalidParameter, Key: keyEmailVerificationInvalidToken}
+
+	var verificationToken model.EmailVerificationToken
+	if err := s.db.Where("token_hash = ?", hashToken(request.Token)).Find(&verificationToken).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, invalidErr
+		}
+		e := transport.Error{Msg: "error when get email verification token", Code: transport.ErrorCodeInternal, Key: keyEmailVerificationVerifyFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	now := s.now()
+	if verificationToken.UsedAt != nil || !now.Before(verificationToken.ExpiresAt) {
+		return nil, invalidErr
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		ret := tx.Model(&model.EmailVerificationToken{}).
+			Where("token_hash = ? AND used_at IS NULL", verificationToken.TokenHash).
+			Update("used_at", now)
+		if ret.Error != nil {
+			return ret.Error
+		}
+		if ret.RowsAffected != 1 {
+			return errEmailChanged
+		}
+		// the address must still be the one the token was sent to
+		emailColumn, email := pii.Match(tx, "email", verificationToken.Email)
+		ret = tx.Model(&model.User{}).
+			Where(fmt.Sprintf("id = ? AND %s = ?", emailColumn), verificationToken.UserID, email).
+			Update("email_verified_at", now)
+		if ret.Error != nil {
+			return ret.Error
+		}
+		if ret.RowsAffected != 1 {
+			return errEmailChanged
+		}
+		return nil
+	})
+	if err == errEmailChanged {
+		return nil, invalidErr
+	}
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not verify email of user %d", verificationToken.UserID), Code: transport.ErrorCodeInternal, Key: keyEmailVerificationVerifyFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	s.log.Info(fmt.Sprintf("email of user %d verified", verificationToken.UserID))
+	return &service.EmptyResponse{}, nil
+}
+
+func (s emailVerificationServiceImpl) checkResendLimit(userID model.UserID, now time.Time) error {
+	var sent []model.EmailVerificationToken
+	err := s.db.Where("user_id = ? AND created_at > ?", userID, now.Add(-s.config.ResendWindow)).
+		Order("created_at desc").Find(&sent).Error
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("error when counting verification mails of user %d", userID), Code: transport.ErrorCodeInternal, Key: keyEmailVerificationSendFailed, Params: userParams(userID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return e
+	}
+	if len(sent) >= s.config.ResendLimit {
+		return transport.Error{
+			Msg:    fmt.Sprintf("at most %d verification mails can be sent in %s", s.config.ResendLimit, s.config.ResendWindow),
+			Code:   transport.ErrorCodeTooManyRequests,
+			Key:    keyEmailVerificationResendLimit,
+			Params: map[string]interface{}{"limit": s.config.ResendLimit, "window": s.config.ResendWindow},
+		}
+	}
+	if len(sent) > 0 && now.Sub(sent[0].CreatedAt) < s.config.ResendInterval {
+		return transport.Error{
+			Msg:    fmt.Sprintf("wait %s between two verification mails", s.config.ResendInterval),
+			Code:   transport.ErrorCodeTooManyRequests,
+			Key:    keyEmailVerificationResendInterval,
+			Params: map[string]interface{}{"interval": s.config.ResendInterval},
+		}
+	}
+	return nil
+}
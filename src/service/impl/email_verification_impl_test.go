@@ -0,0 +1,135 @@
+package impl
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/assert"
+	"regexp"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/mail"
+)
+
+func initEmailVerificationMock() (emailVerificationServiceImpl, userMock) {
+	s := initUserMock()
+	svc := emailVerificationServiceImpl{
+		db:     s.svc.db,
+		log:    s.svc.log,
+		mailer: fakeMailer{sent: make(chan mail.Message, 1)},
+		config: EmailVerificationConfig{
+			TTL:            time.Hour,
+			Link:           "https://app/verify?token={token}",
+			ResendInterval: time.Minute,
+			ResendLimit:    2,
+			ResendWindow:   time.Hour,
+		},
+		now: time.Now,
+	}
+	return svc, s
+}
+
+func TestEmailVerificationServiceImpl_SendEmailVerification(t *testing.T) {
+	tokenColumns := []string{"token_hash", "user_id", "email", "expires_at", "used_at", "created_at"}
+	email := "ql@example.com"
+
+	tests := []struct {
+		name    string
+		sent    []time.Duration
+		errCode transport.ResponseCode
+	}{
+		{
+			name: "first mail",
+		},
+		{
+			name:    "too soon",
+			sent:    []time.Duration{10 * time.Second},
+			errCode: transport.ErrorCodeTooManyRequests,
+		},
+		{
+			name:    "too many",
+			sent:    []time.Duration{10 * time.Minute, 20 * time.Minute},
+			errCode: transport.ErrorCodeTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, s := initEmailVerificationMock()
+			user := s.userData[0]
+			user.Email = &email
+
+			s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id = ?)")).
+				WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(user)...))
+			rows := s.mock.NewRows(tokenColumns)
+			for _, ago := range tt.sent {
+				rows.AddRow("hash", 1, email, time.Now().Add(time.Hour), nil, time.Now().Add(-ago))
+			}
+			s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `email_verification_tokens`")).WillReturnRows(rows)
+			if tt.errCode == 0 {
+				s.mock.ExpectBegin()
+				s.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `email_verification_tokens`")).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				s.mock.ExpectCommit()
+			}
+
+			_, err := svc.SendEmailVerification(context.Background(), service.SendEmailVerificationRequest{UserID: 1})
+			assert.NilError(t, s.mock.ExpectationsWereMet())
+			if tt.errCode != 0 {
+				assert.Equal(t, err.(transport.Error).Code, tt.errCode)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, (<-svc.mailer.(fakeMailer).sent).To, email)
+		})
+	}
+}
+
+func TestEmailVerificationServiceImpl_VerifyEmail(t *testing.T) {
+	tokenColumns := []string{"token_hash", "user_id", "email", "expires_at", "used_at", "created_at"}
+
+	tests := []struct {
+		name         string
+		userAffected int64
+		errCode      transport.ResponseCode
+	}{
+		{
+			name:         "success",
+			userAffected: 1,
+		},
+		{
+			name:         "email changed since",
+			userAffected: 0,
+			errCode:      transport.ErrorCodeInvalidParameter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, s := initEmailVerificationMock()
+			s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `email_verification_tokens`  WHERE (token_hash = ?)")).
+				WithArgs(hashToken("verify-token")).
+				WillReturnRows(s.mock.NewRows(tokenColumns).
+					AddRow(hashToken("verify-token"), 1, "ql@example.com", time.Now().Add(time.Hour), nil, time.Now()))
+			s.mock.ExpectBegin()
+			s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `email_verification_tokens`")).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `users` SET `email_verified_at` = ? WHERE (id = ? AND email = ?)")).
+				WillReturnResult(sqlmock.NewResult(0, tt.userAffected))
+			if tt.errCode != 0 {
+				s.mock.ExpectRollback()
+			} else {
+				s.mock.ExpectCommit()
+			}
+
+			_, err := svc.VerifyEmail(context.Background(), service.VerifyEmailRequest{Token: "verify-token"})
+			assert.NilError(t, s.mock.ExpectationsWereMet())
+			if tt.errCode != 0 {
+				assert.Equal(t, err.(transport.Error).Code, tt.errCode)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"github.com/jinzhu/gorm"
+	"strconv"
+	"strings"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	log2 "user-service/src/service/util/log"
+	"user-service/src/service/util/token"
+)
+
+type ImpersonationConfig struct {
+	// TTL is the lifetime of impersonation tokens, it is capped by the lifetime of access tokens.
+	TTL time.Duration
+}
+
+// keys of the errors of ImpersonationService
+const (
+	keyImpersonationSelf           = "impersonation.self"
+	keyImpersonationReasonRequired = "impersonation.reason_required"
+	keyImpersonationInactiveUser   = "impersonation.inactive_user"
+	keyImpersonationNotAllowed     = "impersonation.not_allowed"
+	keyImpersonationStartFailed    = "impersonation.start_failed"
+	keyImpersonationNotFound       = "impersonation.not_found"
+	keyImpersonationEndFailed      = "impersonation.end_failed"
+	keyImpersonationUserRequired   = "impersonation.user_required"
+	keyImpersonationNested         = "impersonation.nested"
+)
+
+type impersonationServiceImpl struct {
+	db       *gorm.DB
+	log      *log2.Logger
+	tokens   *token.Issuer
+	sessions service.SessionService
+	audit    service.AuditService
+	config   ImpersonationConfig
+	now      func() time.Time
+}
+
+func NewImpersonationServiceImpl(db *gorm.DB, log *log2.Logger, tokens *token.Issuer, sessions service.SessionService,
+	audit service.AuditService, config ImpersonationConfig) (service.ImpersonationService, error) {
+	src := impersonationServiceImpl{
+		db:       db,
+		log:      log,
+		tokens:   tokens,
+		sessions: sessions,
+		audit:    audit,
+		config:   config,
+		now:      time.Now,
+	}
+
+	return src, nil
+}
+
+func (s impersonationServiceImpl) Impersonate(ctx context.Context, request service.ImpersonateRequest) (*service.ImpersonationResponse, error) {
+	admin, err := s.admin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if admin.IsUser(request.UserID) {
+		return nil, transport.Error{Msg: "can not impersonate yourself", Code: transport.ErrorCodeInvalidParameter, Key: keyImpersonationSelf}
+	}
+	reason := strings.TrimSpace(request.Reason)
+	if len(reason) == 0 {
+		return nil, transport.Error{Msg: "reason is required", Code: transport.ErrorCodeInvalidParameter, Key: keyImpersonationReasonRequired}
+	}
+
+	var user model.User
+	if err := s.db.Where("id = ?", request.UserID).Find(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			msg := fmt.Sprintf("not found user %d", request.UserID)
+			s.log.Error(msg)
+			return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyUserNotFound, Params: userParams(request.UserID)}
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyUserGetFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if user.Status != nil && *user.Status != model.StatusActive {
+		return nil, transport.Error{Msg: fmt.Sprintf("user %d is inactive", user.ID), Code: transport.ErrorCodePermissionDenied, Key: keyImpersonationInactiveUser, Params: userParams(user.ID)}
+	}
+	role := model.RoleUser
+	if user.Role != nil {
+		role = *user.Role
+	}
+	// admins and whoever may impersonate can't be impersonated, it would escalate to their permissions
+	if role == model.RoleAdmin || (auth.Principal{Permissions: rolePermissions(role)}).HasPermission(auth.PermissionImpersonate) {
+		msg := fmt.Sprintf("%s can not impersonate user %d with role %s", admin, user.ID, role)
+		s.log.Warn(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodePermissionDenied, Key: keyImpersonationNotAllowed, Params: map[string]interface{}{"id": user.ID, "role": role}}
+	}
+
+	session, err := s.sessions.CreateSession(ctx, service.CreateSessionRequest{UserID: user.ID})
+	if err != nil {
+		return nil, err
+	}
+	adminID, _ := strconv.Atoi(admin.ID)
+	now := s.now()
+	impersonation := model.Impersonation{
+		AdminID:   model.UserID(adminID),
+		UserID:    user.ID,
+		SessionID: session.ID,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+
+	access, claims, err := s.tokens.Issue(token.Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.Itoa(int(user.ID)),
+			ExpiresAt: now.Add(s.config.TTL).Unix(),
+		},
+		Type:    token.TypeAccess,
+		Role:    string(role),
+		Scope:   token.ScopeImpersonation,
+		Session: session.ID,
+		Actor:   &token.Actor{Subject: admin.ID},
+	})
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can't issue impersonation token for user %d", user.ID), Code: transport.ErrorCodeInternal, Key: keyImpersonationStartFailed, Params: userParams(user.ID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	impersonation.ExpiresAt = time.Unix(claims.ExpiresAt, 0)
+
+	if err := s.db.Create(&impersonation).Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not create impersonation of user %d", user.ID), Code: transport.ErrorCodeInternal, Key: keyImpersonationStartFailed, Params: userParams(user.ID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	_, err = s.audit.Record(ctx, service.RecordAuditRequest{
+		Action: "impersonation.start",
+		Detail: fmt.Sprintf("impersonation %d of user %d: %s", impersonation.ID, user.ID, reason),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &service.ImpersonationResponse{
+		Impersonation: impersonation,
+		AccessToken:   access,
+		ExpiresIn:     int(claims.ExpiresAt - claims.IssuedAt),
+	}, nil
+}
+
+func (s impersonationServiceImpl) EndImpersonation(ctx context.Context, request service.EndImpersonationRequest) (*service.EmptyResponse, error) {
+	admin, err := s.admin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var impersonation model.Impersonation
+	if err := s.db.Where("id = ? AND admin_id = ?", request.ImpersonationID, admin.ID).Find(&impersonation).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			msg := fmt.Sprintf("not found impersonation %d of %s", request.ImpersonationID, admin)
+			s.log.Error(msg)
+			return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyImpersonationNotFound, Params: map[string]interface{}{"id": request.ImpersonationID}}
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get impersonation %d", request.ImpersonationID), Code: transport.ErrorCodeInternal, Key: keyImpersonationEndFailed, Params: map[string]interface{}{"id": request.ImpersonationID}}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	result := s.db.Model(&model.Impersonation{}).
+		Where("id = ? AND ended_at IS NULL", impersonation.ID).
+		Update("ended_at", s.now())
+	if result.Error != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not end impersonation %d", impersonation.ID), Code: transport.ErrorCodeInternal, Key: keyImpersonationEndFailed, Params: map[string]interface{}{"id": impersonation.ID}}.Wrap(result.Error)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if result.RowsAffected == 0 {
+		return &service.EmptyResponse{}, nil
+	}
+
+	_, err = s.sessions.RevokeSession(ctx, service.RevokeSessionRequest{UserID: impersonation.UserID, SessionID: impersonation.SessionID})
+	if err != nil {
+		// the session may be revoked already, e.g. by the user
+		if e, ok := err.(transport.Error); !ok || e.Code != transport.ErrorCodeNotFound {
+			return nil, err
+		}
+	}
+	_, err = s.audit.Record(ctx, service.RecordAuditRequest{
+		Action: "impersonation.end",
+		Detail: fmt.Sprintf("impersonation %d of user %d", impersonation.ID, impersonation.UserID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &service.EmptyResponse{}, nil
+}
+
+// admin is the user of the context, impersonation can't be started from an API key or another impersonation.
+func (s impersonationServiceImpl) admin(ctx context.Context) (*auth.Principal, error) {
+	principal, ok := auth.FromContext(ctx)
+	if !ok || principal.Type != auth.PrincipalUser {
+		return nil, transport.Error{Msg: "impersonation requires a user", Code: transport.ErrorCodePermissionDenied, Key: keyImpersonationUserRequired}
+	}
+	if principal.Impersonator != nil {
+		return nil, transport.Error{Msg: "impersonation can not be nested", Code: transport.ErrorCodePermissionDenied, Key: keyImpersonationNested}
+	}
+	return principal, nil
+}
@@ -0,0 +1,122 @@
+package impl
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/assert"
+	"regexp"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/token"
+)
+
+// fakeAuditService keeps the recorded actions with the principal they were recorded for.
+type fakeAuditService struct {
+	service.AuditService
+	records []string
+}
+
+func (f *fakeAuditService) Record(ctx context.Context, request service.RecordAuditRequest) (*service.EmptyResponse, error) {
+	record := request.Action
+	if principal, ok := auth.FromContext(ctx); ok {
+		record += " " + principal.String()
+	}
+	f.records = append(f.records, record)
+	return &service.EmptyResponse{}, nil
+}
+
+func initImpersonationMock(t *testing.T) (impersonationServiceImpl, authServiceImpl, userMock, *fakeAuditService) {
+	authSvc, s, _, _ := initAuthMock(t)
+	audit := &fakeAuditService{}
+	svc, err := NewImpersonationServiceImpl(s.svc.db, s.svc.log, authSvc.tokens, authSvc.sessions, audit, ImpersonationConfig{TTL: 30 * time.Second})
+	assert.NilError(t, err)
+	return svc.(impersonationServiceImpl), authSvc, s, audit
+}
+
+func TestImpersonationServiceImpl_Impersonate(t *testing.T) {
+	svc, authSvc, s, audit := initImpersonationMock(t)
+	admin := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "9", Role: model.RoleAdmin})
+
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id = ?)")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(s.userData[0])...))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `impersonations`")).WillReturnResult(sqlmock.NewResult(5, 1))
+	s.mock.ExpectCommit()
+
+	res, err := svc.Impersonate(admin, service.ImpersonateRequest{UserID: 1, Reason: "ticket 42"})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+	assert.Equal(t, res.Impersonation.ID, model.ImpersonationID(5))
+	assert.Equal(t, res.Impersonation.AdminID, model.UserID(9))
+	assert.Equal(t, res.ExpiresIn, 30)
+	assert.DeepEqual(t, audit.records, []string{"impersonation.start USER:9"})
+
+	claims, err := svc.tokens.Parse(res.AccessToken, token.TypeAccess)
+	assert.NilError(t, err)
+	assert.Equal(t, claims.Scope, token.ScopeImpersonation)
+	assert.Equal(t, claims.Actor.Subject, "9")
+
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id = ?)")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(s.userData[0])...))
+	principal, err := authSvc.Authenticate(context.Background(), service.AuthenticateTokenRequest{Token: res.AccessToken})
+	assert.NilError(t, err)
+	assert.Equal(t, principal.String(), "USER:1 (impersonated by USER:9)")
+	assert.Equal(t, principal.Role, model.RoleUser)
+
+	// nested impersonation is refused
+	_, err = svc.Impersonate(auth.NewContext(context.Background(), principal), service.ImpersonateRequest{UserID: 2, Reason: "again"})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodePermissionDenied)
+
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `impersonations`  WHERE (id = ? AND admin_id = ?)")).
+		WithArgs(5, "9").
+		WillReturnRows(s.mock.NewRows([]string{"id", "admin_id", "user_id", "session_id"}).AddRow(5, 9, 1, claims.Session))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `impersonations` SET `ended_at` = ?  WHERE (id = ? AND ended_at IS NULL)")).
+		WithArgs(sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	_, err = svc.EndImpersonation(admin, service.EndImpersonationRequest{ImpersonationID: 5})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+	assert.DeepEqual(t, audit.records, []string{"impersonation.start USER:9", "impersonation.end USER:9"})
+
+	// the token is rejected with its session
+	_, err = authSvc.Authenticate(context.Background(), service.AuthenticateTokenRequest{Token: res.AccessToken})
+	assert.Error(t, err, errInvalidSession.Error())
+}
+
+func TestImpersonationServiceImpl_Impersonate_Denied(t *testing.T) {
+	svc, _, s, audit := initImpersonationMock(t)
+	admin := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "9", Role: model.RoleAdmin})
+
+	roleAdmin := model.RoleAdmin
+	other := s.userData[0]
+	other.ID = 3
+	other.Role = &roleAdmin
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id = ?)")).
+		WithArgs(3).
+		WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(other)...))
+
+	_, err := svc.Impersonate(admin, service.ImpersonateRequest{UserID: 3, Reason: "ticket 42"})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodePermissionDenied)
+
+	_, err = svc.Impersonate(admin, service.ImpersonateRequest{UserID: 9, Reason: "ticket 42"})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodeInvalidParameter)
+
+	_, err = svc.Impersonate(admin, service.ImpersonateRequest{UserID: 1, Reason: " "})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodeInvalidParameter)
+
+	apiKey := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalAPIKey, ID: "1"})
+	_, err = svc.Impersonate(apiKey, service.ImpersonateRequest{UserID: 1, Reason: "ticket 42"})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodePermissionDenied)
+
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+	assert.Equal(t, len(audit.records), 0)
+}
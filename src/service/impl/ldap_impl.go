@@ -0,0 +1,403 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/ldap"
+	log2 "user-service/src/service/util/log"
+	"user-service/src/service/util/pii"
+)
+
+const (
+	ldapFieldName   = "name"
+	ldapFieldEmail  = "email"
+	ldapFieldGender = "gender"
+	ldapFieldStatus = "status"
+)
+
+// keys of the errors of LDAPService
+const (
+	keyLDAPSyncFailed = "ldap.sync_failed"
+	keyLDAPBindFailed = "ldap.bind_failed"
+)
+
+type LDAPConfig struct {
+	BaseDN     string
+	UserFilter string
+	// Attributes maps the fields of users (name, email and gender) to attributes of the directory,
+	// name is required and unmapped fields are left as they are
+	Attributes map[string]string
+}
+
+type ldapServiceImpl struct {
+	db        *gorm.DB
+	log       *log2.Logger
+	directory ldap.Directory
+	sessions  service.SessionService
+	config    LDAPConfig
+	now       func() time.Time
+}
+
+func NewLDAPServiceImpl(db *gorm.DB, log *log2.Logger, directory ldap.Directory, sessions service.SessionService,
+	config LDAPConfig) (service.LDAPService, error) {
+	if len(config.Attributes[ldapFieldName]) == 0 {
+		return nil, errors.New("ldap attribute of name is required")
+	}
+	for field := range config.Attributes {
+		if field != ldapFieldName && field != ldapFieldEmail && field != ldapFieldGender {
+			return nil, errors.Errorf("unknown user field %s in ldap attributes", field)
+		}
+	}
+
+	src := ldapServiceImpl{
+		db:        db,
+		log:       log,
+		directory: directory,
+		sessions:  sessions,
+		config:    config,
+		now:       time.Now,
+	}
+
+	return src, nil
+}
+
+func (s ldapServiceImpl) Sync(ctx context.Context, request service.LDAPSyncRequest) (*service.LDAPSyncResponse, error) {
+	var attributes []string
+	for _, attribute := range s.config.Attributes {
+		attributes = append(attributes, attribute)
+	}
+	entries, err := s.directory.Search(ctx, s.config.BaseDN, s.config.UserFilter, attributes)
+	if err != nil {
+		e := transport.Error{Msg: "can't search ldap users", Code: transport.ErrorCodeInternal, Key: keyLDAPSyncFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	var links []model.LDAPUser
+	if err := s.db.Find(&links).Error; err != nil {
+		e := transport.Error{Msg: "error when get ldap users", Code: transport.ErrorCodeInternal, Key: keyLDAPSyncFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	// an empty result is more likely a wrong base dn or filter than a directory without users
+	if len(entries) == 0 && len(links) > 0 {
+		msg := fmt.Sprintf("ldap search of %s returned no users, refusing to deactivate %d users", s.config.BaseDN, len(links))
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal, Key: keyLDAPSyncFailed}
+	}
+
+	linksByDN := map[string]model.LDAPUser{}
+	linksByUser := map[model.UserID]model.LDAPUser{}
+	var userIDs []model.UserID
+	for _, link := range links {
+		linksByDN[strings.ToLower(link.DN)] = link
+		linksByUser[link.UserID] = link
+		userIDs = append(userIDs, link.UserID)
+	}
+	users, err := s.getUsers(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &service.LDAPSyncResponse{DryRun: request.DryRun}
+	now := s.now()
+	seen := map[model.UserID]bool{}
+	for _, entry := range entries {
+		mapped := s.mapEntry(entry)
+		change := service.LDAPUserChange{DN: entry.DN, Name: mapped.Name}
+		if len(mapped.Name) == 0 {
+			change.Error = fmt.Sprintf("attribute %s is missing", s.config.Attributes[ldapFieldName])
+			res.Failed = append(res.Failed, change)
+			continue
+		}
+
+		link, linked := linksByDN[strings.ToLower(entry.DN)]
+		var user *model.User
+		if linked {
+			if u, ok := users[link.UserID]; ok {
+				user = &u
+			}
+		} else {
+			// a user with the same name is linked to the entry only when linkConflict finds nothing against it
+			if user, err = s.getUserByName(mapped.Name); err != nil {
+				return nil, err
+			}
+			if user != nil {
+				if other, ok := linksByUser[user.ID]; ok {
+					change.Error = fmt.Sprintf("name belongs to user %d linked to %s", user.ID, other.DN)
+					res.Failed = append(res.Failed, change)
+					continue
+				}
+				conflict, err := s.linkConflict(*user, mapped)
+				if err != nil {
+					return nil, err
+				}
+				if len(conflict) > 0 {
+					change.Error = conflict
+					res.Failed = append(res.Failed, change)
+					continue
+				}
+			}
+		}
+
+		if user == nil {
+			change.Fields = s.diff(model.User{}, mapped, nil)
+			if !request.DryRun {
+				if change.UserID, err = s.createUser(mapped, entry.DN, now); err != nil {
+					change.Error = err.Error()
+					res.Failed = append(res.Failed, change)
+					continue
+				}
+			}
+			res.Created = append(res.Created, change)
+			continue
+		}
+
+		seen[user.ID] = true
+		change.UserID = user.ID
+		var deactivatedAt *time.Time
+		if linked {
+			deactivatedAt = link.DeactivatedAt
+		}
+		change.Fields = s.diff(*user, mapped, deactivatedAt)
+		if len(change.Fields) == 0 && linked {
+			res.Unchanged++
+			continue
+		}
+		if !request.DryRun {
+			if err := s.updateUser(user.ID, change.Fields, entry.DN, linked, now); err != nil {
+				change.Error = err.Error()
+				res.Failed = append(res.Failed, change)
+				continue
+			}
+		}
+		res.Updated = append(res.Updated, change)
+	}
+
+	for _, link := range links {
+		user, ok := users[link.UserID]
+		if seen[link.UserID] || !ok || (user.Status != nil && *user.Status != model.StatusActive) {
+			continue
+		}
+		change := service.LDAPUserChange{
+			DN:     link.DN,
+			UserID: link.UserID,
+			Name:   user.Name,
+			Fields: map[string]string{ldapFieldStatus: string(model.StatusInactive)},
+		}
+		if !request.DryRun {
+			if err := s.deactivateUser(ctx, link.UserID, now); err != nil {
+				change.Error = err.Error()
+				res.Failed = append(res.Failed, change)
+				continue
+			}
+		}
+		res.Deactivated = append(res.Deactivated, change)
+	}
+
+	if !request.DryRun {
+		var seenIDs []model.UserID
+		for id := range seen {
+			seenIDs = append(seenIDs, id)
+		}
+		if len(seenIDs) > 0 {
+			if err := s.db.Model(&model.LDAPUser{}).Where("user_id IN (?)", seenIDs).Update("synced_at", now).Error; err != nil {
+				s.log.Warn(fmt.Sprintf("can't update synced_at of ldap users: %v", err))
+			}
+		}
+	}
+
+	s.log.Info(fmt.Sprintf("ldap sync (dry run: %t): %d created, %d updated, %d deactivated, %d failed, %d unchanged",
+		request.DryRun, len(res.Created), len(res.Updated), len(res.Deactivated), len(res.Failed), res.Unchanged))
+	return res, nil
+}
+
+func (s ldapServiceImpl) Bind(ctx context.Context, request service.LDAPBindRequest) (*service.LDAPBindResponse, error) {
+	var link model.LDAPUser
+	if err := s.db.Where("user_id = ?", request.UserID).Find(&link).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return &service.LDAPBindResponse{}, nil
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get ldap user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyLDAPBindFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	if err := s.directory.Bind(ctx, link.DN, request.Password); err != nil {
+		if err == ldap.ErrInvalidCredentials {
+			return &service.LDAPBindResponse{Linked: true}, nil
+		}
+		e := transport.Error{Msg: fmt.Sprintf("can't bind ldap user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyLDAPBindFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	return &service.LDAPBindResponse{Linked: true, Authenticated: true}, nil
+}
+
+// mapEntry only sets the mapped fields, genders other than MALE and FEMALE are ignored.
+func (s ldapServiceImpl) mapEntry(entry ldap.Entry) model.User {
+	user := model.User{Name: strings.TrimSpace(entry.Get(s.config.Attributes[ldapFieldName]))}
+	if attribute, ok := s.config.Attributes[ldapFieldEmail]; ok {
+		if email := strings.TrimSpace(entry.Get(attribute)); len(email) > 0 {
+			user.Email = &email
+		}
+	}
+	if attribute, ok := s.config.Attributes[ldapFieldGender]; ok {
+		if gender := model.Gender(strings.ToUpper(entry.Get(attribute))); gender.IsValid() {
+			user.Gender = gender
+		}
+	}
+	return user
+}
+
+// diff returns the fields of user which differ from the directory, a user deactivated by the sync is activated again.
+func (s ldapServiceImpl) diff(user model.User, mapped model.User, deactivatedAt *time.Time) map[string]string {
+	fields := map[string]string{}
+	if user.Name != mapped.Name {
+		fields[ldapFieldName] = mapped.Name
+	}
+	if _, ok := s.config.Attributes[ldapFieldEmail]; ok && !equalStrings(user.Email, mapped.Email) {
+		fields[ldapFieldEmail] = ""
+		if mapped.Email != nil {
+			fields[ldapFieldEmail] = *mapped.Email
+		}
+	}
+	if len(mapped.Gender) > 0 && user.Gender != mapped.Gender {
+		fields[ldapFieldGender] = string(mapped.Gender)
+	}
+	if deactivatedAt != nil {
+		fields[ldapFieldStatus] = string(model.StatusActive)
+	}
+	return fields
+}
+
+func (s ldapServiceImpl) getUsers(ids []model.UserID) (map[model.UserID]model.User, error) {
+	users := map[model.UserID]model.User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+	var list []model.User
+	if err := s.db.Where("id IN (?)", ids).Find(&list).Error; err != nil {
+		e := transport.Error{Msg: "error when get users linked to ldap", Code: transport.ErrorCodeInternal, Key: keyLDAPSyncFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	for _, user := range list {
+		users[user.ID] = user
+	}
+	return users, nil
+}
+
+func (s ldapServiceImpl) getUserByName(name string) (*model.User, error) {
+	var user model.User
+	if err := pii.Where(s.db, "name", name).Find(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		e := transport.Error{Msg: "error when get user by name", Code: transport.ErrorCodeInternal, Key: keyLDAPSyncFailed}.Wrap(err)
+		s.log.Error(log2.Sprintf("error when get user %s: %v", log2.Sensitive{Value: name}, err))
+		return nil, e
+	}
+	return &user, nil
+}
+
+// linkConflict tells why a local user can't be linked to the entry with its name. Once linked, the password of the
+// user is checked by the directory only, so whoever can add entries would take the account over: ADMIN users and
+// users with a local password are never linked, the others only when their verified email is the one of the entry.
+func (s ldapServiceImpl) linkConflict(user model.User, mapped model.User) (string, error) {
+	if user.Role != nil && *user.Role == model.RoleAdmin {
+		return fmt.Sprintf("name belongs to ADMIN user %d", user.ID), nil
+	}
+	if user.EmailVerifiedAt == nil || user.Email == nil || mapped.Email == nil || !strings.EqualFold(*user.Email, *mapped.Email) {
+		return fmt.Sprintf("name belongs to user %d whose verified email isn't the one of the entry", user.ID), nil
+	}
+	var count int
+	if err := s.db.Model(&model.Credential{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("error when get credentials of user %d", user.ID), Code: transport.ErrorCodeInternal, Key: keyLDAPSyncFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return "", e
+	}
+	if count > 0 {
+		return fmt.Sprintf("name belongs to user %d who has a local password", user.ID), nil
+	}
+	return "", nil
+}
+
+// createUser trusts the directory, so the email is verified.
+func (s ldapServiceImpl) createUser(user model.User, dn string, now time.Time) (model.UserID, error) {
+	if user.Email != nil {
+		user.EmailVerifiedAt = &now
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("id").Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.LDAPUser{UserID: user.ID, DN: dn, SyncedAt: now}).Error
+	})
+	if err != nil {
+		s.log.Error(fmt.Sprintf("can't create user of ldap entry %s: %v", dn, err))
+		return 0, errors.New("can't create user")
+	}
+	s.log.Info(fmt.Sprintf("user %d is created from ldap entry %s", user.ID, dn))
+	return user.ID, nil
+}
+
+func (s ldapServiceImpl) updateUser(id model.UserID, fields map[string]string, dn string, linked bool, now time.Time) error {
+	updates := map[string]interface{}{}
+	for field, value := range fields {
+		updates[field] = value
+	}
+	if email, ok := fields[ldapFieldEmail]; ok {
+		updates["email_verified_at"] = now
+		if len(email) == 0 {
+			updates[ldapFieldEmail] = nil
+			updates["email_verified_at"] = nil
+		}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := tx.Model(&model.User{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if !linked {
+			return tx.Create(&model.LDAPUser{UserID: id, DN: dn, SyncedAt: now}).Error
+		}
+		if _, ok := fields[ldapFieldStatus]; ok {
+			return tx.Model(&model.LDAPUser{}).Where("user_id = ?", id).Update("deactivated_at", nil).Error
+		}
+		return nil
+	})
+	if err != nil {
+		s.log.Error(fmt.Sprintf("can't update user %d from ldap entry %s: %v", id, dn, err))
+		return errors.New("can't update user")
+	}
+	return nil
+}
+
+// deactivateUser also revokes the sessions, a user who left the directory must not keep using the service.
+func (s ldapServiceImpl) deactivateUser(ctx context.Context, id model.UserID, now time.Time) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("id = ?", id).Update("status", model.StatusInactive).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.LDAPUser{}).Where("user_id = ?", id).Update("deactivated_at", now).Error
+	})
+	if err != nil {
+		s.log.Error(fmt.Sprintf("can't deactivate user %d missing from ldap: %v", id, err))
+		return errors.New("can't deactivate user")
+	}
+	if _, err := s.sessions.RevokeAllSessions(ctx, service.RevokeAllSessionsRequest{UserID: id}); err != nil {
+		return err
+	}
+	s.log.Info(fmt.Sprintf("user %d is deactivated, it is missing from ldap", id))
+	return nil
+}
@@ -0,0 +1,228 @@
+package impl
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/assert"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/util/ldap"
+)
+
+type fakeSessionRevoker struct {
+	service.SessionService
+	revoked []model.UserID
+}
+
+func (f *fakeSessionRevoker) RevokeAllSessions(_ context.Context, request service.RevokeAllSessionsRequest) (*service.RevokeSessionsResponse, error) {
+	f.revoked = append(f.revoked, request.UserID)
+	return &service.RevokeSessionsResponse{}, nil
+}
+
+func initLDAPMock(t *testing.T) (ldapServiceImpl, userMock, *ldap.MemoryDirectory, *fakeSessionRevoker) {
+	s := initUserMock()
+	directory := ldap.NewMemoryDirectory()
+	directory.Add("uid=alice,ou=people,dc=example,dc=com", "alice-secret", map[string][]string{
+		"objectClass": {"inetOrgPerson"}, "uid": {"alice"}, "mail": {"alice@example.com"},
+	})
+	directory.Add("uid=bob,ou=people,dc=example,dc=com", "", map[string][]string{
+		"objectClass": {"inetOrgPerson"}, "uid": {"bob"}, "mail": {"bob@new.example.com"},
+	})
+	directory.Add("uid=printer,ou=people,dc=example,dc=com", "", map[string][]string{
+		"objectClass": {"device"}, "uid": {"printer"},
+	})
+
+	sessions := &fakeSessionRevoker{}
+	svc, err := NewLDAPServiceImpl(s.svc.db, s.svc.log, directory, sessions, LDAPConfig{
+		BaseDN:     "ou=people,dc=example,dc=com",
+		UserFilter: "(objectClass=inetOrgPerson)",
+		Attributes: map[string]string{"name": "uid", "email": "mail"},
+	})
+	assert.NilError(t, err)
+	src := svc.(ldapServiceImpl)
+	src.now = func() time.Time { return time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return src, s, directory, sessions
+}
+
+// expectLinks links bob (2) and carol (3), carol is no longer in the directory.
+func expectLinks(s userMock) {
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `ldap_users`")).
+		WillReturnRows(s.mock.NewRows([]string{"user_id", "dn", "synced_at", "deactivated_at"}).
+			AddRow(2, "uid=bob,ou=people,dc=example,dc=com", time.Now(), nil).
+			AddRow(3, "uid=carol,ou=people,dc=example,dc=com", time.Now(), nil))
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id IN (?,?))")).
+		WithArgs(2, 3).
+		WillReturnRows(s.mock.NewRows(s.userColumn).
+			AddRow(2, "bob", "MALE", "ACTIVE", "USER", "bob@example.com", time.Now(), nil).
+			AddRow(3, "carol", "FEMALE", "ACTIVE", "USER", "carol@example.com", time.Now(), nil))
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (name = ?)")).
+		WithArgs("alice").
+		WillReturnRows(s.mock.NewRows(s.userColumn))
+}
+
+func TestLDAPServiceImpl_Sync_DryRun(t *testing.T) {
+	svc, s, _, sessions := initLDAPMock(t)
+	expectLinks(s)
+
+	res, err := svc.Sync(context.Background(), service.LDAPSyncRequest{DryRun: true})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+
+	assert.DeepEqual(t, res, &service.LDAPSyncResponse{
+		DryRun: true,
+		Created: []service.LDAPUserChange{{
+			DN:     "uid=alice,ou=people,dc=example,dc=com",
+			Name:   "alice",
+			Fields: map[string]string{"name": "alice", "email": "alice@example.com"},
+		}},
+		Updated: []service.LDAPUserChange{{
+			DN:     "uid=bob,ou=people,dc=example,dc=com",
+			UserID: 2,
+			Name:   "bob",
+			Fields: map[string]string{"email": "bob@new.example.com"},
+		}},
+		Deactivated: []service.LDAPUserChange{{
+			DN:     "uid=carol,ou=people,dc=example,dc=com",
+			UserID: 3,
+			Name:   "carol",
+			Fields: map[string]string{"status": "INACTIVE"},
+		}},
+	})
+	assert.Equal(t, len(sessions.revoked), 0)
+}
+
+func TestLDAPServiceImpl_Sync(t *testing.T) {
+	svc, s, _, sessions := initLDAPMock(t)
+	expectLinks(s)
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `users`")).WillReturnResult(sqlmock.NewResult(4, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `ldap_users`")).
+		WithArgs(4, "uid=alice,ou=people,dc=example,dc=com", svc.now(), nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `users` SET `email` = ?, `email_verified_at` = ?  WHERE (id = ?)")).
+		WithArgs("bob@new.example.com", svc.now(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `users` SET `status` = ?  WHERE (id = ?)")).
+		WithArgs(model.StatusInactive, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `ldap_users` SET `deactivated_at` = ?  WHERE (user_id = ?)")).
+		WithArgs(svc.now(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `ldap_users` SET `synced_at` = ?  WHERE (user_id IN (?))")).
+		WithArgs(svc.now(), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	res, err := svc.Sync(context.Background(), service.LDAPSyncRequest{})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+	assert.Equal(t, res.Created[0].UserID, model.UserID(4))
+	assert.Equal(t, len(res.Updated), 1)
+	assert.Equal(t, len(res.Deactivated), 1)
+	assert.Equal(t, len(res.Failed), 0)
+	assert.DeepEqual(t, sessions.revoked, []model.UserID{3})
+}
+
+func TestLDAPServiceImpl_Sync_EmptyDirectory(t *testing.T) {
+	svc, s, directory, _ := initLDAPMock(t)
+	directory.Remove("uid=alice,ou=people,dc=example,dc=com")
+	directory.Remove("uid=bob,ou=people,dc=example,dc=com")
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `ldap_users`")).
+		WillReturnRows(s.mock.NewRows([]string{"user_id", "dn", "synced_at", "deactivated_at"}).
+			AddRow(2, "uid=bob,ou=people,dc=example,dc=com", time.Now(), nil))
+
+	_, err := svc.Sync(context.Background(), service.LDAPSyncRequest{})
+	assert.ErrorContains(t, err, "refusing to deactivate 1 users")
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestLDAPServiceImpl_Bind(t *testing.T) {
+	svc, s, _, _ := initLDAPMock(t)
+	linkColumns := []string{"user_id", "dn", "synced_at", "deactivated_at"}
+
+	tests := []struct {
+		name     string
+		password string
+		linked   bool
+		want     service.LDAPBindResponse
+	}{
+		{name: "not linked", password: "alice-secret", want: service.LDAPBindResponse{}},
+		{name: "wrong password", password: "wrong", linked: true, want: service.LDAPBindResponse{Linked: true}},
+		{name: "success", password: "alice-secret", linked: true, want: service.LDAPBindResponse{Linked: true, Authenticated: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := s.mock.NewRows(linkColumns)
+			if tt.linked {
+				rows.AddRow(1, "uid=alice,ou=people,dc=example,dc=com", time.Now(), nil)
+			}
+			s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `ldap_users`  WHERE (user_id = ?)")).
+				WithArgs(1).
+				WillReturnRows(rows)
+
+			res, err := svc.Bind(context.Background(), service.LDAPBindRequest{UserID: 1, Password: tt.password})
+			assert.NilError(t, err)
+			assert.DeepEqual(t, *res, tt.want)
+		})
+	}
+}
+
+func TestLDAPServiceImpl_Sync_LinkExistingUser(t *testing.T) {
+	verified := time.Now()
+	tests := []struct {
+		name        string
+		role        model.Role
+		email       string
+		verifiedAt  *time.Time
+		credentials int
+		conflict    string
+	}{
+		{name: "admin", role: model.RoleAdmin, email: "alice@example.com", verifiedAt: &verified, conflict: "ADMIN"},
+		{name: "unverified email", role: model.RoleUser, email: "alice@example.com", conflict: "verified email"},
+		{name: "other email", role: model.RoleUser, email: "alice@other.example.com", verifiedAt: &verified, conflict: "verified email"},
+		{name: "local password", role: model.RoleUser, email: "alice@example.com", verifiedAt: &verified, credentials: 1, conflict: "local password"},
+		{name: "linked", role: model.RoleUser, email: "ALICE@example.com", verifiedAt: &verified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, s, directory, _ := initLDAPMock(t)
+			directory.Remove("uid=bob,ou=people,dc=example,dc=com")
+			s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `ldap_users`")).
+				WillReturnRows(s.mock.NewRows([]string{"user_id", "dn", "synced_at", "deactivated_at"}))
+			s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (name = ?)")).
+				WithArgs("alice").
+				WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(5, "alice", "FEMALE", "ACTIVE", tt.role, tt.email, tt.verifiedAt, nil))
+			if tt.role != model.RoleAdmin && tt.verifiedAt != nil && tt.email != "alice@other.example.com" {
+				s.mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `credentials`  WHERE (user_id = ?)")).
+					WithArgs(5).
+					WillReturnRows(s.mock.NewRows([]string{"count(*)"}).AddRow(tt.credentials))
+			}
+
+			res, err := svc.Sync(context.Background(), service.LDAPSyncRequest{DryRun: true})
+			assert.NilError(t, err)
+			assert.NilError(t, s.mock.ExpectationsWereMet())
+			if len(tt.conflict) > 0 {
+				assert.Equal(t, len(res.Failed), 1)
+				assert.Assert(t, strings.Contains(res.Failed[0].Error, tt.conflict))
+				return
+			}
+			assert.Equal(t, len(res.Failed), 0)
+			assert.Equal(t, res.Updated[0].UserID, model.UserID(5))
+		})
+	}
+}
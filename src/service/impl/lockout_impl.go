@@ -0,0 +1,347 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/metrics"
+	"github.com/jinzhu/gorm"
+	"strconv"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	log2 "user-service/src/service/util/log"
+)
+
+// LockoutPolicy locks an account or an address for Duration after Threshold failures, a Threshold of 0
+// disables the tracking.
+type LockoutPolicy struct {
+	Threshold int
+	Duration  time.Duration
+}
+
+type LockoutConfig struct {
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+	// Delay is the wait after a failure, it doubles with every further failure up to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+	Account  LockoutPolicy
+	IP       LockoutPolicy
+}
+
+type LockoutMetrics struct {
+	Failures     metrics.Counter
+	Throttled    metrics.Counter
+	AccountLocks metrics.Counter
+	IPLocks      metrics.Counter
+	Unlocks      metrics.Counter
+}
+
+// keys of the errors of LockoutService
+const (
+	keyLockoutThrottled     = "lockout.throttled"
+	keyLockoutAccountLocked = "lockout.account_locked"
+	keyLockoutIPLocked      = "lockout.ip_locked"
+	keyLockoutRecordFailed  = "lockout.record_failed"
+	keyLockoutCheckFailed   = "lockout.check_failed"
+	keyLockoutResetFailed   = "lockout.reset_failed"
+	keyLockoutUnlockFailed  = "lockout.unlock_failed"
+	keyLockoutEventsFailed  = "lockout.events_failed"
+)
+
+type lockoutServiceImpl struct {
+	db      *gorm.DB
+	log     *log2.Logger
+	config  LockoutConfig
+	metrics LockoutMetrics
+	now     func() time.Time
+}
+
+type lockoutSubject struct {
+	scope   model.LockoutScope
+	subject string
+	policy  LockoutPolicy
+}
+
+const lockoutEventsLimit = 20
+
+func NewLockoutServiceImpl(db *gorm.DB, log *log2.Logger, config LockoutConfig, metrics LockoutMetrics) (service.LockoutService, error) {
+	src := lockoutServiceImpl{
+		db:      db,
+		log:     log,
+		config:  config,
+		metrics: metrics,
+		now:     time.Now,
+	}
+
+	return src, nil
+}
+
+func (s lockoutServiceImpl) CheckLogin(_ context.Context, request service.LoginAttemptRequest) error {
+	now := s.now()
+	for _, subject := range s.subjects(request) {
+		failure, err := s.getFailure(subject.scope, subject.subject)
+		if err != nil {
+			return err
+		}
+		if failure == nil {
+			continue
+		}
+
+		if failure.LockedUntil != nil {
+			if now.Before(*failure.LockedUntil) {
+				s.metrics.Throttled.Add(1)
+				return lockedError(subject.scope, failure.LockedUntil.Sub(now))
+			}
+			// the lock is over, the next failure starts a new count
+			continue
+		}
+		if now.Sub(failure.LastFailureAt) > s.config.Window {
+			continue
+		}
+		if wait := failure.LastFailureAt.Add(s.config.delay(failure.Failures)).Sub(now); wait > 0 {
+			s.metrics.Throttled.Add(1)
+			return transport.Error{
+				Msg:    fmt.Sprintf("too many failed logins, retry in %s", roundUp(wait)),
+				Code:   transport.ErrorCodeTooManyRequests,
+				Key:    keyLockoutThrottled,
+				Params: map[string]interface{}{"wait": roundUp(wait)},
+			}
+		}
+	}
+	return nil
+}
+
+func (s lockoutServiceImpl) RecordLoginFailure(_ context.Context, request service.LoginAttemptRequest) error {
+	now := s.now()
+	s.metrics.Failures.Add(1)
+	for _, subject := range s.subjects(request) {
+		// failures older than the window or from before an expired lock aren't counted, the assignments are
+		// evaluated in order so failures must be computed before locked_until and last_failure_at change
+		err := s.db.Exec("INSERT INTO `login_failures` (`scope`, `subject`, `failures`, `last_failure_at`) VALUES (?, ?, 1, ?) "+
+			"ON DUPLICATE KEY UPDATE "+
+			"`failures` = IF(`last_failure_at` < ? OR `locked_until` <= ?, 1, `failures` + 1), "+
+			"`locked_until` = IF(`locked_until` <= ?, NULL, `locked_until`), "+
+			"`last_failure_at` = VALUES(`last_failure_at`)",
+			subject.scope, subject.subject, now, now.Add(-s.config.Window), now, now).Error
+		if err != nil {
+			e := transport.Error{Msg: fmt.Sprintf("can't record failed login of %s %s", subject.scope, subject.subject), Code: transport.ErrorCodeInternal, Key: keyLockoutRecordFailed}.Wrap(err)
+			s.log.Error(e.Error())
+			return e
+		}
+
+		failure, err := s.getFailure(subject.scope, subject.subject)
+		if err != nil {
+			return err
+		}
+		if failure == nil || failure.Failures < subject.policy.Threshold {
+			continue
+		}
+		if err := s.lock(subject, failure.Failures, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s lockoutServiceImpl) RecordLoginSuccess(_ context.Context, request service.LoginAttemptRequest) error {
+	if request.UserID == nil || s.config.Account.Threshold <= 0 {
+		return nil
+	}
+	err := s.db.Where("scope = ? AND subject = ?", model.LockoutScopeAccount, userSubject(*request.UserID)).
+		Delete(&model.LoginFailure{}).Error
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can't reset failed logins of user %d", *request.UserID), Code: transport.ErrorCodeInternal, Key: keyLockoutResetFailed, Params: userParams(*request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return e
+	}
+	return nil
+}
+
+func (s lockoutServiceImpl) GetLockout(_ context.Context, request service.GetLockoutRequest) (*service.LockoutResponse, error) {
+	if err := s.checkUser(request.UserID); err != nil {
+		return nil, err
+	}
+	return s.lockout(request.UserID)
+}
+
+func (s lockoutServiceImpl) UnlockUser(ctx context.Context, request service.UnlockUserRequest) (*service.LockoutResponse, error) {
+	if err := s.checkUser(request.UserID); err != nil {
+		return nil, err
+	}
+
+	subject := userSubject(request.UserID)
+	failure, err := s.getFailure(model.LockoutScopeAccount, subject)
+	if err != nil {
+		return nil, err
+	}
+	if failure != nil {
+		if err := s.db.Where("scope = ? AND subject = ?", model.LockoutScopeAccount, subject).Delete(&model.LoginFailure{}).Error; err != nil {
+			e := transport.Error{Msg: fmt.Sprintf("can't unlock user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyLockoutUnlockFailed, Params: userParams(request.UserID)}.Wrap(err)
+			s.log.Error(e.Error())
+			return nil, e
+		}
+	}
+
+	now := s.now()
+	if failure != nil && failure.LockedUntil != nil && now.Before(*failure.LockedUntil) {
+		var actor string
+		if principal, ok := auth.FromContext(ctx); ok {
+			actor = fmt.Sprintf("%s:%s", principal.Type, principal.ID)
+		}
+		event := model.LockoutEvent{
+			Scope:     model.LockoutScopeAccount,
+			Subject:   subject,
+			Event:     model.LockoutEventUnlocked,
+			Actor:     actor,
+			CreatedAt: now,
+		}
+		if err := s.db.Create(&event).Error; err != nil {
+			e := transport.Error{Msg: fmt.Sprintf("can't record unlock of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyLockoutUnlockFailed, Params: userParams(request.UserID)}.Wrap(err)
+			s.log.Error(e.Error())
+			return nil, e
+		}
+		s.metrics.Unlocks.Add(1)
+		s.log.Info(fmt.Sprintf("user %d is unlocked by %s", request.UserID, actor))
+	}
+
+	return s.lockout(request.UserID)
+}
+
+// lock is conditional so concurrent failures reaching the threshold record a single event.
+func (s lockoutServiceImpl) lock(subject lockoutSubject, failures int, now time.Time) error {
+	lockedUntil := now.Add(subject.policy.Duration)
+	result := s.db.Model(&model.LoginFailure{}).
+		Where("scope = ? AND subject = ? AND (locked_until IS NULL OR locked_until <= ?)", subject.scope, subject.subject, now).
+		Update("locked_until", lockedUntil)
+	if result.Error != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can't lock %s %s", subject.scope, subject.subject), Code: transport.ErrorCodeInternal, Key: keyLockoutRecordFailed}.Wrap(result.Error)
+		s.log.Error(e.Error())
+		return e
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	event := model.LockoutEvent{
+		Scope:       subject.scope,
+		Subject:     subject.subject,
+		Event:       model.LockoutEventLocked,
+		LockedUntil: &lockedUntil,
+		CreatedAt:   now,
+	}
+	if err := s.db.Create(&event).Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can't record lock of %s %s", subject.scope, subject.subject), Code: transport.ErrorCodeInternal, Key: keyLockoutRecordFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return e
+	}
+
+	if subject.scope == model.LockoutScopeAccount {
+		s.metrics.AccountLocks.Add(1)
+	} else {
+		s.metrics.IPLocks.Add(1)
+	}
+	s.log.Warn(fmt.Sprintf("%s %s is locked until %s after %d failed logins", subject.scope, subject.subject,
+		lockedUntil.Format(time.RFC3339), failures))
+	return nil
+}
+
+func (s lockoutServiceImpl) lockout(userID model.UserID) (*service.LockoutResponse, error) {
+	subject := userSubject(userID)
+	failure, err := s.getFailure(model.LockoutScopeAccount, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []model.LockoutEvent
+	err = s.db.Where("scope = ? AND subject = ?", model.LockoutScopeAccount, subject).
+		Order("id desc").Limit(lockoutEventsLimit).Find(&events).Error
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("error when get lockout events of user %d", userID), Code: transport.ErrorCodeInternal, Key: keyLockoutEventsFailed, Params: userParams(userID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	response := &service.LockoutResponse{Events: events}
+	now := s.now()
+	if failure != nil && now.Sub(failure.LastFailureAt) <= s.config.Window {
+		response.Failures = failure.Failures
+	}
+	if failure != nil && failure.LockedUntil != nil && now.Before(*failure.LockedUntil) {
+		response.Locked = true
+		response.LockedUntil = failure.LockedUntil
+	}
+	return response, nil
+}
+
+func (s lockoutServiceImpl) checkUser(userID model.UserID) error {
+	var count int
+	if err := s.db.Model(&model.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("error when get user %d", userID), Code: transport.ErrorCodeInternal, Key: keyUserGetFailed, Params: userParams(userID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return e
+	}
+	if count == 0 {
+		msg := fmt.Sprintf("not found user %d", userID)
+		s.log.Error(msg)
+		return transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyUserNotFound, Params: userParams(userID)}
+	}
+	return nil
+}
+
+func (s lockoutServiceImpl) getFailure(scope model.LockoutScope, subject string) (*model.LoginFailure, error) {
+	var failure model.LoginFailure
+	if err := s.db.Where("scope = ? AND subject = ?", scope, subject).Find(&failure).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get failed logins of %s %s", scope, subject), Code: transport.ErrorCodeInternal, Key: keyLockoutCheckFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	return &failure, nil
+}
+
+func (s lockoutServiceImpl) subjects(request service.LoginAttemptRequest) []lockoutSubject {
+	var subjects []lockoutSubject
+	if request.UserID != nil && s.config.Account.Threshold > 0 {
+		subjects = append(subjects, lockoutSubject{scope: model.LockoutScopeAccount, subject: userSubject(*request.UserID), policy: s.config.Account})
+	}
+	if len(request.IP) > 0 && s.config.IP.Threshold > 0 {
+		subjects = append(subjects, lockoutSubject{scope: model.LockoutScopeIP, subject: request.IP, policy: s.config.IP})
+	}
+	return subjects
+}
+
+// delay is the wait after the given number of failures.
+func (c LockoutConfig) delay(failures int) time.Duration {
+	if failures <= 0 || c.Delay <= 0 {
+		return 0
+	}
+	delay := c.Delay
+	for i := 1; i < failures && delay < c.MaxDelay; i++ {
+		delay *= 2
+	}
+	if c.MaxDelay > 0 && delay > c.MaxDelay {
+		delay = c.MaxDelay
+	}
+	return delay
+}
+
+func lockedError(scope model.LockoutScope, wait time.Duration) error {
+	msg, key := fmt.Sprintf("account is locked after too many failed logins, retry in %s", roundUp(wait)), keyLockoutAccountLocked
+	if scope == model.LockoutScopeIP {
+		msg, key = fmt.Sprintf("too many failed logins from this address, retry in %s", roundUp(wait)), keyLockoutIPLocked
+	}
+	return transport.Error{Msg: msg, Code: transport.ErrorCodeTooManyRequests, Key: key, Params: map[string]interface{}{"wait": roundUp(wait)}}
+}
+
+func roundUp(d time.Duration) time.Duration {
+	return (d + time.Second - 1).Truncate(time.Second)
+}
+
+func userSubject(id model.UserID) string {
+	return strconv.Itoa(int(id))
+}
@@ -0,0 +1,155 @@
+package impl
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-kit/kit/metrics/generic"
+	"gotest.tools/assert"
+	"regexp"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+)
+
+var loginFailureColumns = []string{"scope", "subject", "failures", "last_failure_at", "locked_until"}
+
+func initLockoutMock(now time.Time) (lockoutServiceImpl, userMock) {
+	s := initUserMock()
+	svc := lockoutServiceImpl{
+		db:  s.svc.db,
+		log: s.svc.log,
+		config: LockoutConfig{
+			Window:   15 * time.Minute,
+			Delay:    time.Second,
+			MaxDelay: 8 * time.Second,
+			Account:  LockoutPolicy{Threshold: 3, Duration: 15 * time.Minute},
+			IP:       LockoutPolicy{Threshold: 0},
+		},
+		metrics: LockoutMetrics{
+			Failures:     generic.NewCounter("failures"),
+			Throttled:    generic.NewCounter("throttled"),
+			AccountLocks: generic.NewCounter("account_locks"),
+			IPLocks:      generic.NewCounter("ip_locks"),
+			Unlocks:      generic.NewCounter("unlocks"),
+		},
+		now: func() time.Time { return now },
+	}
+	return svc, s
+}
+
+func TestLockoutConfig_delay(t *testing.T) {
+	config := LockoutConfig{Delay: time.Second, MaxDelay: 5 * time.Second}
+	for failures, expected := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		assert.Equal(t, config.delay(failures), expected)
+	}
+}
+
+func TestLockoutServiceImpl_CheckLogin(t *testing.T) {
+	now := time.Now()
+	userID := model.UserID(1)
+	lockedUntil := now.Add(time.Minute)
+	expired := now.Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		failure []driver.Value
+		errCode transport.ResponseCode
+	}{
+		{
+			name: "no failure",
+		},
+		{
+			name:    "within delay",
+			failure: []driver.Value{"ACCOUNT", "1", 2, now.Add(-time.Second), nil},
+			errCode: transport.ErrorCodeTooManyRequests,
+		},
+		{
+			name:    "after delay",
+			failure: []driver.Value{"ACCOUNT", "1", 2, now.Add(-3 * time.Second), nil},
+		},
+		{
+			name:    "locked",
+			failure: []driver.Value{"ACCOUNT", "1", 3, now.Add(-time.Hour), lockedUntil},
+			errCode: transport.ErrorCodeTooManyRequests,
+		},
+		{
+			name:    "lock expired",
+			failure: []driver.Value{"ACCOUNT", "1", 3, now.Add(-time.Second), expired},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, s := initLockoutMock(now)
+			rows := s.mock.NewRows(loginFailureColumns)
+			if tt.failure != nil {
+				rows.AddRow(tt.failure...)
+			}
+			s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `login_failures`  WHERE (scope = ? AND subject = ?)")).
+				WithArgs(model.LockoutScopeAccount, "1").
+				WillReturnRows(rows)
+
+			err := svc.CheckLogin(context.Background(), service.LoginAttemptRequest{UserID: &userID, IP: "10.0.0.1"})
+			assert.NilError(t, s.mock.ExpectationsWereMet())
+			if tt.errCode != 0 {
+				assert.Equal(t, err.(transport.Error).Code, tt.errCode)
+				assert.Equal(t, svc.metrics.Throttled.(*generic.Counter).Value(), float64(1))
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestLockoutServiceImpl_RecordLoginFailure(t *testing.T) {
+	now := time.Now()
+	userID := model.UserID(1)
+
+	tests := []struct {
+		name     string
+		failures int
+		locked   bool
+	}{
+		{
+			name:     "below threshold",
+			failures: 2,
+		},
+		{
+			name:     "threshold reached",
+			failures: 3,
+			locked:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, s := initLockoutMock(now)
+			s.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `login_failures`")).
+				WithArgs(model.LockoutScopeAccount, "1", now, now.Add(-15*time.Minute), now, now).
+				WillReturnResult(sqlmock.NewResult(0, 2))
+			s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `login_failures`")).
+				WillReturnRows(s.mock.NewRows(loginFailureColumns).AddRow("ACCOUNT", "1", tt.failures, now, nil))
+			if tt.locked {
+				s.mock.ExpectBegin()
+				s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `login_failures` SET `locked_until` = ? WHERE (scope = ? AND subject = ? AND (locked_until IS NULL OR locked_until <= ?))")).
+					WithArgs(now.Add(15*time.Minute), model.LockoutScopeAccount, "1", now).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				s.mock.ExpectCommit()
+				s.mock.ExpectBegin()
+				s.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `lockout_events`")).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				s.mock.ExpectCommit()
+			}
+
+			// the address isn't tracked, its threshold is 0
+			err := svc.RecordLoginFailure(context.Background(), service.LoginAttemptRequest{UserID: &userID, IP: "10.0.0.1"})
+			assert.NilError(t, err)
+			assert.NilError(t, s.mock.ExpectationsWereMet())
+			locks := svc.metrics.AccountLocks.(*generic.Counter).Value()
+			assert.Equal(t, locks == 1, tt.locked)
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package impl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/encryption"
+	log2 "user-service/src/service/util/log"
+	"user-service/src/service/util/totp"
+)
+
+type MFAConfig struct {
+	Issuer        string
+	RequiredRoles []model.Role
+	// Skew is the number of time steps accepted before and after the current one.
+	Skew          int64
+	RecoveryCodes int
+}
+
+// keys of the errors of MFAService
+const (
+	keyMFAAlreadyEnrolled     = "mfa.already_enrolled"
+	keyMFASecretFailed        = "mfa.secret_failed"
+	keyMFAEnrollFailed        = "mfa.enroll_failed"
+	keyMFANoPendingEnrollment = "mfa.no_pending_enrollment"
+	keyMFAInvalidCode         = "mfa.invalid_code"
+	keyMFAConfirmFailed       = "mfa.confirm_failed"
+	keyMFACodeRequired        = "mfa.code_required"
+	keyMFADisableFailed       = "mfa.disable_failed"
+	keyMFANotEnrolled         = "mfa.not_enrolled"
+	keyMFARecoveryCodesFailed = "mfa.recovery_codes_failed"
+	keyMFAStatusFailed        = "mfa.status_failed"
+	keyMFAVerifyFailed        = "mfa.verify_failed"
+	keyMFAGetFailed           = "mfa.get_failed"
+)
+
+type mfaServiceImpl struct {
+	db     *gorm.DB
+	log    *log2.Logger
+	box    *encryption.AESGCM
+	config MFAConfig
+	now    func() time.Time
+}
+
+func NewMFAServiceImpl(db *gorm.DB, log *log2.Logger, box *encryption.AESGCM, config MFAConfig) (service.MFAService, error) {
+	src := mfaServiceImpl{
+		db:     db,
+		log:    log,
+		box:    box,
+		config: config,
+		now:    time.Now,
+	}
+
+	return src, nil
+}
+
+func (s mfaServiceImpl) EnrollTOTP(ctx context.Context, request service.EnrollTOTPRequest) (*service.EnrollTOTPResponse, error) {
+	if err := checkCredentialsTarget(ctx, s.db, request.UserID); err != nil {
+		return nil, err
+	}
+	user, err := s.getUser(request.UserID)
+	if err != nil {
+		return nil, err
+	}
+	factor, err := s.getFactor(request.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if factor != nil && factor.IsConfirmed() {
+		return nil, transport.Error{Msg: "totp is already enrolled, disable it first", Code: transport.ErrorCodeInvalidParameter, Key: keyMFAAlreadyEnrolled}
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		e := transport.Error{Msg: "can not generate totp secret", Code: transport.ErrorCodeInternal, Key: keyMFASecretFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	sealed, err := s.box.Seal([]byte(secret), mfaAdditionalData(request.UserID))
+	if err != nil {
+		e := transport.Error{Msg: "can not encrypt totp secret", Code: transport.ErrorCodeInternal, Key: keyMFASecretFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	// a pending enrollment is replaced, until it is confirmed the old secret is useless anyway
+	if err := s.db.Save(&model.MFAFactor{UserID: request.UserID, Secret: sealed, CreatedAt: s.now()}).Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not save totp secret of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyMFAEnrollFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	return &service.EnrollTOTPResponse{
+		Secret: secret,
+		URI:    totp.URI(s.config.Issuer, user.Name, secret),
+	}, nil
+}
+
+func (s mfaServiceImpl) ConfirmTOTP(ctx context.Context, request service.ConfirmTOTPRequest) (*service.RecoveryCodesResponse, error) {
+	if err := checkCredentialsTarget(ctx, s.db, request.UserID); err != nil {
+		return nil, err
+	}
+	factor, err := s.getFactor(request.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if factor == nil || factor.IsConfirmed() {
+		return nil, transport.Error{Msg: "there is no pending totp enrollment", Code: transport.ErrorCodeInvalidParameter, Key: keyMFANoPendingEnrollment}
+	}
+
+	step, ok, err := s.validateCode(factor, request.Code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, transport.Error{Msg: "invalid totp code", Code: transport.ErrorCodeInvalidParameter, Key: keyMFAInvalidCode}
+	}
+
+	var codes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		now := s.now()
+		err := tx.Model(factor).Updates(map[string]interface{}{"confirmed_at": now, "last_step": step}).Error
+		if err != nil {
+			return err
+		}
+		codes, err = s.replaceRecoveryCodes(tx, request.UserID)
+		return err
+	})
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not confirm totp of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyMFAConfirmFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	s.log.Info(fmt.Sprintf("totp enrolled for user %d", request.UserID))
+	return &service.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (s mfaServiceImpl) DisableTOTP(ctx context.Context, request service.DisableTOTPRequest) (*service.EmptyResponse, error) {
+	if err := checkCredentialsTarget(ctx, s.db, request.UserID); err != nil {
+		return nil, err
+	}
+	// users prove they still hold the factor they remove, a stolen session isn't enough
+	if principal, ok := auth.FromContext(ctx); ok && principal.IsUser(request.UserID) {
+		factor, err := s.getFactor(request.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if factor != nil && factor.IsConfirmed() {
+			res, err := s.VerifyMFA(ctx, service.VerifyMFARequest{UserID: request.UserID, Code: request.Code, RecoveryCode: request.RecoveryCode})
+			if err != nil {
+				return nil, err
+			}
+			if !res.Valid {
+				return nil, transport.Error{Msg: "a valid totp or recovery code is required", Code: transport.ErrorCodePermissionDenied, Key: keyMFACodeRequired}
+			}
+		}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", request.UserID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", request.UserID).Delete(&model.MFAFactor{}).Error
+	})
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not disable totp of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyMFADisableFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	s.log.Info(fmt.Sprintf("totp disabled for user %d", request.UserID))
+	return &service.EmptyResponse{}, nil
+}
+
+func (s mfaServiceImpl) RegenerateRecoveryCodes(ctx context.Context, request service.RegenerateRecoveryCodesRequest) (*service.RecoveryCodesResponse, error) {
+	if err := checkCredentialsTarget(ctx, s.db, request.UserID); err != nil {
+		return nil, err
+	}
+	factor, err := s.getFactor(request.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if factor == nil || !factor.IsConfirmed() {
+		return nil, transport.Error{Msg: "totp is not enrolled", Code: transport.ErrorCodeInvalidParameter, Key: keyMFANotEnrolled}
+	}
+
+	var codes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		codes, err = s.replaceRecoveryCodes(tx, request.UserID)
+		return err
+	})
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not generate recovery codes of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyMFARecoveryCodesFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	return &service.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (s mfaServiceImpl) GetMFAStatus(_ context.Context, request service.GetMFAStatusRequest) (*service.MFAStatusResponse, error) {
+	user, err := s.getUser(request.UserID)
+	if err != nil {
+		return nil, err
+	}
+	factor, err := s.getFactor(request.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &service.MFAStatusResponse{
+		Enrolled: factor != nil && factor.IsConfirmed(),
+		Required: user.Role != nil && s.isRequiredFor(*user.Role),
+	}
+	if res.Enrolled {
+		err := s.db.Model(&model.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", request.UserID).
+			Count(&res.RecoveryCodesLeft).Error
+		if err != nil {
+			e := transport.Error{Msg: fmt.Sprintf("error when counting recovery codes of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyMFAStatusFailed, Params: userParams(request.UserID)}.Wrap(err)
+			s.log.Error(e.Error())
+			return nil, e
+		}
+	}
+	return res, nil
+}
+
+func (s mfaServiceImpl) VerifyMFA(_ context.Context, request service.VerifyMFARequest) (*service.VerifyMFAResponse, error) {
+	factor, err := s.getFactor(request.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if factor == nil || !factor.IsConfirmed() {
+		return &service.VerifyMFAResponse{Valid: false}, nil
+	}
+
+	if len(request.RecoveryCode) > 0 {
+		ret := s.db.Model(&model.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", request.UserID, hashToken(normalizeRecoveryCode(request.RecoveryCode))).
+			Update("used_at", s.now())
+		if ret.Error != nil {
+			e := transport.Error{Msg: fmt.Sprintf("can not use recovery code of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyMFAVerifyFailed, Params: userParams(request.UserID)}.Wrap(ret.Error)
+			s.log.Error(e.Error())
+			return nil, e
+		}
+		if ret.RowsAffected == 1 {
+			s.log.Info(fmt.Sprintf("recovery code used by user %d", request.UserID))
+		}
+		return &service.VerifyMFAResponse{Valid: ret.RowsAffected == 1}, nil
+	}
+
+	step, ok, err := s.validateCode(factor, request.Code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return &service.VerifyMFAResponse{Valid: false}, nil
+	}
+
+	// a code can't be replayed, neither can a code older than the last accepted one
+	ret := s.db.Model(factor).Where("last_step < ?", step).Update("last_step", step)
+	if ret.Error != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not save totp step of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyMFAVerifyFailed, Params: userParams(request.UserID)}.Wrap(ret.Error)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	return &service.VerifyMFAResponse{Valid: ret.RowsAffected == 1}, nil
+}
+
+func (s mfaServiceImpl) validateCode(factor *model.MFAFactor, code string) (int64, bool, error) {
+	secret, err := s.box.Open(factor.Secret, mfaAdditionalData(factor.UserID))
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not decrypt totp secret of user %d", factor.UserID), Code: transport.ErrorCodeInternal, Key: keyMFAVerifyFailed, Params: userParams(factor.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return 0, false, e
+	}
+	step, ok := totp.Validate(string(secret), strings.TrimSpace(code), s.now(), s.config.Skew)
+	return step, ok, nil
+}
+
+func (s mfaServiceImpl) replaceRecoveryCodes(tx *gorm.DB, userID model.UserID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, s.config.RecoveryCodes)
+	for i := 0; i < s.config.RecoveryCodes; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Omit("id").Create(&model.RecoveryCode{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(code))}).Error
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func (s mfaServiceImpl) getFactor(userID model.UserID) (*model.MFAFactor, error) {
+	var factor model.MFAFactor
+	if err := s.db.Where("user_id = ?", userID).Find(&factor).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get mfa factor of user %d", userID), Code: transport.ErrorCodeInternal, Key: keyMFAGetFailed, Params: userParams(userID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	return &factor, nil
+}
+
+func (s mfaServiceImpl) getUser(userID model.UserID) (*model.User, error) {
+	var user model.User
+	if err := s.db.Where("id = ?", userID).Find(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			msg := fmt.Sprintf("not found user %d", userID)
+			s.log.Error(msg)
+			return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyUserNotFound, Params: userParams(userID)}
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get user %d", userID), Code: transport.ErrorCodeInternal, Key: keyUserGetFailed, Params: userParams(userID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	return &user, nil
+}
+
+func (s mfaServiceImpl) isRequiredFor(role model.Role) bool {
+	for _, r := range s.config.RequiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func mfaAdditionalData(userID model.UserID) []byte {
+	return []byte(fmt.Sprintf("mfa:%d", userID))
+}
+
+// generateRecoveryCode returns codes like "k3x7m-q2v9a", easy to type from a printed sheet.
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+}
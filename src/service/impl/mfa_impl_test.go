@@ -0,0 +1,92 @@
+package impl
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/assert"
+	"regexp"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/encryption"
+	"user-service/src/service/util/totp"
+)
+
+func TestRecoveryCode(t *testing.T) {
+	code, err := generateRecoveryCode()
+	assert.NilError(t, err)
+	assert.Equal(t, len(code), 11)
+	assert.Equal(t, normalizeRecoveryCode(" "+code[:5]+code[6:]+" "), normalizeRecoveryCode(code))
+}
+
+func TestMFAServiceImpl_ValidateCode(t *testing.T) {
+	s := initUserMock()
+	box, err := encryption.NewAESGCM([]byte("0123456789abcdef0123456789abcdef"))
+	assert.NilError(t, err)
+	now := time.Unix(1111111109, 0)
+	svc := mfaServiceImpl{
+		db:     s.svc.db,
+		log:    s.svc.log,
+		box:    box,
+		config: MFAConfig{Skew: 1},
+		now:    func() time.Time { return now },
+	}
+
+	secret, err := totp.GenerateSecret()
+	assert.NilError(t, err)
+	sealed, err := box.Seal([]byte(secret), mfaAdditionalData(1))
+	assert.NilError(t, err)
+	code, err := totp.Code(secret, totp.Step(now))
+	assert.NilError(t, err)
+
+	step, ok, err := svc.validateCode(&model.MFAFactor{UserID: 1, Secret: sealed}, code)
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+	assert.Equal(t, step, totp.Step(now))
+
+	// the secret is bound to its owner
+	_, _, err = svc.validateCode(&model.MFAFactor{UserID: 2, Secret: sealed}, code)
+	assert.Assert(t, err != nil)
+}
+
+func TestMFAServiceImpl_DisableTOTP(t *testing.T) {
+	s := initUserMock()
+	box, err := encryption.NewAESGCM([]byte("0123456789abcdef0123456789abcdef"))
+	assert.NilError(t, err)
+	svc := mfaServiceImpl{db: s.svc.db, log: s.svc.log, box: box, config: MFAConfig{Skew: 1}, now: time.Now}
+	secret, err := totp.GenerateSecret()
+	assert.NilError(t, err)
+	sealed, err := box.Seal([]byte(secret), mfaAdditionalData(1))
+	assert.NilError(t, err)
+	expectFactor := func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `mfa_factors`  WHERE (user_id = ?)")).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "secret", "last_step", "confirmed_at", "created_at"}).
+				AddRow(1, sealed, 0, time.Now(), time.Now()))
+	}
+	self := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "1", Role: model.RoleUser})
+
+	// users need a code of the factor to disable their own
+	expectFactor()
+	expectFactor()
+	_, err = svc.DisableTOTP(self, service.DisableTOTPRequest{UserID: 1, Code: "000000"})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodePermissionDenied)
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	assert.NilError(t, err)
+	expectFactor()
+	expectFactor()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `mfa_factors` SET `last_step` = ?")).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `mfa_recovery_codes`")).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `mfa_factors`")).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	_, err = svc.DisableTOTP(self, service.DisableTOTPRequest{UserID: 1, Code: code})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+}
@@ -0,0 +1,504 @@
+package impl
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"github.com/jinzhu/gorm"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	log2 "user-service/src/service/util/log"
+)
+
+const (
+	oidcSigningAlgorithm = "RS256"
+	oidcRSAKeyBits       = 2048
+	oidcScopeOpenID      = "openid"
+	oidcScopeProfile     = "profile"
+)
+
+type OIDCConfig struct {
+	Issuer         string
+	CodeTTL        time.Duration
+	AccessTokenTTL time.Duration
+	IDTokenTTL     time.Duration
+	// KeyRetention is how long a retired signing key stays in the JWKS, it must be longer than token lifetimes.
+	KeyRetention time.Duration
+}
+
+type oidcServiceImpl struct {
+	db     *gorm.DB
+	log    *log2.Logger
+	config OIDCConfig
+	now    func() time.Time
+}
+
+func NewOIDCServiceImpl(db *gorm.DB, log *log2.Logger, config OIDCConfig) (service.OIDCService, error) {
+	src := oidcServiceImpl{
+		db:     db,
+		log:    log,
+		config: config,
+		now:    time.Now,
+	}
+
+	if _, _, err := src.activeKey(); gorm.IsRecordNotFoundError(err) {
+		if _, err = src.RotateSigningKey(context.Background(), service.RotateSigningKeyRequest{}); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return src, nil
+}
+
+func (s oidcServiceImpl) Discovery(_ context.Context, _ service.DiscoveryRequest) (*service.DiscoveryResponse, error) {
+	issuer := strings.TrimSuffix(s.config.Issuer, "/")
+	return &service.DiscoveryResponse{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{oidcSigningAlgorithm},
+		ScopesSupported:                   []string{oidcScopeOpenID, oidcScopeProfile},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "nonce", "name", "gender"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+	}, nil
+}
+
+func (s oidcServiceImpl) JWKS(_ context.Context, _ service.JWKSRequest) (*service.JWKSResponse, error) {
+	keys, err := s.publishedKeys()
+	if err != nil {
+		msg := fmt.Sprintf("error when getting signing keys from db %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	res := &service.JWKSResponse{Keys: []service.JWK{}}
+	for _, key := range keys {
+		private, err := parsePrivateKey(key.PrivateKey)
+		if err != nil {
+			s.log.Error(fmt.Sprintf("can't parse signing key %s: %v", key.ID, err))
+			continue
+		}
+		res.Keys = append(res.Keys, service.JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Kid: key.ID,
+			Alg: key.Algorithm,
+			N:   base64.RawURLEncoding.EncodeToString(private.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(private.E)).Bytes()),
+		})
+	}
+	return res, nil
+}
+
+func (s oidcServiceImpl) Authorize(ctx context.Context, request service.AuthorizeRequest) (*service.AuthorizeResponse, error) {
+	client, err := s.getClient(request.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	// errors before the redirect uri is validated must not be sent to it
+	if !client.RedirectURIs.Contains(request.RedirectURI) {
+		return nil, transport.OAuthError{Code: transport.OAuthInvalidRequest, Description: "redirect_uri is not registered"}
+	}
+
+	redirect := func(params url.Values) (*service.AuthorizeResponse, error) {
+		if len(request.State) > 0 {
+			params.Set("state", request.State)
+		}
+		u, _ := url.Parse(request.RedirectURI)
+		query := u.Query()
+		for k, v := range params {
+			query[k] = v
+		}
+		u.RawQuery = query.Encode()
+		return &service.AuthorizeResponse{RedirectURL: u.String()}, nil
+	}
+	redirectError := func(code string, description string) (*service.AuthorizeResponse, error) {
+		return redirect(url.Values{"error": {code}, "error_description": {description}})
+	}
+
+	if request.ResponseType != "code" {
+		return redirectError(transport.OAuthUnsupportedResponseType, "only response_type=code is supported")
+	}
+	if !containsScope(request.Scope, oidcScopeOpenID) {
+		return redirectError(transport.OAuthInvalidScope, "scope must contain openid")
+	}
+	if len(request.CodeChallenge) == 0 || request.CodeChallengeMethod != "S256" {
+		return redirectError(transport.OAuthInvalidRequest, "code_challenge with code_challenge_method=S256 is required")
+	}
+
+	principal, ok := auth.FromContext(ctx)
+	if !ok || principal.Type != auth.PrincipalUser {
+		return redirectError(transport.OAuthLoginRequired, "user must be logged in")
+	}
+	if principal.MFAEnrollmentOnly {
+		return redirectError(transport.OAuthLoginRequired, "user must enroll a second factor first")
+	}
+	userID, err := strconv.Atoi(principal.ID)
+	if err != nil {
+		return redirectError(transport.OAuthLoginRequired, "user must be logged in")
+	}
+
+	code, err := randomToken(32)
+	if err != nil {
+		s.log.Error(fmt.Sprintf("can't generate authorization code: %v", err))
+		return redirectError(transport.OAuthServerError, "can't generate authorization code")
+	}
+	authCode := model.OIDCAuthorizationCode{
+		CodeHash:      hashToken(code),
+		ClientID:      client.ID,
+		UserID:        model.UserID(userID),
+		RedirectURI:   request.RedirectURI,
+		Scope:         request.Scope,
+		Nonce:         request.Nonce,
+		CodeChallenge: request.CodeChallenge,
+		ExpiresAt:     s.now().Add(s.config.CodeTTL),
+	}
+	if err := s.db.Create(&authCode).Error; err != nil {
+		s.log.Error(fmt.Sprintf("can't save authorization code for client %s: %v", client.ID, err))
+		return redirectError(transport.OAuthServerError, "can't save authorization code")
+	}
+
+	return redirect(url.Values{"code": {code}})
+}
+
+func (s oidcServiceImpl) Token(_ context.Context, request service.OIDCTokenRequest) (*service.OIDCTokenResponse, error) {
+	if request.GrantType != "authorization_code" {
+		return nil, transport.OAuthError{Code: transport.OAuthUnsupportedGrantType}
+	}
+
+	client, err := s.getClient(request.ClientID)
+	if err != nil {
+		return nil, transport.OAuthError{Code: transport.OAuthInvalidClient}
+	}
+	if !client.IsPublic() && subtle.ConstantTimeCompare([]byte(hashToken(request.ClientSecret)), []byte(client.SecretHash)) != 1 {
+		return nil, transport.OAuthError{Code: transport.OAuthInvalidClient}
+	}
+
+	var code model.OIDCAuthorizationCode
+	if err := s.db.Where("code_hash = ?", hashToken(request.Code)).Find(&code).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, transport.OAuthError{Code: transport.OAuthInvalidGrant, Description: "unknown authorization code"}
+		}
+		s.log.Error(fmt.Sprintf("error when get authorization code: %v", err))
+		return nil, transport.OAuthError{Code: transport.OAuthServerError}
+	}
+
+	now := s.now()
+	if code.UsedAt != nil || !now.Before(code.ExpiresAt) || code.ClientID != client.ID || code.RedirectURI != request.RedirectURI {
+		return nil, transport.OAuthError{Code: transport.OAuthInvalidGrant, Description: "authorization code is expired, used or issued to another client"}
+	}
+	if subtle.ConstantTimeCompare([]byte(pkceChallenge(request.CodeVerifier)), []byte(code.CodeChallenge)) != 1 {
+		return nil, transport.OAuthError{Code: transport.OAuthInvalidGrant, Description: "code_verifier doesn't match code_challenge"}
+	}
+
+	// a code can only be redeemed once, even by concurrent requests
+	ret := s.db.Model(&code).Where("used_at IS NULL").Update("used_at", now)
+	if ret.Error != nil {
+		s.log.Error(fmt.Sprintf("can't mark authorization code as used: %v", ret.Error))
+		return nil, transport.OAuthError{Code: transport.OAuthServerError}
+	}
+	if ret.RowsAffected != 1 {
+		return nil, transport.OAuthError{Code: transport.OAuthInvalidGrant, Description: "authorization code is already used"}
+	}
+
+	var user model.User
+	if err := s.db.Where("id = ?", code.UserID).Find(&user).Error; err != nil {
+		s.log.Error(fmt.Sprintf("error when get user %d: %v", code.UserID, err))
+		return nil, transport.OAuthError{Code: transport.OAuthInvalidGrant}
+	}
+	if user.Status != nil && *user.Status != model.StatusActive {
+		return nil, transport.OAuthError{Code: transport.OAuthInvalidGrant, Description: "user is inactive"}
+	}
+
+	kid, key, err := s.activeKey()
+	if err != nil {
+		s.log.Error(fmt.Sprintf("can't load signing key: %v", err))
+		return nil, transport.OAuthError{Code: transport.OAuthServerError}
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, transport.OAuthError{Code: transport.OAuthServerError}
+	}
+	subject := strconv.Itoa(int(user.ID))
+	accessToken, err := signJWT(kid, key, jwt.MapClaims{
+		"iss":   s.config.Issuer,
+		"sub":   subject,
+		"aud":   client.ID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(s.config.AccessTokenTTL).Unix(),
+		"jti":   jti,
+		"scope": code.Scope,
+	})
+	if err != nil {
+		s.log.Error(fmt.Sprintf("can't sign access token: %v", err))
+		return nil, transport.OAuthError{Code: transport.OAuthServerError}
+	}
+
+	idClaims := jwt.MapClaims{
+		"iss": s.config.Issuer,
+		"sub": subject,
+		"aud": client.ID,
+		"iat": now.Unix(),
+		"exp": now.Add(s.config.IDTokenTTL).Unix(),
+	}
+	if len(code.Nonce) > 0 {
+		idClaims["nonce"] = code.Nonce
+	}
+	for k, v := range userClaims(user, code.Scope) {
+		idClaims[k] = v
+	}
+	idToken, err := signJWT(kid, key, idClaims)
+	if err != nil {
+		s.log.Error(fmt.Sprintf("can't sign id token: %v", err))
+		return nil, transport.OAuthError{Code: transport.OAuthServerError}
+	}
+
+	return &service.OIDCTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(s.config.AccessTokenTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       code.Scope,
+	}, nil
+}
+
+func (s oidcServiceImpl) UserInfo(_ context.Context, request service.UserInfoRequest) (*service.UserInfoResponse, error) {
+	invalidToken := transport.OAuthError{Code: transport.OAuthInvalidToken}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(request.AccessToken, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		key, err := s.publishedKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		return &key.PublicKey, nil
+	})
+	if err != nil || !claims.VerifyIssuer(s.config.Issuer, true) {
+		return nil, invalidToken
+	}
+	// id tokens are signed with the same keys but carry no scope
+	scope, ok := claims["scope"].(string)
+	if !ok {
+		return nil, invalidToken
+	}
+	subject, _ := claims["sub"].(string)
+
+	var user model.User
+	if err := s.db.Where("id = ?", subject).Find(&user).Error; err != nil {
+		return nil, invalidToken
+	}
+
+	res := service.UserInfoResponse(userClaims(user, scope))
+	return &res, nil
+}
+
+func (s oidcServiceImpl) RegisterClient(_ context.Context, request service.RegisterOIDCClientRequest) (*service.OIDCClientResponse, error) {
+	if len(request.Name) == 0 {
+		return nil, transport.Error{Msg: "name of client is required", Code: transport.ErrorCodeInvalidParameter}
+	}
+	if len(request.RedirectURIs) == 0 {
+		return nil, transport.Error{Msg: "at least one redirect_uri is required", Code: transport.ErrorCodeInvalidParameter}
+	}
+	for _, uri := range request.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || len(u.Fragment) > 0 || strings.Contains(uri, ",") {
+			return nil, transport.Error{Msg: fmt.Sprintf("invalid redirect_uri %s", uri), Code: transport.ErrorCodeInvalidParameter}
+		}
+	}
+
+	clientID, err := randomToken(16)
+	if err != nil {
+		msg := fmt.Sprintf("can not generate client id: %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+	client := model.OIDCClient{
+		ID:           clientID,
+		Name:         request.Name,
+		RedirectURIs: request.RedirectURIs,
+		CreatedAt:    s.now(),
+	}
+
+	var secret string
+	if !request.Public {
+		if secret, err = randomToken(32); err != nil {
+			msg := fmt.Sprintf("can not generate client secret: %v", err)
+			s.log.Error(msg)
+			return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+		}
+		client.SecretHash = hashToken(secret)
+	}
+
+	if err := s.db.Create(&client).Error; err != nil {
+		msg := fmt.Sprintf("can not create client %s: %v", request.Name, err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+	return &service.OIDCClientResponse{Client: client, ClientSecret: secret}, nil
+}
+
+func (s oidcServiceImpl) ListClients(_ context.Context, _ service.ListOIDCClientsRequest) (*service.OIDCClientsResponse, error) {
+	clients := []model.OIDCClient{}
+	if err := s.db.Order("created_at asc").Find(&clients).Error; err != nil {
+		msg := fmt.Sprintf("error when getting clients from db %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+	return &service.OIDCClientsResponse{Clients: clients}, nil
+}
+
+// RotateSigningKey makes a new key active, the previous keys are retired but stay in the JWKS for
+// KeyRetention so tokens signed with them can still be verified.
+func (s oidcServiceImpl) RotateSigningKey(_ context.Context, _ service.RotateSigningKeyRequest) (*service.SigningKeyResponse, error) {
+	private, err := rsa.GenerateKey(rand.Reader, oidcRSAKeyBits)
+	if err != nil {
+		msg := fmt.Sprintf("can not generate signing key: %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+	kid, err := randomToken(12)
+	if err != nil {
+		msg := fmt.Sprintf("can not generate key id: %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	now := s.now()
+	key := model.OIDCSigningKey{
+		ID:        kid,
+		Algorithm: oidcSigningAlgorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(private),
+		})),
+		Active:    true,
+		CreatedAt: now,
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.OIDCSigningKey{}).Where("active = ?", true).
+			Updates(map[string]interface{}{"active": false, "retired_at": now}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&key).Error
+	})
+	if err != nil {
+		msg := fmt.Sprintf("can not rotate signing key: %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	s.log.Info(fmt.Sprintf("signing key rotated, new key %s", kid))
+	return &service.SigningKeyResponse{KeyID: kid, Algorithm: key.Algorithm, CreatedAt: now}, nil
+}
+
+func (s oidcServiceImpl) getClient(clientID string) (*model.OIDCClient, error) {
+	var client model.OIDCClient
+	if err := s.db.Where("id = ?", clientID).Find(&client).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, transport.OAuthError{Code: transport.OAuthInvalidRequest, Description: "unknown client_id"}
+		}
+		s.log.Error(fmt.Sprintf("error when get client %s: %v", clientID, err))
+		return nil, transport.OAuthError{Code: transport.OAuthServerError}
+	}
+	return &client, nil
+}
+
+func (s oidcServiceImpl) activeKey() (string, *rsa.PrivateKey, error) {
+	var key model.OIDCSigningKey
+	if err := s.db.Where("active = ?", true).Order("created_at desc").First(&key).Error; err != nil {
+		return "", nil, err
+	}
+	private, err := parsePrivateKey(key.PrivateKey)
+	return key.ID, private, err
+}
+
+func (s oidcServiceImpl) publishedKeys() ([]model.OIDCSigningKey, error) {
+	var keys []model.OIDCSigningKey
+	err := s.db.Where("active = ? OR retired_at > ?", true, s.now().Add(-s.config.KeyRetention)).
+		Order("created_at desc").Find(&keys).Error
+	return keys, err
+}
+
+func (s oidcServiceImpl) publishedKey(kid string) (*rsa.PrivateKey, error) {
+	keys, err := s.publishedKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.ID == kid {
+			return parsePrivateKey(key.PrivateKey)
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %s", kid)
+}
+
+// userClaims maps a user to the standard OIDC claims allowed by the scope.
+func userClaims(user model.User, scope string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": strconv.Itoa(int(user.ID)),
+	}
+	if containsScope(scope, oidcScopeProfile) {
+		claims["name"] = user.Name
+		if len(user.Gender) > 0 {
+			claims["gender"] = strings.ToLower(string(user.Gender))
+		}
+	}
+	return claims
+}
+
+func signJWT(kid string, key *rsa.PrivateKey, claims jwt.MapClaims) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = kid
+	return t.SignedString(key)
+}
+
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("invalid pem data")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func containsScope(scope string, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,107 @@
+package impl
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/assert"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/transport"
+)
+
+func TestPKCEChallenge(t *testing.T) {
+	// test vector from RFC 7636 appendix B
+	assert.Equal(t, pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"), "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+}
+
+func TestOIDCServiceImpl_Authorize(t *testing.T) {
+	s := initUserMock()
+	svc := oidcServiceImpl{
+		db:     s.svc.db,
+		log:    s.svc.log,
+		config: OIDCConfig{Issuer: "http://localhost", CodeTTL: time.Minute},
+		now:    time.Now,
+	}
+	validRequest := service.AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            "client",
+		RedirectURI:         "https://app.example.com/callback",
+		Scope:               "openid profile",
+		State:               "xyz",
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: "S256",
+	}
+	userCtx := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "1"})
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		modify    func(r *service.AuthorizeRequest)
+		errCode   string
+		wantQuery map[string]string
+		storeCode bool
+	}{
+		{
+			name:    "unregistered redirect uri",
+			ctx:     userCtx,
+			modify:  func(r *service.AuthorizeRequest) { r.RedirectURI = "https://evil.example.com" },
+			errCode: transport.OAuthInvalidRequest,
+		},
+		{
+			name:      "missing pkce",
+			ctx:       userCtx,
+			modify:    func(r *service.AuthorizeRequest) { r.CodeChallenge = "" },
+			wantQuery: map[string]string{"error": transport.OAuthInvalidRequest, "state": "xyz"},
+		},
+		{
+			name:      "not logged in",
+			ctx:       context.Background(),
+			modify:    func(r *service.AuthorizeRequest) {},
+			wantQuery: map[string]string{"error": transport.OAuthLoginRequired, "state": "xyz"},
+		},
+		{
+			name:      "success",
+			ctx:       userCtx,
+			modify:    func(r *service.AuthorizeRequest) {},
+			wantQuery: map[string]string{"state": "xyz"},
+			storeCode: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `oidc_clients`  WHERE (id = ?)")).
+				WithArgs("client").
+				WillReturnRows(s.mock.NewRows([]string{"id", "name", "secret_hash", "redirect_uris", "created_at"}).
+					AddRow("client", "app", "", "https://app.example.com/callback", time.Now()))
+			if tt.storeCode {
+				s.mock.ExpectBegin()
+				s.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `oidc_authorization_codes`")).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				s.mock.ExpectCommit()
+			}
+
+			request := validRequest
+			tt.modify(&request)
+			res, err := svc.Authorize(tt.ctx, request)
+			if len(tt.errCode) > 0 {
+				assert.Equal(t, err.(transport.OAuthError).Code, tt.errCode)
+				return
+			}
+			assert.NilError(t, err)
+
+			u, err := url.Parse(res.RedirectURL)
+			assert.NilError(t, err)
+			assert.Equal(t, u.Host, "app.example.com")
+			for k, v := range tt.wantQuery {
+				assert.Equal(t, u.Query().Get(k), v)
+			}
+			assert.Equal(t, len(u.Query().Get("code")) > 0, tt.storeCode)
+			assert.NilError(t, s.mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strconv"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	log2 "user-service/src/service/util/log"
+	"user-service/src/service/util/pii"
+	"user-service/src/service/util/token"
+	"user-service/src/service/util/webauthn"
+)
+
+type PasskeyConfig struct {
+	WebAuthn     webauthn.Config
+	ChallengeTTL time.Duration
+}
+
+// keys of the errors of PasskeyService
+const (
+	keyPasskeyInvalidRegistration = "passkey.invalid_registration"
+	keyPasskeyRegisterFailed      = "passkey.register_failed"
+	keyPasskeyInvalid             = "passkey.invalid"
+	keyPasskeyLoginFailed         = "passkey.login_failed"
+	keyPasskeyNotFound            = "passkey.not_found"
+	keyPasskeyRevokeFailed        = "passkey.revoke_failed"
+	keyPasskeyListFailed          = "passkey.list_failed"
+	keyPasskeyChallengeFailed     = "passkey.challenge_failed"
+	keyPasskeyChallengeUseFailed  = "passkey.challenge_use_failed"
+)
+
+type passkeyServiceImpl struct {
+	db       *gorm.DB
+	log      *log2.Logger
+	tokens   *token.Issuer
+	sessions service.SessionService
+	config   PasskeyConfig
+	now      func() time.Time
+}
+
+func NewPasskeyServiceImpl(db *gorm.DB, log *log2.Logger, tokens *token.Issuer, sessions service.SessionService,
+	config PasskeyConfig) (service.PasskeyService, error) {
+	src := passkeyServiceImpl{
+		db:       db,
+		log:      log,
+		tokens:   tokens,
+		sessions: sessions,
+		config:   config,
+		now:      time.Now,
+	}
+
+	return src, nil
+}
+
+func (s passkeyServiceImpl) BeginPasskeyRegistration(ctx context.Context, request service.BeginPasskeyRegistrationRequest) (*service.BeginPasskeyRegistrationResponse, error) {
+	if err := checkCredentialsTarget(ctx, s.db, request.UserID); err != nil {
+		return nil, err
+	}
+	var user model.User
+	if err := s.db.Where("id = ?", request.UserID).Find(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			msg := fmt.Sprintf("not found user %d", request.UserID)
+			s.log.Error(msg)
+			return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyUserNotFound, Params: userParams(request.UserID)}
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyUserGetFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	passkeys, err := s.getPasskeys(request.UserID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := s.newChallenge(webauthn.CeremonyCreate, &request.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make([]service.CredentialParameter, 0, len(webauthn.SupportedAlgorithms))
+	for _, alg := range webauthn.SupportedAlgorithms {
+		params = append(params, service.CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	return &service.BeginPasskeyRegistrationResponse{PublicKey: service.CredentialCreationOptions{
+		RP: service.RelyingParty{ID: s.config.WebAuthn.RPID, Name: s.config.WebAuthn.RPName},
+		User: service.PasskeyUser{
+			ID:          userHandle(user.ID),
+			Name:        user.Name,
+			DisplayName: user.Name,
+		},
+		Challenge:          challenge,
+		PubKeyCredParams:   params,
+		Timeout:            s.config.ChallengeTTL.Milliseconds(),
+		ExcludeCredentials: credentialDescriptors(passkeys),
+		AuthenticatorSelection: service.AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}}, nil
+}
+
+func (s passkeyServiceImpl) FinishPasskeyRegistration(ctx context.Context, request service.FinishPasskeyRegistrationRequest) (*service.PasskeyResponse, error) {
+	if err := checkCredentialsTarget(ctx, s.db, request.UserID); err != nil {
+		return nil, err
+	}
+	invalidErr := func(reason string) error {
+		return transport.Error{Msg: fmt.Sprintf("invalid passkey registration: %s", reason), Code: transport.ErrorCodeInvalidParameter, Key: keyPasskeyInvalidRegistration, Params: map[string]interface{}{"reason": reason}}
+	}
+
+	clientDataJSON, err := webauthn.Encoding.DecodeString(request.Response.ClientDataJSON)
+	if err != nil {
+		return nil, invalidErr("clientDataJSON is not base64url")
+	}
+	challenge, err := s.consumeChallenge(clientDataJSON, webauthn.CeremonyCreate)
+	if err != nil {
+		return nil, err
+	}
+	if challenge == nil || challenge.UserID == nil || *challenge.UserID != request.UserID {
+		return nil, invalidErr("unknown or expired challenge")
+	}
+	if err := s.config.WebAuthn.VerifyClientData(clientDataJSON, webauthn.CeremonyCreate, challenge.Challenge); err != nil {
+		return nil, invalidErr(err.Error())
+	}
+
+	attestationObject, err := webauthn.Encoding.DecodeString(request.Response.AttestationObject)
+	if err != nil {
+		return nil, invalidErr("attestationObject is not base64url")
+	}
+	authData, err := webauthn.ParseAttestationObject(attestationObject)
+	if err != nil {
+		return nil, invalidErr(err.Error())
+	}
+	if err := s.config.WebAuthn.VerifyAuthenticatorData(authData); err != nil {
+		return nil, invalidErr(err.Error())
+	}
+	if _, err := webauthn.ParsePublicKey(authData.PublicKey); err != nil {
+		return nil, invalidErr(err.Error())
+	}
+
+	credentialID := webauthn.Encoding.EncodeToString(authData.CredentialID)
+	if len(request.ID) > 0 && request.ID != credentialID {
+		return nil, invalidErr("credential id mismatch")
+	}
+	var count int
+	if err := s.db.Model(&model.Passkey{}).Where("credential_id = ?", credentialID).Count(&count).Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("error when checking passkey of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyPasskeyRegisterFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if count > 0 {
+		return nil, invalidErr("passkey is already registered")
+	}
+
+	name := request.Name
+	if len(name) == 0 {
+		name = "passkey"
+	}
+	passkey := model.Passkey{
+		UserID:       request.UserID,
+		Name:         name,
+		CredentialID: credentialID,
+		PublicKey:    authData.PublicKey,
+		SignCount:    authData.SignCount,
+		Transports:   request.Response.Transports,
+		CreatedAt:    s.now(),
+	}
+	if err := s.db.Omit("id").Create(&passkey).Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not save passkey of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyPasskeyRegisterFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	s.log.Info(fmt.Sprintf("passkey %d registered for user %d", passkey.ID, request.UserID))
+	return &service.PasskeyResponse{Passkey: passkey}, nil
+}
+
+func (s passkeyServiceImpl) BeginPasskeyLogin(_ context.Context, request service.BeginPasskeyLoginRequest) (*service.BeginPasskeyLoginResponse, error) {
+	var userID *model.UserID
+	passkeys := []model.Passkey{}
+	if len(request.Name) > 0 {
+		var user model.User
+		err := pii.Where(s.db, "name", request.Name).Find(&user).Error
+		if err != nil && !gorm.IsRecordNotFoundError(err) {
+			e := transport.Error{Msg: "error when get user for passkey login", Code: transport.ErrorCodeInternal, Key: keyPasskeyLoginFailed}.Wrap(err)
+			s.log.Error(e.Error())
+			return nil, e
+		}
+		// unknown names get the same answer as discoverable logins, so users can't be enumerated
+		if err == nil {
+			userID = &user.ID
+			if passkeys, err = s.getPasskeys(user.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	challenge, err := s.newChallenge(webauthn.CeremonyGet, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &service.BeginPasskeyLoginResponse{PublicKey: service.CredentialRequestOptions{
+		Challenge:        challenge,
+		Timeout:          s.config.ChallengeTTL.Milliseconds(),
+		RPID:             s.config.WebAuthn.RPID,
+		AllowCredentials: credentialDescriptors(passkeys),
+		UserVerification: "required",
+	}}, nil
+}
+
+func (s passkeyServiceImpl) FinishPasskeyLogin(ctx context.Context, request service.FinishPasskeyLoginRequest) (*service.TokenResponse, error) {
+	invalidErr := transport.Error{Msg: "invalid passkey", Code: transport.ErrorCodeUnauthorized, Key: keyPasskeyInvalid}
+
+	clientDataJSON, err := webauthn.Encoding.DecodeString(request.Response.ClientDataJSON)
+	if err != nil {
+		return nil, invalidErr
+	}
+	challenge, err := s.consumeChallenge(clientDataJSON, webauthn.CeremonyGet)
+	if err != nil {
+		return nil, err
+	}
+	if challenge == nil {
+		return nil, invalidErr
+	}
+	if err := s.config.WebAuthn.VerifyClientData(clientDataJSON, webauthn.CeremonyGet, challenge.Challenge); err != nil {
+		s.log.Warn(fmt.Sprintf("passkey login rejected: %v", err))
+		return nil, invalidErr
+	}
+
+	var passkey model.Passkey
+	if err := s.db.Where("credential_id = ?", request.ID).Find(&passkey).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, invalidErr
+		}
+		e := transport.Error{Msg: "error when get passkey for login", Code: transport.ErrorCodeInternal, Key: keyPasskeyLoginFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if challenge.UserID != nil && *challenge.UserID != passkey.UserID {
+		return nil, invalidErr
+	}
+	if len(request.Response.UserHandle) > 0 && request.Response.UserHandle != userHandle(passkey.UserID) {
+		return nil, invalidErr
+	}
+
+	rawAuthData, err := webauthn.Encoding.DecodeString(request.Response.AuthenticatorData)
+	if err != nil {
+		return nil, invalidErr
+	}
+	signature, err := webauthn.Encoding.DecodeString(request.Response.Signature)
+	if err != nil {
+		return nil, invalidErr
+	}
+	authData, err := webauthn.ParseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, invalidErr
+	}
+	if err := s.config.WebAuthn.VerifyAuthenticatorData(authData); err != nil {
+		s.log.Warn(fmt.Sprintf("passkey login rejected for user %d: %v", passkey.UserID, err))
+		return nil, invalidErr
+	}
+	publicKey, err := webauthn.ParsePublicKey(passkey.PublicKey)
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not parse public key of passkey %d", passkey.ID), Code: transport.ErrorCodeInternal, Key: keyPasskeyLoginFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if !publicKey.Verify(webauthn.SignedData(rawAuthData, clientDataJSON), signature) {
+		return nil, invalidErr
+	}
+
+	// authenticators without counter always send 0, a counter going backwards means the key was cloned
+	if (authData.SignCount > 0 || passkey.SignCount > 0) && authData.SignCount <= passkey.SignCount {
+		s.log.Warn(fmt.Sprintf("sign counter of passkey %d went from %d to %d, it may be cloned", passkey.ID, passkey.SignCount, authData.SignCount))
+		return nil, invalidErr
+	}
+	err = s.db.Model(&passkey).Updates(map[string]interface{}{"sign_count": authData.SignCount, "last_used_at": s.now()}).Error
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not update passkey %d", passkey.ID), Code: transport.ErrorCodeInternal, Key: keyPasskeyLoginFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	var user model.User
+	if err := s.db.Where("id = ?", passkey.UserID).Find(&user).Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("error when get user %d", passkey.UserID), Code: transport.ErrorCodeInternal, Key: keyUserGetFailed, Params: userParams(passkey.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if user.Status != nil && *user.Status != model.StatusActive {
+		return nil, transport.Error{Msg: "user is inactive", Code: transport.ErrorCodePermissionDenied, Key: keyAuthInactiveUser}
+	}
+
+	// a verified passkey proves possession and the user, it satisfies mfa by itself
+	return issueUserTokens(ctx, s.tokens, s.sessions, s.log, user, "")
+}
+
+func (s passkeyServiceImpl) ListPasskeys(_ context.Context, request service.ListPasskeysRequest) (*service.PasskeysResponse, error) {
+	passkeys, err := s.getPasskeys(request.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return &service.PasskeysResponse{Passkeys: passkeys}, nil
+}
+
+func (s passkeyServiceImpl) RevokePasskey(ctx context.Context, request service.RevokePasskeyRequest) (*service.EmptyResponse, error) {
+	if err := checkCredentialsTarget(ctx, s.db, request.UserID); err != nil {
+		return nil, err
+	}
+	ret := s.db.Where("id = ? AND user_id = ?", request.PasskeyID, request.UserID).Delete(&model.Passkey{})
+	if err := ret.Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not revoke passkey %d", request.PasskeyID), Code: transport.ErrorCodeInternal, Key: keyPasskeyRevokeFailed, Params: map[string]interface{}{"id": request.PasskeyID}}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if ret.RowsAffected == 0 {
+		msg := fmt.Sprintf("not found passkey %d", request.PasskeyID)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyPasskeyNotFound, Params: map[string]interface{}{"id": request.PasskeyID}}
+	}
+
+	s.log.Info(fmt.Sprintf("passkey %d of user %d revoked", request.PasskeyID, request.UserID))
+	return &service.EmptyResponse{}, nil
+}
+
+func (s passkeyServiceImpl) getPasskeys(userID model.UserID) ([]model.Passkey, error) {
+	passkeys := []model.Passkey{}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&passkeys).Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("error when get passkeys of user %d", userID), Code: transport.ErrorCodeInternal, Key: keyPasskeyListFailed, Params: userParams(userID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	return passkeys, nil
+}
+
+func (s passkeyServiceImpl) newChallenge(ceremony string, userID *model.UserID) (string, error) {
+	challenge, err := randomToken(32)
+	if err != nil {
+		e := transport.Error{Msg: "can not generate passkey challenge", Code: transport.ErrorCodeInternal, Key: keyPasskeyChallengeFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return "", e
+	}
+
+	now := s.now()
+	// abandoned ceremonies are cleaned up here, there is no background job for that
+	if err := s.db.Where("expires_at < ?", now).Delete(&model.PasskeyChallenge{}).Error; err != nil {
+		s.log.Warn(fmt.Sprintf("can not delete expired passkey challenges: %v", err))
+	}
+	err = s.db.Create(&model.PasskeyChallenge{
+		Challenge: challenge,
+		Ceremony:  ceremony,
+		UserID:    userID,
+		ExpiresAt: now.Add(s.config.ChallengeTTL),
+	}).Error
+	if err != nil {
+		e := transport.Error{Msg: "can not save passkey challenge", Code: transport.ErrorCodeInternal, Key: keyPasskeyChallengeFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return "", e
+	}
+	return challenge, nil
+}
+
+// consumeChallenge returns the challenge signed in clientDataJSON, or nil when it is unknown, expired or already used.
+func (s passkeyServiceImpl) consumeChallenge(clientDataJSON []byte, ceremony string) (*model.PasskeyChallenge, error) {
+	var clientData webauthn.CollectedClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil || len(clientData.Challenge) == 0 {
+		return nil, nil
+	}
+
+	var challenge model.PasskeyChallenge
+	if err := s.db.Where("challenge = ? AND ceremony = ?", clientData.Challenge, ceremony).Find(&challenge).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, nil
+		}
+		e := transport.Error{Msg: "error when get passkey challenge", Code: transport.ErrorCodeInternal, Key: keyPasskeyChallengeUseFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	// the delete decides which of concurrent requests gets the challenge
+	ret := s.db.Where("challenge = ?", challenge.Challenge).Delete(&model.PasskeyChallenge{})
+	if ret.Error != nil {
+		e := transport.Error{Msg: "can not use passkey challenge", Code: transport.ErrorCodeInternal, Key: keyPasskeyChallengeUseFailed}.Wrap(ret.Error)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if ret.RowsAffected != 1 || !s.now().Before(challenge.ExpiresAt) {
+		return nil, nil
+	}
+	return &challenge, nil
+}
+
+// userHandle identifies the user to the authenticator, it is returned with discoverable credentials.
+func userHandle(userID model.UserID) string {
+	return webauthn.Encoding.EncodeToString([]byte(strconv.Itoa(int(userID))))
+}
+
+func credentialDescriptors(passkeys []model.Passkey) []service.CredentialDescriptor {
+	descriptors := make([]service.CredentialDescriptor, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		descriptors = append(descriptors, service.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         passkey.CredentialID,
+			Transports: passkey.Transports,
+		})
+	}
+	return descriptors
+}
@@ -0,0 +1,70 @@
+package impl
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/assert"
+	"regexp"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+)
+
+func initPasskeyMock(t *testing.T) (passkeyServiceImpl, userMock) {
+	authSvc, s, _, _ := initAuthMock(t)
+	svc, err := NewPasskeyServiceImpl(s.svc.db, s.svc.log, authSvc.tokens, authSvc.sessions, PasskeyConfig{ChallengeTTL: time.Minute})
+	assert.NilError(t, err)
+	return svc.(passkeyServiceImpl), s
+}
+
+func TestPasskeyServiceImpl_ListPasskeys(t *testing.T) {
+	svc, s := initPasskeyMock(t)
+
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `passkeys`  WHERE (user_id = ?) ORDER BY `id`")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows([]string{"id", "user_id", "name"}).AddRow(4, 1, "laptop").AddRow(6, 1, "phone"))
+
+	res, err := svc.ListPasskeys(context.Background(), service.ListPasskeysRequest{UserID: 1})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+	assert.Equal(t, len(res.Passkeys), 2)
+	assert.Equal(t, res.Passkeys[1].Name, "phone")
+}
+
+func TestPasskeyServiceImpl_RevokePasskey(t *testing.T) {
+	svc, s := initPasskeyMock(t)
+	expectRole := func(role model.Role) {
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT role FROM `users`  WHERE (id = ?) ORDER BY `users`.`id` ASC LIMIT 1")).
+			WithArgs(2).
+			WillReturnRows(s.mock.NewRows([]string{"role"}).AddRow(role))
+	}
+	expectDelete := func(deleted int64) {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `passkeys`  WHERE (id = ? AND user_id = ?)")).
+			WithArgs(4, 2).
+			WillReturnResult(sqlmock.NewResult(0, deleted))
+		s.mock.ExpectCommit()
+	}
+	support := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "3", Role: model.RoleSupport})
+	request := service.RevokePasskeyRequest{UserID: 2, PasskeyID: 4}
+
+	// the passkeys of higher roles are out of reach
+	expectRole(model.RoleAdmin)
+	_, err := svc.RevokePasskey(support, request)
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodePermissionDenied)
+
+	expectRole(model.RoleUser)
+	expectDelete(1)
+	_, err = svc.RevokePasskey(support, request)
+	assert.NilError(t, err)
+
+	// users revoke their own passkeys, whatever their role
+	self := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "2", Role: model.RoleAdmin})
+	expectDelete(0)
+	_, err = svc.RevokePasskey(self, request)
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodeNotFound)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+}
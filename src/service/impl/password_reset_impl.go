@@ -0,0 +1,136 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"net/url"
+	"strings"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/mail"
+	"user-service/src/service/util/pii"
+)
+
+type PasswordResetConfig struct {
+	TTL time.Duration
+	// Link is the page of the web app that asks for the new password, {token} is replaced by the token.
+	Link string
+}
+
+func (s authServiceImpl) ForgotPassword(_ context.Context, request service.ForgotPasswordRequest) (*service.EmptyResponse, error) {
+	var user model.User
+	if err := pii.Where(s.db, "email", request.Email).Find(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return &service.EmptyResponse{}, nil
+		}
+		msg := fmt.Sprintf("error when get user for password reset: %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+	if user.Status != nil && *user.Status != model.StatusActive {
+		return &service.EmptyResponse{}, nil
+	}
+
+	resetToken, err := randomToken(32)
+	if err != nil {
+		msg := fmt.Sprintf("can not generate password reset token: %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	now := s.now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// only the last requested token works
+		if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&model.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.PasswordResetToken{
+			TokenHash: hashToken(resetToken),
+			UserID:    user.ID,
+			ExpiresAt: now.Add(s.reset.TTL),
+			CreatedAt: now,
+		}).Error
+	})
+	if err != nil {
+		msg := fmt.Sprintf("can not save password reset token of user %d: %v", user.ID, err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	// sent in background, the response time must not tell whether the account exists
+	msg := mail.Message{
+		To:      *user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nOpen this link to choose a new password, it expires in %s:\n%s\n\n"+
+			"If you didn't ask for it, you can ignore this message.\n",
+			user.Name, s.reset.TTL, strings.Replace(s.reset.Link, "{token}", url.QueryEscape(resetToken), -1)),
+	}
+	go func() {
+		if err := s.mailer.Send(context.Background(), msg); err != nil {
+			s.log.Error(fmt.Sprintf("can not send password reset mail to user %d: %v", user.ID, err))
+		}
+	}()
+
+	s.log.Info(fmt.Sprintf("password reset requested for user %d", user.ID))
+	return &service.EmptyResponse{}, nil
+}
+
+func (s authServiceImpl) ResetPassword(ctx context.Context, request service.ResetPasswordRequest) (*service.EmptyResponse, error) {
+	invalidErr := transport.Error{Msg: "invalid or expired password reset token", Code: transport.ErrorCodeInvalidParameter}
+
+	if err := s.policy.Validate(request.Password); err != nil {
+		return nil, transport.Error{Msg: err.Error(), Code: transport.ErrorCodeInvalidParameter, Key: keyAuthWeakPassword, Params: map[string]interface{}{"reason": err.Error()}}
+	}
+
+	var resetToken model.PasswordResetToken
+	if err := s.db.Where("token_hash = ?", hashToken(request.Token)).Find(&resetToken).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, invalidErr
+		}
+		msg := fmt.Sprintf("error when get password reset token: %v", err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+	now := s.now()
+	if resetToken.UsedAt != nil || !now.Before(resetToken.ExpiresAt) {
+		return nil, invalidErr
+	}
+
+	hash, err := s.hasher.Hash(request.Password)
+	if err != nil {
+		msg := fmt.Sprintf("can not hash password of user %d: %v", resetToken.UserID, err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+
+	used := false
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// the conditional update makes the token single use even with concurrent requests
+		ret := tx.Model(&model.PasswordResetToken{}).
+			Where("token_hash = ? AND used_at IS NULL", resetToken.TokenHash).
+			Update("used_at", now)
+		if ret.Error != nil || ret.RowsAffected != 1 {
+			return ret.Error
+		}
+		used = true
+		return tx.Save(&model.Credential{UserID: resetToken.UserID, PasswordHash: hash, UpdatedAt: now}).Error
+	})
+	if err != nil {
+		msg := fmt.Sprintf("can not reset password of user %d: %v", resetToken.UserID, err)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+	}
+	if !used {
+		return nil, invalidErr
+	}
+
+	s.log.Info(fmt.Sprintf("password of user %d reset", resetToken.UserID))
+	// whoever knew the old password is logged out too
+	if _, err := s.sessions.RevokeAllSessions(ctx, service.RevokeAllSessionsRequest{UserID: resetToken.UserID}); err != nil {
+		return nil, err
+	}
+	return &service.EmptyResponse{}, nil
+}
@@ -0,0 +1,283 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	log2 "user-service/src/service/util/log"
+)
+
+type PrivacyConfig struct {
+	// GracePeriod is the time left to cancel an erasure, users are erased at once when it is 0.
+	GracePeriod time.Duration
+}
+
+// keys of the errors of PrivacyService
+const (
+	keyPrivacyExportFailed        = "privacy.export_failed"
+	keyPrivacyGetFailed           = "privacy.get_failed"
+	keyPrivacyErasureCreateFailed = "privacy.erasure_create_failed"
+	keyPrivacyErasureNotFound     = "privacy.erasure_not_found"
+	keyPrivacyErasureCancelFailed = "privacy.erasure_cancel_failed"
+	keyPrivacyErasuresRunFailed   = "privacy.erasures_run_failed"
+	keyPrivacyEraseFailed         = "privacy.erase_failed"
+)
+
+type privacyServiceImpl struct {
+	db     *gorm.DB
+	log    *log2.Logger
+	audit  service.AuditService
+	config PrivacyConfig
+	now    func() time.Time
+}
+
+func NewPrivacyServiceImpl(db *gorm.DB, log *log2.Logger, audit service.AuditService, config PrivacyConfig) (service.PrivacyService, error) {
+	src := privacyServiceImpl{
+		db:     db,
+		log:    log,
+		audit:  audit,
+		config: config,
+		now:    time.Now,
+	}
+
+	return src, nil
+}
+
+func (s privacyServiceImpl) ExportUser(_ context.Context, request service.ExportUserRequest) (*service.UserExport, error) {
+	userID := request.UserID
+	export := service.UserExport{ExportedAt: s.now()}
+	if found, err := s.findOne(s.db.Where("id = ?", userID), &export.User, "user", userID); err != nil {
+		return nil, err
+	} else if !found {
+		msg := fmt.Sprintf("not found user %d", userID)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyUserNotFound, Params: userParams(userID)}
+	}
+
+	var credential model.Credential
+	if found, err := s.findOne(s.db.Where("user_id = ?", userID), &credential, "credential", userID); err != nil {
+		return nil, err
+	} else if found {
+		export.PasswordUpdatedAt = &credential.UpdatedAt
+	}
+
+	var factor model.MFAFactor
+	if found, err := s.findOne(s.db.Where("user_id = ?", userID), &factor, "mfa factor", userID); err != nil {
+		return nil, err
+	} else if found {
+		export.MFA = &service.MFAExport{CreatedAt: factor.CreatedAt, ConfirmedAt: factor.ConfirmedAt}
+		err := s.db.Model(&model.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).
+			Count(&export.MFA.RecoveryCodesLeft).Error
+		if err != nil {
+			e := transport.Error{Msg: fmt.Sprintf("error when count recovery codes of user %d", userID), Code: transport.ErrorCodeInternal, Key: keyPrivacyExportFailed, Params: userParams(userID)}.Wrap(err)
+			s.log.Error(e.Error())
+			return nil, e
+		}
+	}
+
+	var link model.LDAPUser
+	if found, err := s.findOne(s.db.Where("user_id = ?", userID), &link, "ldap link", userID); err != nil {
+		return nil, err
+	} else if found {
+		export.LDAP = &link
+	}
+
+	principal := fmt.Sprintf("%s:%d", auth.PrincipalUser, userID)
+	lists := []struct {
+		what  string
+		query *gorm.DB
+		out   interface{}
+	}{
+		{"passkeys", s.db.Where("user_id = ?", userID).Order("id"), &export.Passkeys},
+		{"sessions", s.db.Where("user_id = ?", userID).Order("created_at"), &export.Sessions},
+		{"lockout events", s.db.Where("scope = ? AND subject = ?", model.LockoutScopeAccount, userSubject(userID)).Order("id"), &export.LockoutEvents},
+		{"impersonations", s.db.Where("user_id = ?", userID).Order("id"), &export.Impersonations},
+		{"audit records", s.db.Where("principal = ? OR actor = ?", principal, principal).Order("id"), &export.AuditRecords},
+		{"erasures", s.db.Where("user_id = ?", userID).Order("id"), &export.Erasures},
+	}
+	for _, list := range lists {
+		if err := list.query.Find(list.out).Error; err != nil {
+			e := transport.Error{Msg: fmt.Sprintf("error when get %s of user %d", list.what, userID), Code: transport.ErrorCodeInternal, Key: keyPrivacyExportFailed, Params: userParams(userID)}.Wrap(err)
+			s.log.Error(e.Error())
+			return nil, e
+		}
+	}
+
+	s.log.Info(fmt.Sprintf("data of user %d is exported", userID))
+	return &export, nil
+}
+
+func (s privacyServiceImpl) EraseUser(ctx context.Context, request service.EraseUserRequest) (*service.ErasureResponse, error) {
+	var user model.User
+	if found, err := s.findOne(s.db.Where("id = ?", request.UserID), &user, "user", request.UserID); err != nil {
+		return nil, err
+	} else if !found {
+		msg := fmt.Sprintf("not found user %d", request.UserID)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyUserNotFound, Params: userParams(request.UserID)}
+	}
+
+	// a pending or carried out erasure answers the request again
+	var erasure model.Erasure
+	query := s.db.Where("user_id = ? AND canceled_at IS NULL", request.UserID).Order("id desc").Limit(1)
+	if found, err := s.findOne(query, &erasure, "erasure", request.UserID); err != nil || found {
+		if err != nil {
+			return nil, err
+		}
+		return &service.ErasureResponse{Erasure: erasure}, nil
+	}
+
+	now := s.now()
+	erasure = model.Erasure{
+		UserID:      request.UserID,
+		RequestedAt: now,
+		ScheduledAt: now.Add(s.config.GracePeriod),
+	}
+	if principal, ok := auth.FromContext(ctx); ok {
+		erasure.RequestedBy = principal.String()
+	}
+	if err := s.db.Create(&erasure).Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not create erasure of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyPrivacyErasureCreateFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	_, err := s.audit.Record(ctx, service.RecordAuditRequest{
+		Action: "user.erasure.request",
+		Detail: fmt.Sprintf("erasure %d of user %d scheduled at %s", erasure.ID, erasure.UserID, erasure.ScheduledAt.Format(time.RFC3339)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if s.config.GracePeriod <= 0 {
+		if err := s.erase(ctx, &erasure); err != nil {
+			return nil, err
+		}
+	}
+	return &service.ErasureResponse{Erasure: erasure}, nil
+}
+
+func (s privacyServiceImpl) CancelErasure(ctx context.Context, request service.CancelErasureRequest) (*service.ErasureResponse, error) {
+	var erasure model.Erasure
+	query := s.db.Where("user_id = ? AND canceled_at IS NULL AND erased_at IS NULL", request.UserID)
+	found, err := s.findOne(query, &erasure, "erasure", request.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	if found {
+		// conditional so a cancellation racing with the erasure can't report a canceled erasure
+		ret := s.db.Model(&model.Erasure{}).Where("id = ? AND erased_at IS NULL", erasure.ID).Update("canceled_at", now)
+		if ret.Error != nil {
+			e := transport.Error{Msg: fmt.Sprintf("can not cancel erasure %d", erasure.ID), Code: transport.ErrorCodeInternal, Key: keyPrivacyErasureCancelFailed, Params: map[string]interface{}{"id": erasure.ID}}.Wrap(ret.Error)
+			s.log.Error(e.Error())
+			return nil, e
+		}
+		found = ret.RowsAffected == 1
+	}
+	if !found {
+		msg := fmt.Sprintf("not found pending erasure of user %d", request.UserID)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyPrivacyErasureNotFound, Params: userParams(request.UserID)}
+	}
+
+	erasure.CanceledAt = &now
+	_, err = s.audit.Record(ctx, service.RecordAuditRequest{
+		Action: "user.erasure.cancel",
+		Detail: fmt.Sprintf("erasure %d of user %d", erasure.ID, erasure.UserID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &service.ErasureResponse{Erasure: erasure}, nil
+}
+
+func (s privacyServiceImpl) RunErasures(ctx context.Context, _ service.RunErasuresRequest) (*service.RunErasuresResponse, error) {
+	var erasures []model.Erasure
+	err := s.db.Where("canceled_at IS NULL AND erased_at IS NULL AND scheduled_at <= ?", s.now()).
+		Order("scheduled_at").Find(&erasures).Error
+	if err != nil {
+		e := transport.Error{Msg: "error when get due erasures", Code: transport.ErrorCodeInternal, Key: keyPrivacyErasuresRunFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	res := service.RunErasuresResponse{}
+	for i := range erasures {
+		err := s.erase(ctx, &erasures[i])
+		if erasures[i].ErasedAt != nil {
+			res.Erased = append(res.Erased, erasures[i].UserID)
+		} else if err != nil {
+			res.Failed = append(res.Failed, erasures[i].UserID)
+		}
+	}
+	return &res, nil
+}
+
+// erase removes the personal data of the user and leaves a tombstone, the erasure is left as is when it was
+// canceled meanwhile.
+func (s privacyServiceImpl) erase(ctx context.Context, erasure *model.Erasure) error {
+	now := s.now()
+	erased := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		ret := tx.Model(&model.Erasure{}).Where("id = ? AND canceled_at IS NULL AND erased_at IS NULL", erasure.ID).
+			Update("erased_at", now)
+		if ret.Error != nil || ret.RowsAffected == 0 {
+			return ret.Error
+		}
+		for _, table := range personalTables {
+			if err := tx.Table(table).Where("user_id = ?", erasure.UserID).Delete(nil).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("scope = ? AND subject = ?", model.LockoutScopeAccount, userSubject(erasure.UserID)).
+			Delete(&model.LoginFailure{}).Error; err != nil {
+			return err
+		}
+		erased = true
+		return tx.Model(&model.User{}).Where("id = ?", erasure.UserID).Updates(map[string]interface{}{
+			"name":              fmt.Sprintf("erased-%d", erasure.UserID),
+			"gender":            gorm.Expr("NULL"),
+			"status":            model.StatusInactive,
+			"role":              model.RoleUser,
+			"email":             nil,
+			"email_verified_at": nil,
+			"phone":             nil,
+		}).Error
+	})
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can't erase user %d", erasure.UserID), Code: transport.ErrorCodeInternal, Key: keyPrivacyEraseFailed, Params: userParams(erasure.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return e
+	}
+	if !erased {
+		return nil
+	}
+
+	erasure.ErasedAt = &now
+	s.log.Info(fmt.Sprintf("user %d is erased", erasure.UserID))
+	_, err = s.audit.Record(ctx, service.RecordAuditRequest{
+		Action: "user.erase",
+		Detail: fmt.Sprintf("erasure %d of user %d", erasure.ID, erasure.UserID),
+	})
+	return err
+}
+
+// findOne is false when there is no record.
+func (s privacyServiceImpl) findOne(query *gorm.DB, out interface{}, what string, userID model.UserID) (bool, error) {
+	if err := query.Find(out).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return false, nil
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get %s of user %d", what, userID), Code: transport.ErrorCodeInternal, Key: keyPrivacyGetFailed, Params: userParams(userID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return false, e
+	}
+	return true, nil
+}
@@ -0,0 +1,180 @@
+package impl
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/assert"
+	"regexp"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+)
+
+var erasureColumns = []string{"id", "user_id", "requested_by", "requested_at", "scheduled_at", "canceled_at", "erased_at"}
+
+func initPrivacyMock(t *testing.T, grace time.Duration) (privacyServiceImpl, userMock, *fakeAuditService) {
+	s := initUserMock()
+	audit := &fakeAuditService{}
+	svc, err := NewPrivacyServiceImpl(s.svc.db, s.svc.log, audit, PrivacyConfig{GracePeriod: grace})
+	assert.NilError(t, err)
+	src := svc.(privacyServiceImpl)
+	src.now = func() time.Time { return time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return src, s, audit
+}
+
+func expectErase(s userMock, erasureID int, userID int) {
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `erasures` SET `erased_at` = ?  WHERE (id = ? AND canceled_at IS NULL AND erased_at IS NULL)")).
+		WithArgs(sqlmock.AnyArg(), erasureID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, table := range personalTables {
+		s.mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `" + table + "`  WHERE (user_id = ?)")).
+			WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	s.mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `login_failures`  WHERE (scope = ? AND subject = ?)")).
+		WithArgs(model.LockoutScopeAccount, "1").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `users` SET `email` = ?, `email_verified_at` = ?, `gender` = NULL, `name` = ?, `phone` = ?, `role` = ?, `status` = ?  WHERE (id = ?)")).
+		WithArgs(nil, nil, "erased-1", nil, model.RoleUser, model.StatusInactive, userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+}
+
+func TestPrivacyServiceImpl_EraseUser(t *testing.T) {
+	svc, s, audit := initPrivacyMock(t, 720*time.Hour)
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "1"})
+	expectUser := func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id = ?)")).
+			WithArgs(1).
+			WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(s.userData[0])...))
+	}
+
+	expectUser()
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `erasures`  WHERE (user_id = ? AND canceled_at IS NULL) ORDER BY id desc LIMIT 1")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows(erasureColumns))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `erasures`")).
+		WithArgs(1, "USER:1", svc.now(), svc.now().Add(720*time.Hour), nil, nil).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	s.mock.ExpectCommit()
+
+	res, err := svc.EraseUser(ctx, service.EraseUserRequest{UserID: 1})
+	assert.NilError(t, err)
+	assert.Equal(t, res.Erasure.ID, model.ErasureID(3))
+	assert.Assert(t, res.Erasure.ErasedAt == nil)
+	assert.DeepEqual(t, audit.records, []string{"user.erasure.request USER:1"})
+
+	// asking again returns the pending erasure
+	expectUser()
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `erasures`  WHERE (user_id = ? AND canceled_at IS NULL) ORDER BY id desc LIMIT 1")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows(erasureColumns).AddRow(3, 1, "USER:1", svc.now(), svc.now().Add(720*time.Hour), nil, nil))
+	res, err = svc.EraseUser(ctx, service.EraseUserRequest{UserID: 1})
+	assert.NilError(t, err)
+	assert.Equal(t, res.Erasure.ID, model.ErasureID(3))
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestPrivacyServiceImpl_EraseUser_NoGracePeriod(t *testing.T) {
+	svc, s, audit := initPrivacyMock(t, 0)
+
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id = ?)")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(s.userData[0])...))
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `erasures`")).
+		WillReturnRows(s.mock.NewRows(erasureColumns))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `erasures`")).WillReturnResult(sqlmock.NewResult(3, 1))
+	s.mock.ExpectCommit()
+	expectErase(s, 3, 1)
+
+	res, err := svc.EraseUser(context.Background(), service.EraseUserRequest{UserID: 1})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+	assert.DeepEqual(t, res.Erasure.ErasedAt, &res.Erasure.ScheduledAt)
+	assert.Equal(t, len(audit.records), 2)
+}
+
+func TestPrivacyServiceImpl_RunErasures(t *testing.T) {
+	svc, s, _ := initPrivacyMock(t, time.Hour)
+
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `erasures`  WHERE (canceled_at IS NULL AND erased_at IS NULL AND scheduled_at <= ?) ORDER BY scheduled_at")).
+		WithArgs(svc.now()).
+		WillReturnRows(s.mock.NewRows(erasureColumns).
+			AddRow(3, 1, "USER:1", svc.now().Add(-time.Hour), svc.now(), nil, nil).
+			AddRow(4, 2, "USER:1", svc.now().Add(-time.Hour), svc.now(), nil, nil))
+	expectErase(s, 3, 1)
+	// the second was canceled meanwhile
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `erasures` SET `erased_at` = ?")).
+		WithArgs(sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+
+	res, err := svc.RunErasures(context.Background(), service.RunErasuresRequest{})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+	assert.DeepEqual(t, res, &service.RunErasuresResponse{Erased: []model.UserID{1}})
+}
+
+func TestPrivacyServiceImpl_CancelErasure(t *testing.T) {
+	svc, s, audit := initPrivacyMock(t, time.Hour)
+
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `erasures`  WHERE (user_id = ? AND canceled_at IS NULL AND erased_at IS NULL)")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows(erasureColumns).AddRow(3, 1, "USER:1", svc.now(), svc.now().Add(time.Hour), nil, nil))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `erasures` SET `canceled_at` = ?  WHERE (id = ? AND erased_at IS NULL)")).
+		WithArgs(svc.now(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	res, err := svc.CancelErasure(context.Background(), service.CancelErasureRequest{UserID: 1})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, res.Erasure.CanceledAt, &[]time.Time{svc.now()}[0])
+	assert.Equal(t, len(audit.records), 1)
+
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `erasures`")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows(erasureColumns))
+	_, err = svc.CancelErasure(context.Background(), service.CancelErasureRequest{UserID: 1})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodeNotFound)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestPrivacyServiceImpl_ExportUser(t *testing.T) {
+	svc, s, _ := initPrivacyMock(t, time.Hour)
+	updatedAt := time.Date(2020, 5, 6, 0, 0, 0, 0, time.UTC)
+
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id = ?)")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(s.userData[0])...))
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `credentials`  WHERE (user_id = ?)")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows([]string{"user_id", "password_hash", "updated_at"}).AddRow(1, "secret hash", updatedAt))
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `mfa_factors`  WHERE (user_id = ?)")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows([]string{"user_id", "secret", "created_at"}).AddRow(1, "secret", updatedAt))
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `mfa_recovery_codes`  WHERE (user_id = ? AND used_at IS NULL)")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows([]string{"count"}).AddRow(8))
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `ldap_users`  WHERE (user_id = ?)")).
+		WithArgs(1).
+		WillReturnRows(s.mock.NewRows([]string{"user_id"}))
+	for _, table := range []string{"passkeys", "sessions", "lockout_events", "impersonations", "audit_records", "erasures"} {
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `" + table + "`")).
+			WillReturnRows(s.mock.NewRows([]string{"id"}))
+	}
+
+	res, err := svc.ExportUser(context.Background(), service.ExportUserRequest{UserID: 1})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+	assert.Equal(t, res.User.Name, "ql")
+	assert.DeepEqual(t, res.PasswordUpdatedAt, &updatedAt)
+	assert.DeepEqual(t, res.MFA, &service.MFAExport{CreatedAt: updatedAt, RecoveryCodesLeft: 8})
+	assert.Assert(t, res.LDAP == nil)
+	assert.Equal(t, len(res.Sessions), 0)
+}
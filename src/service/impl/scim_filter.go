@@ -0,0 +1,150 @@
+package impl
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"user-service/src/service/model"
+	"user-service/src/service/util/pii"
+	"user-service/src/service/util/scim"
+)
+
+const scimUserSchemaPrefix = "urn:ietf:params:scim:schemas:core:2.0:user:"
+
+// scimUserColumns maps the lower cased SCIM attributes of a user to the columns of users, the single email
+// of a user is its primary email.
+var scimUserColumns = map[string]string{
+	"id":             "id",
+	"username":       "name",
+	"displayname":    "name",
+	"name.formatted": "name",
+	"emails":         "email",
+	"emails.value":   "email",
+	"active":         "status",
+}
+
+var sqlOperators = map[string]string{
+	scim.OpEqual:          "=",
+	scim.OpGreater:        ">",
+	scim.OpLess:           "<",
+	scim.OpGreaterOrEqual: ">=",
+	scim.OpLessOrEqual:    "<=",
+}
+
+// scimFilterSQL translates a SCIM filter to a condition on users, the columns encrypted by the codec can only be
+// compared with eq and ne on their blind indexes.
+func scimFilterSQL(f scim.Filter, codec *pii.Codec) (string, []interface{}, error) {
+	return scimFilterSQLWithPrefix(f, "", codec)
+}
+
+func scimFilterSQLWithPrefix(f scim.Filter, prefix string, codec *pii.Codec) (string, []interface{}, error) {
+	switch e := f.(type) {
+	case scim.LogExpr:
+		left, leftArgs, err := scimFilterSQLWithPrefix(e.Left, prefix, codec)
+		if err != nil {
+			return "", nil, err
+		}
+		right, rightArgs, err := scimFilterSQLWithPrefix(e.Right, prefix, codec)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, strings.ToUpper(e.Op), right), append(leftArgs, rightArgs...), nil
+	case scim.NotExpr:
+		sql, args, err := scimFilterSQLWithPrefix(e.Filter, prefix, codec)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("NOT (%s)", sql), args, nil
+	case scim.ValuePath:
+		if len(prefix) > 0 {
+			return "", nil, errors.New("value paths can't be nested")
+		}
+		return scimFilterSQLWithPrefix(e.Filter, scimAttribute(e.Path)+".", codec)
+	case scim.AttrExpr:
+		return scimAttrExprSQL(e, prefix+scimAttribute(e.Path), codec)
+	}
+	return "", nil, errors.Errorf("unsupported filter %T", f)
+}
+
+func scimAttrExprSQL(e scim.AttrExpr, attribute string, codec *pii.Codec) (string, []interface{}, error) {
+	column, ok := scimUserColumns[attribute]
+	if !ok {
+		return "", nil, errors.Errorf("filtering on %s isn't supported", e.Path)
+	}
+
+	if e.Op == scim.OpPresent {
+		return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", column, column), nil, nil
+	}
+	if e.Value == nil {
+		switch e.Op {
+		case scim.OpEqual:
+			return fmt.Sprintf("%s IS NULL", column), nil, nil
+		case scim.OpNotEqual:
+			return fmt.Sprintf("%s IS NOT NULL", column), nil, nil
+		}
+		return "", nil, errors.Errorf("null can't be compared with %s", e.Op)
+	}
+
+	value := e.Value
+	switch column {
+	case "status":
+		active, ok := e.Value.(bool)
+		if !ok || (e.Op != scim.OpEqual && e.Op != scim.OpNotEqual) {
+			return "", nil, errors.New("active can only be compared to true or false with eq or ne")
+		}
+		status := model.StatusInactive
+		if active == (e.Op == scim.OpEqual) {
+			status = model.StatusActive
+		}
+		return "status = ?", []interface{}{status}, nil
+	case "id":
+		// ids are strings in SCIM, they are numbers here
+		s, ok := e.Value.(string)
+		id, err := strconv.Atoi(s)
+		if !ok || err != nil {
+			return "", nil, errors.Errorf("invalid id %v", e.Value)
+		}
+		value = id
+	default:
+		s, ok := e.Value.(string)
+		if !ok {
+			return "", nil, errors.Errorf("%s must be compared to a string", e.Path)
+		}
+		if codec.Encrypts(column) {
+			if e.Op != scim.OpEqual && e.Op != scim.OpNotEqual {
+				return "", nil, errors.Errorf("%s is encrypted, it can only be compared with eq or ne", e.Path)
+			}
+			column, value = pii.IndexColumn(column), codec.Index(column, s)
+		}
+	}
+
+	switch e.Op {
+	case scim.OpNotEqual:
+		return fmt.Sprintf("(%s IS NULL OR %s <> ?)", column, column), []interface{}{value}, nil
+	case scim.OpContains, scim.OpStartsWith, scim.OpEndsWith:
+		s, ok := value.(string)
+		if !ok {
+			return "", nil, errors.Errorf("%s can't be compared with %s", e.Path, e.Op)
+		}
+		pattern := escapeLike(s)
+		if e.Op != scim.OpStartsWith {
+			pattern = "%" + pattern
+		}
+		if e.Op != scim.OpEndsWith {
+			pattern = pattern + "%"
+		}
+		return fmt.Sprintf("%s LIKE ?", column), []interface{}{pattern}, nil
+	}
+	return fmt.Sprintf("%s %s ?", column, sqlOperators[e.Op]), []interface{}{value}, nil
+}
+
+// scimAttribute lower cases the attribute, the names of SCIM attributes are case insensitive, and removes the
+// schema of fully qualified names.
+func scimAttribute(path string) string {
+	return strings.TrimPrefix(strings.ToLower(path), scimUserSchemaPrefix)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
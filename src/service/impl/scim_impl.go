@@ -0,0 +1,481 @@
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	log2 "user-service/src/service/util/log"
+	"user-service/src/service/util/scim"
+)
+
+const (
+	scimResourceUser = "User"
+	scimEmailWork    = "work"
+)
+
+type SCIMConfig struct {
+	// BaseURL is the absolute URL of the SCIM endpoints, e.g. https://example.com/scim/v2
+	BaseURL string
+	// MaxResults caps the count of users listed at once
+	MaxResults int
+	// Policy is checked against the attributes the SCIM requests change, the user endpoints check it in transport.
+	Policy auth.FieldPolicy
+}
+
+type scimServiceImpl struct {
+	users  service.UserService
+	log    *log2.Logger
+	config SCIMConfig
+}
+
+func NewSCIMServiceImpl(users service.UserService, log *log2.Logger, config SCIMConfig) (service.SCIMService, error) {
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	src := scimServiceImpl{
+		users:  users,
+		log:    log,
+		config: config,
+	}
+
+	return src, nil
+}
+
+func (s scimServiceImpl) ServiceProviderConfig(_ context.Context, _ service.SCIMServiceProviderConfigRequest) (*service.SCIMServiceProviderConfig, error) {
+	return &service.SCIMServiceProviderConfig{
+		Schemas: []string{service.SCIMSchemaServiceProviderConfig},
+		Patch:   service.SCIMSupported{Supported: true},
+		Filter:  service.SCIMFilterConfig{Supported: true, MaxResults: s.config.MaxResults},
+		AuthenticationSchemes: []service.SCIMAuthenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "API key",
+			Description: "An API key sent as a bearer token in the Authorization header",
+			Primary:     true,
+		}},
+		Meta: service.SCIMMeta{
+			ResourceType: "ServiceProviderConfig",
+			Location:     s.config.BaseURL + "/ServiceProviderConfig",
+		},
+	}, nil
+}
+
+func (s scimServiceImpl) Schemas(_ context.Context, request service.SCIMSchemasRequest) (interface{}, error) {
+	schema := s.userSchema()
+	if len(request.ID) == 0 {
+		return listResponse(schema), nil
+	}
+	if request.ID != schema.ID {
+		return nil, transport.Error{Msg: fmt.Sprintf("not found schema %s", request.ID), Code: transport.ErrorCodeNotFound}
+	}
+	return schema, nil
+}
+
+func (s scimServiceImpl) ResourceTypes(_ context.Context, request service.SCIMResourceTypesRequest) (interface{}, error) {
+	resourceType := service.SCIMResourceType{
+		Schemas:     []string{service.SCIMSchemaResourceType},
+		ID:          scimResourceUser,
+		Name:        scimResourceUser,
+		Endpoint:    "/Users",
+		Description: "User Account",
+		Schema:      service.SCIMSchemaUser,
+		Meta: service.SCIMMeta{
+			ResourceType: "ResourceType",
+			Location:     s.config.BaseURL + "/ResourceTypes/" + scimResourceUser,
+		},
+	}
+	if len(request.Name) == 0 {
+		return listResponse(resourceType), nil
+	}
+	if request.Name != resourceType.Name {
+		return nil, transport.Error{Msg: fmt.Sprintf("not found resource type %s", request.Name), Code: transport.ErrorCodeNotFound}
+	}
+	return resourceType, nil
+}
+
+func (s scimServiceImpl) CreateUser(ctx context.Context, request service.SCIMCreateUserRequest) (*service.SCIMUser, error) {
+	user, err := fromSCIMUser(request.User, model.StatusActive)
+	if err != nil {
+		return nil, err
+	}
+	if err := transport.CheckWrittenFields(ctx, s.config.Policy, 0, changedFields(model.User{}, user)); err != nil {
+		return nil, err
+	}
+	if err := s.checkUnique(ctx, user); err != nil {
+		return nil, err
+	}
+
+	res, err := s.users.PostUser(ctx, service.PostUserRequest{User: user})
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info(fmt.Sprintf("user %d is provisioned by scim", res.User.ID))
+	return s.toSCIMUser(res.User), nil
+}
+
+func (s scimServiceImpl) GetUser(ctx context.Context, request service.SCIMGetUserRequest) (*service.SCIMUser, error) {
+	id, err := parseSCIMID(request.ID)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.users.GetUser(ctx, service.GetUserRequest{UserID: id})
+	if err != nil {
+		return nil, err
+	}
+	return s.toSCIMUser(res.User), nil
+}
+
+func (s scimServiceImpl) ListUsers(ctx context.Context, request service.SCIMListUsersRequest) (*service.SCIMListResponse, error) {
+	usersRequest := service.GetUsersRequest{OrderBy: []string{"id asc"}}
+	if len(request.Filter) > 0 {
+		filter, err := scim.ParseFilter(request.Filter)
+		if err != nil {
+			return nil, transport.SCIMError{Type: transport.SCIMInvalidFilter, Detail: err.Error()}
+		}
+		usersRequest.SCIMFilter = filter
+	}
+
+	startIndex := request.StartIndex
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count := s.config.MaxResults
+	if request.Count != nil && *request.Count < count {
+		count = *request.Count
+	}
+	// a count of 0 only asks for totalResults, one user is still queried to have them counted
+	usersRequest.Paging = service.Paging{Limit: count, Offset: startIndex - 1}
+	if count <= 0 {
+		usersRequest.Paging.Limit = 1
+	}
+
+	res, err := s.users.GetUsers(ctx, usersRequest)
+	if err != nil {
+		if e, ok := err.(transport.Error); ok && e.Code == transport.ErrorCodeInvalidParameter {
+			return nil, transport.SCIMError{Type: transport.SCIMInvalidFilter, Detail: e.Msg}
+		}
+		return nil, err
+	}
+
+	list := &service.SCIMListResponse{
+		Schemas:      []string{service.SCIMSchemaListResponse},
+		TotalResults: res.Paginator.TotalRecord,
+		StartIndex:   startIndex,
+		Resources:    []interface{}{},
+	}
+	if count > 0 {
+		for _, user := range res.Users {
+			list.Resources = append(list.Resources, s.toSCIMUser(user))
+		}
+	}
+	list.ItemsPerPage = len(list.Resources)
+	return list, nil
+}
+
+func (s scimServiceImpl) ReplaceUser(ctx context.Context, request service.SCIMReplaceUserRequest) (*service.SCIMUser, error) {
+	id, err := parseSCIMID(request.ID)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.target(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.replace(ctx, current, request.User)
+}
+
+func (s scimServiceImpl) PatchUser(ctx context.Context, request service.SCIMPatchRequest) (*service.SCIMUser, error) {
+	id, err := parseSCIMID(request.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(request.Operations) == 0 {
+		return nil, transport.SCIMError{Type: transport.SCIMInvalidSyntax, Detail: "Operations are required"}
+	}
+	current, err := s.target(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	user := s.toSCIMUser(current)
+	for _, op := range request.Operations {
+		if err := applySCIMPatch(user, op); err != nil {
+			return nil, err
+		}
+	}
+	return s.replace(ctx, current, *user)
+}
+
+func (s scimServiceImpl) DeleteUser(ctx context.Context, request service.SCIMDeleteUserRequest) (*service.EmptyResponse, error) {
+	id, err := parseSCIMID(request.ID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.target(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.users.DeleteUser(ctx, service.DeleteUserRequest{UserID: id})
+}
+
+// target gets the user a request changes, users whose role is above the role of the caller can't be changed,
+// like checkCredentialsTarget refuses to manage their credentials.
+func (s scimServiceImpl) target(ctx context.Context, id model.UserID) (model.User, error) {
+	res, err := s.users.GetUser(ctx, service.GetUserRequest{UserID: id})
+	if err != nil {
+		return model.User{}, err
+	}
+	user := res.User
+	if principal, ok := auth.FromContext(ctx); ok && !principal.IsUser(user.ID) && outranks(user.Role, principal) {
+		msg := fmt.Sprintf("%s can't provision user %d with role %s", principal, user.ID, *user.Role)
+		s.log.Warn(msg)
+		return model.User{}, transport.Error{Msg: msg, Code: transport.ErrorCodePermissionDenied}
+	}
+	return user, nil
+}
+
+// replace keeps the attributes which have no SCIM counterpart, i.e. the gender, and the status when active is
+// left out.
+func (s scimServiceImpl) replace(ctx context.Context, current model.User, scimUser service.SCIMUser) (*service.SCIMUser, error) {
+	user, err := fromSCIMUser(scimUser, scimStatus(current))
+	if err != nil {
+		return nil, err
+	}
+	user.ID = current.ID
+	user.Gender = current.Gender
+	if err := transport.CheckWrittenFields(ctx, s.config.Policy, user.ID, changedFields(current, user)); err != nil {
+		return nil, err
+	}
+	if err := s.checkUnique(ctx, user); err != nil {
+		return nil, err
+	}
+
+	res, err := s.users.ReplaceUser(ctx, service.ReplaceUserRequest{User: user})
+	if err != nil {
+		return nil, err
+	}
+	return s.toSCIMUser(res.User), nil
+}
+
+// checkUnique gives a SCIM uniqueness error instead of failing on the unique keys of users.
+func (s scimServiceImpl) checkUnique(ctx context.Context, user model.User) error {
+	filters := map[string]model.User{"userName": {Name: user.Name}}
+	if user.Email != nil {
+		filters["emails"] = model.User{Email: user.Email}
+	}
+	for attribute, filter := range filters {
+		res, err := s.users.GetUsers(ctx, service.GetUsersRequest{Filter: filter, Paging: service.Paging{Limit: 1}})
+		if err != nil {
+			return err
+		}
+		if len(res.Users) > 0 && res.Users[0].ID != user.ID {
+			return transport.SCIMError{Type: transport.SCIMUniqueness, Detail: fmt.Sprintf("%s is already taken", attribute)}
+		}
+	}
+	return nil
+}
+
+func (s scimServiceImpl) toSCIMUser(user model.User) *service.SCIMUser {
+	id := strconv.Itoa(int(user.ID))
+	active := scimStatus(user) == model.StatusActive
+	scimUser := &service.SCIMUser{
+		Schemas:     []string{service.SCIMSchemaUser},
+		ID:          id,
+		UserName:    user.Name,
+		DisplayName: user.Name,
+		Active:      &active,
+		Meta: &service.SCIMMeta{
+			ResourceType: scimResourceUser,
+			Location:     s.config.BaseURL + "/Users/" + id,
+		},
+	}
+	if user.Email != nil {
+		scimUser.Emails = []service.SCIMEmail{{Value: *user.Email, Type: scimEmailWork, Primary: true}}
+	}
+	return scimUser
+}
+
+// fromSCIMUser keeps the primary email, or the first one when none is primary. Users without active get status.
+func fromSCIMUser(scimUser service.SCIMUser, status model.Status) (model.User, error) {
+	if len(strings.TrimSpace(scimUser.UserName)) == 0 {
+		return model.User{}, transport.SCIMError{Type: transport.SCIMInvalidValue, Detail: "userName is required"}
+	}
+
+	if scimUser.Active != nil {
+		status = model.StatusActive
+		if !*scimUser.Active {
+			status = model.StatusInactive
+		}
+	}
+	user := model.User{Name: scimUser.UserName, Status: &status}
+	for i, email := range scimUser.Emails {
+		if i == 0 || email.Primary {
+			value := email.Value
+			user.Email = &value
+		}
+		if email.Primary {
+			break
+		}
+	}
+	return user, nil
+}
+
+// changedFields are the fields of users the SCIM attributes change, they are checked against the field policy.
+func changedFields(current model.User, user model.User) []string {
+	var fields []string
+	if user.Name != current.Name {
+		fields = append(fields, "name")
+	}
+	if !equalStrings(user.Email, current.Email) {
+		fields = append(fields, "email")
+	}
+	if scimStatus(user) != scimStatus(current) {
+		fields = append(fields, "status")
+	}
+	return fields
+}
+
+// scimStatus is the status users have for SCIM, those without status are active.
+func scimStatus(user model.User) model.Status {
+	if user.Status == nil {
+		return model.StatusActive
+	}
+	return *user.Status
+}
+
+// applySCIMPatch supports add, replace and remove of userName, active and emails, other attributes are ignored
+// as they would be on create.
+func applySCIMPatch(user *service.SCIMUser, op service.SCIMPatchOperation) error {
+	name := strings.ToLower(op.Op)
+	if name != "add" && name != "replace" && name != "remove" {
+		return transport.SCIMError{Type: transport.SCIMInvalidSyntax, Detail: fmt.Sprintf("unsupported op %s", op.Op)}
+	}
+
+	if len(op.Path) == 0 {
+		if name == "remove" {
+			return transport.SCIMError{Type: transport.SCIMInvalidPath, Detail: "remove requires a path"}
+		}
+		var attributes map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attributes); err != nil {
+			return transport.SCIMError{Type: transport.SCIMInvalidValue, Detail: "value must be an object when there is no path"}
+		}
+		for attribute, value := range attributes {
+			if err := applySCIMPatch(user, service.SCIMPatchOperation{Op: op.Op, Path: attribute, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := scim.ParsePath(op.Path)
+	if err != nil {
+		return transport.SCIMError{Type: transport.SCIMInvalidPath, Detail: err.Error()}
+	}
+	attribute := scimAttribute(path.Attribute)
+	if len(path.SubAttribute) > 0 {
+		attribute += "." + strings.ToLower(path.SubAttribute)
+	}
+
+	switch attribute {
+	case "username":
+		if name == "remove" {
+			return transport.SCIMError{Type: transport.SCIMMutability, Detail: "userName is required"}
+		}
+		if err := json.Unmarshal(op.Value, &user.UserName); err != nil {
+			return transport.SCIMError{Type: transport.SCIMInvalidValue, Detail: "userName must be a string"}
+		}
+	case "active":
+		if name == "remove" {
+			user.Active = nil
+			return nil
+		}
+		active, err := scimBool(op.Value)
+		if err != nil {
+			return err
+		}
+		user.Active = &active
+	case "emails":
+		if name == "remove" {
+			user.Emails = nil
+			return nil
+		}
+		var emails []service.SCIMEmail
+		if err := json.Unmarshal(op.Value, &emails); err != nil {
+			return transport.SCIMError{Type: transport.SCIMInvalidValue, Detail: "emails must be an array of emails"}
+		}
+		user.Emails = emails
+	case "emails.value":
+		// users have a single email, so every filter targets it
+		if name == "remove" {
+			user.Emails = nil
+			return nil
+		}
+		var value string
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return transport.SCIMError{Type: transport.SCIMInvalidValue, Detail: "email must be a string"}
+		}
+		user.Emails = []service.SCIMEmail{{Value: value, Type: scimEmailWork, Primary: true}}
+	}
+	return nil
+}
+
+// scimBool also accepts the "True" and "False" strings sent by some identity providers.
+func scimBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, nil
+		}
+	}
+	return false, transport.SCIMError{Type: transport.SCIMInvalidValue, Detail: "active must be true or false"}
+}
+
+// parseSCIMID gives not found for ids which can't be users.
+func parseSCIMID(id string) (model.UserID, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil || n <= 0 {
+		return 0, transport.Error{Msg: fmt.Sprintf("not found user %s", id), Code: transport.ErrorCodeNotFound}
+	}
+	return model.UserID(n), nil
+}
+
+func listResponse(resource interface{}) *service.SCIMListResponse {
+	return &service.SCIMListResponse{
+		Schemas:      []string{service.SCIMSchemaListResponse},
+		TotalResults: 1,
+		StartIndex:   1,
+		ItemsPerPage: 1,
+		Resources:    []interface{}{resource},
+	}
+}
+
+func (s scimServiceImpl) userSchema() service.SCIMSchema {
+	return service.SCIMSchema{
+		Schemas:     []string{service.SCIMSchemaSchema},
+		ID:          service.SCIMSchemaUser,
+		Name:        scimResourceUser,
+		Description: "User Account",
+		Attributes: []service.SCIMAttribute{
+			{Name: "userName", Type: "string", Required: true, Mutability: "readWrite", Returned: "default", Uniqueness: "server"},
+			{Name: "displayName", Type: "string", Mutability: "readOnly", Returned: "default", Uniqueness: "none"},
+			{Name: "active", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+			{Name: "emails", Type: "complex", MultiValued: true, Mutability: "readWrite", Returned: "default", Uniqueness: "none",
+				SubAttributes: []service.SCIMAttribute{
+					{Name: "value", Type: "string", Mutability: "readWrite", Returned: "default", Uniqueness: "server"},
+					{Name: "type", Type: "string", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+					{Name: "primary", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+				}},
+		},
+		Meta: service.SCIMMeta{
+			ResourceType: "Schema",
+			Location:     s.config.BaseURL + "/Schemas/" + service.SCIMSchemaUser,
+		},
+	}
+}
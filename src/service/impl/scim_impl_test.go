@@ -0,0 +1,276 @@
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"gotest.tools/assert"
+	"testing"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/encryption"
+	"user-service/src/service/util/paging"
+	"user-service/src/service/util/pii"
+	"user-service/src/service/util/scim"
+)
+
+func TestSCIMFilterSQL(t *testing.T) {
+	tests := []struct {
+		filter    string
+		condition string
+		args      []interface{}
+		wantErr   bool
+	}{
+		{`userName eq "bjensen"`, "name = ?", []interface{}{"bjensen"}, false},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "b_j"`, "name LIKE ?", []interface{}{`b\_j%`}, false},
+		{`emails co "@example.com"`, "email LIKE ?", []interface{}{"%@example.com%"}, false},
+		{`emails[type eq "work" or value ew ".org"]`, "", nil, true},
+		{`emails[value ew ".org"]`, "email LIKE ?", []interface{}{"%.org"}, false},
+		{`active eq false`, "status = ?", []interface{}{model.StatusInactive}, false},
+		{`active ne false`, "status = ?", []interface{}{model.StatusActive}, false},
+		{`id eq "12" and not (emails pr)`, "(id = ? AND NOT ((email IS NOT NULL AND email <> '')))", []interface{}{12}, false},
+		{`userName ne "a" or displayName gt "b"`, "((name IS NULL OR name <> ?) OR name > ?)", []interface{}{"a", "b"}, false},
+		{`emails eq null`, "email IS NULL", nil, false},
+		{`title eq "Tour Guide"`, "", nil, true},
+		{`active eq "yes"`, "", nil, true},
+		{`userName eq 12`, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := scim.ParseFilter(tt.filter)
+			assert.NilError(t, err)
+
+			condition, args, err := scimFilterSQL(f, nil)
+			if tt.wantErr {
+				assert.Assert(t, err != nil)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, condition, tt.condition)
+			assert.DeepEqual(t, args, tt.args)
+		})
+	}
+}
+
+func TestSCIMFilterSQL_Encrypted(t *testing.T) {
+	keyring, err := encryption.NewKeyring(1, map[int][]byte{1: make([]byte, 32)}, make([]byte, 32))
+	assert.NilError(t, err)
+	codec, err := pii.NewCodec(keyring, []string{"name"})
+	assert.NilError(t, err)
+
+	f, _ := scim.ParseFilter(`userName eq "bjensen" and emails co "@example.com"`)
+	condition, args, err := scimFilterSQL(f, codec)
+	assert.NilError(t, err)
+	assert.Equal(t, condition, "(name_bidx = ? AND email LIKE ?)")
+	assert.DeepEqual(t, args, []interface{}{codec.Index("name", "bjensen"), "%@example.com%"})
+
+	f, _ = scim.ParseFilter(`userName sw "b"`)
+	_, _, err = scimFilterSQL(f, codec)
+	assert.ErrorContains(t, err, "userName is encrypted")
+}
+
+func TestApplySCIMPatch(t *testing.T) {
+	active := true
+	newUser := func() *service.SCIMUser {
+		return &service.SCIMUser{
+			UserName: "bjensen",
+			Active:   &active,
+			Emails:   []service.SCIMEmail{{Value: "bjensen@example.com", Type: "work", Primary: true}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		op      service.SCIMPatchOperation
+		check   func(t *testing.T, user *service.SCIMUser)
+		errType string
+	}{
+		{
+			name: "replace active with a string",
+			op:   service.SCIMPatchOperation{Op: "Replace", Path: "active", Value: json.RawMessage(`"False"`)},
+			check: func(t *testing.T, user *service.SCIMUser) {
+				assert.Equal(t, *user.Active, false)
+			},
+		},
+		{
+			name: "replace without path",
+			op:   service.SCIMPatchOperation{Op: "replace", Value: json.RawMessage(`{"userName":"babs","active":false,"title":"x"}`)},
+			check: func(t *testing.T, user *service.SCIMUser) {
+				assert.Equal(t, user.UserName, "babs")
+				assert.Equal(t, *user.Active, false)
+			},
+		},
+		{
+			name: "replace email by filter",
+			op:   service.SCIMPatchOperation{Op: "replace", Path: `emails[type eq "work"].value`, Value: json.RawMessage(`"babs@example.com"`)},
+			check: func(t *testing.T, user *service.SCIMUser) {
+				assert.Equal(t, user.Emails[0].Value, "babs@example.com")
+			},
+		},
+		{
+			name: "remove emails",
+			op:   service.SCIMPatchOperation{Op: "remove", Path: "emails"},
+			check: func(t *testing.T, user *service.SCIMUser) {
+				assert.Equal(t, len(user.Emails), 0)
+			},
+		},
+		{
+			name:    "remove userName",
+			op:      service.SCIMPatchOperation{Op: "remove", Path: "userName"},
+			errType: transport.SCIMMutability,
+		},
+		{
+			name:    "invalid path",
+			op:      service.SCIMPatchOperation{Op: "replace", Path: `emails[type eq`, Value: json.RawMessage(`"x"`)},
+			errType: transport.SCIMInvalidPath,
+		},
+		{
+			name:    "unsupported op",
+			op:      service.SCIMPatchOperation{Op: "move", Path: "userName"},
+			errType: transport.SCIMInvalidSyntax,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := newUser()
+			err := applySCIMPatch(user, tt.op)
+			if len(tt.errType) > 0 {
+				assert.Equal(t, err.(transport.SCIMError).Type, tt.errType)
+				return
+			}
+			assert.NilError(t, err)
+			tt.check(t, user)
+		})
+	}
+}
+
+// fakeUserService lists the users it is given, it records the last GetUsersRequest and the users replaced and
+// deleted.
+type fakeUserService struct {
+	service.UserService
+	users    []model.User
+	request  *service.GetUsersRequest
+	replaced []model.User
+	deleted  []model.UserID
+}
+
+func (f *fakeUserService) GetUser(_ context.Context, request service.GetUserRequest) (*service.UserResponse, error) {
+	for _, user := range f.users {
+		if user.ID == request.UserID {
+			return &service.UserResponse{User: user}, nil
+		}
+	}
+	return nil, transport.Error{Code: transport.ErrorCodeNotFound}
+}
+
+func (f *fakeUserService) ReplaceUser(_ context.Context, request service.ReplaceUserRequest) (*service.UserResponse, error) {
+	f.replaced = append(f.replaced, request.User)
+	return &service.UserResponse{User: request.User}, nil
+}
+
+func (f *fakeUserService) DeleteUser(_ context.Context, request service.DeleteUserRequest) (*service.EmptyResponse, error) {
+	f.deleted = append(f.deleted, request.UserID)
+	return &service.EmptyResponse{}, nil
+}
+
+func (f *fakeUserService) GetUsers(_ context.Context, request service.GetUsersRequest) (*service.UsersResponse, error) {
+	f.request = &request
+	var users []model.User
+	for _, user := range f.users {
+		if len(request.Filter.Name) > 0 && request.Filter.Name != user.Name {
+			continue
+		}
+		if request.Filter.Email != nil && !equalStrings(request.Filter.Email, user.Email) {
+			continue
+		}
+		users = append(users, user)
+	}
+	total := len(users)
+	if len(users) > request.Paging.Limit {
+		users = users[:request.Paging.Limit]
+	}
+	return &service.UsersResponse{Users: users, Paginator: &paging.Paginator{TotalRecord: total}}, nil
+}
+
+func TestSCIMServiceImpl_ListUsers(t *testing.T) {
+	users := &fakeUserService{users: []model.User{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}}
+	src, _ := NewSCIMServiceImpl(users, initUserMock().svc.log, SCIMConfig{BaseURL: "https://example.com/scim/v2/", MaxResults: 10})
+
+	count := 0
+	res, err := src.ListUsers(context.Background(), service.SCIMListUsersRequest{StartIndex: 1, Count: &count})
+	assert.NilError(t, err)
+	assert.Equal(t, res.TotalResults, 2)
+	assert.Equal(t, res.ItemsPerPage, 0)
+	assert.Equal(t, len(res.Resources), 0)
+
+	count = 50
+	res, err = src.ListUsers(context.Background(), service.SCIMListUsersRequest{StartIndex: 3, Count: &count, Filter: `userName sw "a"`})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, users.request.Paging, service.Paging{Limit: 10, Offset: 2})
+	assert.Assert(t, users.request.SCIMFilter != nil)
+	assert.Equal(t, res.StartIndex, 3)
+	assert.Equal(t, res.ItemsPerPage, 2)
+	assert.Equal(t, res.Resources[0].(*service.SCIMUser).Meta.Location, "https://example.com/scim/v2/Users/1")
+
+	_, err = src.ListUsers(context.Background(), service.SCIMListUsersRequest{Filter: `userName eq`})
+	assert.Equal(t, err.(transport.SCIMError).Type, transport.SCIMInvalidFilter)
+}
+
+func TestSCIMServiceImpl_CreateUser(t *testing.T) {
+	email := "b@example.com"
+	users := &fakeUserService{users: []model.User{{ID: 1, Name: "a"}, {ID: 2, Name: "b", Email: &email}}}
+	src, _ := NewSCIMServiceImpl(users, initUserMock().svc.log, SCIMConfig{MaxResults: 10})
+
+	_, err := src.CreateUser(context.Background(), service.SCIMCreateUserRequest{User: service.SCIMUser{UserName: "a"}})
+	assert.Equal(t, err.(transport.SCIMError).Type, transport.SCIMUniqueness)
+
+	_, err = src.CreateUser(context.Background(), service.SCIMCreateUserRequest{User: service.SCIMUser{
+		UserName: "c",
+		Emails:   []service.SCIMEmail{{Value: "c@example.com"}, {Value: email, Primary: true}},
+	}})
+	assert.Equal(t, err.(transport.SCIMError).Type, transport.SCIMUniqueness)
+
+	_, err = src.CreateUser(context.Background(), service.SCIMCreateUserRequest{User: service.SCIMUser{}})
+	assert.Equal(t, err.(transport.SCIMError).Type, transport.SCIMInvalidValue)
+}
+
+func TestSCIMServiceImpl_Policy(t *testing.T) {
+	roleAdmin := model.RoleAdmin
+	inactive := model.StatusInactive
+	users := &fakeUserService{users: []model.User{{ID: 1, Name: "a"}, {ID: 2, Name: "b", Status: &inactive}, {ID: 9, Name: "root", Role: &roleAdmin}}}
+	src, _ := NewSCIMServiceImpl(users, initUserMock().svc.log, SCIMConfig{
+		MaxResults: 10,
+		Policy:     auth.FieldPolicy{Write: map[string][]string{"status": {"ADMIN"}}},
+	})
+	support := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "3", Role: model.RoleSupport})
+	admin := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "8", Role: model.RoleAdmin})
+	deactivate := service.SCIMPatchOperation{Op: "replace", Path: "active", Value: json.RawMessage(`false`)}
+
+	// only admins may change the status
+	_, err := src.PatchUser(support, service.SCIMPatchRequest{ID: "1", Operations: []service.SCIMPatchOperation{deactivate}})
+	assert.Equal(t, err.(transport.Error).Key, "user.fields_not_allowed")
+	assert.DeepEqual(t, err.(transport.Error).Fields, []transport.FieldError{{Field: "status", Msg: "not allowed to write", Key: "field.not_allowed"}})
+	_, err = src.CreateUser(support, service.SCIMCreateUserRequest{User: service.SCIMUser{UserName: "c", Active: new(bool)}})
+	assert.Equal(t, err.(transport.Error).Key, "user.fields_not_allowed")
+	_, err = src.PatchUser(admin, service.SCIMPatchRequest{ID: "1", Operations: []service.SCIMPatchOperation{deactivate}})
+	assert.NilError(t, err)
+
+	// active left out keeps the status
+	res, err := src.ReplaceUser(support, service.SCIMReplaceUserRequest{ID: "2", User: service.SCIMUser{UserName: "bob"}})
+	assert.NilError(t, err)
+	assert.Equal(t, *res.Active, false)
+
+	// admins can't be changed by lower roles
+	_, err = src.DeleteUser(support, service.SCIMDeleteUserRequest{ID: "9"})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodePermissionDenied)
+	_, err = src.ReplaceUser(support, service.SCIMReplaceUserRequest{ID: "9", User: service.SCIMUser{UserName: "root"}})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodePermissionDenied)
+	_, err = src.DeleteUser(support, service.SCIMDeleteUserRequest{ID: "1"})
+	assert.NilError(t, err)
+	_, err = src.DeleteUser(admin, service.SCIMDeleteUserRequest{ID: "9"})
+	assert.NilError(t, err)
+
+	assert.Equal(t, len(users.replaced), 2)
+	assert.DeepEqual(t, users.deleted, []model.UserID{1, 9})
+}
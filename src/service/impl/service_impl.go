@@ -2,85 +2,269 @@ package impl
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jinzhu/gorm"
+	"go.uber.org/zap"
+	"strings"
 	"user-service/src/service"
+	"user-service/src/service/auth"
 	"user-service/src/service/model"
 	"user-service/src/service/transport"
+	"user-service/src/service/util/jsonpatch"
 	log2 "user-service/src/service/util/log"
 	"user-service/src/service/util/paging"
+	"user-service/src/service/util/pii"
+	"user-service/src/service/util/sqlerr"
+)
+
+// keys of the errors of UserService, clients may rely on them unlike on the messages
+const (
+	keyUserNotFound      = "user.not_found"
+	keyUserGetFailed     = "user.get_failed"
+	keyUserCreateFailed  = "user.create_failed"
+	keyUserUpdateFailed  = "user.update_failed"
+	keyUserDeleteFailed  = "user.delete_failed"
+	keyUserInvalidEmail  = "user.invalid_email"
+	keyUserInvalidPhone  = "user.invalid_phone"
+	keyUserInvalidRole   = "user.invalid_role"
+	keyUserInvalidFilter = "user.invalid_filter"
+	keyUserInvalidOrder  = "user.invalid_order"
+	keyUserListFailed    = "user.list_failed"
+	keyUserConflict      = "user.conflict"
+	keyUserInvalidPatch  = "user.invalid_patch"
+	keyUserTestFailed    = "user.patch_test_failed"
 )
 
 type serviceImpl struct {
-	db  *gorm.DB
-	log *log2.Logger
+	db           *gorm.DB
+	log          *log2.Logger
+	verification service.EmailVerificationService
 }
 
-func NewServiceImpl(db *gorm.DB, log *log2.Logger) (service.UserService, error) {
+func NewServiceImpl(db *gorm.DB, log *log2.Logger, verification service.EmailVerificationService) (service.UserService, error) {
 	src := serviceImpl{
-		db:  db,
-		log: log,
+		db:           db,
+		log:          log,
+		verification: verification,
 	}
 
 	return src, nil
 }
 
 func (s serviceImpl) GetUser(_ context.Context, request service.GetUserRequest) (*service.UserResponse, error) {
+	user, err := s.getUser(s.db, request.UserID)
+	if err != nil {
+		return nil, err
+	}
 
+	return &service.UserResponse{User: *user}, nil
+}
+
+// getUser reads the user with db, which may be a transaction locking the row.
+func (s serviceImpl) getUser(db *gorm.DB, id model.UserID) (*model.User, error) {
 	var user model.User
 
-	if err := s.db.Where("id = ?", request.UserID).Find(&user).Error; err != nil {
+	if err := db.Where("id = ?", id).Find(&user).Error; err != nil {
 		if gorm.IsRecordNotFoundError(err) {
-			msg := fmt.Sprintf("not found user %d", request.UserID)
+			msg := fmt.Sprintf("not found user %d", id)
 			s.log.Error(msg)
-			return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound}
+			return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyUserNotFound, Params: userParams(id)}
 		}
-		msg := fmt.Sprintf("error when get user %d: %v", request.UserID, err)
-		s.log.Error(msg)
-		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+		e := transport.Error{Msg: fmt.Sprintf("can't get user %d", id), Code: transport.ErrorCodeInternal, Key: keyUserGetFailed, Params: userParams(id)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
 	}
 
-	return &service.UserResponse{User: user}, nil
+	return &user, nil
 }
 
-func (s serviceImpl) PostUser(_ context.Context, request service.PostUserRequest) (*service.UserResponse, error) {
+func (s serviceImpl) PostUser(ctx context.Context, request service.PostUserRequest) (*service.UserResponse, error) {
+	if err := validateEmail(request.User.Email); err != nil {
+		return nil, err
+	}
+	if err := validatePhone(request.User.Phone); err != nil {
+		return nil, err
+	}
 	ret := s.db.Omit("id").Create(&request.User)
 	if err := ret.Error; err != nil {
-		msg := fmt.Sprintf("can not create new user %v, %v", request.User, err)
-		s.log.Error(msg)
-		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+		if e, ok := s.conflictError(ctx, request.User, err); ok {
+			return nil, e
+		}
+		e := transport.Error{Msg: "can't create the user", Code: transport.ErrorCodeInternal, Key: keyUserCreateFailed}.Wrap(err)
+		s.log.Error(e.Error(), zap.Any("user", request.User))
+		return nil, e
+	}
+	if request.User.Email != nil {
+		s.sendEmailVerification(ctx, request.User.ID)
 	}
 	return &service.UserResponse{User: request.User}, nil
 }
 
 func (s serviceImpl) PatchUser(ctx context.Context, request service.PatchUserRequest) (*service.UserResponse, error) {
+	if request.MergePatch != nil || request.Operations != nil {
+		return s.applyPatch(ctx, request)
+	}
+
 	var err error
+	if err = validateEmail(request.User.Email); err != nil {
+		return nil, err
+	}
+	if err = validatePhone(request.User.Phone); err != nil {
+		return nil, err
+	}
+
+	emailChanged := false
+	if request.User.Email != nil {
+		// a new address is unverified, the same address keeps its verification
+		emailColumn, email := pii.Match(s.db, "email", *request.User.Email)
+		ret := s.db.Model(&model.User{}).
+			Where(fmt.Sprintf("id = ? AND (%s IS NULL OR %s <> ?)", emailColumn, emailColumn), request.User.ID, email).
+			Updates(map[string]interface{}{"email": *request.User.Email, "email_verified_at": nil})
+		if err = ret.Error; err != nil {
+			if e, ok := s.conflictError(ctx, request.User, err); ok {
+				return nil, e
+			}
+			e := transport.Error{Msg: fmt.Sprintf("can't update email of user %d", request.User.ID), Code: transport.ErrorCodeInternal, Key: keyUserUpdateFailed, Params: userParams(request.User.ID)}.Wrap(err)
+			s.log.Error(e.Error())
+			return nil, e
+		}
+		emailChanged = ret.RowsAffected == 1
+	}
+
 	ret := s.db.Model(&request.User).Updates(&request.User)
 	if err = ret.Error; err != nil {
-		msg := fmt.Sprintf("can't update user info: %d", request.User.ID)
-		s.log.Error(msg)
-		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+		if e, ok := s.conflictError(ctx, request.User, err); ok {
+			return nil, e
+		}
+		e := transport.Error{Msg: fmt.Sprintf("can't update user %d", request.User.ID), Code: transport.ErrorCodeInternal, Key: keyUserUpdateFailed, Params: userParams(request.User.ID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if emailChanged {
+		s.sendEmailVerification(ctx, request.User.ID)
 	}
 
 	return s.GetUser(ctx, service.GetUserRequest{UserID: request.User.ID})
 }
 
+// applyPatch replaces the user by the patched user, the fields the patch doesn't change are written as they are.
+// The row is locked from the read to the write, concurrent patches apply one after the other.
+func (s serviceImpl) applyPatch(ctx context.Context, request service.PatchUserRequest) (*service.UserResponse, error) {
+	var user model.User
+	emailChanged := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		current, err := s.getUser(tx.Set("gorm:query_option", "FOR UPDATE"), request.User.ID)
+		if err != nil {
+			return err
+		}
+		if user, err = patchUser(*current, request); err != nil {
+			return err
+		}
+		if err = validateEmail(user.Email); err != nil {
+			return err
+		}
+		if err = validatePhone(user.Phone); err != nil {
+			return err
+		}
+		emailChanged, err = s.replaceUser(ctx, tx, *current, service.ReplaceUserRequest{User: user, ReplacePhone: true})
+		return err
+	})
+	if err != nil {
+		if e, ok := err.(transport.Error); ok {
+			return nil, e
+		}
+		e := transport.Error{Msg: fmt.Sprintf("can't patch user %d", request.User.ID), Code: transport.ErrorCodeInternal, Key: keyUserUpdateFailed, Params: userParams(request.User.ID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if emailChanged && user.Email != nil {
+		s.sendEmailVerification(ctx, request.User.ID)
+	}
+
+	return s.GetUser(ctx, service.GetUserRequest{UserID: request.User.ID})
+}
+
+// patchUser applies the merge patch or the operations of the request to the user.
+func patchUser(current model.User, request service.PatchUserRequest) (model.User, error) {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return model.User{}, transport.InternalError(err)
+	}
+
+	var patched []byte
+	if request.MergePatch != nil {
+		patched, err = jsonpatch.MergePatch(doc, request.MergePatch)
+	} else {
+		patched, err = jsonpatch.Apply(doc, request.Operations)
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return model.User{}, transport.Error{Msg: fmt.Sprintf("the patch doesn't apply to user %d: %v", request.User.ID, err), Code: transport.ErrorCodeConflict,
+			Key: keyUserTestFailed, Params: userParams(request.User.ID)}
+	}
+	var user model.User
+	if err == nil {
+		err = json.Unmarshal(patched, &user)
+	}
+	if err != nil {
+		return model.User{}, transport.Error{Msg: fmt.Sprintf("invalid patch: %v", err), Code: transport.ErrorCodeInvalidParameter, Key: keyUserInvalidPatch,
+			Params: map[string]interface{}{"reason": err.Error()}}
+	}
+
+	user.ID = request.User.ID
+	return user, nil
+}
+
 func (s serviceImpl) GetUsers(_ context.Context, request service.GetUsersRequest) (*service.UsersResponse, error) {
 	var users []model.User
-	db := s.db.Where(request.Filter)
+	// encrypted columns are filtered on their blind indexes
+	filter, db := request.Filter, s.db
+	if len(filter.Name) > 0 {
+		db = pii.Where(db, "name", filter.Name)
+		filter.Name = ""
+	}
+	if filter.Email != nil {
+		db = pii.Where(db, "email", *filter.Email)
+		filter.Email = nil
+	}
+	db = db.Where(filter)
+	codec := pii.FromDB(s.db)
+	for _, orderBy := range request.OrderBy {
+		if column := strings.Fields(orderBy); len(column) > 0 && codec.Encrypts(column[0]) {
+			return nil, transport.Error{Msg: fmt.Sprintf("users can't be ordered by the encrypted %s", column[0]), Code: transport.ErrorCodeInvalidParameter, Key: keyUserInvalidOrder,
+				Params: map[string]interface{}{"column": column[0]}}
+		}
+	}
+	if request.EmailVerified != nil {
+		if *request.EmailVerified {
+			db = db.Where("email_verified_at IS NOT NULL")
+		} else {
+			db = db.Where("email_verified_at IS NULL")
+		}
+	}
+	if request.SCIMFilter != nil {
+		condition, args, err := scimFilterSQL(request.SCIMFilter, codec)
+		if err != nil {
+			return nil, transport.Error{Msg: fmt.Sprintf("invalid filter: %v", err), Code: transport.ErrorCodeInvalidParameter, Key: keyUserInvalidFilter,
+				Params: map[string]interface{}{"reason": err.Error()}}
+		}
+		db = db.Where(condition, args...)
+	}
 
 	paginator, err := paging.Paging(&paging.Param{
 		DB:      db,
 		Page:    request.Paging.Page,
 		Limit:   request.Paging.Limit,
+		Offset:  request.Paging.Offset,
 		OrderBy: request.OrderBy,
 		ShowSQL: true,
 	}, &users)
 
 	if err != nil {
-		msg := fmt.Sprintf("error when getting users from db %v", err)
-		s.log.Error(msg)
-		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeInternal}
+		e := transport.Error{Msg: "can't get users", Code: transport.ErrorCodeInternal, Key: keyUserListFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
 	}
 
 	return &service.UsersResponse{
@@ -88,3 +272,196 @@ func (s serviceImpl) GetUsers(_ context.Context, request service.GetUsersRequest
 		Paginator: paginator,
 	}, nil
 }
+
+func (s serviceImpl) SetUserRole(ctx context.Context, request service.SetUserRoleRequest) (*service.UserResponse, error) {
+	if !request.Role.IsValid() {
+		return nil, transport.Error{Msg: fmt.Sprintf("invalid role %s", request.Role), Code: transport.ErrorCodeInvalidParameter, Key: keyUserInvalidRole,
+			Params: map[string]interface{}{"role": request.Role}}
+	}
+
+	err := s.db.Model(&model.User{}).Where("id = ?", request.UserID).Update("role", request.Role).Error
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can't update role of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyUserUpdateFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	s.log.Info(fmt.Sprintf("role of user %d set to %s", request.UserID, request.Role))
+	return s.GetUser(ctx, service.GetUserRequest{UserID: request.UserID})
+}
+
+func (s serviceImpl) ReplaceUser(ctx context.Context, request service.ReplaceUserRequest) (*service.UserResponse, error) {
+	if err := validateEmail(request.User.Email); err != nil {
+		return nil, err
+	}
+	if err := validatePhone(request.User.Phone); err != nil {
+		return nil, err
+	}
+	current, err := s.GetUser(ctx, service.GetUserRequest{UserID: request.User.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	emailChanged, err := s.replaceUser(ctx, s.db, current.User, request)
+	if err != nil {
+		return nil, err
+	}
+	if emailChanged && request.User.Email != nil {
+		s.sendEmailVerification(ctx, request.User.ID)
+	}
+
+	return s.GetUser(ctx, service.GetUserRequest{UserID: request.User.ID})
+}
+
+// replaceUser writes the replacement of current with db, it tells whether the email changed.
+func (s serviceImpl) replaceUser(ctx context.Context, db *gorm.DB, current model.User, request service.ReplaceUserRequest) (bool, error) {
+	updates := map[string]interface{}{
+		"name": request.User.Name,
+		// Gender isn't a pointer, nil would be written as an empty string
+		"gender": gorm.Expr("NULL"),
+		"email":  request.User.Email,
+	}
+	// an unset status is kept, replacing a user doesn't reactivate it
+	if request.User.Status != nil {
+		updates["status"] = *request.User.Status
+	}
+	if len(request.User.Gender) > 0 {
+		updates["gender"] = request.User.Gender
+	}
+	if request.ReplacePhone {
+		updates["phone"] = request.User.Phone
+	}
+	emailChanged := !equalStrings(current.Email, request.User.Email)
+	if emailChanged {
+		updates["email_verified_at"] = nil
+	}
+
+	if err := db.Model(&model.User{}).Where("id = ?", request.User.ID).Updates(updates).Error; err != nil {
+		if e, ok := s.conflictError(ctx, request.User, err); ok {
+			return false, e
+		}
+		e := transport.Error{Msg: fmt.Sprintf("can't replace user %d", request.User.ID), Code: transport.ErrorCodeInternal, Key: keyUserUpdateFailed, Params: userParams(request.User.ID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return false, e
+	}
+	return emailChanged, nil
+}
+
+// personalTables reference users, their rows are deleted when the user is deleted or erased.
+var personalTables = []string{
+	"credentials", "sessions", "password_reset_tokens", "email_verification_tokens", "oidc_authorization_codes",
+	"mfa_recovery_codes", "mfa_factors", "passkeys", "passkey_challenges", "ldap_users",
+}
+
+// userTables reference users, their rows are deleted with the user. The rows of the tables which aren't
+// personal are kept when the user is only erased.
+var userTables = append([]string{"impersonations", "erasures"}, personalTables...)
+
+func (s serviceImpl) DeleteUser(_ context.Context, request service.DeleteUserRequest) (*service.EmptyResponse, error) {
+	deleted := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range userTables {
+			if err := tx.Table(table).Where("user_id = ?", request.UserID).Delete(nil).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("scope = ? AND subject = ?", model.LockoutScopeAccount, userSubject(request.UserID)).
+			Delete(&model.LoginFailure{}).Error; err != nil {
+			return err
+		}
+		ret := tx.Where("id = ?", request.UserID).Delete(&model.User{})
+		deleted = ret.RowsAffected == 1
+		return ret.Error
+	})
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can't delete user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keyUserDeleteFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if !deleted {
+		msg := fmt.Sprintf("not found user %d", request.UserID)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keyUserNotFound, Params: userParams(request.UserID)}
+	}
+
+	s.log.Info(fmt.Sprintf("user %d is deleted", request.UserID))
+	return &service.EmptyResponse{}, nil
+}
+
+// conflictError tells which unique field of the user is already taken when err violates a unique key. The id of the
+// user holding the value is given to principals which may read users.
+func (s serviceImpl) conflictError(ctx context.Context, user model.User, err error) (transport.Error, bool) {
+	key, ok := sqlerr.UniqueViolation(err)
+	if !ok {
+		return transport.Error{}, false
+	}
+	values := map[string]string{"name": user.Name}
+	if user.Email != nil {
+		values["email"] = *user.Email
+	}
+	// the driver error quotes the duplicate value, only the key and the field are logged
+	field, ok := sqlerr.KeyColumn(key, "name", "email")
+	if !ok {
+		s.log.Warn(fmt.Sprintf("user %d conflicts with another user on key %s", user.ID, key))
+		return transport.Error{Msg: "the user conflicts with another user", Code: transport.ErrorCodeConflict}.Wrap(err), true
+	}
+
+	e := transport.Error{
+		Msg:    fmt.Sprintf("%s is already taken", field),
+		Code:   transport.ErrorCodeConflict,
+		Key:    keyUserConflict,
+		Params: map[string]interface{}{"field": field},
+		Fields: []transport.FieldError{{Field: field, Msg: "is already taken", Key: "field.taken"}},
+	}.Wrap(err)
+	if principal, ok := auth.FromContext(ctx); ok && principal.HasPermission(auth.PermissionUsersRead) {
+		var existing model.User
+		if err := pii.Where(s.db, field, values[field]).Select("id").First(&existing).Error; err == nil && existing.ID != user.ID {
+			e.ExistingID = int64(existing.ID)
+		}
+	}
+	s.log.Warn(fmt.Sprintf("user %d conflicts with another user on key %s, %s is already taken", user.ID, key, field))
+	return e, true
+}
+
+// sendEmailVerification doesn't fail the request, the user can ask for another mail later.
+func (s serviceImpl) sendEmailVerification(ctx context.Context, userID model.UserID) {
+	if s.verification == nil {
+		return
+	}
+	if _, err := s.verification.SendEmailVerification(ctx, service.SendEmailVerificationRequest{UserID: userID}); err != nil {
+		s.log.Warn(fmt.Sprintf("can't send verification mail to user %d: %v", userID, err))
+	}
+}
+
+func validateEmail(email *string) error {
+	if email != nil && !model.IsValidEmail(*email) {
+		return transport.Error{Msg: fmt.Sprintf("invalid email %s", *email), Code: transport.ErrorCodeInvalidParameter, Key: keyUserInvalidEmail,
+			Params: map[string]interface{}{"email": *email}}
+	}
+	return nil
+}
+
+func validatePhone(phone *string) error {
+	if phone != nil && !model.IsValidPhone(*phone) {
+		msg := fmt.Sprintf("invalid phone %s", *phone)
+		return transport.Error{Msg: msg, Code: transport.ErrorCodeInvalidParameter, Key: keyUserInvalidPhone,
+			Params: map[string]interface{}{"phone": *phone},
+			Fields: []transport.FieldError{
+				{Field: "phone", Msg: "must be in E.164 format, e.g. +84912345678", Key: "field.phone_format"},
+			},
+		}
+	}
+	return nil
+}
+
+// userParams are the params of the messages about a user.
+func userParams(id model.UserID) map[string]interface{} {
+	return map[string]interface{}{"id": id}
+}
+
+func equalStrings(a *string, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
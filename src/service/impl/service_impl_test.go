@@ -3,20 +3,26 @@ package impl
 import (
 	"context"
 	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 	"gotest.tools/assert"
 	"os"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 	"user-service/src/service"
+	"user-service/src/service/auth"
 	"user-service/src/service/model"
 	"user-service/src/service/transport"
+	"user-service/src/service/util/jsonpatch"
 	"user-service/src/service/util/log"
 )
 
@@ -30,6 +36,7 @@ type userMock struct {
 func initUserMock() userMock {
 	sttActive := model.StatusActive
 	sttInactive := model.StatusInactive
+	roleUser := model.RoleUser
 	db, mock, _ := sqlmock.New()
 	gormDB, _ := gorm.Open("mysql", db)
 	encoder := zapcore.NewJSONEncoder(zapcore.EncoderConfig{
@@ -47,11 +54,11 @@ func initUserMock() userMock {
 	}
 
 	return userMock{
-		userColumn: []string{"id", "name", "gender", "status"},
+		userColumn: []string{"id", "name", "gender", "status", "role", "email", "email_verified_at", "phone"},
 		userData: []model.User{
-			{ID: 1, Name: "ql", Gender: model.Male, Status: &sttActive},
-			{ID: 1, Name: "ql", Gender: model.Female, Status: &sttActive},
-			{ID: 2, Name: "ql", Gender: model.Male, Status: &sttInactive},
+			{ID: 1, Name: "ql", Gender: model.Male, Status: &sttActive, Role: &roleUser},
+			{ID: 1, Name: "ql", Gender: model.Female, Status: &sttActive, Role: &roleUser},
+			{ID: 2, Name: "ql", Gender: model.Male, Status: &sttInactive, Role: &roleUser},
 		},
 		svc:  s,
 		mock: mock,
@@ -199,6 +206,44 @@ func TestServiceImpl_PostUser(t *testing.T) {
 	}
 }
 
+func TestServiceImpl_PostUser_Conflict(t *testing.T) {
+	s := initUserMock()
+	duplicate := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'ql' for key 'users.name'"}
+	expectDuplicate := func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `users` (`name`,`gender`) VALUES (?,?)")).WillReturnError(duplicate)
+		s.mock.ExpectRollback()
+	}
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	s.svc.log = log.NewLogger(zap.New(core))
+
+	// the id of the existing user is left out for callers which can't read users
+	expectDuplicate()
+	_, err := s.svc.PostUser(context.Background(), service.PostUserRequest{User: model.User{Name: "ql"}})
+	e := err.(transport.Error)
+	assert.Equal(t, e.Code, transport.ErrorCodeConflict)
+	assert.Equal(t, e.Key, keyUserConflict)
+	assert.Equal(t, e.Fields[0].Field, "name")
+	assert.Equal(t, e.ExistingID, int64(0))
+
+	// the duplicate value isn't logged
+	assert.Equal(t, logs.Len(), 1)
+	assert.Equal(t, logs.All()[0].Message, "user 0 conflicts with another user on key users.name, name is already taken")
+	assert.Assert(t, !strings.Contains(logs.All()[0].Message, "'ql'"))
+
+	expectDuplicate()
+	s.mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM `users`  WHERE (name = ?) ORDER BY `users`.`id` ASC LIMIT 1")).
+		WithArgs("ql").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "1", Permissions: []string{auth.PermissionUsersRead}})
+	_, err = s.svc.PostUser(ctx, service.PostUserRequest{User: model.User{Name: "ql"}})
+	e = err.(transport.Error)
+	assert.Equal(t, e.Code, transport.ErrorCodeConflict)
+	assert.Equal(t, e.ExistingID, int64(3))
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+}
+
 func TestServiceImpl_PatchUser(t *testing.T) {
 	s := initUserMock()
 
@@ -284,3 +329,101 @@ func TestServiceImpl_PatchUser(t *testing.T) {
 		}
 	}
 }
+
+func TestServiceImpl_PatchUser_Patches(t *testing.T) {
+	s := initUserMock()
+	expectUser := func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id = ?)")).
+			WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(s.userData[0])...))
+	}
+
+	// the user is read locked, in the transaction of the write
+	expectLockedUser := func() {
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id = ?) FOR UPDATE")).
+			WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(s.userData[0])...))
+	}
+
+	// a failed test leaves the user as it is
+	expectLockedUser()
+	s.mock.ExpectRollback()
+	_, err := s.svc.PatchUser(context.Background(), service.PatchUserRequest{User: model.User{ID: 1},
+		Operations: []jsonpatch.Operation{{Op: jsonpatch.OpTest, Path: "/name", Value: json.RawMessage(`"QL"`)}}})
+	e := err.(transport.Error)
+	assert.Equal(t, e.Code, transport.ErrorCodeConflict)
+	assert.Equal(t, e.Key, keyUserTestFailed)
+
+	// the fields the merge patch doesn't name are written back, null clears the gender
+	expectLockedUser()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `users` SET `email` = ?, `gender` = NULL, `name` = ?, `phone` = ?, `status` = ? WHERE (id = ?)")).
+		WithArgs(nil, "QL", "+84912345678", model.StatusActive, 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	expectUser()
+	_, err = s.svc.PatchUser(context.Background(), service.PatchUserRequest{User: model.User{ID: 1},
+		MergePatch: json.RawMessage(`{"name":"QL","gender":null,"phone":"+84912345678"}`)})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+}
+
+func TestServiceImpl_ReplaceUser(t *testing.T) {
+	s := initUserMock()
+	expectUser := func() {
+		s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (id = ?)")).
+			WithArgs(2).
+			WillReturnRows(s.mock.NewRows(s.userColumn).AddRow(structToDriverValueArray(s.userData[2])...))
+	}
+
+	// the status isn't written when it is unset, the inactive user stays inactive
+	expectUser()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `users` SET `email` = ?, `gender` = ?, `name` = ?, `phone` = ? WHERE (id = ?)")).
+		WithArgs(nil, model.Female, "QL", nil, 2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	expectUser()
+	res, err := s.svc.ReplaceUser(context.Background(), service.ReplaceUserRequest{
+		User:         model.User{ID: 2, Name: "QL", Gender: model.Female},
+		ReplacePhone: true,
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+	assert.Equal(t, *res.User.Status, model.StatusInactive)
+}
+
+func TestServiceImpl_DeleteUser(t *testing.T) {
+	expectDeletes := func(mock sqlmock.Sqlmock, deleted int64) {
+		mock.ExpectBegin()
+		for _, table := range userTables {
+			mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `" + table + "`  WHERE (user_id = ?)")).
+				WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `login_failures`  WHERE (scope = ? AND subject = ?)")).
+			WithArgs(model.LockoutScopeAccount, "1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `users`  WHERE (id = ?)")).
+			WithArgs(1).WillReturnResult(sqlmock.NewResult(0, deleted))
+		mock.ExpectCommit()
+	}
+
+	tests := []struct {
+		name    string
+		deleted int64
+		wantErr transport.ResponseCode
+	}{
+		{name: "user not found", deleted: 0, wantErr: transport.ErrorCodeNotFound},
+		{name: "delete success", deleted: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := initUserMock()
+			expectDeletes(s.mock, tt.deleted)
+			_, err := s.svc.DeleteUser(context.Background(), service.DeleteUserRequest{UserID: 1})
+			if tt.wantErr != 0 {
+				assert.Equal(t, err.(transport.Error).Code, tt.wantErr)
+			} else {
+				assert.NilError(t, err)
+			}
+			assert.NilError(t, s.mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	log2 "user-service/src/service/util/log"
+)
+
+const (
+	// sessionTouchInterval limits the writes made to record the use of a session by access tokens.
+	sessionTouchInterval = time.Minute
+	maxUserAgentLength   = 512
+)
+
+// keys of the errors of SessionService
+const (
+	keySessionInvalid         = "session.invalid"
+	keySessionCreateFailed    = "session.create_failed"
+	keySessionUseFailed       = "session.use_failed"
+	keySessionListFailed      = "session.list_failed"
+	keySessionNotFound        = "session.not_found"
+	keySessionRevokeFailed    = "session.revoke_failed"
+	keySessionRevokeAllFailed = "session.revoke_all_failed"
+)
+
+type sessionServiceImpl struct {
+	db  *gorm.DB
+	log *log2.Logger
+	// ttl is the lifetime of a session after its last refresh, i.e. the lifetime of refresh tokens.
+	ttl time.Duration
+	now func() time.Time
+}
+
+var errInvalidSession = transport.Error{Msg: "session is revoked or expired", Code: transport.ErrorCodeUnauthorized, Key: keySessionInvalid}
+
+func NewSessionServiceImpl(db *gorm.DB, log *log2.Logger, ttl time.Duration) (service.SessionService, error) {
+	src := sessionServiceImpl{
+		db:  db,
+		log: log,
+		ttl: ttl,
+		now: time.Now,
+	}
+
+	return src, nil
+}
+
+func (s sessionServiceImpl) CreateSession(ctx context.Context, request service.CreateSessionRequest) (*model.Session, error) {
+	id, err := randomToken(16)
+	if err != nil {
+		e := transport.Error{Msg: "can not generate session id", Code: transport.ErrorCodeInternal, Key: keySessionCreateFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	userAgent := auth.UserAgentFromContext(ctx)
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	now := s.now()
+	session := model.Session{
+		ID:         id,
+		UserID:     request.UserID,
+		Device:     describeDevice(userAgent),
+		IP:         auth.ClientIPFromContext(ctx),
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.ttl),
+	}
+	if err := s.db.Create(&session).Error; err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not create session for user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keySessionCreateFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	return &session, nil
+}
+
+func (s sessionServiceImpl) UseSession(ctx context.Context, request service.UseSessionRequest) error {
+	if len(request.SessionID) == 0 {
+		return errInvalidSession
+	}
+
+	var session model.Session
+	if err := s.db.Where("id = ?", request.SessionID).Find(&session).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return errInvalidSession
+		}
+		e := transport.Error{Msg: fmt.Sprintf("error when get session %s", request.SessionID), Code: transport.ErrorCodeInternal, Key: keySessionUseFailed}.Wrap(err)
+		s.log.Error(e.Error())
+		return e
+	}
+
+	now := s.now()
+	if session.UserID != request.UserID || session.RevokedAt != nil || !now.Before(session.ExpiresAt) {
+		return errInvalidSession
+	}
+
+	var updates map[string]interface{}
+	if request.Refresh {
+		updates = map[string]interface{}{
+			"last_used_at": now,
+			"ip":           auth.ClientIPFromContext(ctx),
+			"expires_at":   now.Add(s.ttl),
+		}
+	} else if now.Sub(session.LastUsedAt) >= sessionTouchInterval {
+		updates = map[string]interface{}{
+			"last_used_at": now,
+			"ip":           auth.ClientIPFromContext(ctx),
+		}
+	}
+	if updates == nil {
+		return nil
+	}
+
+	// conditional so a refresh racing with a revocation can't extend a revoked session
+	result := s.db.Model(&model.Session{}).Where("id = ? AND revoked_at IS NULL", session.ID).Updates(updates)
+	if result.Error != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not update session %s", session.ID), Code: transport.ErrorCodeInternal, Key: keySessionUseFailed}.Wrap(result.Error)
+		s.log.Error(e.Error())
+		return e
+	}
+	if result.RowsAffected == 0 {
+		return errInvalidSession
+	}
+	return nil
+}
+
+func (s sessionServiceImpl) ListSessions(ctx context.Context, request service.ListSessionsRequest) (*service.SessionsResponse, error) {
+	var sessions []model.Session
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", request.UserID, s.now()).
+		Order("last_used_at desc").Find(&sessions).Error
+	if err != nil {
+		e := transport.Error{Msg: fmt.Sprintf("error when get sessions of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keySessionListFailed, Params: userParams(request.UserID)}.Wrap(err)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	if principal, ok := auth.FromContext(ctx); ok && len(principal.SessionID) > 0 {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == principal.SessionID
+		}
+	}
+	if sessions == nil {
+		sessions = []model.Session{}
+	}
+	return &service.SessionsResponse{Sessions: sessions}, nil
+}
+
+func (s sessionServiceImpl) RevokeSession(_ context.Context, request service.RevokeSessionRequest) (*service.EmptyResponse, error) {
+	result := s.db.Model(&model.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", request.SessionID, request.UserID).
+		Update("revoked_at", s.now())
+	if result.Error != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not revoke session %s", request.SessionID), Code: transport.ErrorCodeInternal, Key: keySessionRevokeFailed, Params: map[string]interface{}{"session": request.SessionID}}.Wrap(result.Error)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+	if result.RowsAffected == 0 {
+		msg := fmt.Sprintf("not found session %s of user %d", request.SessionID, request.UserID)
+		s.log.Error(msg)
+		return nil, transport.Error{Msg: msg, Code: transport.ErrorCodeNotFound, Key: keySessionNotFound, Params: map[string]interface{}{"session": request.SessionID, "id": request.UserID}}
+	}
+
+	s.log.Info(fmt.Sprintf("session %s of user %d is revoked", request.SessionID, request.UserID))
+	return &service.EmptyResponse{}, nil
+}
+
+func (s sessionServiceImpl) RevokeAllSessions(_ context.Context, request service.RevokeAllSessionsRequest) (*service.RevokeSessionsResponse, error) {
+	db := s.db.Model(&model.Session{}).Where("user_id = ? AND revoked_at IS NULL", request.UserID)
+	if len(request.Except) > 0 {
+		db = db.Where("id <> ?", request.Except)
+	}
+	result := db.Update("revoked_at", s.now())
+	if result.Error != nil {
+		e := transport.Error{Msg: fmt.Sprintf("can not revoke sessions of user %d", request.UserID), Code: transport.ErrorCodeInternal, Key: keySessionRevokeAllFailed, Params: userParams(request.UserID)}.Wrap(result.Error)
+		s.log.Error(e.Error())
+		return nil, e
+	}
+
+	s.log.Info(fmt.Sprintf("%d sessions of user %d are revoked", result.RowsAffected, request.UserID))
+	return &service.RevokeSessionsResponse{Revoked: result.RowsAffected}, nil
+}
+
+var (
+	// the order matters, e.g. Edge and Chrome user agents also contain "Chrome/" and "Safari/"
+	knownBrowsers = [][2]string{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	}
+	knownSystems = [][2]string{
+		{"Windows", "Windows"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Android", "Android"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// describeDevice gives a short description of a user agent, users recognize their sessions by it.
+func describeDevice(userAgent string) string {
+	var browser, system string
+	for _, known := range knownBrowsers {
+		if strings.Contains(userAgent, known[0]) {
+			browser = known[1]
+			break
+		}
+	}
+	for _, known := range knownSystems {
+		if strings.Contains(userAgent, known[0]) {
+			system = known[1]
+			break
+		}
+	}
+
+	switch {
+	case len(browser) > 0 && len(system) > 0:
+		return browser + " on " + system
+	case len(browser) > 0:
+		return browser
+	case len(system) > 0:
+		return system
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package impl
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"gotest.tools/assert"
+	"regexp"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/transport"
+)
+
+var sessionColumns = []string{"id", "user_id", "device", "ip", "user_agent", "created_at", "last_used_at", "expires_at", "revoked_at"}
+
+func initSessionMock(now time.Time) (sessionServiceImpl, userMock) {
+	s := initUserMock()
+	svc := sessionServiceImpl{
+		db:  s.svc.db,
+		log: s.svc.log,
+		ttl: time.Hour,
+		now: func() time.Time { return now },
+	}
+	return svc, s
+}
+
+func TestDescribeDevice(t *testing.T) {
+	tests := map[string]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0":           "Edge on Windows",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0":                                                                  "Firefox on Linux",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1": "Safari on iOS",
+		"curl/8.4.0": "",
+	}
+	for userAgent, expected := range tests {
+		assert.Equal(t, describeDevice(userAgent), expected)
+	}
+}
+
+func TestSessionServiceImpl_UseSession(t *testing.T) {
+	now := time.Now()
+	revokedAt := now.Add(-time.Minute)
+
+	tests := []struct {
+		name      string
+		request   service.UseSessionRequest
+		session   []driver.Value
+		expectErr bool
+		touched   bool
+	}{
+		{
+			name:    "recently used",
+			request: service.UseSessionRequest{SessionID: "sid", UserID: 1},
+			session: []driver.Value{"sid", 1, "", "", "", now, now, now.Add(time.Hour), nil},
+		},
+		{
+			name:    "touched",
+			request: service.UseSessionRequest{SessionID: "sid", UserID: 1},
+			session: []driver.Value{"sid", 1, "", "", "", now, now.Add(-time.Hour), now.Add(time.Hour), nil},
+			touched: true,
+		},
+		{
+			name:    "refreshed",
+			request: service.UseSessionRequest{SessionID: "sid", UserID: 1, Refresh: true},
+			session: []driver.Value{"sid", 1, "", "", "", now, now, now.Add(time.Hour), nil},
+			touched: true,
+		},
+		{
+			name:      "revoked",
+			request:   service.UseSessionRequest{SessionID: "sid", UserID: 1},
+			session:   []driver.Value{"sid", 1, "", "", "", now, now, now.Add(time.Hour), revokedAt},
+			expectErr: true,
+		},
+		{
+			name:      "expired",
+			request:   service.UseSessionRequest{SessionID: "sid", UserID: 1, Refresh: true},
+			session:   []driver.Value{"sid", 1, "", "", "", now, now, now.Add(-time.Second), nil},
+			expectErr: true,
+		},
+		{
+			name:      "other user",
+			request:   service.UseSessionRequest{SessionID: "sid", UserID: 2},
+			session:   []driver.Value{"sid", 1, "", "", "", now, now, now.Add(time.Hour), nil},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, s := initSessionMock(now)
+			s.mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `sessions`  WHERE (id = ?)")).
+				WithArgs("sid").
+				WillReturnRows(s.mock.NewRows(sessionColumns).AddRow(tt.session...))
+			if tt.touched {
+				s.mock.ExpectBegin()
+				s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `sessions` SET")).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				s.mock.ExpectCommit()
+			}
+
+			err := svc.UseSession(context.Background(), tt.request)
+			assert.NilError(t, s.mock.ExpectationsWereMet())
+			if tt.expectErr {
+				assert.Error(t, err, errInvalidSession.Error())
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func TestSessionServiceImpl_RevokeSession(t *testing.T) {
+	now := time.Now()
+	svc, s := initSessionMock(now)
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `sessions` SET `revoked_at` = ? WHERE (id = ? AND user_id = ? AND revoked_at IS NULL)")).
+		WithArgs(now, "sid", 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	_, err := svc.RevokeSession(context.Background(), service.RevokeSessionRequest{UserID: 1, SessionID: "sid"})
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodeNotFound)
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `sessions` SET `revoked_at` = ? WHERE (user_id = ? AND revoked_at IS NULL)")).
+		WithArgs(now, 1).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	s.mock.ExpectCommit()
+	res, err := svc.RevokeAllSessions(context.Background(), service.RevokeAllSessionsRequest{UserID: 1})
+	assert.NilError(t, err)
+	assert.Equal(t, res.Revoked, int64(3))
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(regexp.QuoteMeta("UPDATE `sessions` SET `revoked_at` = ? WHERE (user_id = ? AND revoked_at IS NULL) AND (id <> ?)")).
+		WithArgs(now, 1, "sid").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mock.ExpectCommit()
+	res, err = svc.RevokeAllSessions(context.Background(), service.RevokeAllSessionsRequest{UserID: 1, Except: "sid"})
+	assert.NilError(t, err)
+	assert.Equal(t, res.Revoked, int64(2))
+	assert.NilError(t, s.mock.ExpectationsWereMet())
+}
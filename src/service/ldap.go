@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"user-service/src/service/model"
+)
+
+// LDAPSyncRequest with DryRun only reports the changes the sync would make.
+type LDAPSyncRequest struct {
+	DryRun bool
+}
+
+// LDAPUserChange describes a user created, updated or deactivated by the sync, Fields are the changed
+// attributes with their new values.
+type LDAPUserChange struct {
+	DN     string            `json:"dn"`
+	UserID model.UserID      `json:"user_id,omitempty"`
+	Name   string            `json:"name"`
+	Fields map[string]string `json:"fields,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+type LDAPSyncResponse struct {
+	DryRun      bool             `json:"dry_run"`
+	Created     []LDAPUserChange `json:"created"`
+	Updated     []LDAPUserChange `json:"updated"`
+	Deactivated []LDAPUserChange `json:"deactivated"`
+	// Failed entries are skipped, e.g. when they have no name or their name belongs to another user
+	Failed    []LDAPUserChange `json:"failed"`
+	Unchanged int              `json:"unchanged"`
+}
+
+type LDAPBindRequest struct {
+	UserID   model.UserID
+	Password string
+}
+
+// LDAPBindResponse is not Linked for users which aren't synced from the directory, their local password applies.
+type LDAPBindResponse struct {
+	Linked        bool
+	Authenticated bool
+}
+
+type LDAPService interface {
+	// Sync imports and updates the users of the directory, linked users missing from it are deactivated.
+	Sync(ctx context.Context, request LDAPSyncRequest) (*LDAPSyncResponse, error)
+	// Bind checks the password of a linked user against the directory.
+	Bind(ctx context.Context, request LDAPBindRequest) (*LDAPBindResponse, error)
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"time"
+	"user-service/src/service/model"
+)
+
+// LoginAttemptRequest identifies who is trying to log in, UserID is nil when the name matches no user.
+type LoginAttemptRequest struct {
+	UserID *model.UserID
+	IP     string
+}
+
+type GetLockoutRequest struct {
+	UserID model.UserID
+}
+
+type LockoutResponse struct {
+	Locked      bool                 `json:"locked"`
+	LockedUntil *time.Time           `json:"locked_until,omitempty"`
+	Failures    int                  `json:"failures"`
+	Events      []model.LockoutEvent `json:"events"`
+}
+
+type UnlockUserRequest struct {
+	UserID model.UserID
+}
+
+type LockoutService interface {
+	// CheckLogin rejects attempts on a locked account or from a locked address, and attempts made before
+	// the delay following the last failure is over.
+	CheckLogin(ctx context.Context, request LoginAttemptRequest) error
+	RecordLoginFailure(ctx context.Context, request LoginAttemptRequest) error
+	// RecordLoginSuccess forgets the failures of the account, the failures of the address are kept.
+	RecordLoginSuccess(ctx context.Context, request LoginAttemptRequest) error
+	GetLockout(ctx context.Context, request GetLockoutRequest) (*LockoutResponse, error)
+	UnlockUser(ctx context.Context, request UnlockUserRequest) (*LockoutResponse, error)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"user-service/src/service/model"
+)
+
+type EnrollTOTPRequest struct {
+	UserID model.UserID
+}
+
+func (r EnrollTOTPRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type EnrollTOTPResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type ConfirmTOTPRequest struct {
+	UserID model.UserID `json:"-"`
+	Code   string       `json:"code"`
+}
+
+func (r ConfirmTOTPRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type RecoveryCodesResponse struct {
+	// RecoveryCodes are single use, they are only shown once.
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// DisableTOTPRequest needs a current Code or a RecoveryCode when users disable their own factor.
+type DisableTOTPRequest struct {
+	UserID       model.UserID `json:"-"`
+	Code         string       `json:"code"`
+	RecoveryCode string       `json:"recovery_code"`
+}
+
+func (r DisableTOTPRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type RegenerateRecoveryCodesRequest struct {
+	UserID model.UserID
+}
+
+func (r RegenerateRecoveryCodesRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type GetMFAStatusRequest struct {
+	UserID model.UserID
+}
+
+func (r GetMFAStatusRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type MFAStatusResponse struct {
+	Enrolled          bool `json:"enrolled"`
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// VerifyMFARequest accepts either a TOTP code or a recovery code.
+type VerifyMFARequest struct {
+	UserID       model.UserID
+	Code         string
+	RecoveryCode string
+}
+
+type VerifyMFAResponse struct {
+	Valid bool
+}
+
+type MFAService interface {
+	EnrollTOTP(ctx context.Context, request EnrollTOTPRequest) (*EnrollTOTPResponse, error)
+	ConfirmTOTP(ctx context.Context, request ConfirmTOTPRequest) (*RecoveryCodesResponse, error)
+	DisableTOTP(ctx context.Context, request DisableTOTPRequest) (*EmptyResponse, error)
+	RegenerateRecoveryCodes(ctx context.Context, request RegenerateRecoveryCodesRequest) (*RecoveryCodesResponse, error)
+	GetMFAStatus(ctx context.Context, request GetMFAStatusRequest) (*MFAStatusResponse, error)
+	VerifyMFA(ctx context.Context, request VerifyMFARequest) (*VerifyMFAResponse, error)
+}
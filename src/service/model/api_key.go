@@ -0,0 +1,35 @@
+package model
+
+import "time"
+
+type APIKeyID int
+
+type Permissions = StringList
+
+type APIKey struct {
+	ID          APIKeyID    `gorm:"column:id" json:"id"`
+	Name        string      `gorm:"column:name" json:"name"`
+	Prefix      string      `gorm:"column:prefix" json:"prefix"`
+	KeyHash     string      `gorm:"column:key_hash" json:"-"`
+	Permissions Permissions `gorm:"column:permissions" json:"permissions"`
+	DailyQuota  int         `gorm:"column:daily_quota" json:"daily_quota"`
+	ExpiresAt   *time.Time  `gorm:"column:expires_at" json:"expires_at,omitempty"`
+	RevokedAt   *time.Time  `gorm:"column:revoked_at" json:"revoked_at,omitempty"`
+	CreatedAt   time.Time   `gorm:"column:created_at" json:"created_at"`
+	// CreatedBy is the principal who created the key, e.g. USER:1
+	CreatedBy string `gorm:"column:created_by" json:"created_by"`
+}
+
+func (k APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+func (k APIKey) IsRevoked() bool {
+	return k.RevokedAt != nil
+}
+
+type APIKeyUsage struct {
+	APIKeyID APIKeyID `gorm:"column:api_key_id" json:"api_key_id"`
+	Day      string   `gorm:"column:day" json:"day"`
+	Count    int      `gorm:"column:count" json:"count"`
+}
@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// AuditRecord keeps who did what, Actor is the real principal and Principal the effective one, they only
+// differ while an admin impersonates a user.
+type AuditRecord struct {
+	ID        int       `gorm:"column:id;primary_key" json:"id"`
+	Actor     string    `gorm:"column:actor" json:"actor"`
+	Principal string    `gorm:"column:principal" json:"principal"`
+	Action    string    `gorm:"column:action" json:"action"`
+	Detail    string    `gorm:"column:detail" json:"detail"`
+	IP        string    `gorm:"column:ip" json:"ip"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (AuditRecord) TableName() string {
+	return "audit_records"
+}
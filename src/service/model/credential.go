@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// Credential is kept out of User so the password hash can never be serialized with a user.
+type Credential struct {
+	UserID       UserID    `gorm:"column:user_id;primary_key" json:"-"`
+	PasswordHash string    `gorm:"column:password_hash" json:"-"`
+	UpdatedAt    time.Time `gorm:"column:updated_at" json:"-"`
+}
+
+// PasswordResetToken stores the hash of the token mailed to the user, the token itself is never stored.
+type PasswordResetToken struct {
+	TokenHash string     `gorm:"column:token_hash;primary_key"`
+	UserID    UserID     `gorm:"column:user_id"`
+	ExpiresAt time.Time  `gorm:"column:expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+	CreatedAt time.Time  `gorm:"column:created_at"`
+}
+
+func (PasswordResetToken) TableName() string {
+	return "password_reset_tokens"
+}
+
+// EmailVerificationToken is bound to the address it was sent to, it can't verify a later address.
+type EmailVerificationToken struct {
+	TokenHash string     `gorm:"column:token_hash;primary_key"`
+	UserID    UserID     `gorm:"column:user_id"`
+	Email     string     `gorm:"column:email" pii:"true"`
+	ExpiresAt time.Time  `gorm:"column:expires_at"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+	CreatedAt time.Time  `gorm:"column:created_at"`
+}
+
+func (EmailVerificationToken) TableName() string {
+	return "email_verification_tokens"
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+type ErasureID int
+
+// Erasure is a request to erase the personal data of a user, it is carried out at ScheduledAt unless it is
+// canceled before. The user row is kept as a tombstone so the records referencing it stay valid.
+type Erasure struct {
+	ID     ErasureID `gorm:"column:id;primary_key" json:"id"`
+	UserID UserID    `gorm:"column:user_id" json:"user_id"`
+	// RequestedBy is the principal who asked for it, e.g. USER:7
+	RequestedBy string     `gorm:"column:requested_by" json:"requested_by"`
+	RequestedAt time.Time  `gorm:"column:requested_at" json:"requested_at"`
+	ScheduledAt time.Time  `gorm:"column:scheduled_at" json:"scheduled_at"`
+	CanceledAt  *time.Time `gorm:"column:canceled_at" json:"canceled_at,omitempty"`
+	ErasedAt    *time.Time `gorm:"column:erased_at" json:"erased_at,omitempty"`
+}
+
+func (Erasure) TableName() string {
+	return "erasures"
+}
@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+type ImpersonationID int
+
+// Impersonation is started by an admin to act as a user, its access token belongs to the session of the user
+// with SessionID, so revoking the session ends it as well.
+type Impersonation struct {
+	ID        ImpersonationID `gorm:"column:id;primary_key" json:"id"`
+	AdminID   UserID          `gorm:"column:admin_id" json:"admin_id"`
+	UserID    UserID          `gorm:"column:user_id" json:"user_id"`
+	SessionID string          `gorm:"column:session_id" json:"session_id"`
+	Reason    string          `gorm:"column:reason" json:"reason"`
+	CreatedAt time.Time       `gorm:"column:created_at" json:"created_at"`
+	ExpiresAt time.Time       `gorm:"column:expires_at" json:"expires_at"`
+	EndedAt   *time.Time      `gorm:"column:ended_at" json:"ended_at"`
+}
+
+func (Impersonation) TableName() string {
+	return "impersonations"
+}
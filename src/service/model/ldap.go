@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// LDAPUser links a user to the directory entry it is synced from.
+type LDAPUser struct {
+	UserID   UserID    `gorm:"column:user_id;primary_key" json:"user_id"`
+	DN       string    `gorm:"column:dn" json:"dn"`
+	SyncedAt time.Time `gorm:"column:synced_at" json:"synced_at"`
+	// DeactivatedAt is set when the sync deactivated the user because the entry was missing,
+	// only those users are activated again when the entry comes back.
+	DeactivatedAt *time.Time `gorm:"column:deactivated_at" json:"deactivated_at"`
+}
+
+func (LDAPUser) TableName() string {
+	return "ldap_users"
+}
@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+type LockoutScope string
+
+const (
+	LockoutScopeAccount LockoutScope = "ACCOUNT"
+	LockoutScopeIP      LockoutScope = "IP"
+)
+
+type LockoutEventType string
+
+const (
+	LockoutEventLocked   LockoutEventType = "LOCKED"
+	LockoutEventUnlocked LockoutEventType = "UNLOCKED"
+)
+
+// LoginFailure counts the recent failed logins of an account (Subject is the user id) or of a client address.
+type LoginFailure struct {
+	Scope         LockoutScope `gorm:"column:scope;primary_key"`
+	Subject       string       `gorm:"column:subject;primary_key"`
+	Failures      int          `gorm:"column:failures"`
+	LastFailureAt time.Time    `gorm:"column:last_failure_at"`
+	LockedUntil   *time.Time   `gorm:"column:locked_until"`
+}
+
+func (LoginFailure) TableName() string {
+	return "login_failures"
+}
+
+type LockoutEvent struct {
+	ID      int64            `gorm:"column:id;primary_key" json:"id"`
+	Scope   LockoutScope     `gorm:"column:scope" json:"scope"`
+	Subject string           `gorm:"column:subject" json:"subject"`
+	Event   LockoutEventType `gorm:"column:event" json:"event"`
+	// Actor is the principal who unlocked, it is empty for automatic locks.
+	Actor       string     `gorm:"column:actor" json:"actor,omitempty"`
+	LockedUntil *time.Time `gorm:"column:locked_until" json:"locked_until,omitempty"`
+	CreatedAt   time.Time  `gorm:"column:created_at" json:"created_at"`
+}
+
+func (LockoutEvent) TableName() string {
+	return "lockout_events"
+}
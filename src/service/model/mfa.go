@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+type MFAFactor struct {
+	UserID UserID `gorm:"column:user_id;primary_key"`
+	// Secret is encrypted, see encryption.AESGCM.
+	Secret      string     `gorm:"column:secret" json:"-"`
+	LastStep    int64      `gorm:"column:last_step"`
+	ConfirmedAt *time.Time `gorm:"column:confirmed_at"`
+	CreatedAt   time.Time  `gorm:"column:created_at"`
+}
+
+func (MFAFactor) TableName() string {
+	return "mfa_factors"
+}
+
+func (f MFAFactor) IsConfirmed() bool {
+	return f.ConfirmedAt != nil
+}
+
+type RecoveryCode struct {
+	ID       int        `gorm:"column:id"`
+	UserID   UserID     `gorm:"column:user_id"`
+	CodeHash string     `gorm:"column:code_hash"`
+	UsedAt   *time.Time `gorm:"column:used_at"`
+}
+
+func (RecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
@@ -0,0 +1,49 @@
+package model
+
+import "time"
+
+type OIDCClient struct {
+	ID           string     `gorm:"column:id;primary_key" json:"client_id"`
+	Name         string     `gorm:"column:name" json:"name"`
+	SecretHash   string     `gorm:"column:secret_hash" json:"-"`
+	RedirectURIs StringList `gorm:"column:redirect_uris" json:"redirect_uris"`
+	CreatedAt    time.Time  `gorm:"column:created_at" json:"created_at"`
+}
+
+// IsPublic reports whether the client can't keep a secret (e.g. SPA, mobile app), these clients rely on PKCE only.
+func (c OIDCClient) IsPublic() bool {
+	return len(c.SecretHash) == 0
+}
+
+func (OIDCClient) TableName() string {
+	return "oidc_clients"
+}
+
+type OIDCSigningKey struct {
+	ID         string     `gorm:"column:id;primary_key"`
+	Algorithm  string     `gorm:"column:algorithm"`
+	PrivateKey string     `gorm:"column:private_key"`
+	Active     bool       `gorm:"column:active"`
+	CreatedAt  time.Time  `gorm:"column:created_at"`
+	RetiredAt  *time.Time `gorm:"column:retired_at"`
+}
+
+func (OIDCSigningKey) TableName() string {
+	return "oidc_signing_keys"
+}
+
+type OIDCAuthorizationCode struct {
+	CodeHash      string     `gorm:"column:code_hash;primary_key"`
+	ClientID      string     `gorm:"column:client_id"`
+	UserID        UserID     `gorm:"column:user_id"`
+	RedirectURI   string     `gorm:"column:redirect_uri"`
+	Scope         string     `gorm:"column:scope"`
+	Nonce         string     `gorm:"column:nonce"`
+	CodeChallenge string     `gorm:"column:code_challenge"`
+	ExpiresAt     time.Time  `gorm:"column:expires_at"`
+	UsedAt        *time.Time `gorm:"column:used_at"`
+}
+
+func (OIDCAuthorizationCode) TableName() string {
+	return "oidc_authorization_codes"
+}
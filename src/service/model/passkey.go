@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+type PasskeyID int
+
+// Passkey is a WebAuthn credential registered by a user.
+type Passkey struct {
+	ID     PasskeyID `gorm:"column:id" json:"id"`
+	UserID UserID    `gorm:"column:user_id" json:"user_id"`
+	Name   string    `gorm:"column:name" json:"name"`
+	// CredentialID is base64url encoded, as the browser sends it
+	CredentialID string `gorm:"column:credential_id" json:"credential_id"`
+	// PublicKey is COSE encoded
+	PublicKey  []byte     `gorm:"column:public_key" json:"-"`
+	SignCount  uint32     `gorm:"column:sign_count" json:"-"`
+	Transports StringList `gorm:"column:transports" json:"transports"`
+	CreatedAt  time.Time  `gorm:"column:created_at" json:"created_at"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at" json:"last_used_at,omitempty"`
+}
+
+func (Passkey) TableName() string {
+	return "passkeys"
+}
+
+// PasskeyChallenge is issued when a ceremony begins and consumed when it finishes.
+type PasskeyChallenge struct {
+	Challenge string `gorm:"column:challenge;primary_key"`
+	Ceremony  string `gorm:"column:ceremony"`
+	// UserID is nil for logins with a discoverable credential, the user is only known at the end
+	UserID    *UserID   `gorm:"column:user_id"`
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+}
+
+func (PasskeyChallenge) TableName() string {
+	return "passkey_challenges"
+}
@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// Session is started by a login, the access and refresh tokens issued for it carry its id.
+type Session struct {
+	ID     string `gorm:"column:id;primary_key" json:"id"`
+	UserID UserID `gorm:"column:user_id" json:"user_id"`
+	// Device is a short description of the user agent, e.g. "Firefox on Linux".
+	Device     string     `gorm:"column:device" json:"device"`
+	IP         string     `gorm:"column:ip" json:"ip"`
+	UserAgent  string     `gorm:"column:user_agent" json:"user_agent"`
+	CreatedAt  time.Time  `gorm:"column:created_at" json:"created_at"`
+	LastUsedAt time.Time  `gorm:"column:last_used_at" json:"last_used_at"`
+	ExpiresAt  time.Time  `gorm:"column:expires_at" json:"expires_at"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at" json:"-"`
+	// Current marks the session of the caller.
+	Current bool `gorm:"-" json:"current"`
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
@@ -1,9 +1,15 @@
 package model
 
+import (
+	"net/mail"
+	"time"
+)
+
 type (
 	UserID int
 	Status string
 	Gender string
+	Role   string
 )
 
 const (
@@ -24,9 +30,55 @@ func (g Gender) IsValid() bool {
 	return g == Female || g == Male
 }
 
+const (
+	RoleUser    Role = "USER"
+	RoleSupport Role = "SUPPORT"
+	RoleAdmin   Role = "ADMIN"
+)
+
+func (r Role) IsValid() bool {
+	return r == RoleUser || r == RoleSupport || r == RoleAdmin
+}
+
+// Rank orders the roles by privilege, unknown roles rank as USER.
+func (r Role) Rank() int {
+	switch r {
+	case RoleAdmin:
+		return 2
+	case RoleSupport:
+		return 1
+	}
+	return 0
+}
+
+// IsValidEmail accepts a bare address, display names like "Name <address>" are rejected.
+func IsValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// IsValidPhone accepts numbers in E.164 format, e.g. +84912345678.
+func IsValidPhone(phone string) bool {
+	if len(phone) < 8 || len(phone) > 16 || phone[0] != '+' || phone[1] == '0' {
+		return false
+	}
+	for _, c := range phone[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// User fields tagged pii are masked in logs, see log.Redact.
 type User struct {
-	ID     UserID `gorm:"column:id" json:"id"`
-	Name   string `gorm:"column:name" json:"name"`
-	Gender Gender `gorm:"column:gender" json:"gender"`
+	ID     UserID  `gorm:"column:id" json:"id"`
+	Name   string  `gorm:"column:name" json:"name" pii:"true"`
+	Gender Gender  `gorm:"column:gender" json:"gender"`
 	Status *Status `gorm:"column:status;default:null" json:"status"`
+	Role   *Role   `gorm:"column:role;default:null" json:"role"`
+	Email  *string `gorm:"column:email;default:null" json:"email" pii:"true"`
+	// EmailVerifiedAt is reset whenever the email changes
+	EmailVerifiedAt *time.Time `gorm:"column:email_verified_at;default:null" json:"email_verified_at"`
+	Phone           *string    `gorm:"column:phone;default:null" json:"phone" pii:"true"`
 }
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"time"
+	"user-service/src/service/model"
+)
+
+type DiscoveryRequest struct{}
+
+type DiscoveryResponse struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+type JWKSRequest struct{}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JWKSResponse struct {
+	Keys []JWK `json:"keys"`
+}
+
+type AuthorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// AuthorizeResponse holds the redirect back to the client, with either a code or an OAuth error.
+type AuthorizeResponse struct {
+	RedirectURL string
+}
+
+type OIDCTokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientID     string
+	ClientSecret string
+	CodeVerifier string
+}
+
+type OIDCTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+type UserInfoRequest struct {
+	AccessToken string
+}
+
+type UserInfoResponse map[string]interface{}
+
+type RegisterOIDCClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Public       bool     `json:"public"`
+}
+
+type OIDCClientResponse struct {
+	Client model.OIDCClient `json:"client"`
+	// ClientSecret is only returned when a confidential client is registered.
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+type OIDCClientsResponse struct {
+	Clients []model.OIDCClient `json:"clients"`
+}
+
+type ListOIDCClientsRequest struct{}
+
+type RotateSigningKeyRequest struct{}
+
+type SigningKeyResponse struct {
+	KeyID     string    `json:"kid"`
+	Algorithm string    `json:"alg"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OIDCService interface {
+	Discovery(ctx context.Context, request DiscoveryRequest) (*DiscoveryResponse, error)
+	JWKS(ctx context.Context, request JWKSRequest) (*JWKSResponse, error)
+	Authorize(ctx context.Context, request AuthorizeRequest) (*AuthorizeResponse, error)
+	Token(ctx context.Context, request OIDCTokenRequest) (*OIDCTokenResponse, error)
+	UserInfo(ctx context.Context, request UserInfoRequest) (*UserInfoResponse, error)
+	RegisterClient(ctx context.Context, request RegisterOIDCClientRequest) (*OIDCClientResponse, error)
+	ListClients(ctx context.Context, request ListOIDCClientsRequest) (*OIDCClientsResponse, error)
+	RotateSigningKey(ctx context.Context, request RotateSigningKeyRequest) (*SigningKeyResponse, error)
+}
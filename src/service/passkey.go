@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"user-service/src/service/model"
+)
+
+// The options and credentials below follow the JSON form of the WebAuthn browser API,
+// binary values are base64url encoded without padding.
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type PasskeyUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+type CredentialCreationOptions struct {
+	RP                     RelyingParty           `json:"rp"`
+	User                   PasskeyUser            `json:"user"`
+	Challenge              string                 `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+type CredentialRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type BeginPasskeyRegistrationRequest struct {
+	UserID model.UserID
+}
+
+func (r BeginPasskeyRegistrationRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type BeginPasskeyRegistrationResponse struct {
+	PublicKey CredentialCreationOptions `json:"publicKey"`
+}
+
+type AttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports"`
+}
+
+type FinishPasskeyRegistrationRequest struct {
+	UserID model.UserID `json:"-"`
+	// Name helps users recognize the passkey when listing them
+	Name     string              `json:"name"`
+	ID       string              `json:"id"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+func (r FinishPasskeyRegistrationRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type PasskeyResponse struct {
+	Passkey model.Passkey `json:"passkey"`
+}
+
+// BeginPasskeyLoginRequest without Name lets the browser offer the discoverable passkeys of any user.
+type BeginPasskeyLoginRequest struct {
+	Name string `json:"name"`
+}
+
+type BeginPasskeyLoginResponse struct {
+	PublicKey CredentialRequestOptions `json:"publicKey"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle"`
+}
+
+type FinishPasskeyLoginRequest struct {
+	ID       string            `json:"id"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+type ListPasskeysRequest struct {
+	UserID model.UserID
+}
+
+func (r ListPasskeysRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type PasskeysResponse struct {
+	Passkeys []model.Passkey `json:"passkeys"`
+}
+
+type RevokePasskeyRequest struct {
+	UserID    model.UserID
+	PasskeyID model.PasskeyID
+}
+
+func (r RevokePasskeyRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type PasskeyService interface {
+	BeginPasskeyRegistration(ctx context.Context, request BeginPasskeyRegistrationRequest) (*BeginPasskeyRegistrationResponse, error)
+	FinishPasskeyRegistration(ctx context.Context, request FinishPasskeyRegistrationRequest) (*PasskeyResponse, error)
+	BeginPasskeyLogin(ctx context.Context, request BeginPasskeyLoginRequest) (*BeginPasskeyLoginResponse, error)
+	FinishPasskeyLogin(ctx context.Context, request FinishPasskeyLoginRequest) (*TokenResponse, error)
+	ListPasskeys(ctx context.Context, request ListPasskeysRequest) (*PasskeysResponse, error)
+	RevokePasskey(ctx context.Context, request RevokePasskeyRequest) (*EmptyResponse, error)
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"time"
+	"user-service/src/service/model"
+)
+
+type ExportUserRequest struct {
+	UserID model.UserID
+}
+
+func (r ExportUserRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+// UserExport holds everything kept about a user but secrets, e.g. password hashes, TOTP secrets and the hashes
+// of single use tokens.
+type UserExport struct {
+	ExportedAt        time.Time             `json:"exported_at"`
+	User              model.User            `json:"user"`
+	PasswordUpdatedAt *time.Time            `json:"password_updated_at"`
+	MFA               *MFAExport            `json:"mfa"`
+	Passkeys          []model.Passkey       `json:"passkeys"`
+	Sessions          []model.Session       `json:"sessions"`
+	LDAP              *model.LDAPUser       `json:"ldap"`
+	LockoutEvents     []model.LockoutEvent  `json:"lockout_events"`
+	Impersonations    []model.Impersonation `json:"impersonations"`
+	AuditRecords      []model.AuditRecord   `json:"audit_records"`
+	Erasures          []model.Erasure       `json:"erasures"`
+}
+
+type MFAExport struct {
+	CreatedAt         time.Time  `json:"created_at"`
+	ConfirmedAt       *time.Time `json:"confirmed_at"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+type EraseUserRequest struct {
+	UserID model.UserID
+}
+
+func (r EraseUserRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type CancelErasureRequest struct {
+	UserID model.UserID
+}
+
+func (r CancelErasureRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type ErasureResponse struct {
+	Erasure model.Erasure `json:"erasure"`
+}
+
+type RunErasuresRequest struct{}
+
+type RunErasuresResponse struct {
+	Erased []model.UserID `json:"erased"`
+	Failed []model.UserID `json:"failed"`
+}
+
+type PrivacyService interface {
+	ExportUser(ctx context.Context, request ExportUserRequest) (*UserExport, error)
+	// EraseUser schedules the erasure after the grace period, asking again returns the pending erasure.
+	EraseUser(ctx context.Context, request EraseUserRequest) (*ErasureResponse, error)
+	CancelErasure(ctx context.Context, request CancelErasureRequest) (*ErasureResponse, error)
+	// RunErasures erases the users whose grace period is over, it is run periodically.
+	RunErasures(ctx context.Context, request RunErasuresRequest) (*RunErasuresResponse, error)
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// The resources below follow SCIM 2.0, see RFC 7643 and RFC 7644.
+
+const (
+	SCIMSchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SCIMSchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SCIMSchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SCIMSchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+)
+
+// SCIMUser maps userName and displayName to the name of the user, the single email of a user is its primary
+// work email and active maps to the status.
+type SCIMUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Emails      []SCIMEmail `json:"emails,omitempty"`
+	Meta        *SCIMMeta   `json:"meta,omitempty"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type SCIMMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+type SCIMListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type SCIMPatchRequest struct {
+	ID         string               `json:"-"`
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+type SCIMCreateUserRequest struct {
+	User SCIMUser
+}
+
+type SCIMGetUserRequest struct {
+	ID string
+}
+
+// SCIMListUsersRequest has a 1-based StartIndex, Count is nil when the client leaves it to the service.
+type SCIMListUsersRequest struct {
+	Filter     string
+	StartIndex int
+	Count      *int
+}
+
+type SCIMReplaceUserRequest struct {
+	ID   string
+	User SCIMUser
+}
+
+type SCIMDeleteUserRequest struct {
+	ID string
+}
+
+type SCIMServiceProviderConfigRequest struct{}
+
+type SCIMSupported struct {
+	Supported bool `json:"supported"`
+}
+
+type SCIMBulk struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type SCIMFilterConfig struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type SCIMAuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary,omitempty"`
+}
+
+type SCIMServiceProviderConfig struct {
+	Schemas               []string                   `json:"schemas"`
+	Patch                 SCIMSupported              `json:"patch"`
+	Bulk                  SCIMBulk                   `json:"bulk"`
+	Filter                SCIMFilterConfig           `json:"filter"`
+	ChangePassword        SCIMSupported              `json:"changePassword"`
+	Sort                  SCIMSupported              `json:"sort"`
+	ETag                  SCIMSupported              `json:"etag"`
+	AuthenticationSchemes []SCIMAuthenticationScheme `json:"authenticationSchemes"`
+	Meta                  SCIMMeta                   `json:"meta"`
+}
+
+// SCIMSchemasRequest without ID lists every schema.
+type SCIMSchemasRequest struct {
+	ID string
+}
+
+type SCIMAttribute struct {
+	Name          string          `json:"name"`
+	Type          string          `json:"type"`
+	MultiValued   bool            `json:"multiValued"`
+	Required      bool            `json:"required"`
+	CaseExact     bool            `json:"caseExact"`
+	Mutability    string          `json:"mutability"`
+	Returned      string          `json:"returned"`
+	Uniqueness    string          `json:"uniqueness"`
+	SubAttributes []SCIMAttribute `json:"subAttributes,omitempty"`
+}
+
+type SCIMSchema struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Attributes  []SCIMAttribute `json:"attributes"`
+	Meta        SCIMMeta        `json:"meta"`
+}
+
+// SCIMResourceTypesRequest without Name lists every resource type.
+type SCIMResourceTypesRequest struct {
+	Name string
+}
+
+type SCIMResourceType struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Endpoint    string   `json:"endpoint"`
+	Description string   `json:"description"`
+	Schema      string   `json:"schema"`
+	Meta        SCIMMeta `json:"meta"`
+}
+
+// SCIMService provisions users from identity providers, it is a SCIM view of UserService.
+type SCIMService interface {
+	ServiceProviderConfig(ctx context.Context, request SCIMServiceProviderConfigRequest) (*SCIMServiceProviderConfig, error)
+	// Schemas returns a SCIMSchema when an id is requested, a SCIMListResponse otherwise.
+	Schemas(ctx context.Context, request SCIMSchemasRequest) (interface{}, error)
+	// ResourceTypes returns a SCIMResourceType when a name is requested, a SCIMListResponse otherwise.
+	ResourceTypes(ctx context.Context, request SCIMResourceTypesRequest) (interface{}, error)
+	CreateUser(ctx context.Context, request SCIMCreateUserRequest) (*SCIMUser, error)
+	GetUser(ctx context.Context, request SCIMGetUserRequest) (*SCIMUser, error)
+	ListUsers(ctx context.Context, request SCIMListUsersRequest) (*SCIMListResponse, error)
+	ReplaceUser(ctx context.Context, request SCIMReplaceUserRequest) (*SCIMUser, error)
+	PatchUser(ctx context.Context, request SCIMPatchRequest) (*SCIMUser, error)
+	DeleteUser(ctx context.Context, request SCIMDeleteUserRequest) (*EmptyResponse, error)
+}
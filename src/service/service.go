@@ -2,8 +2,11 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"user-service/src/service/model"
+	"user-service/src/service/util/jsonpatch"
 	"user-service/src/service/util/paging"
+	"user-service/src/service/util/scim"
 )
 
 type GetUserRequest struct {
@@ -12,21 +15,92 @@ type GetUserRequest struct {
 
 type PostUserRequest struct {
 	User model.User
+	// Fields are the JSON names of the fields sent by the client, the visibility policy is checked on them
+	Fields []string
 }
 
+func (r PostUserRequest) WrittenFields() []string {
+	return r.Fields
+}
+
+// PatchUserRequest writes the fields of User which aren't empty, or applies MergePatch (RFC 7396) or Operations
+// (RFC 6902) to the user when one is set; patches can clear fields.
 type PatchUserRequest struct {
-	User model.User
+	User       model.User
+	Fields     []string
+	MergePatch json.RawMessage
+	Operations []jsonpatch.Operation
+}
+
+func (r PatchUserRequest) TargetUserID() model.UserID {
+	return r.User.ID
+}
+
+func (r PatchUserRequest) WrittenFields() []string {
+	return r.Fields
+}
+
+// ReadFields are the fields compared by the test operations, whether a test fails tells their value.
+func (r PatchUserRequest) ReadFields() []string {
+	var fields []string
+	for _, op := range r.Operations {
+		if op.Op != jsonpatch.OpTest {
+			continue
+		}
+		if tokens, err := jsonpatch.ParsePointer(op.Path); err == nil && len(tokens) > 0 {
+			fields = append(fields, tokens[0])
+		}
+	}
+	return fields
+}
+
+// ReplaceUserRequest sets every attribute of the user but the role and phone, empty attributes are cleared except
+// the status, which is kept when unset. The phone is replaced too with ReplacePhone, SCIM users have none.
+type ReplaceUserRequest struct {
+	User         model.User
+	ReplacePhone bool
+}
+
+func (r ReplaceUserRequest) TargetUserID() model.UserID {
+	return r.User.ID
+}
+
+// WrittenFields are all the fields replaced, set or not, the status only when it is set.
+func (r ReplaceUserRequest) WrittenFields() []string {
+	fields := []string{"name", "gender", "email"}
+	if r.User.Status != nil {
+		fields = append(fields, "status")
+	}
+	if r.ReplacePhone {
+		fields = append(fields, "phone")
+	}
+	return fields
+}
+
+type DeleteUserRequest struct {
+	UserID model.UserID
+}
+
+type SetUserRoleRequest struct {
+	UserID model.UserID `json:"-"`
+	Role   model.Role   `json:"role"`
 }
 
 type Paging struct {
 	Page  int `json:"page"`
 	Limit int `json:"limit"`
+	// Offset is used instead of Page when set, e.g. for SCIM startIndex
+	Offset int `json:"-"`
 }
 
 type GetUsersRequest struct {
-	Filter  model.User
-	OrderBy []string
-	Paging  Paging
+	Filter model.User
+	// EmailVerified filters on email_verified_at, nil doesn't filter
+	EmailVerified *bool
+	// SCIMFilter filters on the SCIM attributes of users, nil doesn't filter
+	SCIMFilter scim.Filter
+	OrderBy    []string
+	Paging     Paging
 }
 
 type UserResponse struct {
@@ -43,4 +117,8 @@ type UserService interface {
 	PostUser(ctx context.Context, request PostUserRequest) (*UserResponse, error)
 	PatchUser(ctx context.Context, request PatchUserRequest) (*UserResponse, error)
 	GetUsers(ctx context.Context, response GetUsersRequest) (*UsersResponse, error)
+	SetUserRole(ctx context.Context, request SetUserRoleRequest) (*UserResponse, error)
+	ReplaceUser(ctx context.Context, request ReplaceUserRequest) (*UserResponse, error)
+	// DeleteUser removes the user and everything attached to it, e.g. credentials and sessions.
+	DeleteUser(ctx context.Context, request DeleteUserRequest) (*EmptyResponse, error)
 }
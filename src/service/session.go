@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"user-service/src/service/model"
+)
+
+type CreateSessionRequest struct {
+	UserID model.UserID
+}
+
+// UseSessionRequest with Refresh extends the session, it is set when a refresh token is exchanged.
+type UseSessionRequest struct {
+	SessionID string
+	UserID    model.UserID
+	Refresh   bool
+}
+
+type ListSessionsRequest struct {
+	UserID model.UserID
+}
+
+func (r ListSessionsRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type SessionsResponse struct {
+	Sessions []model.Session `json:"sessions"`
+}
+
+type RevokeSessionRequest struct {
+	UserID    model.UserID
+	SessionID string
+}
+
+func (r RevokeSessionRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+// RevokeAllSessionsRequest keeps the session Except, e.g. the one of users changing their own password.
+type RevokeAllSessionsRequest struct {
+	UserID model.UserID
+	Except string
+}
+
+func (r RevokeAllSessionsRequest) TargetUserID() model.UserID {
+	return r.UserID
+}
+
+type RevokeSessionsResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+type SessionService interface {
+	// CreateSession is called by every way of logging in, the client address and user agent are read from ctx.
+	CreateSession(ctx context.Context, request CreateSessionRequest) (*model.Session, error)
+	// UseSession rejects revoked and expired sessions, it is called for every token presented.
+	UseSession(ctx context.Context, request UseSessionRequest) error
+	ListSessions(ctx context.Context, request ListSessionsRequest) (*SessionsResponse, error)
+	RevokeSession(ctx context.Context, request RevokeSessionRequest) (*EmptyResponse, error)
+	RevokeAllSessions(ctx context.Context, request RevokeAllSessionsRequest) (*RevokeSessionsResponse, error)
+}
@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+type APIKeyEndpoints struct {
+	CreateAPIKey   endpoint.Endpoint
+	ListAPIKeys    endpoint.Endpoint
+	RotateAPIKey   endpoint.Endpoint
+	RevokeAPIKey   endpoint.Endpoint
+	GetAPIKeyUsage endpoint.Endpoint
+}
+
+// MakeAPIKeyEndpoints always requires authentication, anonymous callers could otherwise grant themselves keys.
+func MakeAPIKeyEndpoints(s service.APIKeyService, authn endpoint.Middleware) APIKeyEndpoints {
+	return APIKeyEndpoints{
+		CreateAPIKey:   secureAuthenticated(authn, auth.PermissionAPIKeysManage, makeCreateAPIKeyEndpoint(s)),
+		ListAPIKeys:    secureAuthenticated(authn, auth.PermissionAPIKeysManage, makeListAPIKeysEndpoint(s)),
+		RotateAPIKey:   secureAuthenticated(authn, auth.PermissionAPIKeysManage, makeRotateAPIKeyEndpoint(s)),
+		RevokeAPIKey:   secureAuthenticated(authn, auth.PermissionAPIKeysManage, makeRevokeAPIKeyEndpoint(s)),
+		GetAPIKeyUsage: secureAuthenticated(authn, auth.PermissionAPIKeysManage, makeGetAPIKeyUsageEndpoint(s)),
+	}
+}
+
+func makeCreateAPIKeyEndpoint(s service.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.CreateAPIKey(ctx, request.(service.CreateAPIKeyRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeListAPIKeysEndpoint(s service.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.ListAPIKeys(ctx, request.(service.ListAPIKeysRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeRotateAPIKeyEndpoint(s service.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.RotateAPIKey(ctx, request.(service.RotateAPIKeyRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeRevokeAPIKeyEndpoint(s service.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.RevokeAPIKey(ctx, request.(service.RevokeAPIKeyRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeGetAPIKeyUsageEndpoint(s service.APIKeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.GetAPIKeyUsage(ctx, request.(service.GetAPIKeyUsageRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
@@ -0,0 +1,228 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/spf13/viper"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+)
+
+type Authenticator struct {
+	APIKeys service.APIKeyService
+	Tokens  service.AuthService
+	// Audit records every request made with an impersonation token, the request is rejected if it can't be recorded.
+	Audit service.AuditService
+}
+
+// Middleware resolves the credentials put in context by the http layer to an auth.Principal.
+func (a Authenticator) Middleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, err := a.Resolve(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if principal, ok := auth.FromContext(ctx); ok && principal.Impersonator != nil && a.Audit != nil {
+				_, err := a.Audit.Record(ctx, service.RecordAuditRequest{Action: "impersonation.request", Detail: fmt.Sprintf("%T", request)})
+				if err != nil {
+					return nil, err
+				}
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// Resolve puts the principal of the credentials in ctx, ctx is returned as is without credentials. A principal
+// already in ctx is kept, e.g. the one the http layer resolved to scope idempotency keys, so the credentials are
+// checked once per request.
+func (a Authenticator) Resolve(ctx context.Context) (context.Context, error) {
+	if _, ok := auth.FromContext(ctx); ok {
+		return ctx, nil
+	}
+	if key := auth.APIKeyFromContext(ctx); len(key) > 0 && a.APIKeys != nil {
+		principal, err := a.APIKeys.Authenticate(ctx, service.AuthenticateAPIKeyRequest{Key: key})
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewContext(ctx, principal), nil
+	}
+	if token := auth.BearerTokenFromContext(ctx); len(token) > 0 && a.Tokens != nil {
+		principal, err := a.Tokens.Authenticate(ctx, service.AuthenticateTokenRequest{Token: token})
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewContext(ctx, principal), nil
+	}
+	return ctx, nil
+}
+
+// RequirePermission rejects principals without the permission. Anonymous requests are only allowed
+// when auth.allow_anonymous is enabled.
+func RequirePermission(permission string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			principal, ok := auth.FromContext(ctx)
+			if !ok {
+				if viper.GetBool("auth.allow_anonymous") {
+					return next(ctx, request)
+				}
+				return nil, errAuthenticationRequired
+			}
+			if principal.MFAEnrollmentOnly {
+				return nil, errMFAEnrollmentRequired
+			}
+			if !principal.HasPermission(permission) {
+				return nil, Error{Msg: fmt.Sprintf("permission %s is required", permission), Code: ErrorCodePermissionDenied,
+					Key: "auth.permission_required", Params: map[string]interface{}{"permission": permission}}
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// requireAuthenticated rejects anonymous requests even when auth.allow_anonymous is enabled.
+func requireAuthenticated(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if _, ok := auth.FromContext(ctx); !ok {
+			return nil, errAuthenticationRequired
+		}
+		return next(ctx, request)
+	}
+}
+
+// denyImpersonation rejects impersonation tokens, it guards the credentials of users and what would outlive
+// the impersonation.
+func denyImpersonation(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if principal, ok := auth.FromContext(ctx); ok && principal.Impersonator != nil {
+			return nil, Error{Msg: "not allowed while impersonating", Code: ErrorCodePermissionDenied, Key: "auth.impersonating"}
+		}
+		return next(ctx, request)
+	}
+}
+
+// errMFAEnrollmentRequired is returned to users who must enroll a second factor before doing anything else.
+var (
+	errAuthenticationRequired = Error{Msg: "authentication is required", Code: ErrorCodeUnauthorized, Key: "auth.authentication_required"}
+	errMFAEnrollmentRequired  = Error{Msg: "mfa enrollment is required", Code: ErrorCodePermissionDenied, Key: "auth.mfa_enrollment_required"}
+)
+
+type userScopedRequest interface {
+	TargetUserID() model.UserID
+}
+
+// RequireSelfOrPermission lets users act on their own account, other principals need the permission.
+// Anonymous requests are always rejected.
+func RequireSelfOrPermission(permission string) endpoint.Middleware {
+	return requireSelfOrPermission(permission, false)
+}
+
+// requireSelfOrPermission with allowEnrollment also accepts users whose token only allows enrolling a second factor.
+func requireSelfOrPermission(permission string, allowEnrollment bool) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			principal, ok := auth.FromContext(ctx)
+			if !ok {
+				return nil, errAuthenticationRequired
+			}
+			if principal.MFAEnrollmentOnly && !allowEnrollment {
+				return nil, errMFAEnrollmentRequired
+			}
+			if r, ok := request.(userScopedRequest); ok && principal.IsUser(r.TargetUserID()) {
+				return next(ctx, request)
+			}
+			if !principal.HasPermission(permission) {
+				return nil, Error{Msg: fmt.Sprintf("permission %s is required", permission), Code: ErrorCodePermissionDenied,
+					Key: "auth.permission_required", Params: map[string]interface{}{"permission": permission}}
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+type fieldWriteRequest interface {
+	WrittenFields() []string
+}
+
+// CheckFieldWrites rejects requests writing fields of users the principal isn't allowed to write by the policy,
+// every such field is reported.
+func CheckFieldWrites(policy auth.FieldPolicy) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			r, ok := request.(fieldWriteRequest)
+			if !ok {
+				return next(ctx, request)
+			}
+			var owner model.UserID
+			if scoped, ok := request.(userScopedRequest); ok {
+				owner = scoped.TargetUserID()
+			}
+			if err := CheckWrittenFields(ctx, policy, owner, r.WrittenFields()); err != nil {
+				return nil, err
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// CheckWrittenFields is CheckFieldWrites for services writing users outside of the user endpoints, e.g. SCIM,
+// owner is the written user, 0 for a new one.
+func CheckWrittenFields(ctx context.Context, policy auth.FieldPolicy, owner model.UserID, fields []string) error {
+	principal, _ := auth.FromContext(ctx)
+	var fieldErrors []FieldError
+	for _, field := range fields {
+		if !policy.CanWrite(principal, owner, field) {
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Msg: "not allowed to write", Key: "field.not_allowed"})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return Error{Msg: "not allowed to write some fields", Code: ErrorCodePermissionDenied, Key: "user.fields_not_allowed", Fields: fieldErrors}
+	}
+	return nil
+}
+
+type fieldReadRequest interface {
+	ReadFields() []string
+}
+
+// CheckFieldReads rejects requests revealing fields of users the principal isn't allowed to read by the policy,
+// every such field is reported.
+func CheckFieldReads(policy auth.FieldPolicy) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			r, ok := request.(fieldReadRequest)
+			if !ok {
+				return next(ctx, request)
+			}
+			principal, _ := auth.FromContext(ctx)
+			var owner model.UserID
+			if scoped, ok := request.(userScopedRequest); ok {
+				owner = scoped.TargetUserID()
+			}
+
+			var fieldErrors []FieldError
+			for _, field := range r.ReadFields() {
+				if !policy.CanRead(principal, owner, field) {
+					fieldErrors = append(fieldErrors, FieldError{Field: field, Msg: "not allowed to read", Key: "field.not_readable"})
+				}
+			}
+			if len(fieldErrors) > 0 {
+				return nil, Error{Msg: "not allowed to read some fields", Code: ErrorCodePermissionDenied, Key: "user.fields_not_readable", Fields: fieldErrors}
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+func secure(authn endpoint.Middleware, permission string, e endpoint.Endpoint) endpoint.Endpoint {
+	return endpoint.Chain(authn, RequirePermission(permission))(e)
+}
+
+// secureAuthenticated is secure for endpoints anonymous callers must never reach, whatever auth.allow_anonymous.
+func secureAuthenticated(authn endpoint.Middleware, permission string, e endpoint.Endpoint) endpoint.Endpoint {
+	return endpoint.Chain(authn, requireAuthenticated, RequirePermission(permission))(e)
+}
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+type AuthEndpoints struct {
+	Login          endpoint.Endpoint
+	LoginMFA       endpoint.Endpoint
+	RefreshToken   endpoint.Endpoint
+	SetPassword    endpoint.Endpoint
+	ForgotPassword endpoint.Endpoint
+	ResetPassword  endpoint.Endpoint
+}
+
+func MakeAuthEndpoints(s service.AuthService, authn endpoint.Middleware) AuthEndpoints {
+	return AuthEndpoints{
+		Login:          makeLoginEndpoint(s),
+		LoginMFA:       makeLoginMFAEndpoint(s),
+		RefreshToken:   makeRefreshTokenEndpoint(s),
+		SetPassword:    endpoint.Chain(authn, denyImpersonation, RequireSelfOrPermission(auth.PermissionCredentialsManage))(makeSetPasswordEndpoint(s)),
+		ForgotPassword: makeForgotPasswordEndpoint(s),
+		ResetPassword:  makeResetPasswordEndpoint(s),
+	}
+}
+
+func makeLoginEndpoint(s service.AuthService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.Login(ctx, request.(service.LoginRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeLoginMFAEndpoint(s service.AuthService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.LoginMFA(ctx, request.(service.LoginMFARequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeRefreshTokenEndpoint(s service.AuthService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.RefreshToken(ctx, request.(service.RefreshTokenRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeSetPasswordEndpoint(s service.AuthService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.SetPassword(ctx, request.(service.SetPasswordRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeForgotPasswordEndpoint(s service.AuthService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.ForgotPassword(ctx, request.(service.ForgotPasswordRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeResetPasswordEndpoint(s service.AuthService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.ResetPassword(ctx, request.(service.ResetPasswordRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
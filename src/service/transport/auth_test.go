@@ -0,0 +1,405 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/spf13/viper"
+	"gotest.tools/assert"
+	"testing"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+)
+
+// callers are the principals the guarded endpoints are checked with, self is user 1 acting on itself.
+var callers = map[string]*auth.Principal{
+	"anonymous": nil,
+	"self":      {Type: auth.PrincipalUser, ID: "1", Role: model.RoleUser, Permissions: []string{auth.PermissionUsersRead}},
+	"user":      {Type: auth.PrincipalUser, ID: "2", Role: model.RoleUser, Permissions: []string{auth.PermissionUsersRead}},
+	"support": {Type: auth.PrincipalUser, ID: "3", Role: model.RoleSupport,
+		Permissions: []string{auth.PermissionUsersRead, auth.PermissionUsersWrite}},
+	"admin": {Type: auth.PrincipalUser, ID: "9", Role: model.RoleAdmin, Permissions: []string{
+		auth.PermissionUsersRead, auth.PermissionUsersWrite, auth.PermissionAPIKeysManage, auth.PermissionOIDCManage,
+		auth.PermissionRolesManage, auth.PermissionLockoutManage, auth.PermissionSessionsManage, auth.PermissionLDAPManage,
+		auth.PermissionImpersonate, auth.PermissionPrivacyManage, auth.PermissionCredentialsManage, auth.PermissionMetricsRead,
+	}},
+}
+
+// noAuthn stands for the Authenticator middleware, the tests put the principal in context themselves.
+var noAuthn = func(next endpoint.Endpoint) endpoint.Endpoint { return next }
+
+// guardCase expects the endpoint to answer each caller with the error code, 0 when the call is allowed.
+type guardCase struct {
+	name    string
+	e       endpoint.Endpoint
+	request interface{}
+	want    map[string]ResponseCode
+}
+
+// checkGuards calls every case as each caller, anonymous requests are allowed by the configuration as they are
+// by default so the endpoints must refuse them themselves.
+func checkGuards(t *testing.T, cases []guardCase) {
+	viper.Set("auth.allow_anonymous", true)
+	defer viper.Set("auth.allow_anonymous", nil)
+
+	for _, c := range cases {
+		for caller, principal := range callers {
+			want, ok := c.want[caller]
+			if !ok {
+				t.Fatalf("%s: no expectation for %s", c.name, caller)
+			}
+			ctx := context.Background()
+			if principal != nil {
+				ctx = auth.NewContext(ctx, principal)
+			}
+			_, err := c.e(ctx, c.request)
+			var got ResponseCode
+			if err != nil {
+				e, ok := err.(Error)
+				assert.Assert(t, ok, "%s as %s: %v", c.name, caller, err)
+				got = e.Code
+			}
+			assert.Equal(t, got, want, "%s as %s", c.name, caller)
+		}
+	}
+}
+
+// fakeAPIKeys resolve the API key "key", fakeTokens the tokens "token" and "impersonation", others are invalid.
+type fakeAPIKeys struct {
+	service.APIKeyService
+}
+
+func (fakeAPIKeys) Authenticate(_ context.Context, request service.AuthenticateAPIKeyRequest) (*auth.Principal, error) {
+	if request.Key != "key" {
+		return nil, Error{Msg: "invalid API key", Code: ErrorCodeUnauthorized}
+	}
+	return &auth.Principal{Type: auth.PrincipalAPIKey, ID: "5"}, nil
+}
+
+type fakeTokens struct {
+	service.AuthService
+}
+
+func (fakeTokens) Authenticate(_ context.Context, request service.AuthenticateTokenRequest) (*auth.Principal, error) {
+	switch request.Token {
+	case "token":
+		return &auth.Principal{Type: auth.PrincipalUser, ID: "1"}, nil
+	case "impersonation":
+		return &auth.Principal{Type: auth.PrincipalUser, ID: "1", Impersonator: &auth.Principal{Type: auth.PrincipalUser, ID: "9"}}, nil
+	}
+	return nil, Error{Msg: "invalid access token", Code: ErrorCodeUnauthorized}
+}
+
+// fakeAudit keeps the details of the records.
+type fakeAudit struct {
+	service.AuditService
+	details []string
+}
+
+func (f *fakeAudit) Record(_ context.Context, request service.RecordAuditRequest) (*service.EmptyResponse, error) {
+	f.details = append(f.details, request.Detail)
+	return &service.EmptyResponse{}, nil
+}
+
+func TestAuthenticator_Resolve(t *testing.T) {
+	authn := Authenticator{APIKeys: fakeAPIKeys{}, Tokens: fakeTokens{}}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		want    string
+		errCode ResponseCode
+	}{
+		{name: "anonymous", ctx: context.Background()},
+		{name: "api key", ctx: auth.WithAPIKey(context.Background(), "key"), want: "API_KEY:5"},
+		{name: "token", ctx: auth.WithBearerToken(context.Background(), "token"), want: "USER:1"},
+		{name: "invalid token", ctx: auth.WithBearerToken(context.Background(), "invalid"), errCode: ErrorCodeUnauthorized},
+		{
+			// the principal resolved before is kept, the credentials aren't checked again
+			name: "resolved",
+			ctx:  auth.NewContext(auth.WithBearerToken(context.Background(), "invalid"), &auth.Principal{Type: auth.PrincipalUser, ID: "2"}),
+			want: "USER:2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, err := authn.Resolve(tt.ctx)
+			if tt.errCode != 0 {
+				assert.Equal(t, err.(Error).Code, tt.errCode)
+				return
+			}
+			assert.NilError(t, err)
+			principal, ok := auth.FromContext(ctx)
+			assert.Equal(t, ok, len(tt.want) > 0)
+			if ok {
+				assert.Equal(t, principal.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_Middleware(t *testing.T) {
+	audit := &fakeAudit{}
+	e := Authenticator{Tokens: fakeTokens{}, Audit: audit}.Middleware()(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		principal, _ := auth.FromContext(ctx)
+		return principal.String(), nil
+	})
+
+	res, err := e(auth.WithBearerToken(context.Background(), "token"), service.ListPasskeysRequest{})
+	assert.NilError(t, err)
+	assert.Equal(t, res, "USER:1")
+	assert.Equal(t, len(audit.details), 0)
+
+	// every request made while impersonating is recorded
+	res, err = e(auth.WithBearerToken(context.Background(), "impersonation"), service.ListPasskeysRequest{})
+	assert.NilError(t, err)
+	assert.Equal(t, res, "USER:1 (impersonated by USER:9)")
+	assert.DeepEqual(t, audit.details, []string{"service.ListPasskeysRequest"})
+}
+
+func TestRequirePermission(t *testing.T) {
+	e := RequirePermission(auth.PermissionUsersWrite)(func(context.Context, interface{}) (interface{}, error) { return "ok", nil })
+	call := func(principal *auth.Principal) ResponseCode {
+		ctx := context.Background()
+		if principal != nil {
+			ctx = auth.NewContext(ctx, principal)
+		}
+		if _, err := e(ctx, nil); err != nil {
+			return err.(Error).Code
+		}
+		return 0
+	}
+
+	viper.Set("auth.allow_anonymous", false)
+	assert.Equal(t, call(nil), ErrorCodeUnauthorized)
+	viper.Set("auth.allow_anonymous", true)
+	assert.Equal(t, call(nil), ResponseCode(0))
+	viper.Set("auth.allow_anonymous", nil)
+
+	assert.Equal(t, call(callers["support"]), ResponseCode(0))
+	assert.Equal(t, call(callers["user"]), ErrorCodePermissionDenied)
+	// users who must enroll a second factor can do nothing else
+	enrolling := *callers["support"]
+	enrolling.MFAEnrollmentOnly = true
+	assert.Equal(t, call(&enrolling), ErrorCodePermissionDenied)
+}
+
+func TestRequireSelfOrPermission(t *testing.T) {
+	next := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+	enrolling := *callers["self"]
+	enrolling.MFAEnrollmentOnly = true
+	ctx := auth.NewContext(context.Background(), &enrolling)
+
+	_, err := RequireSelfOrPermission(auth.PermissionCredentialsManage)(next)(ctx, service.EnrollTOTPRequest{UserID: 1})
+	assert.Equal(t, err.(Error).Code, ErrorCodePermissionDenied)
+	_, err = requireSelfOrPermission(auth.PermissionCredentialsManage, true)(next)(ctx, service.EnrollTOTPRequest{UserID: 1})
+	assert.NilError(t, err)
+	// the enrollment only concerns the own account
+	_, err = requireSelfOrPermission(auth.PermissionCredentialsManage, true)(next)(ctx, service.EnrollTOTPRequest{UserID: 2})
+	assert.Equal(t, err.(Error).Code, ErrorCodePermissionDenied)
+}
+
+func TestDenyImpersonation(t *testing.T) {
+	e := denyImpersonation(func(context.Context, interface{}) (interface{}, error) { return "ok", nil })
+	impersonated := *callers["self"]
+	impersonated.Impersonator = callers["admin"]
+
+	_, err := e(auth.NewContext(context.Background(), &impersonated), nil)
+	assert.Equal(t, err.(Error).Key, "auth.impersonating")
+	_, err = e(auth.NewContext(context.Background(), callers["self"]), nil)
+	assert.NilError(t, err)
+}
+
+type fakeAPIKeyService struct {
+	service.APIKeyService
+}
+
+func (fakeAPIKeyService) CreateAPIKey(context.Context, service.CreateAPIKeyRequest) (*service.APIKeyResponse, error) {
+	return &service.APIKeyResponse{}, nil
+}
+
+func (fakeAPIKeyService) ListAPIKeys(context.Context, service.ListAPIKeysRequest) (*service.APIKeysResponse, error) {
+	return &service.APIKeysResponse{}, nil
+}
+
+func (fakeAPIKeyService) RotateAPIKey(context.Context, service.RotateAPIKeyRequest) (*service.APIKeyResponse, error) {
+	return &service.APIKeyResponse{}, nil
+}
+
+func (fakeAPIKeyService) RevokeAPIKey(context.Context, service.RevokeAPIKeyRequest) (*service.APIKeyResponse, error) {
+	return &service.APIKeyResponse{}, nil
+}
+
+func (fakeAPIKeyService) GetAPIKeyUsage(context.Context, service.GetAPIKeyUsageRequest) (*service.APIKeyUsageResponse, error) {
+	return &service.APIKeyUsageResponse{}, nil
+}
+
+// adminOnly is the expectation of the endpoints guarded by a permission only admins hold.
+var adminOnly = map[string]ResponseCode{
+	"anonymous": ErrorCodeUnauthorized,
+	"self":      ErrorCodePermissionDenied,
+	"user":      ErrorCodePermissionDenied,
+	"support":   ErrorCodePermissionDenied,
+	"admin":     0,
+}
+
+func TestAPIKeyEndpoints(t *testing.T) {
+	endpoints := MakeAPIKeyEndpoints(fakeAPIKeyService{}, noAuthn)
+	checkGuards(t, []guardCase{
+		{name: "CreateAPIKey", e: endpoints.CreateAPIKey, request: service.CreateAPIKeyRequest{}, want: adminOnly},
+		{name: "ListAPIKeys", e: endpoints.ListAPIKeys, request: service.ListAPIKeysRequest{}, want: adminOnly},
+		{name: "RotateAPIKey", e: endpoints.RotateAPIKey, request: service.RotateAPIKeyRequest{}, want: adminOnly},
+		{name: "RevokeAPIKey", e: endpoints.RevokeAPIKey, request: service.RevokeAPIKeyRequest{}, want: adminOnly},
+		{name: "GetAPIKeyUsage", e: endpoints.GetAPIKeyUsage, request: service.GetAPIKeyUsageRequest{}, want: adminOnly},
+	})
+}
+
+func TestMetricsEndpoints(t *testing.T) {
+	endpoints := MakeMetricsEndpoints(noAuthn)
+	checkGuards(t, []guardCase{
+		{name: "Vars", e: endpoints.Vars, want: adminOnly},
+	})
+}
+
+type fakePasskeyService struct {
+	service.PasskeyService
+}
+
+func (fakePasskeyService) BeginPasskeyRegistration(context.Context, service.BeginPasskeyRegistrationRequest) (*service.BeginPasskeyRegistrationResponse, error) {
+	return &service.BeginPasskeyRegistrationResponse{}, nil
+}
+
+func (fakePasskeyService) ListPasskeys(context.Context, service.ListPasskeysRequest) (*service.PasskeysResponse, error) {
+	return &service.PasskeysResponse{}, nil
+}
+
+func (fakePasskeyService) RevokePasskey(context.Context, service.RevokePasskeyRequest) (*service.EmptyResponse, error) {
+	return &service.EmptyResponse{}, nil
+}
+
+func TestPasskeyEndpoints(t *testing.T) {
+	endpoints := MakePasskeyEndpoints(fakePasskeyService{}, noAuthn)
+	// only the user itself and credentials:manage reach the passkeys of a user
+	selfOrManage := map[string]ResponseCode{
+		"anonymous": ErrorCodeUnauthorized,
+		"self":      0,
+		"user":      ErrorCodePermissionDenied,
+		"support":   ErrorCodePermissionDenied,
+		"admin":     0,
+	}
+	checkGuards(t, []guardCase{
+		{name: "BeginRegistration", e: endpoints.BeginRegistration, request: service.BeginPasskeyRegistrationRequest{UserID: 1}, want: selfOrManage},
+		{name: "ListPasskeys", e: endpoints.ListPasskeys, request: service.ListPasskeysRequest{UserID: 1}, want: selfOrManage},
+		{name: "RevokePasskey", e: endpoints.RevokePasskey, request: service.RevokePasskeyRequest{UserID: 1, PasskeyID: 4}, want: selfOrManage},
+	})
+}
+
+type fakeOIDCService struct {
+	service.OIDCService
+}
+
+func (fakeOIDCService) Authorize(context.Context, service.AuthorizeRequest) (*service.AuthorizeResponse, error) {
+	return &service.AuthorizeResponse{}, nil
+}
+
+func (fakeOIDCService) RegisterClient(context.Context, service.RegisterOIDCClientRequest) (*service.OIDCClientResponse, error) {
+	return &service.OIDCClientResponse{}, nil
+}
+
+func (fakeOIDCService) ListClients(context.Context, service.ListOIDCClientsRequest) (*service.OIDCClientsResponse, error) {
+	return &service.OIDCClientsResponse{}, nil
+}
+
+func (fakeOIDCService) RotateSigningKey(context.Context, service.RotateSigningKeyRequest) (*service.SigningKeyResponse, error) {
+	return &service.SigningKeyResponse{}, nil
+}
+
+func TestOIDCEndpoints(t *testing.T) {
+	endpoints := MakeOIDCEndpoints(fakeOIDCService{}, noAuthn)
+	checkGuards(t, []guardCase{
+		{name: "RegisterClient", e: endpoints.RegisterClient, request: service.RegisterOIDCClientRequest{}, want: adminOnly},
+		{name: "ListClients", e: endpoints.ListClients, request: service.ListOIDCClientsRequest{}, want: adminOnly},
+		{name: "RotateSigningKey", e: endpoints.RotateSigningKey, request: service.RotateSigningKeyRequest{}, want: adminOnly},
+		// the service redirects anonymous users with login_required
+		{name: "Authorize", e: endpoints.Authorize, request: service.AuthorizeRequest{}, want: map[string]ResponseCode{
+			"anonymous": 0, "self": 0, "user": 0, "support": 0, "admin": 0,
+		}},
+	})
+}
+
+type fakeLockoutService struct {
+	service.LockoutService
+}
+
+func (fakeLockoutService) GetLockout(context.Context, service.GetLockoutRequest) (*service.LockoutResponse, error) {
+	return &service.LockoutResponse{}, nil
+}
+
+func (fakeLockoutService) UnlockUser(context.Context, service.UnlockUserRequest) (*service.LockoutResponse, error) {
+	return &service.LockoutResponse{}, nil
+}
+
+func TestLockoutEndpoints(t *testing.T) {
+	endpoints := MakeLockoutEndpoints(fakeLockoutService{}, noAuthn)
+	// users can't read their own lockout either, it would tell how many guesses are left
+	checkGuards(t, []guardCase{
+		{name: "GetLockout", e: endpoints.GetLockout, request: service.GetLockoutRequest{UserID: 1}, want: adminOnly},
+		{name: "UnlockUser", e: endpoints.UnlockUser, request: service.UnlockUserRequest{UserID: 1}, want: adminOnly},
+	})
+}
+
+type fakeSCIMService struct {
+	service.SCIMService
+}
+
+func (fakeSCIMService) Schemas(context.Context, service.SCIMSchemasRequest) (interface{}, error) {
+	return &service.SCIMListResponse{}, nil
+}
+
+func (fakeSCIMService) CreateUser(context.Context, service.SCIMCreateUserRequest) (*service.SCIMUser, error) {
+	return &service.SCIMUser{}, nil
+}
+
+func (fakeSCIMService) GetUser(context.Context, service.SCIMGetUserRequest) (*service.SCIMUser, error) {
+	return &service.SCIMUser{}, nil
+}
+
+func (fakeSCIMService) ListUsers(context.Context, service.SCIMListUsersRequest) (*service.SCIMListResponse, error) {
+	return &service.SCIMListResponse{}, nil
+}
+
+func (fakeSCIMService) ReplaceUser(context.Context, service.SCIMReplaceUserRequest) (*service.SCIMUser, error) {
+	return &service.SCIMUser{}, nil
+}
+
+func (fakeSCIMService) PatchUser(context.Context, service.SCIMPatchRequest) (*service.SCIMUser, error) {
+	return &service.SCIMUser{}, nil
+}
+
+func (fakeSCIMService) DeleteUser(context.Context, service.SCIMDeleteUserRequest) (*service.EmptyResponse, error) {
+	return &service.EmptyResponse{}, nil
+}
+
+func TestSCIMEndpoints(t *testing.T) {
+	endpoints := MakeSCIMEndpoints(fakeSCIMService{}, noAuthn)
+	read := map[string]ResponseCode{"anonymous": ErrorCodeUnauthorized, "self": 0, "user": 0, "support": 0, "admin": 0}
+	write := map[string]ResponseCode{
+		"anonymous": ErrorCodeUnauthorized,
+		"self":      ErrorCodePermissionDenied,
+		"user":      ErrorCodePermissionDenied,
+		"support":   0,
+		"admin":     0,
+	}
+	checkGuards(t, []guardCase{
+		// the discovery endpoints are public
+		{name: "Schemas", e: endpoints.Schemas, request: service.SCIMSchemasRequest{}, want: map[string]ResponseCode{
+			"anonymous": 0, "self": 0, "user": 0, "support": 0, "admin": 0,
+		}},
+		{name: "GetUser", e: endpoints.GetUser, request: service.SCIMGetUserRequest{ID: "1"}, want: read},
+		{name: "ListUsers", e: endpoints.ListUsers, request: service.SCIMListUsersRequest{}, want: read},
+		{name: "CreateUser", e: endpoints.CreateUser, request: service.SCIMCreateUserRequest{}, want: write},
+		{name: "ReplaceUser", e: endpoints.ReplaceUser, request: service.SCIMReplaceUserRequest{ID: "1"}, want: write},
+		{name: "PatchUser", e: endpoints.PatchUser, request: service.SCIMPatchRequest{ID: "1"}, want: write},
+		{name: "DeleteUser", e: endpoints.DeleteUser, request: service.SCIMDeleteUserRequest{ID: "1"}, want: write},
+	})
+}
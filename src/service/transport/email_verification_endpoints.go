@@ -0,0 +1,38 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+type EmailVerificationEndpoints struct {
+	SendEmailVerification endpoint.Endpoint
+	VerifyEmail           endpoint.Endpoint
+}
+
+func MakeEmailVerificationEndpoints(s service.EmailVerificationService, authn endpoint.Middleware) EmailVerificationEndpoints {
+	return EmailVerificationEndpoints{
+		SendEmailVerification: endpoint.Chain(authn, RequireSelfOrPermission(auth.PermissionUsersWrite))(makeSendEmailVerificationEndpoint(s)),
+		VerifyEmail:           makeVerifyEmailEndpoint(s),
+	}
+}
+
+func makeSendEmailVerificationEndpoint(s service.EmailVerificationService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.SendEmailVerification(ctx, request.(service.SendEmailVerificationRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeVerifyEmailEndpoint(s service.EmailVerificationService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.VerifyEmail(ctx, request.(service.VerifyEmailRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
@@ -4,21 +4,28 @@ import (
 	"context"
 	"github.com/go-kit/kit/endpoint"
 	"user-service/src/service"
+	"user-service/src/service/auth"
 )
 
 type Endpoints struct {
-	GetUser   endpoint.Endpoint
-	PostUser  endpoint.Endpoint
-	PatchUser endpoint.Endpoint
-	GetUsers  endpoint.Endpoint
+	GetUser     endpoint.Endpoint
+	PostUser    endpoint.Endpoint
+	PatchUser   endpoint.Endpoint
+	ReplaceUser endpoint.Endpoint
+	GetUsers    endpoint.Endpoint
+	SetRole     endpoint.Endpoint
 }
 
-func MakeEndpoints(s service.UserService) Endpoints {
+// MakeEndpoints checks the writes of the policy and the fields tested by JSON Patches, the other reads are filtered
+// when encoding the responses.
+func MakeEndpoints(s service.UserService, authn endpoint.Middleware, policy auth.FieldPolicy) Endpoints {
 	return Endpoints{
-		GetUser:   makeGetUserEndpoint(s),
-		PostUser:  makePostUserEndpoint(s),
-		PatchUser: makePatchUserEndpoint(s),
-		GetUsers:  makeGetUsersEndpoint(s),
+		GetUser:     secure(authn, auth.PermissionUsersRead, makeGetUserEndpoint(s)),
+		PostUser:    secure(authn, auth.PermissionUsersWrite, CheckFieldWrites(policy)(makePostUserEndpoint(s))),
+		PatchUser:   secure(authn, auth.PermissionUsersWrite, endpoint.Chain(CheckFieldWrites(policy), CheckFieldReads(policy))(makePatchUserEndpoint(s))),
+		ReplaceUser: secure(authn, auth.PermissionUsersWrite, CheckFieldWrites(policy)(makeReplaceUserEndpoint(s))),
+		GetUsers:    secure(authn, auth.PermissionUsersRead, makeGetUsersEndpoint(s)),
+		SetRole:     endpoint.Chain(authn, requireAuthenticated, RequirePermission(auth.PermissionRolesManage))(makeSetRoleEndpoint(s)),
 	}
 }
 
@@ -57,3 +64,21 @@ func makePatchUserEndpoint(s service.UserService) endpoint.Endpoint {
 		}, err
 	}
 }
+
+func makeReplaceUserEndpoint(s service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.ReplaceUser(ctx, request.(service.ReplaceUserRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeSetRoleEndpoint(s service.UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.SetUserRole(ctx, request.(service.SetUserRoleRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
@@ -1,21 +1,32 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"user-service/src/service"
 	"user-service/src/service/model"
 	"user-service/src/service/transport"
+	"user-service/src/service/util/jsonpatch"
 )
 
+// noRequest decodes the requests of endpoints taking nothing from them.
+func noRequest(context.Context, *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
 func getVar(req *http.Request, name string) (string, error) {
 	vars := mux.Vars(req)
 	val, has := vars[name]
@@ -50,6 +61,73 @@ func getParamIntWithDefault(req *http.Request, name string, defaultValue int) in
 	return intVal
 }
 
+func getBoolParam(req *http.Request, name string) (*bool, error) {
+	value := getParam(req, name)
+	if len(value) == 0 {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, transport.Error{
+			Msg:  fmt.Sprintf("%s must be true or false", name),
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return &b, nil
+}
+
+// defaultMaxBodySize is the limit of request bodies when http_server.max_body_size isn't set.
+const defaultMaxBodySize = 1 << 20
+
+// readBody reads the body of the request up to http_server.max_body_size bytes, larger bodies are rejected.
+func readBody(req *http.Request) ([]byte, error) {
+	defer req.Body.Close()
+	limit := viper.GetInt64("http_server.max_body_size")
+	if limit <= 0 {
+		limit = defaultMaxBodySize
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	if int64(len(b)) > limit {
+		return nil, transport.Error{Msg: fmt.Sprintf("body is larger than %d bytes", limit), Code: transport.ErrorCodePayloadTooLarge,
+			Key: "request.body_too_large", Params: map[string]interface{}{"limit": limit}}
+	}
+	return b, nil
+}
+
+// checkContentType returns the media type of the body, bodies which aren't sent as one of mediaTypes are rejected.
+func checkContentType(req *http.Request, mediaTypes ...string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err == nil {
+		for _, accepted := range mediaTypes {
+			if mediaType == accepted {
+				return mediaType, nil
+			}
+		}
+	}
+	accepted := strings.Join(mediaTypes, ", ")
+	return "", transport.Error{Msg: "Content-Type must be one of " + accepted, Code: transport.ErrorCodeUnsupportedMediaType,
+		Key: "request.unsupported_media_type", Params: map[string]interface{}{"media_types": accepted}}
+}
+
+func decodeJSONBody(req *http.Request, v interface{}) error {
+	b, err := readBody(req)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(b, v); err != nil {
+		return transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return nil
+}
+
 func GetUserRequest(c context.Context, req *http.Request) (request interface{}, err error) {
 	userID, err := getVarInt(req, "userID")
 	if err != nil {
@@ -70,42 +148,280 @@ func PatchUserRequest(c context.Context, req *http.Request) (request interface{}
 		}
 	}
 
-	b, err := ioutil.ReadAll(req.Body)
-	defer req.Body.Close()
+	mediaType, err := checkContentType(req, jsonMediaType, mergePatchMediaType, jsonPatchMediaType)
 	if err != nil {
-		return nil, transport.Error{
-			Code: transport.ErrorCodeInvalidParameter,
-		}
+		return nil, err
+	}
+	switch mediaType {
+	case mergePatchMediaType:
+		patchRequest.MergePatch, patchRequest.Fields, err = decodeMergePatch(req)
+	case jsonPatchMediaType:
+		patchRequest.Operations, patchRequest.Fields, err = decodeJSONPatch(req)
+	default:
+		patchRequest.Fields, err = decodeUserBody(req, &patchRequest.User, readOnlyPatchedUserFields)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	patchRequest.User.ID = model.UserID(userId)
+	return patchRequest, nil
+}
 
-	if err = json.Unmarshal(b, &patchRequest.User); err != nil {
+// ReplaceUserRequest replaces every field of the user but the read-only ones, including the phone.
+func ReplaceUserRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var replaceRequest service.ReplaceUserRequest
+	userId, err := getVarInt(req, "userID")
+	if err != nil {
 		return nil, transport.Error{
 			Code: transport.ErrorCodeInvalidParameter,
 		}
 	}
 
-	patchRequest.User.ID = model.UserID(userId)
-	return patchRequest, nil
+	if _, err = decodeUserBody(req, &replaceRequest.User, readOnlyReplacedUserFields); err != nil {
+		return nil, err
+	}
+
+	replaceRequest.User.ID = model.UserID(userId)
+	replaceRequest.ReplacePhone = true
+	return replaceRequest, nil
+}
+
+// decodeMergePatch checks the fields of an application/merge-patch+json body, null values clear their field.
+func decodeMergePatch(req *http.Request) (json.RawMessage, []string, error) {
+	b, err := readBody(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields, err := writtenUserFields(b, readOnlyPatchedUserFields, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = decodeUser(b, &model.User{}); err != nil {
+		return nil, nil, err
+	}
+	return b, fields, nil
+}
+
+// decodeJSONPatch checks the operations of an application/json-patch+json body, the fields written are the ones
+// of the paths of add, remove and replace. Values are checked once applied.
+func decodeJSONPatch(req *http.Request) ([]jsonpatch.Operation, []string, error) {
+	b, err := readBody(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ops []jsonpatch.Operation
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&ops); err != nil || ops == nil {
+		return nil, nil, transport.Error{Msg: "body must be an array of operations", Code: transport.ErrorCodeInvalidParameter, Key: "request.invalid_body"}
+	}
+
+	names := userFieldNames()
+	var fields []string
+	var fieldErrors []transport.FieldError
+	for i, op := range ops {
+		path := fmt.Sprintf("[%d]", i)
+		if err := op.Validate(); err != nil {
+			fieldErrors = append(fieldErrors, transport.FieldError{Field: path, Msg: err.Error(), Key: "field.invalid_operation",
+				Params: map[string]interface{}{"reason": err.Error()}})
+			continue
+		}
+		tokens, _ := jsonpatch.ParsePointer(op.Path)
+		if len(tokens) == 0 || !names[tokens[0]] {
+			fieldErrors = append(fieldErrors, transport.FieldError{Field: path + ".path", Msg: "is unknown", Key: "field.unknown"})
+			continue
+		}
+		if op.Op == jsonpatch.OpTest {
+			continue
+		}
+		if fieldError, ok := readOnlyPatchedUserFields[tokens[0]]; ok {
+			fieldError.Field = path + ".path"
+			fieldErrors = append(fieldErrors, fieldError)
+			continue
+		}
+		if !containsString(fields, tokens[0]) {
+			fields = append(fields, tokens[0])
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return nil, nil, transport.Error{Msg: "invalid operations", Code: transport.ErrorCodeInvalidParameter, Key: "user.invalid_patch_operations", Fields: fieldErrors}
+	}
+	return ops, fields, nil
 }
 
 func PostUserRequest(c context.Context, req *http.Request) (request interface{}, err error) {
 	var postRequest service.PostUserRequest
 
-	b, err := ioutil.ReadAll(req.Body)
-	defer req.Body.Close()
+	if postRequest.Fields, err = decodeUserBody(req, &postRequest.User, readOnlyUserFields); err != nil {
+		return nil, err
+	}
+	return postRequest, nil
+}
+
+// userFields are the JSON names of the fields of model.User, in their order.
+var userFields = func() []string {
+	var fields []string
+	t := reflect.TypeOf(model.User{})
+	for i := 0; i < t.NumField(); i++ {
+		fields = append(fields, strings.Split(t.Field(i).Tag.Get("json"), ",")[0])
+	}
+	return fields
+}()
+
+func userFieldNames() map[string]bool {
+	names := map[string]bool{}
+	for _, name := range userFields {
+		names[name] = true
+	}
+	return names
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnlyUserFields can't be sent in user bodies, the role is changed through SetUserRoleRequest and
+// email_verified_at through the email verification.
+var readOnlyUserFields = map[string]transport.FieldError{
+	"role":              {Msg: "is changed with PUT /user/{id}/role", Key: "field.role_read_only"},
+	"email_verified_at": {Msg: "is set by the email verification", Key: "field.email_verified_at_read_only"},
+}
+
+// readOnlyPatchedUserFields can't be sent in the bodies of PatchUserRequest, the id is the one of the path.
+var readOnlyPatchedUserFields = map[string]transport.FieldError{
+	"id":                {Msg: "is read-only", Key: "field.read_only"},
+	"status":            {Msg: "is read-only", Key: "field.read_only"},
+	"role":              readOnlyUserFields["role"],
+	"email_verified_at": readOnlyUserFields["email_verified_at"],
+}
+
+// readOnlyReplacedUserFields can't be sent in the bodies of ReplaceUserRequest, unlike patches they can set the
+// status.
+var readOnlyReplacedUserFields = map[string]transport.FieldError{
+	"id":                readOnlyPatchedUserFields["id"],
+	"role":              readOnlyUserFields["role"],
+	"email_verified_at": readOnlyUserFields["email_verified_at"],
+}
+
+// decodeUserBody decodes the application/json body of a user into user and returns the fields it writes. Unknown
+// and read-only fields and values of the wrong type are rejected, naming their JSON path.
+func decodeUserBody(req *http.Request, user *model.User, readOnly map[string]transport.FieldError) ([]string, error) {
+	if _, err := checkContentType(req, jsonMediaType); err != nil {
+		return nil, err
+	}
+	b, err := readBody(req)
 	if err != nil {
-		return nil, transport.Error{
-			Code: transport.ErrorCodeInvalidParameter,
+		return nil, err
+	}
+
+	fields, err := writtenUserFields(b, readOnly, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = decodeUser(b, user); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// decodeUser decodes a single JSON object into user, the error names the field of the wrong type or unknown.
+func decodeUser(b []byte, user *model.User) error {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(user)
+	if err == nil {
+		if _, err = decoder.Token(); err == io.EOF {
+			return nil
+		}
+		err = errors.New("invalid data after the object")
+	}
+	e := transport.Error{Msg: "invalid body", Code: transport.ErrorCodeInvalidParameter, Key: "request.invalid_body"}
+	if fieldError, ok := jsonFieldError(err); ok {
+		e.Fields = []transport.FieldError{fieldError}
+	}
+	return e
+}
+
+// jsonFieldError tells which field encoding/json failed to decode.
+func jsonFieldError(err error) (transport.FieldError, bool) {
+	if typeError, ok := err.(*json.UnmarshalTypeError); ok && len(typeError.Field) > 0 {
+		return transport.FieldError{Field: typeError.Field, Msg: fmt.Sprintf("can't be a %s", typeError.Value),
+			Key: "field.invalid_type", Params: map[string]interface{}{"type": typeError.Value}}, true
+	}
+	if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+		field, _ := strconv.Unquote(strings.TrimPrefix(msg, "json: unknown field "))
+		return transport.FieldError{Field: field, Msg: "is unknown", Key: "field.unknown"}, true
+	}
+	return transport.FieldError{}, false
+}
+
+// writtenUserFields gives the JSON names of the fields of model.User set in body, null values don't write
+// anything. Names are matched case-insensitively like encoding/json does. Every unknown or read-only field is
+// rejected. Merge patches match names exactly and clear the fields set to null.
+func writtenUserFields(body []byte, readOnly map[string]transport.FieldError, mergePatch bool) ([]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, transport.Error{Msg: "invalid body", Code: transport.ErrorCodeInvalidParameter, Key: "request.invalid_body"}
+	}
+
+	var fields []string
+	var fieldErrors []transport.FieldError
+	known := map[string]bool{}
+	for _, name := range userFields {
+		written := false
+		for key, value := range raw {
+			if key != name && (mergePatch || !strings.EqualFold(key, name)) {
+				continue
+			}
+			known[key] = true
+			if (string(value) == "null" && !mergePatch) || written {
+				continue
+			}
+			written = true
+			if fieldError, ok := readOnly[name]; ok {
+				fieldError.Field = name
+				fieldErrors = append(fieldErrors, fieldError)
+			} else {
+				fields = append(fields, name)
+			}
+		}
+	}
+	var unknown []string
+	for key := range raw {
+		if !known[key] {
+			unknown = append(unknown, key)
 		}
 	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		fieldErrors = append(fieldErrors, transport.FieldError{Field: key, Msg: "is unknown", Key: "field.unknown"})
+	}
+	if len(fieldErrors) > 0 {
+		return nil, transport.Error{Msg: "read-only or unknown fields are set", Code: transport.ErrorCodeInvalidParameter, Key: "user.read_only_fields", Fields: fieldErrors}
+	}
+	return fields, nil
+}
 
-	if err = json.Unmarshal(b, &postRequest.User); err != nil {
+func SetUserRoleRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var setRoleRequest service.SetUserRoleRequest
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
 		return nil, transport.Error{
 			Code: transport.ErrorCodeInvalidParameter,
 		}
 	}
-	postRequest.User.Status = nil
-	return postRequest, nil
+
+	if err := decodeJSONBody(req, &setRoleRequest); err != nil {
+		return nil, err
+	}
+	setRoleRequest.UserID = model.UserID(userID)
+	return setRoleRequest, nil
 }
 
 func getPagingInfo(_ context.Context, req *http.Request) service.Paging {
@@ -156,17 +472,98 @@ func getOrderByParam(_ context.Context, req *http.Request, fieldsSupportedOrderB
 	return fmt.Sprintf("%s %s", orderBy, direction)
 }
 
+// usersOrderBy are the fields users can be ordered by.
+var usersOrderBy = []string{"id", "name", "gender"}
+
 func getOrderByParam_(ctx context.Context, req *http.Request) []string {
-	supportedOrderBy := []string{"id", "name", "gender"}
+	return []string{getOrderByParam(ctx, req, usersOrderBy, "id")}
+}
+
+// usersQueryParams are the query params of GetUsersRequest.
+var usersQueryParams = map[string]bool{
+	"name": true, "gender": true, "email_verified": true, "order_by": true, "page": true, "limit": true,
+}
+
+// checkUsersQuery rejects the query params that the lenient decoding ignores or defaults: unknown params, pages and
+// limits which aren't numbers, unknown orders and directions. Every offending param is listed.
+func checkUsersQuery(req *http.Request) error {
+	query := req.URL.Query()
+	var fieldErrors []transport.FieldError
+	var unknown []string
+	for name := range query {
+		if !usersQueryParams[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		fieldErrors = append(fieldErrors, transport.FieldError{Field: name, Msg: "is unknown", Key: "field.unknown"})
+	}
 
-	return []string{getOrderByParam(ctx, req, supportedOrderBy, "id")}
+	for _, name := range []string{"page", "limit"} {
+		if value := query.Get(name); len(value) > 0 {
+			if n, err := strconv.Atoi(value); err != nil || n < 1 {
+				fieldErrors = append(fieldErrors, transport.FieldError{Field: name, Msg: "must be a positive integer", Key: "field.positive_integer"})
+			}
+		}
+	}
+	if value := query.Get("gender"); len(value) > 0 && !model.Gender(value).IsValid() {
+		fieldErrors = append(fieldErrors, transport.FieldError{Field: "gender", Msg: "must be MALE or FEMALE", Key: "field.gender"})
+	}
+	if value := query.Get("email_verified"); len(value) > 0 {
+		if _, err := strconv.ParseBool(value); err != nil {
+			fieldErrors = append(fieldErrors, transport.FieldError{Field: "email_verified", Msg: "must be true or false", Key: "field.boolean"})
+		}
+	}
+	if value := query.Get("order_by"); len(value) > 0 && !validOrderBy(value, usersOrderBy) {
+		fieldErrors = append(fieldErrors, transport.FieldError{
+			Field:  "order_by",
+			Msg:    fmt.Sprintf("must be <field> or <field>.asc or <field>.desc, fields are %s", strings.Join(usersOrderBy, ", ")),
+			Key:    "field.order_by",
+			Params: map[string]interface{}{"fields": strings.Join(usersOrderBy, ", ")},
+		})
+	}
+
+	if len(fieldErrors) > 0 {
+		return transport.Error{Msg: "invalid query parameters", Code: transport.ErrorCodeInvalidParameter, Key: "user.invalid_query", Fields: fieldErrors}
+	}
+	return nil
 }
 
+func validOrderBy(value string, supported []string) bool {
+	fields := strings.Split(value, ".")
+	if len(fields) > 2 {
+		return false
+	}
+	if len(fields) == 2 {
+		if direction := strings.ToLower(fields[1]); direction != "asc" && direction != "desc" {
+			return false
+		}
+	}
+	for _, field := range supported {
+		if strings.ToLower(fields[0]) == field {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUsersRequest checks the query strictly for the versions of api.strict_query_versions.
 func GetUsersRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	if strictQuery(c) {
+		if err := checkUsersQuery(req); err != nil {
+			return nil, err
+		}
+	}
+	emailVerified, err := getBoolParam(req, "email_verified")
+	if err != nil {
+		return nil, err
+	}
 
 	return service.GetUsersRequest{
-		Filter:  getFilterParam(c, req),
-		OrderBy: getOrderByParam_(c, req),
-		Paging:  getPagingInfo(c, req),
+		Filter:        getFilterParam(c, req),
+		EmailVerified: emailVerified,
+		OrderBy:       getOrderByParam_(c, req),
+		Paging:        getPagingInfo(c, req),
 	}, nil
 }
@@ -0,0 +1,54 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+)
+
+func CreateAPIKeyRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var createRequest service.CreateAPIKeyRequest
+	if err := decodeJSONBody(req, &createRequest); err != nil {
+		return nil, err
+	}
+	return createRequest, nil
+}
+
+func ListAPIKeysRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	return service.ListAPIKeysRequest{IncludeRevoked: getParam(req, "include_revoked") == "true"}, nil
+}
+
+func RotateAPIKeyRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	keyID, err := getVarInt(req, "keyID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.RotateAPIKeyRequest{KeyID: model.APIKeyID(keyID)}, nil
+}
+
+func RevokeAPIKeyRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	keyID, err := getVarInt(req, "keyID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.RevokeAPIKeyRequest{KeyID: model.APIKeyID(keyID)}, nil
+}
+
+func GetAPIKeyUsageRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	keyID, err := getVarInt(req, "keyID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.GetAPIKeyUsageRequest{
+		KeyID: model.APIKeyID(keyID),
+		Days:  getParamIntWithDefault(req, "days", 0),
+	}, nil
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+)
+
+func LoginRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var loginRequest service.LoginRequest
+	if err := decodeJSONBody(req, &loginRequest); err != nil {
+		return nil, err
+	}
+	return loginRequest, nil
+}
+
+func LoginMFARequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var loginRequest service.LoginMFARequest
+	if err := decodeJSONBody(req, &loginRequest); err != nil {
+		return nil, err
+	}
+	return loginRequest, nil
+}
+
+func RefreshTokenRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var refreshRequest service.RefreshTokenRequest
+	if err := decodeJSONBody(req, &refreshRequest); err != nil {
+		return nil, err
+	}
+	return refreshRequest, nil
+}
+
+func SetPasswordRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var setPasswordRequest service.SetPasswordRequest
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+
+	if err := decodeJSONBody(req, &setPasswordRequest); err != nil {
+		return nil, err
+	}
+	setPasswordRequest.UserID = model.UserID(userID)
+	return setPasswordRequest, nil
+}
+
+func ForgotPasswordRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var forgotRequest service.ForgotPasswordRequest
+	if err := decodeJSONBody(req, &forgotRequest); err != nil {
+		return nil, err
+	}
+	return forgotRequest, nil
+}
+
+func ResetPasswordRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var resetRequest service.ResetPasswordRequest
+	if err := decodeJSONBody(req, &resetRequest); err != nil {
+		return nil, err
+	}
+	return resetRequest, nil
+}
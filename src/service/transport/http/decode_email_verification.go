@@ -0,0 +1,27 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+)
+
+func SendEmailVerificationRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.SendEmailVerificationRequest{UserID: model.UserID(userID)}, nil
+}
+
+func VerifyEmailRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var verifyRequest service.VerifyEmailRequest
+	if err := decodeJSONBody(req, &verifyRequest); err != nil {
+		return nil, err
+	}
+	return verifyRequest, nil
+}
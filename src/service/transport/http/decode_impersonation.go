@@ -0,0 +1,34 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+)
+
+func ImpersonateRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	var impersonateRequest service.ImpersonateRequest
+	if err := decodeJSONBody(req, &impersonateRequest); err != nil {
+		return nil, err
+	}
+	impersonateRequest.UserID = model.UserID(userID)
+	return impersonateRequest, nil
+}
+
+func EndImpersonationRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	impersonationID, err := getVarInt(req, "impersonationID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.EndImpersonationRequest{ImpersonationID: model.ImpersonationID(impersonationID)}, nil
+}
@@ -0,0 +1,15 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"user-service/src/service"
+)
+
+func LDAPSyncRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	dryRun, err := getBoolParam(req, "dry_run")
+	if err != nil {
+		return nil, err
+	}
+	return service.LDAPSyncRequest{DryRun: dryRun != nil && *dryRun}, nil
+}
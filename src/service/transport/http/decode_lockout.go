@@ -0,0 +1,29 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+)
+
+func GetLockoutRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.GetLockoutRequest{UserID: model.UserID(userID)}, nil
+}
+
+func UnlockUserRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.UnlockUserRequest{UserID: model.UserID(userID)}, nil
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+)
+
+func EnrollTOTPRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.EnrollTOTPRequest{UserID: model.UserID(userID)}, nil
+}
+
+func ConfirmTOTPRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var confirmRequest service.ConfirmTOTPRequest
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+
+	if err := decodeJSONBody(req, &confirmRequest); err != nil {
+		return nil, err
+	}
+	confirmRequest.UserID = model.UserID(userID)
+	return confirmRequest, nil
+}
+
+func DisableTOTPRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	var disableRequest service.DisableTOTPRequest
+	// the body with the code is optional, it is only needed to disable the own factor
+	if req.ContentLength != 0 {
+		if err := decodeJSONBody(req, &disableRequest); err != nil {
+			return nil, err
+		}
+	}
+	disableRequest.UserID = model.UserID(userID)
+	return disableRequest, nil
+}
+
+func RegenerateRecoveryCodesRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.RegenerateRecoveryCodesRequest{UserID: model.UserID(userID)}, nil
+}
+
+func GetMFAStatusRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.GetMFAStatusRequest{UserID: model.UserID(userID)}, nil
+}
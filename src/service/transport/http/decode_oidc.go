@@ -0,0 +1,77 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"user-service/src/service"
+	"user-service/src/service/transport"
+)
+
+func DiscoveryRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	return service.DiscoveryRequest{}, nil
+}
+
+func JWKSRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	return service.JWKSRequest{}, nil
+}
+
+func AuthorizeRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, transport.OAuthError{Code: transport.OAuthInvalidRequest}
+	}
+	return service.AuthorizeRequest{
+		ResponseType:        req.Form.Get("response_type"),
+		ClientID:            req.Form.Get("client_id"),
+		RedirectURI:         req.Form.Get("redirect_uri"),
+		Scope:               req.Form.Get("scope"),
+		State:               req.Form.Get("state"),
+		Nonce:               req.Form.Get("nonce"),
+		CodeChallenge:       req.Form.Get("code_challenge"),
+		CodeChallengeMethod: req.Form.Get("code_challenge_method"),
+	}, nil
+}
+
+func OIDCTokenRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, transport.OAuthError{Code: transport.OAuthInvalidRequest}
+	}
+	tokenRequest := service.OIDCTokenRequest{
+		GrantType:    req.PostForm.Get("grant_type"),
+		Code:         req.PostForm.Get("code"),
+		RedirectURI:  req.PostForm.Get("redirect_uri"),
+		ClientID:     req.PostForm.Get("client_id"),
+		ClientSecret: req.PostForm.Get("client_secret"),
+		CodeVerifier: req.PostForm.Get("code_verifier"),
+	}
+	// client_secret_basic takes precedence over client_secret_post
+	if clientID, secret, ok := req.BasicAuth(); ok {
+		tokenRequest.ClientID = clientID
+		tokenRequest.ClientSecret = secret
+	}
+	return tokenRequest, nil
+}
+
+func UserInfoRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	header := req.Header.Get("Authorization")
+	if len(header) <= 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return nil, transport.OAuthError{Code: transport.OAuthInvalidToken}
+	}
+	return service.UserInfoRequest{AccessToken: strings.TrimSpace(header[7:])}, nil
+}
+
+func RegisterOIDCClientRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var registerRequest service.RegisterOIDCClientRequest
+	if err := decodeJSONBody(req, &registerRequest); err != nil {
+		return nil, err
+	}
+	return registerRequest, nil
+}
+
+func ListOIDCClientsRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	return service.ListOIDCClientsRequest{}, nil
+}
+
+func RotateSigningKeyRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	return service.RotateSigningKeyRequest{}, nil
+}
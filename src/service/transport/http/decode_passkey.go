@@ -0,0 +1,80 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"user-service/src/service"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+)
+
+func BeginPasskeyRegistrationRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.BeginPasskeyRegistrationRequest{UserID: model.UserID(userID)}, nil
+}
+
+func FinishPasskeyRegistrationRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var finishRequest service.FinishPasskeyRegistrationRequest
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+
+	if err := decodeJSONBody(req, &finishRequest); err != nil {
+		return nil, err
+	}
+	finishRequest.UserID = model.UserID(userID)
+	return finishRequest, nil
+}
+
+func BeginPasskeyLoginRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var beginRequest service.BeginPasskeyLoginRequest
+	// the body is optional, without name the browser offers discoverable passkeys
+	if req.ContentLength != 0 {
+		if err := decodeJSONBody(req, &beginRequest); err != nil {
+			return nil, err
+		}
+	}
+	return beginRequest, nil
+}
+
+func FinishPasskeyLoginRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var finishRequest service.FinishPasskeyLoginRequest
+	if err := decodeJSONBody(req, &finishRequest); err != nil {
+		return nil, err
+	}
+	return finishRequest, nil
+}
+
+func ListPasskeysRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.ListPasskeysRequest{UserID: model.UserID(userID)}, nil
+}
+
+func RevokePasskeyRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	userID, err := getVarInt(req, "userID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	passkeyID, err := getVarInt(req, "passkeyID")
+	if err != nil {
+		return nil, transport.Error{
+			Code: transport.ErrorCodeInvalidParameter,
+		}
+	}
+	return service.RevokePasskeyRequest{UserID: model.UserID(userID), PasskeyID: model.PasskeyID(passkeyID)}, nil
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"user-service/src/service"
+	"user-service/src/service/transport"
+)
+
+func SCIMServiceProviderConfigRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	return service.SCIMServiceProviderConfigRequest{}, nil
+}
+
+func SCIMSchemasRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	id, _ := getVar(req, "id")
+	return service.SCIMSchemasRequest{ID: id}, nil
+}
+
+func SCIMResourceTypesRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	name, _ := getVar(req, "name")
+	return service.SCIMResourceTypesRequest{Name: name}, nil
+}
+
+func SCIMCreateUserRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var createRequest service.SCIMCreateUserRequest
+	if err := decodeSCIMBody(req, &createRequest.User); err != nil {
+		return nil, err
+	}
+	return createRequest, nil
+}
+
+func SCIMGetUserRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	id, _ := getVar(req, "id")
+	return service.SCIMGetUserRequest{ID: id}, nil
+}
+
+func SCIMListUsersRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	listRequest := service.SCIMListUsersRequest{Filter: getParam(req, "filter"), StartIndex: 1}
+	if value := getParam(req, "startIndex"); len(value) > 0 {
+		if listRequest.StartIndex, err = strconv.Atoi(value); err != nil {
+			return nil, transport.SCIMError{Type: transport.SCIMInvalidValue, Detail: "startIndex must be an integer"}
+		}
+	}
+	if value := getParam(req, "count"); len(value) > 0 {
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, transport.SCIMError{Type: transport.SCIMInvalidValue, Detail: "count must be an integer"}
+		}
+		listRequest.Count = &count
+	}
+	return listRequest, nil
+}
+
+func SCIMReplaceUserRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	replaceRequest := service.SCIMReplaceUserRequest{}
+	replaceRequest.ID, _ = getVar(req, "id")
+	if err := decodeSCIMBody(req, &replaceRequest.User); err != nil {
+		return nil, err
+	}
+	return replaceRequest, nil
+}
+
+func SCIMPatchUserRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	var patchRequest service.SCIMPatchRequest
+	if err := decodeSCIMBody(req, &patchRequest); err != nil {
+		return nil, err
+	}
+	patchRequest.ID, _ = getVar(req, "id")
+	return patchRequest, nil
+}
+
+func SCIMDeleteUserRequest(c context.Context, req *http.Request) (request interface{}, err error) {
+	id, _ := getVar(req, "id")
+	return service.SCIMDeleteUserRequest{ID: id}, nil
+}
+
+// decodeSCIMBody fails with a SCIM error, clients expect one for any request to the SCIM endpoints.
+func decodeSCIMBody(req *http.Request, v interface{}) error {
+	b, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		return transport.SCIMError{Type: transport.SCIMInvalidSyntax}
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return transport.SCIMError{Type: transport.SCIMInvalidSyntax, Detail: err.Error()}
+	}
+	return nil
+}
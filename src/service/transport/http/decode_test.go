@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"user-service/src/service"
 	"user-service/src/service/model"
@@ -32,6 +33,12 @@ func createHttpRequestWithVar(request *http.Request, vars map[string]string) *ht
 	return httpReq
 }
 
+func createJSONRequest(method string, target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
 func createPathWithQuery(path string, query map[string]string) string {
 	queryStr := ""
 	for key, value := range query {
@@ -97,6 +104,21 @@ func TestGetUsersRequest(t *testing.T) {
 			},
 			expectedErr: false,
 		},
+		{
+			name:    "email verified",
+			request: createRequest("GET", createPathWithQuery("/test", map[string]string{"email_verified": "false"}), nil),
+			expectedResult: service.GetUsersRequest{
+				EmailVerified: new(bool),
+				Paging:        service.Paging{Page: 1, Limit: 10},
+				OrderBy:       []string{"id asc"},
+			},
+			expectedErr: false,
+		},
+		{
+			name:        "invalid email verified",
+			request:     createRequest("GET", createPathWithQuery("/test", map[string]string{"email_verified": "maybe"}), nil),
+			expectedErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -105,6 +127,7 @@ func TestGetUsersRequest(t *testing.T) {
 			if err != nil {
 				assert.Equal(t, tt.expectedErr, true)
 			} else {
+				assert.Equal(t, tt.expectedErr, false)
 				rs, _ := result.(service.GetUsersRequest)
 				assert.Equal(t, tt.expectedResult, rs)
 			}
@@ -113,6 +136,7 @@ func TestGetUsersRequest(t *testing.T) {
 }
 
 func TestPostUserRequest(t *testing.T) {
+	inactive := model.StatusInactive
 	tests := []struct {
 		name    string
 		req     *http.Request
@@ -121,30 +145,56 @@ func TestPostUserRequest(t *testing.T) {
 	}{
 		{
 			name: "normal",
-			req: httptest.NewRequest("POST",
-				"http://host.com/user",
-				bytes.NewBuffer([]byte(`
+			req: createJSONRequest("POST", "http://host.com/user", bytes.NewBuffer([]byte(`
 {
 	"name":"QL",
 	"gender":"MALE"
 }`,
-				))),
+			))),
 			want: service.PostUserRequest{
 				User: model.User{
 					Name:   "QL",
 					Gender: "MALE",
 				},
+				Fields: []string{"name", "gender"},
 			},
 			wantErr: false,
 		},
+		{
+			name: "null fields aren't written",
+			req: createJSONRequest("POST", "http://host.com/user",
+				bytes.NewBuffer([]byte(`{"Name":"QL","status":"INACTIVE","phone":null}`))),
+			want: service.PostUserRequest{
+				User: model.User{
+					Name:   "QL",
+					Status: &inactive,
+				},
+				Fields: []string{"name", "status"},
+			},
+		},
+		{
+			name:    "read-only field",
+			req:     createJSONRequest("POST", "http://host.com/user", bytes.NewBuffer([]byte(`{"name":"QL","role":"ADMIN"}`))),
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			req:     createJSONRequest("POST", "http://host.com/user", bytes.NewBuffer([]byte(`{"name":"QL","nickname":"q"}`))),
+			wantErr: true,
+		},
+		{
+			name:    "wrong type",
+			req:     createJSONRequest("POST", "http://host.com/user", bytes.NewBuffer([]byte(`{"name":1}`))),
+			wantErr: true,
+		},
 		{
 			name:    "missing body",
-			req:     httptest.NewRequest("POST", "http://host.com/user", bytes.NewBuffer([]byte(nil))),
+			req:     createJSONRequest("POST", "http://host.com/user", bytes.NewBuffer([]byte(nil))),
 			wantErr: true,
 		},
 		{
 			name:    "not valid json body",
-			req:     httptest.NewRequest("POST", "http://host.com/user", bytes.NewBuffer([]byte(`nil`))),
+			req:     createJSONRequest("POST", "http://host.com/user", bytes.NewBuffer([]byte(`nil`))),
 			wantErr: true,
 		},
 	}
@@ -163,7 +213,7 @@ func TestPostUserRequest(t *testing.T) {
 }
 
 func createTestPatchUserRequest(user string, body string) *http.Request {
-	httpReq := httptest.NewRequest("PATCH", "http://host.com/user/2", bytes.NewBuffer([]byte(body)))
+	httpReq := createJSONRequest("PATCH", "http://host.com/user/2", bytes.NewBuffer([]byte(body)))
 	httpReq = mux.SetURLVars(httpReq, map[string]string{
 		"userID": user,
 	})
@@ -185,6 +235,7 @@ func TestPatchUserRequest(t *testing.T) {
 					ID:     2,
 					Gender: "MALE",
 				},
+				Fields: []string{"gender"},
 			},
 			wantErr: false,
 		},
@@ -193,6 +244,11 @@ func TestPatchUserRequest(t *testing.T) {
 			req:     createTestPatchUserRequest("2", `nil`),
 			wantErr: true,
 		},
+		{
+			name:    "id and status are read-only",
+			req:     createTestPatchUserRequest("2", `{"id":3,"status":"INACTIVE"}`),
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -207,3 +263,120 @@ func TestPatchUserRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestGetUsersRequest_Strict(t *testing.T) {
+	viper.Set("paging_max_size", 10)
+	viper.Set("api.strict_query_versions", []string{"2"})
+	defer viper.Set("api.strict_query_versions", nil)
+	path := "/users?page=abc&limit=5&order_by=name.sideways&sort=name&gender=MALE&q=1"
+
+	// version 1 keeps ignoring what it doesn't understand
+	req := createRequest("GET", path, nil)
+	result, err := GetUsersRequest(extractAPIVersion(context.Background(), req), req)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.(service.GetUsersRequest).OrderBy, []string{"name asc"})
+
+	req = createRequest("GET", path, map[string]string{apiVersionHeader: "2"})
+	_, err = GetUsersRequest(extractAPIVersion(context.Background(), req), req)
+	e := err.(transport.Error)
+	assert.Equal(t, e.Code, transport.ErrorCodeInvalidParameter)
+	var fields []string
+	for _, field := range e.Fields {
+		fields = append(fields, field.Field)
+	}
+	assert.Equal(t, fields, []string{"q", "sort", "page", "order_by"})
+
+	req = createRequest("GET", "/users?page=2&limit=5&order_by=gender.DESC&email_verified=true", map[string]string{apiVersionHeader: "2"})
+	_, err = GetUsersRequest(extractAPIVersion(context.Background(), req), req)
+	assert.Equal(t, err, nil)
+}
+
+func TestDecodeUserBody_Errors(t *testing.T) {
+	viper.Set("http_server.max_body_size", 32)
+	defer viper.Set("http_server.max_body_size", nil)
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		code        transport.ResponseCode
+		fields      []string
+	}{
+		{"no content type", "", `{}`, transport.ErrorCodeUnsupportedMediaType, nil},
+		{"form", "application/x-www-form-urlencoded", `{}`, transport.ErrorCodeUnsupportedMediaType, nil},
+		{"too large", "application/json", `{"name":"` + strings.Repeat("a", 32) + `"}`, transport.ErrorCodePayloadTooLarge, nil},
+		{"unknown fields", "application/json; charset=utf-8", `{"b":1,"id":2,"a":3}`, transport.ErrorCodeInvalidParameter, []string{"id", "a", "b"}},
+		{"wrong type", "application/json", `{"phone":12}`, transport.ErrorCodeInvalidParameter, []string{"phone"}},
+		{"trailing data", "application/json", `{"name":"a"} {}`, transport.ErrorCodeInvalidParameter, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createTestPatchUserRequest("2", tt.body)
+			req.Header.Set("Content-Type", tt.contentType)
+			_, err := PatchUserRequest(context.Background(), req)
+			e := err.(transport.Error)
+			assert.Equal(t, e.Code, tt.code)
+			var fields []string
+			for _, field := range e.Fields {
+				fields = append(fields, field.Field)
+			}
+			assert.Equal(t, fields, tt.fields)
+		})
+	}
+}
+
+func TestPatchUserRequest_Patches(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		fields      []string
+		errFields   []string
+	}{
+		{"merge patch", "application/merge-patch+json", `{"name":"QL","phone":null}`, []string{"name", "phone"}, nil},
+		{"merge patch names are exact", "application/merge-patch+json", `{"Name":"QL"}`, nil, []string{"Name"}},
+		{"merge patch read-only", "application/merge-patch+json", `{"status":null}`, nil, []string{"status"}},
+		{"merge patch wrong type", "application/merge-patch+json", `{"gender":1}`, nil, []string{"gender"}},
+		{"json patch", "application/json-patch+json",
+			`[{"op":"test","path":"/role","value":"USER"},{"op":"replace","path":"/name","value":"QL"},{"op":"remove","path":"/phone"}]`,
+			[]string{"name", "phone"}, nil},
+		{"json patch invalid operations", "application/json-patch+json",
+			`[{"op":"move","path":"/name"},{"op":"replace","path":"/role","value":"ADMIN"},{"op":"add","path":"/nickname","value":"q"},{"op":"remove","path":""}]`,
+			nil, []string{"[0]", "[1].path", "[2].path", "[3].path"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createTestPatchUserRequest("2", tt.body)
+			req.Header.Set("Content-Type", tt.contentType)
+			got, err := PatchUserRequest(context.Background(), req)
+			if tt.errFields != nil {
+				e := err.(transport.Error)
+				assert.Equal(t, e.Code, transport.ErrorCodeInvalidParameter)
+				var fields []string
+				for _, field := range e.Fields {
+					fields = append(fields, field.Field)
+				}
+				assert.Equal(t, fields, tt.errFields)
+				return
+			}
+			assert.Equal(t, err, nil)
+			patchRequest := got.(service.PatchUserRequest)
+			assert.Equal(t, patchRequest.User.ID, model.UserID(2))
+			assert.Equal(t, patchRequest.Fields, tt.fields)
+			assert.Equal(t, patchRequest.MergePatch != nil || patchRequest.Operations != nil, true)
+		})
+	}
+}
+
+func TestReplaceUserRequest(t *testing.T) {
+	req := createHttpRequestWithVar(createJSONRequest("PUT", "http://host.com/user/2",
+		bytes.NewBuffer([]byte(`{"name":"QL","status":"INACTIVE"}`))), map[string]string{"userID": "2"})
+	got, err := ReplaceUserRequest(context.Background(), req)
+	assert.Equal(t, err, nil)
+	inactive := model.StatusInactive
+	assert.Equal(t, got, service.ReplaceUserRequest{User: model.User{ID: 2, Name: "QL", Status: &inactive}, ReplacePhone: true})
+
+	req = createHttpRequestWithVar(createJSONRequest("PUT", "http://host.com/user/2",
+		bytes.NewBuffer([]byte(`{"name":"QL","role":"ADMIN"}`))), map[string]string{"userID": "2"})
+	_, err = ReplaceUserRequest(context.Background(), req)
+	assert.Equal(t, err.(transport.Error).Code, transport.ErrorCodeInvalidParameter)
+}
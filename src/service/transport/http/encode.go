@@ -1,30 +1,48 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"expvar"
+	"fmt"
+	http2 "github.com/go-kit/kit/transport/http"
 	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
 	"user-service/src/service/transport"
+	"user-service/src/service/util/paging"
 )
 
-func encodeErrorResponse(_ context.Context, err error, w http.ResponseWriter) {
+func encodeErrorResponse(ctx context.Context, err error, w http.ResponseWriter) {
 	if err == http.ErrHandlerTimeout {
 		return
 	}
 
-	e, ok := err.(transport.Error)
-	if !ok {
-		panic("encodeError invalid error")
+	// the cause of the error is left out, only the public message and key are sent
+	lang := transport.LanguageFromContext(ctx)
+	e := transport.PublicError(err).Localize(lang)
+
+	if len(lang) > 0 {
+		w.Header().Set("Content-Language", lang)
 	}
+	status := codeToHTTPStatus(e.Code)
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if format, instance := errorFormatFromContext(ctx); format == ErrorFormatProblem {
+		w.Header().Set("Content-Type", problemMediaType+"; charset=utf-8")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(e.Problem(status, http.StatusText(status), instance))
+		return
+	}
 
-	w.WriteHeader(codeToHTTPStatus(e.Code))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": transport.ErrorResponse{
-			Code: e.Code,
-			Msg:  e.Error(),
-		},
+		"error": e.Response(),
 	})
 }
 
@@ -43,6 +61,16 @@ func codeToHTTPStatus(code transport.ResponseCode) int {
 		status = http.StatusNotImplemented
 	case transport.ErrorCodeUnauthorized:
 		status = http.StatusUnauthorized
+	case transport.ErrorCodeTooManyRequests:
+		status = http.StatusTooManyRequests
+	case transport.ErrorCodePayloadTooLarge:
+		status = http.StatusRequestEntityTooLarge
+	case transport.ErrorCodeUnsupportedMediaType:
+		status = http.StatusUnsupportedMediaType
+	case transport.ErrorCodeConflict:
+		status = http.StatusConflict
+	case transport.ErrorCodeUnprocessableEntity:
+		status = http.StatusUnprocessableEntity
 	default:
 		status = http.StatusInternalServerError
 	}
@@ -62,3 +90,191 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 	return json.NewEncoder(w).Encode(response)
 }
 
+// encodeUserResponse leaves out the fields of users the principal can't read.
+func encodeUserResponse(policy auth.FieldPolicy) http2.EncodeResponseFunc {
+	return func(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+		r, ok := response.(transport.APIResponse)
+		if !ok {
+			return encodeResponse(ctx, w, response)
+		}
+		principal, _ := auth.FromContext(ctx)
+		switch data := r.Data.(type) {
+		case *service.UserResponse:
+			r.Data = visibleUserResponse{User: visibleUser{user: data.User, principal: principal, policy: policy}}
+		case *service.UsersResponse:
+			users := make([]visibleUser, len(data.Users))
+			for i, user := range data.Users {
+				users[i] = visibleUser{user: user, principal: principal, policy: policy}
+			}
+			r.Data = visibleUsersResponse{Users: users, Paginator: data.Paginator}
+		}
+		return encodeResponse(ctx, w, r)
+	}
+}
+
+type visibleUserResponse struct {
+	User visibleUser `json:"user"`
+}
+
+type visibleUsersResponse struct {
+	Users     []visibleUser     `json:"users"`
+	Paginator *paging.Paginator `json:"paginator"`
+}
+
+// visibleUser encodes the fields of the user the principal can read, in the order of model.User.
+type visibleUser struct {
+	user      model.User
+	principal *auth.Principal
+	policy    auth.FieldPolicy
+}
+
+func (u visibleUser) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	v := reflect.ValueOf(u.user)
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" || !u.policy.CanRead(u.principal, u.user.ID, name) {
+			continue
+		}
+		value, err := json.Marshal(v.Field(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strconv.Quote(name))
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func encodeOAuthErrorResponse(ctx context.Context, err error, w http.ResponseWriter) {
+	e, ok := err.(transport.OAuthError)
+	if !ok {
+		encodeErrorResponse(ctx, err, w)
+		return
+	}
+
+	status := http.StatusBadRequest
+	switch e.Code {
+	case transport.OAuthInvalidClient:
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+	case transport.OAuthInvalidToken:
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	case transport.OAuthServerError:
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	body := map[string]string{"error": e.Code}
+	if len(e.Description) > 0 {
+		body["error_description"] = e.Description
+	}
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// encodeOAuthResponse writes protocol documents as is, tokens must never be cached.
+func encodeOAuthResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response)
+}
+
+func encodeRedirectResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	res := response.(*service.AuthorizeResponse)
+	w.Header().Set("Location", res.RedirectURL)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusFound)
+	return nil
+}
+
+// encodeSCIMErrorResponse also encodes the errors of authentication and of UserService, see RFC 7644 section 3.12.
+func encodeSCIMErrorResponse(ctx context.Context, err error, w http.ResponseWriter) {
+	if err == http.ErrHandlerTimeout {
+		return
+	}
+
+	body := map[string]interface{}{"schemas": []string{service.SCIMSchemaError}}
+	status := http.StatusInternalServerError
+	switch e := err.(type) {
+	case transport.SCIMError:
+		status = http.StatusBadRequest
+		if e.Type == transport.SCIMUniqueness {
+			status = http.StatusConflict
+		}
+		body["scimType"] = e.Type
+		if len(e.Detail) > 0 {
+			body["detail"] = e.Detail
+		}
+	default:
+		public := transport.PublicError(err).Localize(transport.LanguageFromContext(ctx))
+		status = codeToHTTPStatus(public.Code)
+		if public.Code == transport.ErrorCodeConflict {
+			body["scimType"] = transport.SCIMUniqueness
+		}
+		body["detail"] = public.Msg
+	}
+	body["status"] = strconv.Itoa(status)
+
+	w.Header().Set("Content-Type", "application/scim+json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func encodeSCIMResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Content-Type", "application/scim+json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(response)
+}
+
+func encodeSCIMCreatedResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	user := response.(*service.SCIMUser)
+	if user.Meta != nil {
+		w.Header().Set("Location", user.Meta.Location)
+	}
+	w.Header().Set("Content-Type", "application/scim+json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(response)
+}
+
+func encodeNoContentResponse(_ context.Context, w http.ResponseWriter, _ interface{}) error {
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// encodeExportResponse serves the export as a JSON file to download.
+func encodeExportResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	export := response.(*service.UserExport)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.json"`, export.User.ID))
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+// encodeVarsResponse writes the variables published with expvar the way expvar.Handler does.
+func encodeVarsResponse(_ context.Context, w http.ResponseWriter, _ interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !first {
+			fmt.Fprintf(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	_, err := fmt.Fprintf(w, "\n}\n")
+	return err
+}
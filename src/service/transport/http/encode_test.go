@@ -0,0 +1,232 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/magiconair/properties/assert"
+	"net/http/httptest"
+	"testing"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/model"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/jsonpatch"
+)
+
+var testFieldPolicy = auth.FieldPolicy{
+	Read:  map[string][]string{"phone": {auth.AudienceSelf, "ADMIN"}},
+	Write: map[string][]string{"status": {"ADMIN"}, "phone": {auth.AudienceSelf, "ADMIN"}},
+}
+
+func TestEncodeUserResponse(t *testing.T) {
+	phone := "+84912345678"
+	user := model.User{ID: 7, Name: "ql", Gender: model.Male, Phone: &phone}
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		want      string
+	}{
+		{
+			name: "anonymous",
+			want: `{"data":{"user":{"id":7,"name":"ql","gender":"MALE","status":null,"role":null,"email":null,"email_verified_at":null}}}`,
+		},
+		{
+			name:      "other user",
+			principal: &auth.Principal{Type: auth.PrincipalUser, ID: "8", Role: model.RoleSupport},
+			want:      `{"data":{"user":{"id":7,"name":"ql","gender":"MALE","status":null,"role":null,"email":null,"email_verified_at":null}}}`,
+		},
+		{
+			name:      "self",
+			principal: &auth.Principal{Type: auth.PrincipalUser, ID: "7", Role: model.RoleUser},
+			want:      `{"data":{"user":{"id":7,"name":"ql","gender":"MALE","status":null,"role":null,"email":null,"email_verified_at":null,"phone":"+84912345678"}}}`,
+		},
+		{
+			name:      "admin",
+			principal: &auth.Principal{Type: auth.PrincipalUser, ID: "1", Role: model.RoleAdmin},
+			want:      `{"data":{"user":{"id":7,"name":"ql","gender":"MALE","status":null,"role":null,"email":null,"email_verified_at":null,"phone":"+84912345678"}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the principal is resolved by the endpoint after the holder is put in context
+			ctx := auth.WithPrincipalHolder(context.Background())
+			if tt.principal != nil {
+				auth.NewContext(ctx, tt.principal)
+			}
+			w := httptest.NewRecorder()
+			err := encodeUserResponse(testFieldPolicy)(ctx, w, transport.APIResponse{Data: &service.UserResponse{User: user}})
+			assert.Equal(t, err, nil)
+			assert.Equal(t, w.Body.String(), tt.want+"\n")
+		})
+	}
+}
+
+func TestCheckFieldWrites(t *testing.T) {
+	next := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+	check := transport.CheckFieldWrites(testFieldPolicy)(next)
+	request := service.PatchUserRequest{User: model.User{ID: 7}, Fields: []string{"name", "status", "phone"}}
+
+	admin := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "1", Role: model.RoleAdmin})
+	_, err := check(admin, request)
+	assert.Equal(t, err, nil)
+
+	support := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "8", Role: model.RoleSupport})
+	_, err = check(support, request)
+	e := err.(transport.Error)
+	assert.Equal(t, e.Code, transport.ErrorCodePermissionDenied)
+	assert.Equal(t, e.Fields, []transport.FieldError{
+		{Field: "status", Msg: "not allowed to write", Key: "field.not_allowed"},
+		{Field: "phone", Msg: "not allowed to write", Key: "field.not_allowed"},
+	})
+
+	self := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "7", Role: model.RoleSupport})
+	_, err = check(self, service.PatchUserRequest{User: model.User{ID: 7}, Fields: []string{"phone"}})
+	assert.Equal(t, err, nil)
+
+	// a replacement writes the status only when it sets one
+	_, err = check(support, service.ReplaceUserRequest{User: model.User{ID: 7, Name: "QL"}})
+	assert.Equal(t, err, nil)
+	inactive := model.StatusInactive
+	_, err = check(support, service.ReplaceUserRequest{User: model.User{ID: 7, Name: "QL", Status: &inactive}})
+	assert.Equal(t, err.(transport.Error).Fields, []transport.FieldError{{Field: "status", Msg: "not allowed to write", Key: "field.not_allowed"}})
+
+	// self doesn't apply to users being created
+	_, err = check(self, service.PostUserRequest{Fields: []string{"name", "phone"}})
+	assert.Equal(t, err.(transport.Error).Fields, []transport.FieldError{{Field: "phone", Msg: "not allowed to write", Key: "field.not_allowed"}})
+}
+
+func TestCheckFieldReads(t *testing.T) {
+	next := func(context.Context, interface{}) (interface{}, error) { return "ok", nil }
+	check := transport.CheckFieldReads(testFieldPolicy)(next)
+	request := service.PatchUserRequest{User: model.User{ID: 7}, Operations: []jsonpatch.Operation{
+		{Op: jsonpatch.OpTest, Path: "/phone", Value: []byte(`"+84912345678"`)},
+		{Op: jsonpatch.OpReplace, Path: "/name", Value: []byte(`"QL"`)},
+	}}
+
+	support := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "8", Role: model.RoleSupport})
+	_, err := check(support, request)
+	e := err.(transport.Error)
+	assert.Equal(t, e.Code, transport.ErrorCodePermissionDenied)
+	assert.Equal(t, e.Fields, []transport.FieldError{{Field: "phone", Msg: "not allowed to read", Key: "field.not_readable"}})
+
+	self := auth.NewContext(context.Background(), &auth.Principal{Type: auth.PrincipalUser, ID: "7", Role: model.RoleUser})
+	_, err = check(self, request)
+	assert.Equal(t, err, nil)
+}
+
+func TestEncodeErrorResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		want   string
+	}{
+		{
+			name:   "keyed error",
+			err:    transport.Error{Msg: "not found user 1", Code: transport.ErrorCodeNotFound, Key: "user.not_found"},
+			status: 404,
+			want:   `{"error":{"msg":"not found user 1","code":4,"key":"user.not_found"}}`,
+		},
+		{
+			name:   "default key",
+			err:    &transport.Error{Msg: "invalid id", Code: transport.ErrorCodeInvalidParameter},
+			status: 400,
+			want:   `{"error":{"msg":"invalid id","code":1,"key":"invalid_parameter"}}`,
+		},
+		{
+			name: "cause is hidden",
+			err: transport.Error{Msg: "can't get user 1", Code: transport.ErrorCodeInternal, Key: "user.get_failed"}.
+				Wrap(errors.New("dial tcp 10.0.0.1:3306: connection refused")),
+			status: 500,
+			want:   `{"error":{"msg":"can't get user 1","code":3,"key":"user.get_failed"}}`,
+		},
+		{
+			name:   "internal error without key",
+			err:    transport.Error{Msg: "error when get user 1: connection refused", Code: transport.ErrorCodeInternal},
+			status: 500,
+			want:   `{"error":{"msg":"internal error","code":3,"key":"internal"}}`,
+		},
+		{
+			name:   "not an Error",
+			err:    errors.New("boom"),
+			status: 500,
+			want:   `{"error":{"msg":"internal error","code":3,"key":"internal"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			encodeErrorResponse(context.Background(), tt.err, w)
+			assert.Equal(t, w.Code, tt.status)
+			assert.Equal(t, w.Body.String(), tt.want+"\n")
+		})
+	}
+}
+
+func TestEncodeErrorResponse_Localized(t *testing.T) {
+	err := transport.Error{Msg: "invalid phone 0912", Code: transport.ErrorCodeInvalidParameter, Key: "user.invalid_phone",
+		Params: map[string]interface{}{"phone": "0912"},
+		Fields: []transport.FieldError{{Field: "phone", Msg: "must be in E.164 format, e.g. +84912345678", Key: "field.phone_format"}},
+	}
+	tests := []struct {
+		acceptLanguage string
+		lang           string
+		want           string
+	}{
+		{
+			acceptLanguage: "vi-VN,vi;q=0.9,en;q=0.8",
+			lang:           "vi",
+			want:           `{"error":{"msg":"số điện thoại 0912 không hợp lệ","code":1,"key":"user.invalid_phone","fields":[{"field":"phone","msg":"phải theo định dạng E.164, ví dụ +84912345678","key":"field.phone_format"}]}}`,
+		},
+		{
+			acceptLanguage: "fr, en;q=0.5",
+			lang:           "en",
+			want:           `{"error":{"msg":"invalid phone 0912","code":1,"key":"user.invalid_phone","fields":[{"field":"phone","msg":"must be in E.164 format, e.g. +84912345678","key":"field.phone_format"}]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptLanguage, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/user/1", nil)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			w := httptest.NewRecorder()
+			encodeErrorResponse(extractLanguage(context.Background(), req), err, w)
+			assert.Equal(t, w.Header().Get("Content-Language"), tt.lang)
+			assert.Equal(t, w.Body.String(), tt.want+"\n")
+		})
+	}
+}
+
+func TestEncodeErrorResponse_Problem(t *testing.T) {
+	err := transport.Error{Msg: "read-only fields are set", Code: transport.ErrorCodeInvalidParameter, Key: "user.read_only_fields",
+		Fields: []transport.FieldError{{Field: "role", Msg: "is changed with PUT /user/{id}/role", Key: "field.role_read_only"}},
+	}
+	req := httptest.NewRequest("PATCH", "/user/7?x=1", nil)
+	req.Header.Set("Accept", "application/problem+json, application/json;q=0.9")
+	w := httptest.NewRecorder()
+	encodeErrorResponse(extractErrorFormat(context.Background(), req), err, w)
+
+	assert.Equal(t, w.Code, 400)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/problem+json; charset=utf-8")
+	assert.Equal(t, w.Body.String(), `{"type":"urn:user-service:error:user.read_only_fields","title":"Bad Request","status":400,`+
+		`"detail":"read-only fields are set","instance":"/user/7?x=1","code":1,"key":"user.read_only_fields",`+
+		`"fields":[{"field":"role","msg":"is changed with PUT /user/{id}/role","key":"field.role_read_only"}]}`+"\n")
+}
+
+func TestNegotiateErrorFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		def    string
+		want   string
+	}{
+		{"", "", ErrorFormatLegacy},
+		{"", ErrorFormatProblem, ErrorFormatProblem},
+		{"*/*", ErrorFormatProblem, ErrorFormatProblem},
+		{"application/problem+json", ErrorFormatLegacy, ErrorFormatProblem},
+		{"application/json, application/problem+json;q=0.5", ErrorFormatLegacy, ErrorFormatProblem},
+		{"application/json", ErrorFormatProblem, ErrorFormatLegacy},
+		{"application/problem+json;q=0, application/json", ErrorFormatProblem, ErrorFormatLegacy},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, negotiateErrorFormat(tt.accept, tt.def), tt.want, tt.accept)
+	}
+}
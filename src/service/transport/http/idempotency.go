@@ -0,0 +1,166 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"user-service/src/service/auth"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/idempotency"
+	"user-service/src/service/util/log"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// validIdempotencyKey is the key without the quotes of a structured header string, UUIDs are expected.
+var validIdempotencyKey = regexp.MustCompile(`^[\x21-\x7e]{1,255}$`)
+
+type IdempotencyConfig struct {
+	// TTL is how long responses are replayed
+	TTL time.Duration
+	// AnonymousTTL is TTL for anonymous requests, whose keys only the key itself scopes, 0 ignores their keys
+	AnonymousTTL time.Duration
+	// LockTimeout frees the keys of requests whose response was never stored, e.g. when the instance stopped
+	LockTimeout time.Duration
+}
+
+// Idempotency replays the response to the first POST sent with an Idempotency-Key to the retries sent with the
+// same key and body. Keys are scoped to the principal the credentials resolve to, so retries still match once the
+// token is refreshed. Anonymous requests share a single scope, a client sending the key and body of another gets its
+// response, so they are only kept for AnonymousTTL. Server errors and 429 aren't stored, the retries of those
+// requests are processed again. The responses are stored as they are, it must only wrap the routes creating users:
+// a route whose response carries a secret would keep it in the store.
+func Idempotency(store idempotency.Store, config IdempotencyConfig, authn transport.Authenticator, logger *log.Logger) HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := strings.Trim(r.Header.Get(idempotencyKeyHeader), `"`)
+			if r.Method != http.MethodPost || len(r.Header.Get(idempotencyKeyHeader)) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			resolved, err := authn.Resolve(extractCredentials(extractClient(ctx, r), r))
+			if err != nil {
+				encodeErrorResponse(ctx, err, w)
+				return
+			}
+			scope, ttl := "anonymous", config.AnonymousTTL
+			if principal, ok := auth.FromContext(resolved); ok {
+				scope, ttl = principal.String(), config.TTL
+				// the endpoint keeps the principal instead of checking the credentials again
+				ctx = auth.NewContext(ctx, principal)
+				r = r.WithContext(ctx)
+			} else if ttl <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !validIdempotencyKey.MatchString(key) {
+				encodeErrorResponse(ctx, transport.Error{Msg: "Idempotency-Key must be 1 to 255 visible characters", Code: transport.ErrorCodeInvalidParameter,
+					Key: "request.invalid_idempotency_key"}, w)
+				return
+			}
+			body, err := readBody(r)
+			if err != nil {
+				encodeErrorResponse(ctx, err, w)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			record := idempotency.Record{
+				Key:         idempotencyHash(scope, key),
+				Fingerprint: idempotencyHash(r.Method, r.URL.RequestURI(), r.Header.Get("Content-Type"), string(body)),
+				ExpiresAt:   time.Now().Add(config.LockTimeout),
+			}
+			existing, err := store.Begin(ctx, record)
+			if err != nil {
+				e := transport.InternalError(fmt.Errorf("can't begin idempotent request: %w", err))
+				logger.Error(e.Error())
+				encodeErrorResponse(ctx, e, w)
+				return
+			}
+			if existing != nil {
+				replay(w, r, *existing, record.Fingerprint)
+				return
+			}
+
+			rw := &storingWriter{ResponseWriter: w}
+			defer func() {
+				if rw.status == 0 || rw.status >= http.StatusInternalServerError || rw.status == http.StatusTooManyRequests {
+					if err := store.Release(ctx, record.Key); err != nil {
+						logger.Error(fmt.Sprintf("can't release idempotent request: %v", err))
+					}
+					return
+				}
+				record.Status, record.Header, record.Body = rw.status, rw.header, rw.body.Bytes()
+				record.ExpiresAt = time.Now().Add(ttl)
+				if err := store.Complete(ctx, record); err != nil {
+					logger.Error(fmt.Sprintf("can't store idempotent response: %v", err))
+				}
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// replay answers the stored response, unless the key was used for another request or its first request is
+// still in progress.
+func replay(w http.ResponseWriter, r *http.Request, record idempotency.Record, fingerprint string) {
+	switch {
+	case record.Fingerprint != fingerprint:
+		encodeErrorResponse(r.Context(), transport.Error{Msg: "Idempotency-Key was already used for another request", Code: transport.ErrorCodeUnprocessableEntity,
+			Key: "request.idempotency_key_reused"}, w)
+	case record.Pending():
+		w.Header().Set("Retry-After", "1")
+		encodeErrorResponse(r.Context(), transport.Error{Msg: "a request with the same Idempotency-Key is in progress", Code: transport.ErrorCodeConflict,
+			Key: "request.idempotency_in_progress"}, w)
+	default:
+		for name, values := range record.Header {
+			w.Header()[name] = values
+		}
+		w.Header().Set(idempotentReplayedHeader, "true")
+		w.WriteHeader(record.Status)
+		_, _ = w.Write(record.Body)
+	}
+}
+
+func idempotencyHash(values ...string) string {
+	h := sha256.New()
+	for _, value := range values {
+		_, _ = fmt.Fprintf(h, "%d:%s", len(value), value)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// storingWriter keeps the response to store it, the request id is left out as every request has its own.
+type storingWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *storingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.header = w.Header().Clone()
+		w.header.Del(requestIDHeader)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *storingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
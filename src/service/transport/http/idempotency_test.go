@@ -0,0 +1,148 @@
+package http
+
+import (
+	"context"
+	"github.com/magiconair/properties/assert"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/idempotency"
+	"user-service/src/service/util/log"
+)
+
+// fakeTokens resolves the tokens "<user>" and "<user>-refreshed" to the user.
+type fakeTokens struct {
+	service.AuthService
+}
+
+func (fakeTokens) Authenticate(_ context.Context, request service.AuthenticateTokenRequest) (*auth.Principal, error) {
+	if request.Token == "invalid" {
+		return nil, transport.Error{Msg: "invalid token", Code: transport.ErrorCodeUnauthorized}
+	}
+	return &auth.Principal{Type: auth.PrincipalUser, ID: strings.TrimSuffix(request.Token, "-refreshed")}, nil
+}
+
+var testAuthenticator = transport.Authenticator{Tokens: fakeTokens{}}
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	status := http.StatusCreated
+	handler := RequestID(Idempotency(idempotency.NewMemoryStore(), IdempotencyConfig{TTL: time.Hour, LockTimeout: time.Minute},
+		testAuthenticator, log.NewLogger(zap.NewNop()))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// the endpoint gets the principal resolved for the key
+		principal, _ := auth.FromContext(r.Context())
+		assert.Equal(t, principal != nil, len(r.Header.Get("Authorization")) > 0)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"id":1}`))
+	})))
+	post := func(key string, token string, body string) *httptest.ResponseRecorder {
+		req := createJSONRequest("POST", "/user", strings.NewReader(body))
+		req.Header.Set(idempotencyKeyHeader, key)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("k1", "1", `{"name":"QL"}`)
+	assert.Equal(t, w.Code, http.StatusCreated)
+	assert.Equal(t, w.Header().Get(idempotentReplayedHeader), "")
+
+	// the retry is replayed with its own request id
+	w = post("k1", "1", `{"name":"QL"}`)
+	assert.Equal(t, calls, 1)
+	assert.Equal(t, w.Code, http.StatusCreated)
+	assert.Equal(t, w.Body.String(), `{"id":1}`)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/json")
+	assert.Equal(t, w.Header().Get(idempotentReplayedHeader), "true")
+	assert.Equal(t, len(w.Header()[http.CanonicalHeaderKey(requestIDHeader)]), 1)
+
+	w = post("k1", "1", `{"name":"Q"}`)
+	assert.Equal(t, w.Code, http.StatusUnprocessableEntity)
+	assert.Equal(t, calls, 1)
+
+	// keys are scoped to the principal, not to the token, anonymous requests ignore them without AnonymousTTL
+	w = post("k1", "1-refreshed", `{"name":"QL"}`)
+	assert.Equal(t, w.Header().Get(idempotentReplayedHeader), "true")
+	post("k1", "2", `{"name":"Q"}`)
+	assert.Equal(t, calls, 2)
+	post("k1", "", `{"name":"Q"}`)
+	post("k1", "", `{"name":"Q"}`)
+	assert.Equal(t, calls, 4)
+
+	// server errors aren't stored
+	status = http.StatusInternalServerError
+	post("k2", "1", `{}`)
+	status = http.StatusCreated
+	w = post("k2", "1", `{}`)
+	assert.Equal(t, calls, 6)
+	assert.Equal(t, w.Code, http.StatusCreated)
+
+	w = post(strings.Repeat("k", 256), "1", `{}`)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	w = post("k3", "invalid", `{}`)
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	assert.Equal(t, calls, 6)
+}
+
+func TestIdempotency_Anonymous(t *testing.T) {
+	calls := 0
+	handler := Idempotency(idempotency.NewMemoryStore(), IdempotencyConfig{TTL: time.Hour, AnonymousTTL: time.Minute, LockTimeout: time.Minute},
+		testAuthenticator, log.NewLogger(zap.NewNop()))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	}))
+	post := func(token string) *httptest.ResponseRecorder {
+		req := createJSONRequest("POST", "/user", strings.NewReader(`{"name":"QL"}`))
+		req.Header.Set(idempotencyKeyHeader, "k1")
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	post("")
+	w := post("")
+	assert.Equal(t, calls, 1)
+	assert.Equal(t, w.Code, http.StatusCreated)
+	assert.Equal(t, w.Header().Get(idempotentReplayedHeader), "true")
+
+	// anonymous keys are apart from the keys of principals
+	w = post("1")
+	assert.Equal(t, calls, 2)
+	assert.Equal(t, w.Header().Get(idempotentReplayedHeader), "")
+}
+
+func TestIdempotency_InProgress(t *testing.T) {
+	store := idempotency.NewMemoryStore()
+	handler := Idempotency(store, IdempotencyConfig{TTL: time.Hour, LockTimeout: time.Minute}, testAuthenticator, log.NewLogger(zap.NewNop()))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// the same request arrives while the first one is processed
+			retry := createJSONRequest("POST", "/user", strings.NewReader(`{}`))
+			retry.Header = r.Header
+			rw := httptest.NewRecorder()
+			Idempotency(store, IdempotencyConfig{}, testAuthenticator, log.NewLogger(zap.NewNop()))(http.NotFoundHandler()).ServeHTTP(rw, retry)
+			assert.Equal(t, rw.Code, http.StatusConflict)
+			assert.Equal(t, rw.Header().Get("Retry-After"), "1")
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+	req := createJSONRequest("POST", "/user", strings.NewReader(`{}`))
+	req.Header.Set(idempotencyKeyHeader, `"k"`)
+	req.Header.Set("Authorization", "Bearer 1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, http.StatusCreated)
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"user-service/src/service/transport"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID keeps ids of clients that can't forge log lines.
+var validRequestID = regexp.MustCompile(`^[\w.:-]{1,64}$`)
+
+// RequestID gives every request an id, the X-Request-ID of the client when it has one, returned in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 16)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(transport.WithRequestID(r.Context(), id)))
+	})
+}
+
+// Recover answers a 500 ErrorResponse when a handler panics, unless the response is already started. The panics
+// of endpoints don't reach it, they are turned into errors by RecoverEndpoint.
+func Recover(recovery transport.Recovery) HTTPMiddleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := extractErrorFormat(extractLanguage(transport.WithRecovery(r.Context(), recovery), r), r)
+			rw := &recordingWriter{ResponseWriter: w}
+			defer func() {
+				value := recover()
+				if value == nil {
+					return
+				}
+				if value == http.ErrAbortHandler {
+					panic(value)
+				}
+				err := recovery.Recovered(ctx, value)
+				if !rw.written {
+					encodeErrorResponse(ctx, err, w)
+				}
+			}()
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	}
+}
+
+// recordingWriter tells if the response is started.
+type recordingWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
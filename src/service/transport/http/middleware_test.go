@@ -0,0 +1,58 @@
+package http
+
+import (
+	"context"
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/gorilla/mux"
+	"github.com/magiconair/properties/assert"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user-service/src/service"
+	"user-service/src/service/transport"
+	"user-service/src/service/util/log"
+)
+
+func TestRecover(t *testing.T) {
+	panics := generic.NewCounter("panics")
+	recovery := transport.Recovery{Log: log.NewLogger(zap.NewNop()), Panics: panics}
+
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/handler").HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("handler")
+	})
+	// the endpoint gets a request of the wrong type
+	r.Methods("GET").Path("/endpoint").Handler(newServer(func(_ context.Context, request interface{}) (interface{}, error) {
+		return request.(service.GetUserRequest), nil
+	},
+		func(context.Context, *http.Request) (interface{}, error) { return service.GetUsersRequest{}, nil },
+		encodeResponse,
+		serverOptions()...))
+	handler := RequestID(Recover(recovery)(r))
+
+	for i, path := range []string{"/handler", "/endpoint"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set(requestIDHeader, "req-1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, w.Code, http.StatusInternalServerError)
+		assert.Equal(t, w.Body.String(), `{"error":{"msg":"internal error","code":3,"key":"internal"}}`+"\n")
+		assert.Equal(t, w.Header().Get(requestIDHeader), "req-1")
+		assert.Equal(t, panics.Value(), float64(i+1))
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(transport.RequestIDFromContext(r.Context())))
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(requestIDHeader, "bad id\n")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, len(w.Body.String()), 32)
+	assert.Equal(t, w.Header().Get(requestIDHeader), w.Body.String())
+}
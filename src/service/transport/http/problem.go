@@ -0,0 +1,81 @@
+package http
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ErrorFormatLegacy is the {"error": ErrorResponse} body
+	ErrorFormatLegacy = "legacy"
+	// ErrorFormatProblem is the application/problem+json body of RFC 7807
+	ErrorFormatProblem = "problem"
+
+	problemMediaType = "application/problem+json"
+	jsonMediaType    = "application/json"
+	// mergePatchMediaType and jsonPatchMediaType are the patches of RFC 7396 and RFC 6902 accepted by PATCH /user
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+type errorFormatKey struct{}
+
+type errorFormat struct {
+	format   string
+	instance string
+}
+
+// extractErrorFormat picks the body of errors from Accept: application/problem+json asks for problems and
+// application/json alone for the legacy body, otherwise http_server.error_format applies.
+func extractErrorFormat(ctx context.Context, req *http.Request) context.Context {
+	format := negotiateErrorFormat(req.Header.Get("Accept"), viper.GetString("http_server.error_format"))
+	return context.WithValue(ctx, errorFormatKey{}, errorFormat{format: format, instance: req.URL.RequestURI()})
+}
+
+// errorFormatFromContext returns the format of errors and the URI of the request, legacy outside of a request.
+func errorFormatFromContext(ctx context.Context) (string, string) {
+	f, ok := ctx.Value(errorFormatKey{}).(errorFormat)
+	if !ok {
+		return ErrorFormatLegacy, ""
+	}
+	return f.format, f.instance
+}
+
+func negotiateErrorFormat(accept string, def string) string {
+	var problem, json bool
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		switch mediaType {
+		case problemMediaType:
+			problem = true
+		case jsonMediaType:
+			json = true
+		}
+	}
+	switch {
+	case problem:
+		return ErrorFormatProblem
+	case json:
+		return ErrorFormatLegacy
+	case def == ErrorFormatProblem:
+		return ErrorFormatProblem
+	default:
+		return ErrorFormatLegacy
+	}
+}
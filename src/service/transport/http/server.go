@@ -1,45 +1,470 @@
 package http
 
 import (
+	"context"
 	"fmt"
+	"github.com/go-kit/kit/endpoint"
 	http2 "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"user-service/src/service"
+	"user-service/src/service/auth"
 	"user-service/src/service/transport"
+	"user-service/src/service/util/i18n"
 	"user-service/src/service/util/log"
 )
 
-func RegisterService(s service.UserService, r *mux.Router) {
-	options := []http2.ServerOption{
+// apiKeyPrefix starts every API key, see APIKeyService.
+const apiKeyPrefix = "usk_"
+
+func serverOptions() []http2.ServerOption {
+	return []http2.ServerOption{
 		http2.ServerErrorEncoder(encodeErrorResponse),
+		http2.ServerBefore(extractCredentials, extractClient, extractLanguage, extractErrorFormat, extractAPIVersion, holdPrincipal),
 	}
+}
+
+// newServer serves an endpoint, its panics are answered with an internal error.
+func newServer(e endpoint.Endpoint, dec http2.DecodeRequestFunc, enc http2.EncodeResponseFunc, options ...http2.ServerOption) *http2.Server {
+	return http2.NewServer(transport.RecoverEndpoint(e), dec, enc, options...)
+}
+
+// holdPrincipal lets the encoders see the principal resolved by the endpoint.
+func holdPrincipal(ctx context.Context, _ *http.Request) context.Context {
+	return auth.WithPrincipalHolder(ctx)
+}
 
-	endpoints := transport.MakeEndpoints(s)
-	r.Methods("GET").Path("/user/{userID:[0-9]+}").Handler(http2.NewServer(endpoints.GetUser,
+func extractCredentials(ctx context.Context, req *http.Request) context.Context {
+	if key := req.Header.Get("X-API-Key"); len(key) > 0 {
+		ctx = auth.WithAPIKey(ctx, key)
+	}
+	if header := req.Header.Get("Authorization"); len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		ctx = auth.WithBearerToken(ctx, strings.TrimSpace(header[7:]))
+	}
+	return ctx
+}
+
+// extractClient only trusts X-Forwarded-For when http_server.trust_forwarded_for is set, the service must then
+// be reached through a proxy appending the address it sees.
+func extractClient(ctx context.Context, req *http.Request) context.Context {
+	ip := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if viper.GetBool("http_server.trust_forwarded_for") {
+		if forwarded := req.Header.Get("X-Forwarded-For"); len(forwarded) > 0 {
+			addresses := strings.Split(forwarded, ",")
+			ip = strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	}
+	return auth.WithUserAgent(auth.WithClientIP(ctx, ip), req.UserAgent())
+}
+
+// extractLanguage picks the language of the messages from Accept-Language, i18n.default_language when the client
+// accepts none of the catalog.
+func extractLanguage(ctx context.Context, req *http.Request) context.Context {
+	lang := i18n.Match(req.Header.Get("Accept-Language"), viper.GetString("i18n.default_language"))
+	return transport.WithLanguage(ctx, lang)
+}
+
+// RegisterService applies the field policy to the users read and written, the retries of user creations sent
+// with an Idempotency-Key go through idempotent.
+func RegisterService(s service.UserService, authn transport.Authenticator, policy auth.FieldPolicy, idempotent HTTPMiddleware, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakeEndpoints(s, authn.Middleware(), policy)
+	encodeUsers := encodeUserResponse(policy)
+	r.Methods("GET").Path("/user/{userID:[0-9]+}").Handler(newServer(endpoints.GetUser,
 		GetUserRequest,
-		encodeResponse,
+		encodeUsers,
 		options...))
 
-	r.Methods("GET").Path("/users").Handler(http2.NewServer(endpoints.GetUsers,
+	r.Methods("GET").Path("/users").Handler(newServer(endpoints.GetUsers,
 		GetUsersRequest,
-		encodeResponse,
+		encodeUsers,
 		options...))
 
-	r.Methods("POST").Path("/user").Handler(http2.NewServer(endpoints.PostUser,
+	r.Methods("POST").Path("/user").Handler(idempotent(newServer(endpoints.PostUser,
 		PostUserRequest,
+		encodeUsers,
+		options...)))
+
+	r.Methods("PATCH").Path("/user/{userID:[0-9]+}").Handler(newServer(endpoints.PatchUser,
+		PatchUserRequest,
+		encodeUsers,
+		options...))
+
+	r.Methods("PUT").Path("/user/{userID:[0-9]+}").Handler(newServer(endpoints.ReplaceUser,
+		ReplaceUserRequest,
+		encodeUsers,
+		options...))
+
+	r.Methods("PUT").Path("/user/{userID:[0-9]+}/role").Handler(newServer(endpoints.SetRole,
+		SetUserRoleRequest,
+		encodeUsers,
+		options...))
+}
+
+func RegisterEmailVerificationService(s service.EmailVerificationService, authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakeEmailVerificationEndpoints(s, authn.Middleware())
+	r.Methods("POST").Path("/user/{userID:[0-9]+}/email/verification").Handler(newServer(endpoints.SendEmailVerification,
+		SendEmailVerificationRequest,
 		encodeResponse,
 		options...))
 
-	r.Methods("PATCH").Path("/user/{userID:[0-9]+}").Handler(http2.NewServer(endpoints.PatchUser,
-		PatchUserRequest,
+	r.Methods("POST").Path("/email/verify").Handler(newServer(endpoints.VerifyEmail,
+		VerifyEmailRequest,
 		encodeResponse,
 		options...))
 }
 
+func RegisterLockoutService(s service.LockoutService, authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakeLockoutEndpoints(s, authn.Middleware())
+	r.Methods("GET").Path("/user/{userID:[0-9]+}/lockout").Handler(newServer(endpoints.GetLockout,
+		GetLockoutRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("DELETE").Path("/user/{userID:[0-9]+}/lockout").Handler(newServer(endpoints.UnlockUser,
+		UnlockUserRequest,
+		encodeResponse,
+		options...))
+}
+
+func RegisterSessionService(s service.SessionService, authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakeSessionEndpoints(s, authn.Middleware())
+	r.Methods("GET").Path("/user/{userID:[0-9]+}/sessions").Handler(newServer(endpoints.ListSessions,
+		ListSessionsRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("DELETE").Path("/user/{userID:[0-9]+}/sessions/{sessionID:[A-Za-z0-9_-]+}").Handler(newServer(endpoints.RevokeSession,
+		RevokeSessionRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("DELETE").Path("/user/{userID:[0-9]+}/sessions").Handler(newServer(endpoints.RevokeAllSessions,
+		RevokeAllSessionsRequest,
+		encodeResponse,
+		options...))
+}
+
+func RegisterLDAPService(s service.LDAPService, authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakeLDAPEndpoints(s, authn.Middleware())
+	r.Methods("POST").Path("/ldap/sync").Handler(newServer(endpoints.Sync,
+		LDAPSyncRequest,
+		encodeResponse,
+		options...))
+}
+
+func RegisterImpersonationService(s service.ImpersonationService, authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakeImpersonationEndpoints(s, authn.Middleware())
+	r.Methods("POST").Path("/user/{userID:[0-9]+}/impersonate").Handler(newServer(endpoints.Impersonate,
+		ImpersonateRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("DELETE").Path("/impersonation/{impersonationID:[0-9]+}").Handler(newServer(endpoints.EndImpersonation,
+		EndImpersonationRequest,
+		encodeResponse,
+		options...))
+}
+
+func RegisterPrivacyService(s service.PrivacyService, authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakePrivacyEndpoints(s, authn.Middleware())
+	r.Methods("GET").Path("/user/{userID:[0-9]+}/export").Handler(newServer(endpoints.ExportUser,
+		ExportUserRequest,
+		encodeExportResponse,
+		options...))
+
+	r.Methods("POST").Path("/user/{userID:[0-9]+}/erase").Handler(newServer(endpoints.EraseUser,
+		EraseUserRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("DELETE").Path("/user/{userID:[0-9]+}/erase").Handler(newServer(endpoints.CancelErasure,
+		CancelErasureRequest,
+		encodeResponse,
+		options...))
+}
+
+// RegisterMetrics serves the counters published with expvar to principals holding metrics:read.
+func RegisterMetrics(authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakeMetricsEndpoints(authn.Middleware())
+	r.Methods("GET").Path("/debug/vars").Handler(newServer(endpoints.Vars,
+		noRequest,
+		encodeVarsResponse,
+		options...))
+}
+
+func RegisterAPIKeyService(s service.APIKeyService, authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakeAPIKeyEndpoints(s, authn.Middleware())
+	r.Methods("POST").Path("/api-key").Handler(newServer(endpoints.CreateAPIKey,
+		CreateAPIKeyRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("GET").Path("/api-keys").Handler(newServer(endpoints.ListAPIKeys,
+		ListAPIKeysRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/api-key/{keyID:[0-9]+}/rotate").Handler(newServer(endpoints.RotateAPIKey,
+		RotateAPIKeyRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("DELETE").Path("/api-key/{keyID:[0-9]+}").Handler(newServer(endpoints.RevokeAPIKey,
+		RevokeAPIKeyRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("GET").Path("/api-key/{keyID:[0-9]+}/usage").Handler(newServer(endpoints.GetAPIKeyUsage,
+		GetAPIKeyUsageRequest,
+		encodeResponse,
+		options...))
+}
+
+func RegisterAuthService(s service.AuthService, authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakeAuthEndpoints(s, authn.Middleware())
+	r.Methods("POST").Path("/login").Handler(newServer(endpoints.Login,
+		LoginRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/login/mfa").Handler(newServer(endpoints.LoginMFA,
+		LoginMFARequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/token/refresh").Handler(newServer(endpoints.RefreshToken,
+		RefreshTokenRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("PUT").Path("/user/{userID:[0-9]+}/password").Handler(newServer(endpoints.SetPassword,
+		SetPasswordRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/password/forgot").Handler(newServer(endpoints.ForgotPassword,
+		ForgotPasswordRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/password/reset").Handler(newServer(endpoints.ResetPassword,
+		ResetPasswordRequest,
+		encodeResponse,
+		options...))
+}
+
+func RegisterMFAService(s service.MFAService, authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakeMFAEndpoints(s, authn.Middleware())
+	r.Methods("GET").Path("/user/{userID:[0-9]+}/mfa").Handler(newServer(endpoints.GetMFAStatus,
+		GetMFAStatusRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/user/{userID:[0-9]+}/mfa/totp").Handler(newServer(endpoints.EnrollTOTP,
+		EnrollTOTPRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/user/{userID:[0-9]+}/mfa/totp/confirm").Handler(newServer(endpoints.ConfirmTOTP,
+		ConfirmTOTPRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("DELETE").Path("/user/{userID:[0-9]+}/mfa/totp").Handler(newServer(endpoints.DisableTOTP,
+		DisableTOTPRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/user/{userID:[0-9]+}/mfa/recovery-codes").Handler(newServer(endpoints.RegenerateRecoveryCodes,
+		RegenerateRecoveryCodesRequest,
+		encodeResponse,
+		options...))
+}
+
+func RegisterPasskeyService(s service.PasskeyService, authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+
+	endpoints := transport.MakePasskeyEndpoints(s, authn.Middleware())
+	r.Methods("POST").Path("/user/{userID:[0-9]+}/passkey/register/begin").Handler(newServer(endpoints.BeginRegistration,
+		BeginPasskeyRegistrationRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/user/{userID:[0-9]+}/passkey/register/finish").Handler(newServer(endpoints.FinishRegistration,
+		FinishPasskeyRegistrationRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("GET").Path("/user/{userID:[0-9]+}/passkeys").Handler(newServer(endpoints.ListPasskeys,
+		ListPasskeysRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("DELETE").Path("/user/{userID:[0-9]+}/passkey/{passkeyID:[0-9]+}").Handler(newServer(endpoints.RevokePasskey,
+		RevokePasskeyRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/login/passkey/begin").Handler(newServer(endpoints.BeginLogin,
+		BeginPasskeyLoginRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/login/passkey/finish").Handler(newServer(endpoints.FinishLogin,
+		FinishPasskeyLoginRequest,
+		encodeResponse,
+		options...))
+}
+
+func RegisterOIDCService(s service.OIDCService, authn transport.Authenticator, r *mux.Router) {
+	options := serverOptions()
+	oauthOptions := append(serverOptions(), http2.ServerErrorEncoder(encodeOAuthErrorResponse))
+
+	endpoints := transport.MakeOIDCEndpoints(s, authn.Middleware())
+	r.Methods("GET").Path("/.well-known/openid-configuration").Handler(newServer(endpoints.Discovery,
+		DiscoveryRequest,
+		encodeOAuthResponse,
+		oauthOptions...))
+
+	r.Methods("GET").Path("/.well-known/jwks.json").Handler(newServer(endpoints.JWKS,
+		JWKSRequest,
+		encodeOAuthResponse,
+		oauthOptions...))
+
+	r.Methods("GET", "POST").Path("/authorize").Handler(newServer(endpoints.Authorize,
+		AuthorizeRequest,
+		encodeRedirectResponse,
+		oauthOptions...))
+
+	r.Methods("POST").Path("/token").Handler(newServer(endpoints.Token,
+		OIDCTokenRequest,
+		encodeOAuthResponse,
+		oauthOptions...))
+
+	r.Methods("GET", "POST").Path("/userinfo").Handler(newServer(endpoints.UserInfo,
+		UserInfoRequest,
+		encodeOAuthResponse,
+		oauthOptions...))
+
+	r.Methods("POST").Path("/oidc/client").Handler(newServer(endpoints.RegisterClient,
+		RegisterOIDCClientRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("GET").Path("/oidc/clients").Handler(newServer(endpoints.ListClients,
+		ListOIDCClientsRequest,
+		encodeResponse,
+		options...))
+
+	r.Methods("POST").Path("/oidc/keys/rotate").Handler(newServer(endpoints.RotateSigningKey,
+		RotateSigningKeyRequest,
+		encodeResponse,
+		options...))
+}
+
+// extractSCIMCredentials lets identity providers send API keys as bearer tokens, most of them can't set X-API-Key.
+func extractSCIMCredentials(ctx context.Context, req *http.Request) context.Context {
+	if token := auth.BearerTokenFromContext(ctx); len(auth.APIKeyFromContext(ctx)) == 0 && strings.HasPrefix(token, apiKeyPrefix) {
+		ctx = auth.WithAPIKey(ctx, token)
+	}
+	return ctx
+}
+
+func RegisterSCIMService(s service.SCIMService, authn transport.Authenticator, r *mux.Router) {
+	options := []http2.ServerOption{
+		http2.ServerErrorEncoder(encodeSCIMErrorResponse),
+		http2.ServerBefore(extractCredentials, extractClient, extractSCIMCredentials),
+	}
+
+	endpoints := transport.MakeSCIMEndpoints(s, authn.Middleware())
+	scim := r.PathPrefix("/scim/v2").Subrouter()
+	scim.Methods("GET").Path("/ServiceProviderConfig").Handler(newServer(endpoints.ServiceProviderConfig,
+		SCIMServiceProviderConfigRequest,
+		encodeSCIMResponse,
+		options...))
+
+	scim.Methods("GET").Path("/Schemas").Handler(newServer(endpoints.Schemas,
+		SCIMSchemasRequest,
+		encodeSCIMResponse,
+		options...))
+
+	scim.Methods("GET").Path("/Schemas/{id}").Handler(newServer(endpoints.Schemas,
+		SCIMSchemasRequest,
+		encodeSCIMResponse,
+		options...))
+
+	scim.Methods("GET").Path("/ResourceTypes").Handler(newServer(endpoints.ResourceTypes,
+		SCIMResourceTypesRequest,
+		encodeSCIMResponse,
+		options...))
+
+	scim.Methods("GET").Path("/ResourceTypes/{name}").Handler(newServer(endpoints.ResourceTypes,
+		SCIMResourceTypesRequest,
+		encodeSCIMResponse,
+		options...))
+
+	scim.Methods("POST").Path("/Users").Handler(newServer(endpoints.CreateUser,
+		SCIMCreateUserRequest,
+		encodeSCIMCreatedResponse,
+		options...))
+
+	scim.Methods("GET").Path("/Users").Handler(newServer(endpoints.ListUsers,
+		SCIMListUsersRequest,
+		encodeSCIMResponse,
+		options...))
+
+	scim.Methods("GET").Path("/Users/{id}").Handler(newServer(endpoints.GetUser,
+		SCIMGetUserRequest,
+		encodeSCIMResponse,
+		options...))
+
+	scim.Methods("PUT").Path("/Users/{id}").Handler(newServer(endpoints.ReplaceUser,
+		SCIMReplaceUserRequest,
+		encodeSCIMResponse,
+		options...))
+
+	scim.Methods("PATCH").Path("/Users/{id}").Handler(newServer(endpoints.PatchUser,
+		SCIMPatchUserRequest,
+		encodeSCIMResponse,
+		options...))
+
+	scim.Methods("DELETE").Path("/Users/{id}").Handler(newServer(endpoints.DeleteUser,
+		SCIMDeleteUserRequest,
+		encodeNoContentResponse,
+		options...))
+}
+
 type Server struct {
 	handler  http.Handler
 	logger   *log.Logger
@@ -59,7 +484,7 @@ func NewServer(handler http.Handler, logger *log.Logger, httpAddr string) *Serve
 func (s *Server) Start() error {
 	errs := make(chan error)
 	go func() {
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 		errs <- fmt.Errorf("%s", <-c)
 	}()
@@ -0,0 +1,39 @@
+package http
+
+import (
+	"context"
+	"github.com/spf13/viper"
+	"net/http"
+	"strings"
+)
+
+const apiVersionHeader = "API-Version"
+
+type apiVersionKey struct{}
+
+// extractAPIVersion keeps the version of the API the client is written for, api.default_version when it doesn't send
+// API-Version. Versions change how requests are checked, not the responses.
+func extractAPIVersion(ctx context.Context, req *http.Request) context.Context {
+	version := strings.TrimSpace(req.Header.Get(apiVersionHeader))
+	if len(version) == 0 {
+		version = viper.GetString("api.default_version")
+	}
+	return context.WithValue(ctx, apiVersionKey{}, version)
+}
+
+func apiVersionFromContext(ctx context.Context) string {
+	version, _ := ctx.Value(apiVersionKey{}).(string)
+	return version
+}
+
+// strictQuery tells if the query params of the request are rejected when unknown or invalid, instead of being
+// ignored or defaulted, see api.strict_query_versions.
+func strictQuery(ctx context.Context) bool {
+	version := apiVersionFromContext(ctx)
+	for _, v := range viper.GetStringSlice("api.strict_query_versions") {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+type ImpersonationEndpoints struct {
+	Impersonate      endpoint.Endpoint
+	EndImpersonation endpoint.Endpoint
+}
+
+func MakeImpersonationEndpoints(s service.ImpersonationService, authn endpoint.Middleware) ImpersonationEndpoints {
+	impersonate := endpoint.Chain(authn, requireAuthenticated, denyImpersonation, RequirePermission(auth.PermissionImpersonate))
+	return ImpersonationEndpoints{
+		Impersonate:      impersonate(makeImpersonateEndpoint(s)),
+		EndImpersonation: impersonate(makeEndImpersonationEndpoint(s)),
+	}
+}
+
+func makeImpersonateEndpoint(s service.ImpersonationService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.Impersonate(ctx, request.(service.ImpersonateRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeEndImpersonationEndpoint(s service.ImpersonationService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.EndImpersonation(ctx, request.(service.EndImpersonationRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
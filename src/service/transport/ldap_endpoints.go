@@ -0,0 +1,27 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+type LDAPEndpoints struct {
+	Sync endpoint.Endpoint
+}
+
+func MakeLDAPEndpoints(s service.LDAPService, authn endpoint.Middleware) LDAPEndpoints {
+	return LDAPEndpoints{
+		Sync: secureAuthenticated(authn, auth.PermissionLDAPManage, makeLDAPSyncEndpoint(s)),
+	}
+}
+
+func makeLDAPSyncEndpoint(s service.LDAPService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.Sync(ctx, request.(service.LDAPSyncRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
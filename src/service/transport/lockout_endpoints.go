@@ -0,0 +1,38 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+type LockoutEndpoints struct {
+	GetLockout endpoint.Endpoint
+	UnlockUser endpoint.Endpoint
+}
+
+func MakeLockoutEndpoints(s service.LockoutService, authn endpoint.Middleware) LockoutEndpoints {
+	return LockoutEndpoints{
+		GetLockout: secureAuthenticated(authn, auth.PermissionLockoutManage, makeGetLockoutEndpoint(s)),
+		UnlockUser: secureAuthenticated(authn, auth.PermissionLockoutManage, makeUnlockUserEndpoint(s)),
+	}
+}
+
+func makeGetLockoutEndpoint(s service.LockoutService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.GetLockout(ctx, request.(service.GetLockoutRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeUnlockUserEndpoint(s service.LockoutService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.UnlockUser(ctx, request.(service.UnlockUserRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
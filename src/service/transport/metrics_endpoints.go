@@ -0,0 +1,20 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service/auth"
+)
+
+type MetricsEndpoints struct {
+	Vars endpoint.Endpoint
+}
+
+// MakeMetricsEndpoints guards the counters, the encoder writes them once the caller holds metrics:read.
+func MakeMetricsEndpoints(authn endpoint.Middleware) MetricsEndpoints {
+	return MetricsEndpoints{
+		Vars: secureAuthenticated(authn, auth.PermissionMetricsRead, func(context.Context, interface{}) (interface{}, error) {
+			return nil, nil
+		}),
+	}
+}
@@ -0,0 +1,75 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+type MFAEndpoints struct {
+	EnrollTOTP              endpoint.Endpoint
+	ConfirmTOTP             endpoint.Endpoint
+	DisableTOTP             endpoint.Endpoint
+	RegenerateRecoveryCodes endpoint.Endpoint
+	GetMFAStatus            endpoint.Endpoint
+}
+
+func MakeMFAEndpoints(s service.MFAService, authn endpoint.Middleware) MFAEndpoints {
+	// users who must enroll can reach enrollment and status, nothing else. The factors of others are managed with
+	// credentials:manage, users:write would let support take over accounts.
+	enrollment := endpoint.Chain(authn, requireSelfOrPermission(auth.PermissionCredentialsManage, true))
+	write := endpoint.Chain(authn, denyImpersonation, RequireSelfOrPermission(auth.PermissionCredentialsManage))
+	return MFAEndpoints{
+		EnrollTOTP:              endpoint.Chain(enrollment, denyImpersonation)(makeEnrollTOTPEndpoint(s)),
+		ConfirmTOTP:             endpoint.Chain(enrollment, denyImpersonation)(makeConfirmTOTPEndpoint(s)),
+		DisableTOTP:             write(makeDisableTOTPEndpoint(s)),
+		RegenerateRecoveryCodes: write(makeRegenerateRecoveryCodesEndpoint(s)),
+		GetMFAStatus:            endpoint.Chain(authn, requireSelfOrPermission(auth.PermissionUsersRead, true))(makeGetMFAStatusEndpoint(s)),
+	}
+}
+
+func makeEnrollTOTPEndpoint(s service.MFAService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.EnrollTOTP(ctx, request.(service.EnrollTOTPRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeConfirmTOTPEndpoint(s service.MFAService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.ConfirmTOTP(ctx, request.(service.ConfirmTOTPRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeDisableTOTPEndpoint(s service.MFAService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.DisableTOTP(ctx, request.(service.DisableTOTPRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeRegenerateRecoveryCodesEndpoint(s service.MFAService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.RegenerateRecoveryCodes(ctx, request.(service.RegenerateRecoveryCodesRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeGetMFAStatusEndpoint(s service.MFAService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.GetMFAStatus(ctx, request.(service.GetMFAStatusRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
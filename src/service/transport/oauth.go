@@ -0,0 +1,27 @@
+package transport
+
+// OAuthError is returned by the OIDC endpoints, it is encoded as described in RFC 6749 section 5.2.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+const (
+	OAuthInvalidRequest          = "invalid_request"
+	OAuthInvalidClient           = "invalid_client"
+	OAuthInvalidGrant            = "invalid_grant"
+	OAuthUnauthorizedClient      = "unauthorized_client"
+	OAuthUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthUnsupportedResponseType = "unsupported_response_type"
+	OAuthInvalidScope            = "invalid_scope"
+	OAuthInvalidToken            = "invalid_token"
+	OAuthLoginRequired           = "login_required"
+	OAuthServerError             = "server_error"
+)
+
+func (e OAuthError) Error() string {
+	if len(e.Description) == 0 {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+// OIDCEndpoints return the protocol responses as is (not wrapped in APIResponse), relying parties expect
+// the documents and tokens described by the OIDC specifications.
+type OIDCEndpoints struct {
+	Discovery        endpoint.Endpoint
+	JWKS             endpoint.Endpoint
+	Authorize        endpoint.Endpoint
+	Token            endpoint.Endpoint
+	UserInfo         endpoint.Endpoint
+	RegisterClient   endpoint.Endpoint
+	ListClients      endpoint.Endpoint
+	RotateSigningKey endpoint.Endpoint
+}
+
+func MakeOIDCEndpoints(s service.OIDCService, authn endpoint.Middleware) OIDCEndpoints {
+	return OIDCEndpoints{
+		Discovery: func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.Discovery(ctx, request.(service.DiscoveryRequest))
+		},
+		JWKS: func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.JWKS(ctx, request.(service.JWKSRequest))
+		},
+		Authorize: endpoint.Chain(authn, denyImpersonation)(func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.Authorize(ctx, request.(service.AuthorizeRequest))
+		}),
+		Token: func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.Token(ctx, request.(service.OIDCTokenRequest))
+		},
+		UserInfo: func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.UserInfo(ctx, request.(service.UserInfoRequest))
+		},
+		RegisterClient:   secureAuthenticated(authn, auth.PermissionOIDCManage, makeRegisterOIDCClientEndpoint(s)),
+		ListClients:      secureAuthenticated(authn, auth.PermissionOIDCManage, makeListOIDCClientsEndpoint(s)),
+		RotateSigningKey: secureAuthenticated(authn, auth.PermissionOIDCManage, makeRotateSigningKeyEndpoint(s)),
+	}
+}
+
+func makeRegisterOIDCClientEndpoint(s service.OIDCService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.RegisterClient(ctx, request.(service.RegisterOIDCClientRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeListOIDCClientsEndpoint(s service.OIDCService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.ListClients(ctx, request.(service.ListOIDCClientsRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeRotateSigningKeyEndpoint(s service.OIDCService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.RotateSigningKey(ctx, request.(service.RotateSigningKeyRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+type PasskeyEndpoints struct {
+	BeginRegistration  endpoint.Endpoint
+	FinishRegistration endpoint.Endpoint
+	BeginLogin         endpoint.Endpoint
+	FinishLogin        endpoint.Endpoint
+	ListPasskeys       endpoint.Endpoint
+	RevokePasskey      endpoint.Endpoint
+}
+
+func MakePasskeyEndpoints(s service.PasskeyService, authn endpoint.Middleware) PasskeyEndpoints {
+	selfOrManage := endpoint.Chain(authn, RequireSelfOrPermission(auth.PermissionCredentialsManage))
+	// a passkey registered on another account logs in as its user
+	manage := endpoint.Chain(authn, denyImpersonation, RequireSelfOrPermission(auth.PermissionCredentialsManage))
+	return PasskeyEndpoints{
+		BeginRegistration:  manage(makeBeginPasskeyRegistrationEndpoint(s)),
+		FinishRegistration: manage(makeFinishPasskeyRegistrationEndpoint(s)),
+		BeginLogin:         makeBeginPasskeyLoginEndpoint(s),
+		FinishLogin:        makeFinishPasskeyLoginEndpoint(s),
+		ListPasskeys:       selfOrManage(makeListPasskeysEndpoint(s)),
+		RevokePasskey:      manage(makeRevokePasskeyEndpoint(s)),
+	}
+}
+
+func makeBeginPasskeyRegistrationEndpoint(s service.PasskeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.BeginPasskeyRegistration(ctx, request.(service.BeginPasskeyRegistrationRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeFinishPasskeyRegistrationEndpoint(s service.PasskeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.FinishPasskeyRegistration(ctx, request.(service.FinishPasskeyRegistrationRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeBeginPasskeyLoginEndpoint(s service.PasskeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.BeginPasskeyLogin(ctx, request.(service.BeginPasskeyLoginRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeFinishPasskeyLoginEndpoint(s service.PasskeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.FinishPasskeyLogin(ctx, request.(service.FinishPasskeyLoginRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeListPasskeysEndpoint(s service.PasskeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.ListPasskeys(ctx, request.(service.ListPasskeysRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeRevokePasskeyEndpoint(s service.PasskeyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.RevokePasskey(ctx, request.(service.RevokePasskeyRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+type PrivacyEndpoints struct {
+	ExportUser    endpoint.Endpoint
+	EraseUser     endpoint.Endpoint
+	CancelErasure endpoint.Endpoint
+}
+
+func MakePrivacyEndpoints(s service.PrivacyService, authn endpoint.Middleware) PrivacyEndpoints {
+	selfOrManage := endpoint.Chain(authn, denyImpersonation, RequireSelfOrPermission(auth.PermissionPrivacyManage))
+	return PrivacyEndpoints{
+		// the export is the archive itself, it isn't wrapped in APIResponse
+		ExportUser: selfOrManage(func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.ExportUser(ctx, request.(service.ExportUserRequest))
+		}),
+		EraseUser:     selfOrManage(makeEraseUserEndpoint(s)),
+		CancelErasure: selfOrManage(makeCancelErasureEndpoint(s)),
+	}
+}
+
+func makeEraseUserEndpoint(s service.PrivacyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.EraseUser(ctx, request.(service.EraseUserRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeCancelErasureEndpoint(s service.PrivacyService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.CancelErasure(ctx, request.(service.CancelErasureRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"go.uber.org/zap"
+	"runtime/debug"
+	"user-service/src/service/util/log"
+)
+
+type requestIDKey struct{}
+
+type recoveryKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the id of the request, empty outside of a request.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Recovery handles the panics of requests: they are logged with their stack and counted, the client gets an
+// internal error.
+type Recovery struct {
+	Log    *log.Logger
+	Panics metrics.Counter
+}
+
+// WithRecovery lets RecoverEndpoint report the panics of the endpoints of the request.
+func WithRecovery(ctx context.Context, recovery Recovery) context.Context {
+	return context.WithValue(ctx, recoveryKey{}, recovery)
+}
+
+// Recovered reports the panic of a request, it must be called by the deferred function recovering it so that the
+// stack is the one of the panic.
+func (r Recovery) Recovered(ctx context.Context, value interface{}) Error {
+	r.Panics.Add(1)
+	r.Log.Error(fmt.Sprintf("panic: %v", value),
+		zap.String("request_id", RequestIDFromContext(ctx)), zap.String("stack", string(debug.Stack())))
+	return InternalError(fmt.Errorf("panic: %v", value))
+}
+
+// RecoverEndpoint returns the panics of the endpoint as internal errors, they are reported to the Recovery of the
+// context.
+func RecoverEndpoint(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		defer func() {
+			if value := recover(); value != nil {
+				response = nil
+				if recovery, ok := ctx.Value(recoveryKey{}).(Recovery); ok {
+					err = recovery.Recovered(ctx, value)
+				} else {
+					err = InternalError(fmt.Errorf("panic: %v", value))
+				}
+			}
+		}()
+		return next(ctx, request)
+	}
+}
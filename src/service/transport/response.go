@@ -1,13 +1,50 @@
 package transport
 
+import (
+	"context"
+	"errors"
+	"user-service/src/service/util/i18n"
+)
+
 type APIResponse struct {
-	Data  interface{}    `json:"data""`
+	Data  interface{}    `json:"data"`
 	Error *ErrorResponse `json:"error,omitempty"`
 }
 
 type ErrorResponse struct {
 	Msg  string       `json:"msg"`
 	Code ResponseCode `json:"code"`
+	// Key identifies the error, unlike Msg it doesn't change with the wording
+	Key    string       `json:"key"`
+	Fields []FieldError `json:"fields,omitempty"`
+	// ExistingID is the resource holding the value of a conflict, when the caller may read it
+	ExistingID int64 `json:"existing_id,omitempty"`
+}
+
+// ProblemResponse is the body of errors in the format of RFC 7807, application/problem+json. Code, Key, Fields and
+// ExistingID are the members of ErrorResponse, as extensions.
+type ProblemResponse struct {
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	Status     int          `json:"status"`
+	Detail     string       `json:"detail,omitempty"`
+	Instance   string       `json:"instance,omitempty"`
+	Code       ResponseCode `json:"code"`
+	Key        string       `json:"key"`
+	Fields     []FieldError `json:"fields,omitempty"`
+	ExistingID int64        `json:"existing_id,omitempty"`
+}
+
+// ProblemTypePrefix starts the type of problems, it is followed by the key of the error.
+const ProblemTypePrefix = "urn:user-service:error:"
+
+// FieldError tells which field of the request is rejected and why.
+type FieldError struct {
+	Field string `json:"field"`
+	Msg   string `json:"msg"`
+	// Key is the message of the catalog, see Error.Localize
+	Key    string                 `json:"key,omitempty"`
+	Params map[string]interface{} `json:"-"`
 }
 
 type ResponseCode int
@@ -20,14 +57,150 @@ const (
 	ErrorCodeEmpty            ResponseCode = 5
 	ErrorCodeNotImplemented   ResponseCode = 6
 	ErrorCodeUnauthorized     ResponseCode = 7
+	ErrorCodeTooManyRequests  ResponseCode = 8
+	// ErrorCodePayloadTooLarge rejects bodies over http_server.max_body_size
+	ErrorCodePayloadTooLarge      ResponseCode = 9
+	ErrorCodeUnsupportedMediaType ResponseCode = 10
+	// ErrorCodeConflict rejects values which must be unique and are already taken
+	ErrorCodeConflict ResponseCode = 11
+	// ErrorCodeUnprocessableEntity rejects requests which are well-formed but can't be processed as they are
+	ErrorCodeUnprocessableEntity ResponseCode = 12
 )
 
+// Error is sent to clients: Msg is the public message and Key identifies the error. The embedded error is the
+// internal cause, it is logged but never sent.
 type Error struct {
 	error
 	Msg  string
 	Code ResponseCode
+	Key  string
+	// Params are interpolated in the message of Key when it is localized
+	Params     map[string]interface{}
+	Fields     []FieldError
+	ExistingID int64
 }
 
+// Error is the message to log, the public message followed by the cause.
 func (e Error) Error() string {
+	if e.error != nil {
+		return e.Msg + ": " + e.error.Error()
+	}
 	return e.Msg
 }
+
+func (e Error) Unwrap() error {
+	return e.error
+}
+
+// Wrap returns the error with its internal cause.
+func (e Error) Wrap(cause error) Error {
+	e.error = cause
+	return e
+}
+
+const internalMsg = "internal error"
+
+// codeKeys are the keys of errors without their own.
+var codeKeys = map[ResponseCode]string{
+	ErrorCodeInvalidParameter:     "invalid_parameter",
+	ErrorCodePermissionDenied:     "permission_denied",
+	ErrorCodeInternal:             "internal",
+	ErrorCodeNotFound:             "not_found",
+	ErrorCodeEmpty:                "empty",
+	ErrorCodeNotImplemented:       "not_implemented",
+	ErrorCodeUnauthorized:         "unauthorized",
+	ErrorCodeTooManyRequests:      "too_many_requests",
+	ErrorCodePayloadTooLarge:      "payload_too_large",
+	ErrorCodeUnsupportedMediaType: "unsupported_media_type",
+	ErrorCodeConflict:             "conflict",
+	ErrorCodeUnprocessableEntity:  "unprocessable_entity",
+}
+
+// InternalError hides an unexpected error behind a generic message.
+func InternalError(cause error) Error {
+	return Error{Msg: internalMsg, Code: ErrorCodeInternal, Key: codeKeys[ErrorCodeInternal]}.Wrap(cause)
+}
+
+// PublicError returns what clients are told of an error: errors which aren't an Error are internal errors, so are
+// internal errors without key as their message may hold the cause.
+func PublicError(err error) Error {
+	var e Error
+	switch x := err.(type) {
+	case Error:
+		e = x
+	case *Error:
+		if x == nil {
+			return InternalError(err)
+		}
+		e = *x
+	default:
+		if !errors.As(err, &e) {
+			return InternalError(err)
+		}
+	}
+	if len(e.Key) == 0 {
+		if e.Code == ErrorCodeInternal {
+			return InternalError(e)
+		}
+		e.Key = codeKeys[e.Code]
+	}
+	return e
+}
+
+// Localize translates the messages of the error and of its fields to the language, the messages whose key isn't in
+// the catalog are kept. The message of a code is only used when the error has no message of its own as it is more
+// generic.
+func (e Error) Localize(lang string) Error {
+	if len(lang) == 0 {
+		return e
+	}
+	if e.Key != codeKeys[e.Code] || len(e.Msg) == 0 {
+		if msg, ok := i18n.Translate(lang, e.Key, e.Params); ok {
+			e.Msg = msg
+		}
+	}
+	if len(e.Fields) > 0 {
+		fields := make([]FieldError, len(e.Fields))
+		for i, field := range e.Fields {
+			if msg, ok := i18n.Translate(lang, field.Key, field.Params); ok {
+				field.Msg = msg
+			}
+			fields[i] = field
+		}
+		e.Fields = fields
+	}
+	return e
+}
+
+type languageKey struct{}
+
+func WithLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey{}, lang)
+}
+
+// LanguageFromContext returns the language of the messages sent to the client, empty when they aren't localized.
+func LanguageFromContext(ctx context.Context) string {
+	lang, _ := ctx.Value(languageKey{}).(string)
+	return lang
+}
+
+// Response is the body of the error sent to clients.
+func (e Error) Response() ErrorResponse {
+	return ErrorResponse{Msg: e.Msg, Code: e.Code, Key: e.Key, Fields: e.Fields, ExistingID: e.ExistingID}
+}
+
+// Problem is the body of the error sent to clients asking for application/problem+json, status is the HTTP status
+// of the response and instance the URI of the request.
+func (e Error) Problem(status int, title string, instance string) ProblemResponse {
+	return ProblemResponse{
+		Type:       ProblemTypePrefix + e.Key,
+		Title:      title,
+		Status:     status,
+		Detail:     e.Msg,
+		Instance:   instance,
+		Code:       e.Code,
+		Key:        e.Key,
+		Fields:     e.Fields,
+		ExistingID: e.ExistingID,
+	}
+}
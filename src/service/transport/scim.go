@@ -0,0 +1,23 @@
+package transport
+
+// SCIMError is returned by the SCIM endpoints, it is encoded as described in RFC 7644 section 3.12.
+type SCIMError struct {
+	Type   string
+	Detail string
+}
+
+const (
+	SCIMInvalidFilter = "invalidFilter"
+	SCIMUniqueness    = "uniqueness"
+	SCIMInvalidSyntax = "invalidSyntax"
+	SCIMInvalidPath   = "invalidPath"
+	SCIMInvalidValue  = "invalidValue"
+	SCIMMutability    = "mutability"
+)
+
+func (e SCIMError) Error() string {
+	if len(e.Detail) == 0 {
+		return e.Type
+	}
+	return e.Type + ": " + e.Detail
+}
@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+// SCIMEndpoints return the SCIM resources as is (not wrapped in APIResponse), like OIDCEndpoints do.
+// The discovery endpoints are public as RFC 7644 section 4 allows, the resources always require authentication.
+type SCIMEndpoints struct {
+	ServiceProviderConfig endpoint.Endpoint
+	Schemas               endpoint.Endpoint
+	ResourceTypes         endpoint.Endpoint
+	CreateUser            endpoint.Endpoint
+	GetUser               endpoint.Endpoint
+	ListUsers             endpoint.Endpoint
+	ReplaceUser           endpoint.Endpoint
+	PatchUser             endpoint.Endpoint
+	DeleteUser            endpoint.Endpoint
+}
+
+func MakeSCIMEndpoints(s service.SCIMService, authn endpoint.Middleware) SCIMEndpoints {
+	return SCIMEndpoints{
+		ServiceProviderConfig: func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.ServiceProviderConfig(ctx, request.(service.SCIMServiceProviderConfigRequest))
+		},
+		Schemas: func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.Schemas(ctx, request.(service.SCIMSchemasRequest))
+		},
+		ResourceTypes: func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.ResourceTypes(ctx, request.(service.SCIMResourceTypesRequest))
+		},
+		CreateUser: secureAuthenticated(authn, auth.PermissionUsersWrite, func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.CreateUser(ctx, request.(service.SCIMCreateUserRequest))
+		}),
+		GetUser: secureAuthenticated(authn, auth.PermissionUsersRead, func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.GetUser(ctx, request.(service.SCIMGetUserRequest))
+		}),
+		ListUsers: secureAuthenticated(authn, auth.PermissionUsersRead, func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.ListUsers(ctx, request.(service.SCIMListUsersRequest))
+		}),
+		ReplaceUser: secureAuthenticated(authn, auth.PermissionUsersWrite, func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.ReplaceUser(ctx, request.(service.SCIMReplaceUserRequest))
+		}),
+		PatchUser: secureAuthenticated(authn, auth.PermissionUsersWrite, func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.PatchUser(ctx, request.(service.SCIMPatchRequest))
+		}),
+		DeleteUser: secureAuthenticated(authn, auth.PermissionUsersWrite, func(ctx context.Context, request interface{}) (interface{}, error) {
+			return s.DeleteUser(ctx, request.(service.SCIMDeleteUserRequest))
+		}),
+	}
+}
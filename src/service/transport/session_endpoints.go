@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"user-service/src/service"
+	"user-service/src/service/auth"
+)
+
+type SessionEndpoints struct {
+	ListSessions      endpoint.Endpoint
+	RevokeSession     endpoint.Endpoint
+	RevokeAllSessions endpoint.Endpoint
+}
+
+func MakeSessionEndpoints(s service.SessionService, authn endpoint.Middleware) SessionEndpoints {
+	selfOrManage := endpoint.Chain(authn, RequireSelfOrPermission(auth.PermissionSessionsManage))
+	return SessionEndpoints{
+		ListSessions:      selfOrManage(makeListSessionsEndpoint(s)),
+		RevokeSession:     selfOrManage(makeRevokeSessionEndpoint(s)),
+		RevokeAllSessions: selfOrManage(makeRevokeAllSessionsEndpoint(s)),
+	}
+}
+
+func makeListSessionsEndpoint(s service.SessionService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.ListSessions(ctx, request.(service.ListSessionsRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeRevokeSessionEndpoint(s service.SessionService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.RevokeSession(ctx, request.(service.RevokeSessionRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
+
+func makeRevokeAllSessionsEndpoint(s service.SessionService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		result, err := s.RevokeAllSessions(ctx, request.(service.RevokeAllSessionsRequest))
+		return APIResponse{
+			Data: result,
+		}, err
+	}
+}
@@ -0,0 +1,59 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+type AESGCM struct {
+	aead cipher.AEAD
+}
+
+func NewAESGCM(key []byte) (*AESGCM, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must have 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCM{aead: aead}, nil
+}
+
+// NewAESGCMFromBase64 is used for keys read from config.
+func NewAESGCMFromBase64(key string) (*AESGCM, error) {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid base64 encryption key")
+	}
+	return NewAESGCM(b)
+}
+
+// Seal returns base64(nonce | ciphertext), additionalData binds the ciphertext to its owner (e.g. user id).
+func (c *AESGCM) Seal(plaintext []byte, additionalData []byte) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, plaintext, additionalData)), nil
+}
+
+func (c *AESGCM) Open(sealed string, additionalData []byte) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid sealed data")
+	}
+	if len(b) < c.aead.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	nonce, ciphertext := b[:c.aead.NonceSize()], b[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, ciphertext, additionalData)
+}
@@ -0,0 +1,94 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"strconv"
+)
+
+// Keyring holds the versioned key encryption keys and the key of the blind indexes. New data is sealed with the
+// current key, the older keys are kept to open what they sealed until it is re-encrypted.
+type Keyring struct {
+	current  int
+	keys     map[int]*AESGCM
+	indexKey []byte
+}
+
+// keyringFile is the format of a keyring file, keys are base64 of 32 random bytes:
+//
+//	{"current": 2, "keys": {"1": "...", "2": "..."}, "index_key": "..."}
+type keyringFile struct {
+	Current  int               `json:"current"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"index_key"`
+}
+
+func NewKeyring(current int, keys map[int][]byte, indexKey []byte) (*Keyring, error) {
+	if len(indexKey) != 32 {
+		return nil, fmt.Errorf("index key must have 32 bytes, got %d", len(indexKey))
+	}
+	k := &Keyring{current: current, keys: map[int]*AESGCM{}, indexKey: indexKey}
+	for version, key := range keys {
+		if version <= 0 {
+			return nil, fmt.Errorf("invalid key version %d", version)
+		}
+		box, err := NewAESGCM(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "key %d", version)
+		}
+		k.keys[version] = box
+	}
+	if _, ok := k.keys[current]; !ok {
+		return nil, fmt.Errorf("current key %d isn't in the keyring", current)
+	}
+	return k, nil
+}
+
+// LoadKeyring reads a keyring file, see keyringFile.
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read keyring")
+	}
+	var file keyringFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, errors.Wrap(err, "invalid keyring")
+	}
+
+	keys := map[int][]byte{}
+	for v, key := range file.Keys {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key version %q", v)
+		}
+		if keys[version], err = base64.StdEncoding.DecodeString(key); err != nil {
+			return nil, errors.Wrapf(err, "invalid base64 key %d", version)
+		}
+	}
+	indexKey, err := base64.StdEncoding.DecodeString(file.IndexKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid base64 index key")
+	}
+	return NewKeyring(file.Current, keys, indexKey)
+}
+
+// Current returns the version and the key sealing new data.
+func (k *Keyring) Current() (int, *AESGCM) {
+	return k.current, k.keys[k.current]
+}
+
+func (k *Keyring) Key(version int) (*AESGCM, error) {
+	box, ok := k.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("key %d isn't in the keyring", version)
+	}
+	return box, nil
+}
+
+// IndexKey keys the blind indexes, it can't be rotated without recomputing every index.
+func (k *Keyring) IndexKey() []byte {
+	return k.indexKey
+}
@@ -0,0 +1,151 @@
+package i18n
+
+var en = map[string]string{
+	// the keys of errors without their own, by transport.ResponseCode
+	"invalid_parameter":      "invalid parameter",
+	"permission_denied":      "permission denied",
+	"internal":               "internal error",
+	"not_found":              "not found",
+	"empty":                  "no content",
+	"not_implemented":        "not implemented",
+	"unauthorized":           "unauthorized",
+	"too_many_requests":      "too many requests",
+	"payload_too_large":      "payload too large",
+	"unsupported_media_type": "unsupported media type",
+	"conflict":               "conflict",
+	"unprocessable_entity":   "unprocessable entity",
+
+	"auth.authentication_required": "authentication is required",
+	"auth.permission_required":     "permission {permission} is required",
+	"auth.impersonating":           "not allowed while impersonating",
+	"auth.mfa_enrollment_required": "mfa enrollment is required",
+
+	"request.invalid_body":            "invalid body",
+	"request.body_too_large":          "body is larger than {limit} bytes",
+	"request.unsupported_media_type":  "Content-Type must be one of {media_types}",
+	"request.invalid_idempotency_key": "Idempotency-Key must be 1 to 255 visible characters",
+	"request.idempotency_key_reused":  "Idempotency-Key was already used for another request",
+	"request.idempotency_in_progress": "a request with the same Idempotency-Key is in progress",
+
+	"user.not_found":                "not found user {id}",
+	"user.get_failed":               "can't get user {id}",
+	"user.list_failed":              "can't get users",
+	"user.create_failed":            "can't create the user",
+	"user.update_failed":            "can't update user {id}",
+	"user.delete_failed":            "can't delete user {id}",
+	"user.invalid_email":            "invalid email {email}",
+	"user.invalid_phone":            "invalid phone {phone}",
+	"user.invalid_role":             "invalid role {role}",
+	"user.invalid_filter":           "invalid filter: {reason}",
+	"user.invalid_order":            "users can't be ordered by the encrypted {column}",
+	"user.read_only_fields":         "read-only or unknown fields are set",
+	"user.fields_not_allowed":       "not allowed to write some fields",
+	"user.fields_not_readable":      "not allowed to read some fields",
+	"user.invalid_query":            "invalid query parameters",
+	"user.conflict":                 "{field} is already taken",
+	"user.invalid_patch":            "invalid patch: {reason}",
+	"user.invalid_patch_operations": "invalid operations",
+	"user.patch_test_failed":        "the patch doesn't apply to user {id}",
+
+	"auth.invalid_credentials":      "invalid name or password",
+	"auth.login_failed":             "can't log in",
+	"auth.token_failed":             "can't issue tokens for user {id}",
+	"auth.password_check_failed":    "can't verify the password of user {id}",
+	"auth.invalid_mfa_token":        "invalid mfa token",
+	"auth.invalid_mfa_code":         "invalid mfa code",
+	"auth.invalid_refresh_token":    "invalid refresh token",
+	"auth.invalid_access_token":     "invalid access token",
+	"auth.weak_password":            "{reason}",
+	"auth.incorrect_password":       "current password is incorrect",
+	"auth.password_save_failed":     "can't save the password of user {id}",
+	"auth.unknown_user":             "user doesn't exist",
+	"auth.inactive_user":            "user is inactive",
+	"auth.credential_get_failed":    "can't get the credential of user {id}",
+	"auth.credentials_out_of_reach": "can't manage the credentials of user {id} with role {role}",
+
+	"mfa.already_enrolled":      "totp is already enrolled, disable it first",
+	"mfa.secret_failed":         "can't create the totp secret",
+	"mfa.enroll_failed":         "can't enroll totp of user {id}",
+	"mfa.no_pending_enrollment": "there is no pending totp enrollment",
+	"mfa.invalid_code":          "invalid totp code",
+	"mfa.confirm_failed":        "can't confirm totp of user {id}",
+	"mfa.code_required":         "a valid totp or recovery code is required",
+	"mfa.disable_failed":        "can't disable totp of user {id}",
+	"mfa.not_enrolled":          "totp is not enrolled",
+	"mfa.recovery_codes_failed": "can't generate recovery codes of user {id}",
+	"mfa.status_failed":         "can't get the mfa status of user {id}",
+	"mfa.verify_failed":         "can't verify the mfa code of user {id}",
+	"mfa.get_failed":            "can't get the mfa factor of user {id}",
+
+	"session.invalid":           "session is revoked or expired",
+	"session.create_failed":     "can't create a session for user {id}",
+	"session.use_failed":        "can't use the session",
+	"session.list_failed":       "can't get the sessions of user {id}",
+	"session.not_found":         "not found session {session} of user {id}",
+	"session.revoke_failed":     "can't revoke session {session}",
+	"session.revoke_all_failed": "can't revoke the sessions of user {id}",
+
+	"passkey.invalid_registration": "invalid passkey registration: {reason}",
+	"passkey.register_failed":      "can't register a passkey for user {id}",
+	"passkey.invalid":              "invalid passkey",
+	"passkey.login_failed":         "can't log in with the passkey",
+	"passkey.not_found":            "not found passkey {id}",
+	"passkey.revoke_failed":        "can't revoke passkey {id}",
+	"passkey.list_failed":          "can't get the passkeys of user {id}",
+	"passkey.challenge_failed":     "can't create the passkey challenge",
+	"passkey.challenge_use_failed": "can't use the passkey challenge",
+
+	"lockout.throttled":      "too many failed logins, retry in {wait}",
+	"lockout.account_locked": "account is locked after too many failed logins, retry in {wait}",
+	"lockout.ip_locked":      "too many failed logins from this address, retry in {wait}",
+	"lockout.record_failed":  "can't record the failed login",
+	"lockout.check_failed":   "can't check the failed logins",
+	"lockout.reset_failed":   "can't reset the failed logins of user {id}",
+	"lockout.unlock_failed":  "can't unlock user {id}",
+	"lockout.events_failed":  "can't get the lockout events of user {id}",
+
+	"ldap.sync_failed": "can't sync users from ldap",
+	"ldap.bind_failed": "can't check the ldap password of user {id}",
+
+	"privacy.export_failed":         "can't export the data of user {id}",
+	"privacy.get_failed":            "can't get the data of user {id}",
+	"privacy.erasure_create_failed": "can't schedule the erasure of user {id}",
+	"privacy.erasure_not_found":     "not found pending erasure of user {id}",
+	"privacy.erasure_cancel_failed": "can't cancel erasure {id}",
+	"privacy.erasures_run_failed":   "can't run the due erasures",
+	"privacy.erase_failed":          "can't erase user {id}",
+
+	"impersonation.self":            "can not impersonate yourself",
+	"impersonation.reason_required": "reason is required",
+	"impersonation.inactive_user":   "user {id} is inactive",
+	"impersonation.not_allowed":     "can't impersonate user {id} with role {role}",
+	"impersonation.start_failed":    "can't impersonate user {id}",
+	"impersonation.not_found":       "not found impersonation {id}",
+	"impersonation.end_failed":      "can't end impersonation {id}",
+	"impersonation.user_required":   "impersonation requires a user",
+	"impersonation.nested":          "impersonation can not be nested",
+
+	"email_verification.no_email":         "user has no email",
+	"email_verification.already_verified": "email is already verified",
+	"email_verification.send_failed":      "can't send the verification mail to user {id}",
+	"email_verification.resend_limit":     "at most {limit} verification mails can be sent in {window}",
+	"email_verification.resend_interval":  "wait {interval} between two verification mails",
+	"email_verification.invalid_token":    "invalid or expired email verification token",
+	"email_verification.verify_failed":    "can't verify the email",
+
+	// messages of FieldError
+	"field.phone_format":                "must be in E.164 format, e.g. +84912345678",
+	"field.role_read_only":              "is changed with PUT /user/{id}/role",
+	"field.email_verified_at_read_only": "is set by the email verification",
+	"field.not_allowed":                 "not allowed to write",
+	"field.not_readable":                "not allowed to read",
+	"field.unknown":                     "is unknown",
+	"field.positive_integer":            "must be a positive integer",
+	"field.gender":                      "must be MALE or FEMALE",
+	"field.boolean":                     "must be true or false",
+	"field.order_by":                    "must be <field> or <field>.asc or <field>.desc, fields are {fields}",
+	"field.read_only":                   "is read-only",
+	"field.invalid_type":                "can't be a {type}",
+	"field.taken":                       "is already taken",
+	"field.invalid_operation":           "{reason}",
+}
@@ -0,0 +1,81 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	English    = "en"
+	Vietnamese = "vi"
+)
+
+// bundles hold the messages of each language by key, a message may refer to the params of its error with {name}.
+var bundles = map[string]map[string]string{
+	English:    en,
+	Vietnamese: vi,
+}
+
+// Supported tells if there is a bundle for the language.
+func Supported(lang string) bool {
+	_, ok := bundles[lang]
+	return ok
+}
+
+// Match returns the supported language preferred by an Accept-Language header, def when none is acceptable.
+// Regions are ignored, vi-VN is vi.
+func Match(acceptLanguage string, def string) string {
+	type choice struct {
+		lang string
+		q    float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(tag) == 0 {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		lang := strings.SplitN(tag, "-", 2)[0]
+		if lang == "*" {
+			lang = def
+		}
+		if Supported(lang) {
+			choices = append(choices, choice{lang: lang, q: q})
+		}
+	}
+	if len(choices) == 0 {
+		return def
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	return choices[0].lang
+}
+
+// Translate returns the message of key in the language, or in English when the language doesn't have it. The
+// placeholders of the params given are replaced, the others are left as they are.
+func Translate(lang string, key string, params map[string]interface{}) (string, bool) {
+	msg, ok := bundles[lang][key]
+	if !ok {
+		if msg, ok = en[key]; !ok {
+			return "", false
+		}
+	}
+	for name, value := range params {
+		msg = strings.Replace(msg, "{"+name+"}", fmt.Sprint(value), -1)
+	}
+	return msg, true
+}
@@ -0,0 +1,55 @@
+package i18n
+
+import (
+	"gotest.tools/assert"
+	"regexp"
+	"testing"
+)
+
+var placeholders = regexp.MustCompile(`\{\w+\}`)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", English},
+		{"vi", Vietnamese},
+		{"vi-VN,vi;q=0.9,en-US;q=0.8", Vietnamese},
+		{"en;q=0.5, vi;q=0.8", Vietnamese},
+		{"fr-FR, en;q=0.3", English},
+		{"vi;q=0", English},
+		{"fr", English},
+		{"*", English},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, Match(tt.acceptLanguage, English), tt.want, tt.acceptLanguage)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	msg, ok := Translate(Vietnamese, "user.not_found", map[string]interface{}{"id": 7})
+	assert.Assert(t, ok)
+	assert.Equal(t, msg, "không tìm thấy người dùng 7")
+
+	// placeholders without param are kept
+	msg, _ = Translate(English, "field.role_read_only", nil)
+	assert.Equal(t, msg, "is changed with PUT /user/{id}/role")
+
+	// unknown languages fall back to English
+	msg, _ = Translate("fr", "internal", nil)
+	assert.Equal(t, msg, "internal error")
+
+	_, ok = Translate(English, "user.unknown", nil)
+	assert.Assert(t, !ok)
+}
+
+// TestBundles keeps the bundles in sync, every message has a translation with the same params.
+func TestBundles(t *testing.T) {
+	for key, msg := range en {
+		translated, ok := vi[key]
+		assert.Assert(t, ok, key)
+		assert.DeepEqual(t, placeholders.FindAllString(translated, -1), placeholders.FindAllString(msg, -1))
+	}
+	assert.Equal(t, len(vi), len(en))
+}
@@ -0,0 +1,149 @@
+package i18n
+
+var vi = map[string]string{
+	"invalid_parameter":      "tham số không hợp lệ",
+	"permission_denied":      "không có quyền truy cập",
+	"internal":               "lỗi hệ thống",
+	"not_found":              "không tìm thấy",
+	"empty":                  "không có nội dung",
+	"not_implemented":        "chưa được hỗ trợ",
+	"unauthorized":           "chưa xác thực",
+	"too_many_requests":      "quá nhiều yêu cầu",
+	"payload_too_large":      "dữ liệu gửi lên quá lớn",
+	"unsupported_media_type": "kiểu dữ liệu không được hỗ trợ",
+	"conflict":               "xung đột dữ liệu",
+	"unprocessable_entity":   "không thể xử lý yêu cầu",
+
+	"auth.authentication_required": "yêu cầu xác thực",
+	"auth.permission_required":     "yêu cầu quyền {permission}",
+	"auth.impersonating":           "không được phép khi đang mạo danh",
+	"auth.mfa_enrollment_required": "cần đăng ký xác thực nhiều lớp",
+
+	"request.invalid_body":            "nội dung yêu cầu không hợp lệ",
+	"request.body_too_large":          "nội dung yêu cầu vượt quá {limit} byte",
+	"request.unsupported_media_type":  "Content-Type phải là một trong {media_types}",
+	"request.invalid_idempotency_key": "Idempotency-Key phải gồm từ 1 đến 255 ký tự hiển thị được",
+	"request.idempotency_key_reused":  "Idempotency-Key đã được dùng cho một yêu cầu khác",
+	"request.idempotency_in_progress": "một yêu cầu có cùng Idempotency-Key đang được xử lý",
+
+	"user.not_found":                "không tìm thấy người dùng {id}",
+	"user.get_failed":               "không thể lấy người dùng {id}",
+	"user.list_failed":              "không thể lấy danh sách người dùng",
+	"user.create_failed":            "không thể tạo người dùng",
+	"user.update_failed":            "không thể cập nhật người dùng {id}",
+	"user.delete_failed":            "không thể xóa người dùng {id}",
+	"user.invalid_email":            "email {email} không hợp lệ",
+	"user.invalid_phone":            "số điện thoại {phone} không hợp lệ",
+	"user.invalid_role":             "vai trò {role} không hợp lệ",
+	"user.invalid_filter":           "bộ lọc không hợp lệ: {reason}",
+	"user.invalid_order":            "không thể sắp xếp người dùng theo trường mã hóa {column}",
+	"user.read_only_fields":         "có trường chỉ đọc hoặc không xác định được gán giá trị",
+	"user.fields_not_allowed":       "không được phép ghi một số trường",
+	"user.fields_not_readable":      "không được phép đọc một số trường",
+	"user.invalid_query":            "tham số truy vấn không hợp lệ",
+	"user.conflict":                 "{field} đã được sử dụng",
+	"user.invalid_patch":            "bản vá không hợp lệ: {reason}",
+	"user.invalid_patch_operations": "thao tác không hợp lệ",
+	"user.patch_test_failed":        "không thể áp dụng bản vá cho người dùng {id}",
+
+	"auth.invalid_credentials":      "tên hoặc mật khẩu không đúng",
+	"auth.login_failed":             "không thể đăng nhập",
+	"auth.token_failed":             "không thể cấp token cho người dùng {id}",
+	"auth.password_check_failed":    "không thể kiểm tra mật khẩu của người dùng {id}",
+	"auth.invalid_mfa_token":        "token xác thực nhiều lớp không hợp lệ",
+	"auth.invalid_mfa_code":         "mã xác thực nhiều lớp không hợp lệ",
+	"auth.invalid_refresh_token":    "refresh token không hợp lệ",
+	"auth.invalid_access_token":     "access token không hợp lệ",
+	"auth.weak_password":            "mật khẩu không đạt yêu cầu: {reason}",
+	"auth.incorrect_password":       "mật khẩu hiện tại không đúng",
+	"auth.password_save_failed":     "không thể lưu mật khẩu của người dùng {id}",
+	"auth.unknown_user":             "người dùng không tồn tại",
+	"auth.inactive_user":            "người dùng không hoạt động",
+	"auth.credential_get_failed":    "không thể lấy thông tin đăng nhập của người dùng {id}",
+	"auth.credentials_out_of_reach": "không thể quản lý thông tin đăng nhập của người dùng {id} có vai trò {role}",
+
+	"mfa.already_enrolled":      "đã đăng ký totp, hãy tắt nó trước",
+	"mfa.secret_failed":         "không thể tạo khóa bí mật totp",
+	"mfa.enroll_failed":         "không thể đăng ký totp cho người dùng {id}",
+	"mfa.no_pending_enrollment": "không có đăng ký totp nào đang chờ xác nhận",
+	"mfa.invalid_code":          "mã totp không hợp lệ",
+	"mfa.confirm_failed":        "không thể xác nhận totp của người dùng {id}",
+	"mfa.code_required":         "cần mã totp hoặc mã khôi phục hợp lệ",
+	"mfa.disable_failed":        "không thể tắt totp của người dùng {id}",
+	"mfa.not_enrolled":          "chưa đăng ký totp",
+	"mfa.recovery_codes_failed": "không thể tạo mã khôi phục cho người dùng {id}",
+	"mfa.status_failed":         "không thể lấy trạng thái xác thực nhiều lớp của người dùng {id}",
+	"mfa.verify_failed":         "không thể kiểm tra mã xác thực nhiều lớp của người dùng {id}",
+	"mfa.get_failed":            "không thể lấy yếu tố xác thực nhiều lớp của người dùng {id}",
+
+	"session.invalid":           "phiên đã bị thu hồi hoặc hết hạn",
+	"session.create_failed":     "không thể tạo phiên cho người dùng {id}",
+	"session.use_failed":        "không thể sử dụng phiên",
+	"session.list_failed":       "không thể lấy các phiên của người dùng {id}",
+	"session.not_found":         "không tìm thấy phiên {session} của người dùng {id}",
+	"session.revoke_failed":     "không thể thu hồi phiên {session}",
+	"session.revoke_all_failed": "không thể thu hồi các phiên của người dùng {id}",
+
+	"passkey.invalid_registration": "đăng ký passkey không hợp lệ: {reason}",
+	"passkey.register_failed":      "không thể đăng ký passkey cho người dùng {id}",
+	"passkey.invalid":              "passkey không hợp lệ",
+	"passkey.login_failed":         "không thể đăng nhập bằng passkey",
+	"passkey.not_found":            "không tìm thấy passkey {id}",
+	"passkey.revoke_failed":        "không thể thu hồi passkey {id}",
+	"passkey.list_failed":          "không thể lấy các passkey của người dùng {id}",
+	"passkey.challenge_failed":     "không thể tạo thử thách passkey",
+	"passkey.challenge_use_failed": "không thể sử dụng thử thách passkey",
+
+	"lockout.throttled":      "quá nhiều lần đăng nhập thất bại, hãy thử lại sau {wait}",
+	"lockout.account_locked": "tài khoản bị khóa do quá nhiều lần đăng nhập thất bại, hãy thử lại sau {wait}",
+	"lockout.ip_locked":      "quá nhiều lần đăng nhập thất bại từ địa chỉ này, hãy thử lại sau {wait}",
+	"lockout.record_failed":  "không thể ghi nhận lần đăng nhập thất bại",
+	"lockout.check_failed":   "không thể kiểm tra các lần đăng nhập thất bại",
+	"lockout.reset_failed":   "không thể đặt lại các lần đăng nhập thất bại của người dùng {id}",
+	"lockout.unlock_failed":  "không thể mở khóa người dùng {id}",
+	"lockout.events_failed":  "không thể lấy các sự kiện khóa của người dùng {id}",
+
+	"ldap.sync_failed": "không thể đồng bộ người dùng từ ldap",
+	"ldap.bind_failed": "không thể kiểm tra mật khẩu ldap của người dùng {id}",
+
+	"privacy.export_failed":         "không thể xuất dữ liệu của người dùng {id}",
+	"privacy.get_failed":            "không thể lấy dữ liệu của người dùng {id}",
+	"privacy.erasure_create_failed": "không thể lên lịch xóa dữ liệu của người dùng {id}",
+	"privacy.erasure_not_found":     "không tìm thấy yêu cầu xóa dữ liệu đang chờ của người dùng {id}",
+	"privacy.erasure_cancel_failed": "không thể hủy yêu cầu xóa dữ liệu {id}",
+	"privacy.erasures_run_failed":   "không thể thực hiện các yêu cầu xóa dữ liệu đến hạn",
+	"privacy.erase_failed":          "không thể xóa dữ liệu của người dùng {id}",
+
+	"impersonation.self":            "không thể mạo danh chính mình",
+	"impersonation.reason_required": "cần có lý do",
+	"impersonation.inactive_user":   "người dùng {id} không hoạt động",
+	"impersonation.not_allowed":     "không thể mạo danh người dùng {id} có vai trò {role}",
+	"impersonation.start_failed":    "không thể mạo danh người dùng {id}",
+	"impersonation.not_found":       "không tìm thấy phiên mạo danh {id}",
+	"impersonation.end_failed":      "không thể kết thúc phiên mạo danh {id}",
+	"impersonation.user_required":   "chỉ người dùng mới có thể mạo danh",
+	"impersonation.nested":          "không thể mạo danh lồng nhau",
+
+	"email_verification.no_email":         "người dùng không có email",
+	"email_verification.already_verified": "email đã được xác minh",
+	"email_verification.send_failed":      "không thể gửi thư xác minh cho người dùng {id}",
+	"email_verification.resend_limit":     "chỉ có thể gửi tối đa {limit} thư xác minh trong {window}",
+	"email_verification.resend_interval":  "hãy chờ {interval} giữa hai thư xác minh",
+	"email_verification.invalid_token":    "token xác minh email không hợp lệ hoặc đã hết hạn",
+	"email_verification.verify_failed":    "không thể xác minh email",
+
+	"field.phone_format":                "phải theo định dạng E.164, ví dụ +84912345678",
+	"field.role_read_only":              "được thay đổi qua PUT /user/{id}/role",
+	"field.email_verified_at_read_only": "được gán khi xác minh email",
+	"field.not_allowed":                 "không được phép ghi",
+	"field.not_readable":                "không được phép đọc",
+	"field.unknown":                     "không được hỗ trợ",
+	"field.positive_integer":            "phải là số nguyên dương",
+	"field.gender":                      "phải là MALE hoặc FEMALE",
+	"field.boolean":                     "phải là true hoặc false",
+	"field.order_by":                    "phải có dạng <trường>, <trường>.asc hoặc <trường>.desc, các trường là {fields}",
+	"field.read_only":                   "chỉ được đọc",
+	"field.invalid_type":                "không được là kiểu {type}",
+	"field.taken":                       "đã được sử dụng",
+	"field.invalid_operation":           "{reason}",
+}
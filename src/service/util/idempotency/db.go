@@ -0,0 +1,109 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jinzhu/gorm"
+	"net/http"
+	"time"
+	"user-service/src/service/util/sqlerr"
+)
+
+type dbRecord struct {
+	Key         string    `gorm:"column:idempotency_key;primary_key"`
+	Fingerprint string    `gorm:"column:fingerprint"`
+	Status      int       `gorm:"column:status"`
+	Header      string    `gorm:"column:header"`
+	Body        []byte    `gorm:"column:body"`
+	CreatedAt   time.Time `gorm:"column:created_at"`
+	ExpiresAt   time.Time `gorm:"column:expires_at"`
+}
+
+func (dbRecord) TableName() string {
+	return "idempotency_keys"
+}
+
+// DBStore keeps the records in the idempotency_keys table, shared by every instance of the service. The primary
+// key lets a single request begin with a key.
+type DBStore struct {
+	db *gorm.DB
+}
+
+func NewDBStore(db *gorm.DB) *DBStore {
+	return &DBStore{db: db}
+}
+
+func (s *DBStore) Begin(_ context.Context, record Record) (*Record, error) {
+	// the expired record of the key is replaced, a pending one whose request never ended too
+	if err := s.db.Where("idempotency_key = ? AND expires_at <= ?", record.Key, time.Now()).Delete(&dbRecord{}).Error; err != nil {
+		return nil, err
+	}
+	row, err := newDBRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.Create(&row).Error
+	if err == nil {
+		return nil, nil
+	}
+	if _, ok := sqlerr.UniqueViolation(err); !ok {
+		return nil, err
+	}
+
+	var existing dbRecord
+	if err = s.db.Where("idempotency_key = ?", record.Key).First(&existing).Error; err != nil {
+		return nil, err
+	}
+	return existing.record()
+}
+
+func (s *DBStore) Complete(_ context.Context, record Record) error {
+	row, err := newDBRecord(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Model(&dbRecord{}).Where("idempotency_key = ?", record.Key).Updates(map[string]interface{}{
+		"status":     row.Status,
+		"header":     row.Header,
+		"body":       row.Body,
+		"expires_at": row.ExpiresAt,
+	}).Error
+}
+
+func (s *DBStore) Release(_ context.Context, key string) error {
+	return s.db.Where("idempotency_key = ? AND status = 0", key).Delete(&dbRecord{}).Error
+}
+
+func (s *DBStore) Purge(_ context.Context) error {
+	return s.db.Where("expires_at <= ?", time.Now()).Delete(&dbRecord{}).Error
+}
+
+func newDBRecord(record Record) (dbRecord, error) {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return dbRecord{}, err
+	}
+	return dbRecord{
+		Key:         record.Key,
+		Fingerprint: record.Fingerprint,
+		Status:      record.Status,
+		Header:      string(header),
+		Body:        record.Body,
+		ExpiresAt:   record.ExpiresAt,
+	}, nil
+}
+
+func (r dbRecord) record() (*Record, error) {
+	var header http.Header
+	if err := json.Unmarshal([]byte(r.Header), &header); err != nil {
+		return nil, err
+	}
+	return &Record{
+		Key:         r.Key,
+		Fingerprint: r.Fingerprint,
+		Status:      r.Status,
+		Header:      header,
+		Body:        r.Body,
+		ExpiresAt:   r.ExpiresAt,
+	}, nil
+}
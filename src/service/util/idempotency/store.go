@@ -0,0 +1,56 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/spf13/viper"
+	"net/http"
+	"time"
+)
+
+// Record is the response to the first request sent with an Idempotency-Key. It is pending, with a zero Status,
+// until that request is answered.
+type Record struct {
+	// Key identifies the key of a client, see the http transport for how it is scoped
+	Key string
+	// Fingerprint tells the requests sent with the key apart
+	Fingerprint string
+	Status      int
+	Header      http.Header
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+func (r Record) Pending() bool {
+	return r.Status == 0
+}
+
+// Store keeps the records of the keys, implementations are chosen with idempotency.store. Expired records are
+// treated as missing.
+type Store interface {
+	// Begin saves the pending record unless its key already has one, which is returned instead.
+	Begin(ctx context.Context, record Record) (*Record, error)
+	// Complete stores the response of the pending record of the key.
+	Complete(ctx context.Context, record Record) error
+	// Release forgets the pending record of the key, the next request sent with it is a first one again.
+	Release(ctx context.Context, key string) error
+	// Purge deletes the expired records.
+	Purge(ctx context.Context) error
+}
+
+const (
+	StoreMemory = "memory"
+	StoreDB     = "db"
+)
+
+func NewStoreFromConfig(db *gorm.DB) (Store, error) {
+	switch store := viper.GetString("idempotency.store"); store {
+	case StoreMemory, "":
+		return NewMemoryStore(), nil
+	case StoreDB:
+		return NewDBStore(db), nil
+	default:
+		return nil, fmt.Errorf("unknown idempotency store %s", store)
+	}
+}
@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	"gotest.tools/assert"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	pending := Record{Key: "k", Fingerprint: "f", ExpiresAt: time.Now().Add(time.Minute)}
+
+	existing, err := s.Begin(ctx, pending)
+	assert.NilError(t, err)
+	assert.Assert(t, existing == nil)
+	existing, _ = s.Begin(ctx, pending)
+	assert.Assert(t, existing.Pending())
+
+	// a released key begins again
+	assert.NilError(t, s.Release(ctx, "k"))
+	existing, _ = s.Begin(ctx, pending)
+	assert.Assert(t, existing == nil)
+
+	done := Record{Key: "k", Fingerprint: "f", Status: http.StatusCreated, Body: []byte("{}"), ExpiresAt: time.Now().Add(time.Hour)}
+	assert.NilError(t, s.Complete(ctx, done))
+	assert.NilError(t, s.Release(ctx, "k"))
+	existing, _ = s.Begin(ctx, pending)
+	assert.DeepEqual(t, *existing, done)
+
+	// expired records are missing
+	expired := Record{Key: "e", Fingerprint: "f", Status: http.StatusOK, ExpiresAt: time.Now().Add(-time.Second)}
+	assert.NilError(t, s.Complete(ctx, expired))
+	assert.NilError(t, s.Purge(ctx))
+	assert.Equal(t, len(s.records), 1)
+	existing, _ = s.Begin(ctx, Record{Key: "e", ExpiresAt: time.Now().Add(time.Minute)})
+	assert.Assert(t, existing == nil)
+}
+
+func TestDBStore_Begin(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	gormDB, _ := gorm.Open("mysql", db)
+	s := NewDBStore(gormDB)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `idempotency_keys`  WHERE (idempotency_key = ? AND expires_at <= ?)")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `idempotency_keys`")).
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'k' for key 'PRIMARY'"})
+	mock.ExpectRollback()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `idempotency_keys`  WHERE (idempotency_key = ?)")).
+		WithArgs("k").
+		WillReturnRows(sqlmock.NewRows([]string{"idempotency_key", "fingerprint", "status", "header", "body"}).
+			AddRow("k", "f", 201, `{"Content-Type":["application/json"]}`, []byte("{}")))
+
+	existing, err := s.Begin(context.Background(), Record{Key: "k", Fingerprint: "f"})
+	assert.NilError(t, err)
+	assert.Equal(t, existing.Status, http.StatusCreated)
+	assert.Equal(t, existing.Header.Get("Content-Type"), "application/json")
+	assert.Equal(t, string(existing.Body), "{}")
+	assert.NilError(t, mock.ExpectationsWereMet())
+}
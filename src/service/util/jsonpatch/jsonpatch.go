@@ -0,0 +1,294 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpReplace = "replace"
+	OpTest    = "test"
+)
+
+// Operation is an operation of a JSON Patch (RFC 6902), move and copy aren't supported. Value is nil when the
+// operation has none, a JSON null is kept as "null".
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ErrTestFailed is returned when the value of a test operation isn't the one of the document.
+var ErrTestFailed = errors.New("test failed")
+
+// Validate checks the operation alone, without a document.
+func (o Operation) Validate() error {
+	switch o.Op {
+	case OpAdd, OpReplace, OpTest:
+		if o.Value == nil {
+			return fmt.Errorf("%s needs a value", o.Op)
+		}
+	case OpRemove:
+	default:
+		return fmt.Errorf("unsupported op %q", o.Op)
+	}
+	_, err := ParsePointer(o.Path)
+	return err
+}
+
+// ParsePointer splits a JSON Pointer (RFC 6901) in its unescaped reference tokens, the root is no token.
+func ParsePointer(path string) ([]string, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	if path[0] != '/' {
+		return nil, fmt.Errorf("path %q must start with /", path)
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// Apply applies the operations to the document in order, the document is left as it is when one fails.
+func Apply(doc []byte, ops []Operation) ([]byte, error) {
+	root, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		if err := op.Validate(); err != nil {
+			return nil, err
+		}
+		tokens, _ := ParsePointer(op.Path)
+		var value interface{}
+		if op.Value != nil {
+			if value, err = decode(op.Value); err != nil {
+				return nil, err
+			}
+		}
+		switch op.Op {
+		case OpTest:
+			current, err := get(root, tokens)
+			if err != nil {
+				return nil, err
+			}
+			if !equal(current, value) {
+				return nil, fmt.Errorf("%s: %w", op.Path, ErrTestFailed)
+			}
+			continue
+		case OpAdd:
+			if len(tokens) == 0 {
+				root = value
+				continue
+			}
+			root, err = update(root, tokens, value, add)
+		case OpRemove:
+			root, err = update(root, tokens, nil, remove)
+		case OpReplace:
+			if len(tokens) == 0 {
+				root = value
+				continue
+			}
+			root, err = update(root, tokens, value, replace)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+// MergePatch applies a JSON Merge Patch (RFC 7396): the members of the patch replace the ones of the document,
+// null removes them and objects are merged recursively.
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decode(patch)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+		} else {
+			t[key] = merge(t[key], value)
+		}
+	}
+	return t
+}
+
+// decode keeps numbers as they are written, 1.0 isn't turned into 1.
+func decode(b []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// operation changes the member key of the parent, it returns the new parent as arrays are reallocated.
+type operation func(parent interface{}, key string, value interface{}) (interface{}, error)
+
+// update applies the operation to the parent of the last token.
+func update(node interface{}, tokens []string, value interface{}, op operation) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("the document can't be removed")
+	}
+	if len(tokens) == 1 {
+		return op(node, tokens[0], value)
+	}
+	child, err := member(node, tokens[0])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = update(child, tokens[1:], value, op); err != nil {
+		return nil, err
+	}
+	return replace(node, tokens[0], child)
+}
+
+func get(node interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		var err error
+		if node, err = member(node, token); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func member(node interface{}, key string) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if value, ok := n[key]; ok {
+			return value, nil
+		}
+	case []interface{}:
+		if i, err := index(key, len(n)-1); err == nil {
+			return n[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not found", key)
+}
+
+func add(parent interface{}, key string, value interface{}) (interface{}, error) {
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[key] = value
+		return p, nil
+	case []interface{}:
+		if key == "-" {
+			return append(p, value), nil
+		}
+		i, err := index(key, len(p))
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, nil)
+		copy(p[i+1:], p[i:])
+		p[i] = value
+		return p, nil
+	}
+	return nil, fmt.Errorf("%s can't be added to a value", key)
+}
+
+func remove(parent interface{}, key string, _ interface{}) (interface{}, error) {
+	if _, err := member(parent, key); err != nil {
+		return nil, err
+	}
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		delete(p, key)
+		return p, nil
+	case []interface{}:
+		i, _ := index(key, len(p)-1)
+		return append(p[:i], p[i+1:]...), nil
+	}
+	return parent, nil
+}
+
+func replace(parent interface{}, key string, value interface{}) (interface{}, error) {
+	if _, err := member(parent, key); err != nil {
+		return nil, err
+	}
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[key] = value
+	case []interface{}:
+		i, _ := index(key, len(p)-1)
+		p[i] = value
+	}
+	return parent, nil
+}
+
+// index parses the index of an array, at most max. Leading zeros aren't allowed.
+func index(key string, max int) (int, error) {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || i > max || (len(key) > 1 && key[0] == '0') {
+		return 0, fmt.Errorf("invalid index %s", key)
+	}
+	return i, nil
+}
+
+// equal compares JSON values, numbers are equal when their values are.
+func equal(a interface{}, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for key, value := range x {
+			other, ok := y[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b) && reflect.TypeOf(a) == reflect.TypeOf(b)
+}
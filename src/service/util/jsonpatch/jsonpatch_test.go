@@ -0,0 +1,75 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	doc := `{"name":"QL","phone":"0123","tags":["a","b"],"n":1.0}`
+	tests := []struct {
+		name    string
+		ops     string
+		want    string
+		wantErr bool
+	}{
+		{"replace", `[{"op":"replace","path":"/name","value":"Q"}]`, `{"n":1.0,"name":"Q","phone":"0123","tags":["a","b"]}`, false},
+		{"remove", `[{"op":"remove","path":"/phone"}]`, `{"n":1.0,"name":"QL","tags":["a","b"]}`, false},
+		{"add to array", `[{"op":"add","path":"/tags/1","value":"c"},{"op":"add","path":"/tags/-","value":"d"}]`,
+			`{"n":1.0,"name":"QL","phone":"0123","tags":["a","c","b","d"]}`, false},
+		{"escaped path", `[{"op":"add","path":"/a~1b~0","value":null}]`, `{"a/b~":null,"n":1.0,"name":"QL","phone":"0123","tags":["a","b"]}`, false},
+		{"test then replace", `[{"op":"test","path":"/n","value":1},{"op":"replace","path":"/n","value":2}]`,
+			`{"n":2,"name":"QL","phone":"0123","tags":["a","b"]}`, false},
+		{"replace a missing member", `[{"op":"replace","path":"/email","value":"a@b.c"}]`, "", true},
+		{"remove out of the array", `[{"op":"remove","path":"/tags/2"}]`, "", true},
+		{"unsupported op", `[{"op":"move","from":"/name","path":"/n"}]`, "", true},
+		{"missing value", `[{"op":"add","path":"/name"}]`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []Operation
+			if err := json.Unmarshal([]byte(tt.ops), &ops); err != nil {
+				t.Fatal(err)
+			}
+			got, err := Apply([]byte(doc), ops)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Apply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply_TestFailed(t *testing.T) {
+	_, err := Apply([]byte(`{"name":"QL"}`), []Operation{{Op: OpTest, Path: "/name", Value: json.RawMessage(`"Q"`)}})
+	if !errors.Is(err, ErrTestFailed) {
+		t.Errorf("Apply() error = %v, want %v", err, ErrTestFailed)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"set and clear", `{"name":"QL","phone":"0123"}`, `{"name":"Q","phone":null}`, `{"name":"Q"}`},
+		{"nested", `{"a":{"b":1,"c":2}}`, `{"a":{"c":null,"d":3}}`, `{"a":{"b":1,"d":3}}`},
+		{"not an object", `{"a":1}`, `["b"]`, `["b"]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MergePatch() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Package ldap reads users from an LDAP directory and checks their passwords with a bind.
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCredentials = errors.New("invalid ldap credentials")
+
+// Entry holds the values of the attributes requested in a search.
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Get returns the first value of the attribute, names are case insensitive.
+func (e Entry) Get(name string) string {
+	for attribute, values := range e.Attributes {
+		if strings.EqualFold(attribute, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+type Directory interface {
+	// Search returns the entries of the subtree of baseDN matching the filter.
+	Search(ctx context.Context, baseDN string, filter string, attributes []string) ([]Entry, error)
+	// Bind fails with ErrInvalidCredentials when the password of dn is wrong.
+	Bind(ctx context.Context, dn string, password string) error
+}
+
+type Config struct {
+	URL string
+	// BindDN and BindPassword are the service account used to search, searches are anonymous without BindDN
+	BindDN       string
+	BindPassword string
+	StartTLS     bool
+	Timeout      time.Duration
+}
+
+// Client connects to the directory for each operation, syncs and logins are too rare to keep a connection.
+type Client struct {
+	config Config
+}
+
+func NewClient(config Config) *Client {
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return &Client{config: config}
+}
+
+func (c *Client) Search(_ context.Context, baseDN string, filter string, attributes []string) ([]Entry, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if len(c.config.BindDN) > 0 {
+		if err := conn.Bind(c.config.BindDN, c.config.BindPassword); err != nil {
+			return nil, errors.Wrap(err, "can't bind service account")
+		}
+	}
+
+	request := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter, attributes, nil)
+	result, err := conn.SearchWithPaging(request, 500)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't search %s", baseDN)
+	}
+
+	entries := make([]Entry, 0, len(result.Entries))
+	for _, e := range result.Entries {
+		entry := Entry{DN: e.DN, Attributes: map[string][]string{}}
+		for _, attribute := range e.Attributes {
+			entry.Attributes[attribute.Name] = attribute.Values
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (c *Client) Bind(_ context.Context, dn string, password string) error {
+	// an empty password is an unauthenticated bind which always succeeds, see RFC 4513 section 5.1.2
+	if len(password) == 0 {
+		return ErrInvalidCredentials
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return ErrInvalidCredentials
+		}
+		return errors.Wrapf(err, "can't bind %s", dn)
+	}
+	return nil
+}
+
+func (c *Client) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(c.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: c.config.Timeout}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't connect to %s", c.config.URL)
+	}
+	conn.SetTimeout(c.config.Timeout)
+
+	if c.config.StartTLS {
+		host := c.config.URL
+		if i := strings.Index(host, "://"); i >= 0 {
+			host = host[i+3:]
+		}
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "can't start tls")
+		}
+	}
+	return conn, nil
+}
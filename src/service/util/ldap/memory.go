@@ -0,0 +1,160 @@
+package ldap
+
+import (
+	"context"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryDirectory is an in-process stand-in for a directory, for tests and local development.
+// Filters support and, or, not, equality, presence and substrings, matching is case insensitive.
+type MemoryDirectory struct {
+	mu        sync.RWMutex
+	entries   map[string]Entry
+	passwords map[string]string
+}
+
+func NewMemoryDirectory() *MemoryDirectory {
+	return &MemoryDirectory{entries: map[string]Entry{}, passwords: map[string]string{}}
+}
+
+// Add creates or replaces the entry, an empty password doesn't allow binding.
+func (d *MemoryDirectory) Add(dn string, password string, attributes map[string][]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := strings.ToLower(dn)
+	d.entries[key] = Entry{DN: dn, Attributes: attributes}
+	d.passwords[key] = password
+}
+
+func (d *MemoryDirectory) Remove(dn string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.entries, strings.ToLower(dn))
+	delete(d.passwords, strings.ToLower(dn))
+}
+
+func (d *MemoryDirectory) Search(_ context.Context, baseDN string, filter string, attributes []string) ([]Entry, error) {
+	packet, err := ldap.CompileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var entries []Entry
+	suffix := strings.ToLower(baseDN)
+	for key, entry := range d.entries {
+		if key != suffix && !strings.HasSuffix(key, ","+suffix) {
+			continue
+		}
+		ok, err := matches(entry, packet)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			entries = append(entries, selectAttributes(entry, attributes))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DN < entries[j].DN })
+	return entries, nil
+}
+
+func (d *MemoryDirectory) Bind(_ context.Context, dn string, password string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	expected, ok := d.passwords[strings.ToLower(dn)]
+	if !ok || len(expected) == 0 || expected != password {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+func selectAttributes(entry Entry, attributes []string) Entry {
+	selected := Entry{DN: entry.DN, Attributes: map[string][]string{}}
+	for _, name := range attributes {
+		for attribute, values := range entry.Attributes {
+			if strings.EqualFold(attribute, name) {
+				selected.Attributes[attribute] = values
+			}
+		}
+	}
+	return selected
+}
+
+func matches(entry Entry, packet *ber.Packet) (bool, error) {
+	switch packet.Tag {
+	case ldap.FilterAnd:
+		for _, child := range packet.Children {
+			if ok, err := matches(entry, child); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case ldap.FilterOr:
+		for _, child := range packet.Children {
+			if ok, err := matches(entry, child); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case ldap.FilterNot:
+		ok, err := matches(entry, packet.Children[0])
+		return !ok, err
+	case ldap.FilterPresent:
+		return len(values(entry, packet.Data.String())) > 0, nil
+	case ldap.FilterEqualityMatch:
+		expected := packet.Children[1].Data.String()
+		for _, value := range values(entry, packet.Children[0].Data.String()) {
+			if strings.EqualFold(value, expected) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case ldap.FilterSubstrings:
+		for _, value := range values(entry, packet.Children[0].Data.String()) {
+			if matchSubstrings(strings.ToLower(value), packet.Children[1].Children) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, errors.Errorf("filter %s isn't supported", ldap.FilterMap[uint64(packet.Tag)])
+}
+
+func matchSubstrings(value string, parts []*ber.Packet) bool {
+	for _, part := range parts {
+		s := strings.ToLower(part.Data.String())
+		switch part.Tag {
+		case ldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(value, s) {
+				return false
+			}
+			value = value[len(s):]
+		case ldap.FilterSubstringsAny:
+			i := strings.Index(value, s)
+			if i < 0 {
+				return false
+			}
+			value = value[i+len(s):]
+		case ldap.FilterSubstringsFinal:
+			if !strings.HasSuffix(value, s) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func values(entry Entry, name string) []string {
+	for attribute, values := range entry.Attributes {
+		if strings.EqualFold(attribute, name) {
+			return values
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package ldap
+
+import (
+	"context"
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestMemoryDirectory_Search(t *testing.T) {
+	d := NewMemoryDirectory()
+	d.Add("uid=alice,ou=people,dc=example,dc=com", "secret", map[string][]string{
+		"objectClass": {"top", "inetOrgPerson"}, "uid": {"alice"}, "mail": {"Alice@example.com"},
+	})
+	d.Add("uid=bob,ou=people,dc=example,dc=com", "", map[string][]string{
+		"objectClass": {"inetOrgPerson"}, "uid": {"bob"},
+	})
+	d.Add("uid=carol,ou=admins,dc=example,dc=com", "", map[string][]string{
+		"objectClass": {"inetOrgPerson"}, "uid": {"carol"}, "mail": {"carol@example.com"},
+	})
+
+	tests := []struct {
+		baseDN string
+		filter string
+		want   []string
+	}{
+		{"ou=people,dc=example,dc=com", "(objectClass=inetOrgPerson)", []string{"alice", "bob"}},
+		{"dc=example,dc=com", "(mail=*)", []string{"alice", "carol"}},
+		{"dc=example,dc=com", "(&(objectClass=inetorgperson)(!(uid=bob)))", []string{"alice", "carol"}},
+		{"dc=example,dc=com", "(|(mail=alice@EXAMPLE.com)(uid=b*))", []string{"alice", "bob"}},
+		{"dc=example,dc=com", "(mail=*@example*com)", []string{"alice", "carol"}},
+		{"ou=other,dc=example,dc=com", "(uid=*)", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			entries, err := d.Search(context.Background(), tt.baseDN, tt.filter, []string{"uid"})
+			assert.NilError(t, err)
+			var uids []string
+			for _, entry := range entries {
+				assert.Equal(t, entry.Get("mail"), "")
+				uids = append(uids, entry.Get("UID"))
+			}
+			assert.DeepEqual(t, uids, tt.want)
+		})
+	}
+
+	_, err := d.Search(context.Background(), "dc=example,dc=com", "(uid>=a)", nil)
+	assert.Assert(t, err != nil)
+}
+
+func TestMemoryDirectory_Bind(t *testing.T) {
+	d := NewMemoryDirectory()
+	d.Add("uid=alice,ou=people,dc=example,dc=com", "secret", nil)
+	d.Add("uid=bob,ou=people,dc=example,dc=com", "", nil)
+
+	assert.NilError(t, d.Bind(context.Background(), "UID=alice,ou=people,dc=example,dc=com", "secret"))
+	assert.Equal(t, d.Bind(context.Background(), "uid=alice,ou=people,dc=example,dc=com", "wrong"), ErrInvalidCredentials)
+	assert.Equal(t, d.Bind(context.Background(), "uid=bob,ou=people,dc=example,dc=com", ""), ErrInvalidCredentials)
+	assert.Equal(t, d.Bind(context.Background(), "uid=nobody,ou=people,dc=example,dc=com", "secret"), ErrInvalidCredentials)
+}
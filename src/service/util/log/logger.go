@@ -1,6 +1,9 @@
 package log
 
-import "go.uber.org/zap"
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
 
 type Logger struct {
 	logger *zap.Logger
@@ -11,17 +14,29 @@ func NewLogger(logger *zap.Logger) *Logger {
 }
 
 func (l *Logger) Info(msg string, field ...zap.Field) {
-	l.logger.Info(msg, append(field, zap.Any("msg", msg))...)
+	l.logger.Info(msg, append(redactFields(field), zap.Any("msg", msg))...)
 }
 
 func (l *Logger) Error(msg string, field ...zap.Field) {
-	l.logger.Error(msg, append(field, zap.Any("msg", msg))...)
+	l.logger.Error(msg, append(redactFields(field), zap.Any("msg", msg))...)
 }
 
 func (l *Logger) Debug(msg string, field ...zap.Field) {
-	l.logger.Debug(msg, append(field, zap.Any("msg", msg))...)
+	l.logger.Debug(msg, append(redactFields(field), zap.Any("msg", msg))...)
 }
 
 func (l *Logger) Warn(msg string, field ...zap.Field) {
-	l.logger.Warn(msg, append(field, zap.Any("msg", msg))...)
+	l.logger.Warn(msg, append(redactFields(field), zap.Any("msg", msg))...)
+}
+
+// redactFields masks the PII of the values of structured fields, messages are redacted by Sprintf.
+func redactFields(fields []zap.Field) []zap.Field {
+	redacted := make([]zap.Field, len(fields), len(fields)+1)
+	for i, f := range fields {
+		if f.Type == zapcore.ReflectType {
+			f.Interface = Redact(f.Interface)
+		}
+		redacted[i] = f
+	}
+	return redacted
 }
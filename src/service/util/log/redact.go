@@ -0,0 +1,187 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Mask replaces the PII in logs.
+const Mask = "***"
+
+// Sensitive marks a value which isn't in a tagged field as PII, it's masked when formatted or logged.
+type Sensitive struct {
+	Value interface{}
+}
+
+func (s Sensitive) Format(f fmt.State, _ rune) {
+	_, _ = io.WriteString(f, Mask)
+}
+
+func (s Sensitive) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Mask)
+}
+
+// Sprintf formats like fmt.Sprintf with the arguments redacted, for messages that are logged.
+func Sprintf(format string, a ...interface{}) string {
+	redacted := make([]interface{}, len(a))
+	for i, arg := range a {
+		redacted[i] = Redact(arg)
+	}
+	return fmt.Sprintf(format, redacted...)
+}
+
+// Redact returns a copy of v with the fields tagged `pii:"true"` masked, also in nested structs, pointers, slices
+// and maps. Strings are replaced by Mask, other values by their zero value, nil pointers stay nil.
+func Redact(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	value := reflect.ValueOf(v)
+	if !hasPII(value.Type()) {
+		return v
+	}
+	return redactValue(value).Interface()
+}
+
+func isPII(field reflect.StructField) bool {
+	return field.Tag.Get("pii") == "true"
+}
+
+// piiTypes caches whether a type holds a tagged field.
+var piiTypes sync.Map
+
+func hasPII(t reflect.Type) bool {
+	if has, ok := piiTypes.Load(t); ok {
+		return has.(bool)
+	}
+	has := inspectPII(t, map[reflect.Type]bool{})
+	piiTypes.Store(t, has)
+	return has
+}
+
+func inspectPII(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if visiting[t] {
+		return false
+	}
+	visiting[t] = true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return inspectPII(t.Elem(), visiting)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if isPII(t.Field(i)) || inspectPII(t.Field(i).Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func redactValue(v reflect.Value) reflect.Value {
+	if !hasPII(v.Type()) {
+		return v
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		p := reflect.New(v.Type().Elem())
+		p.Elem().Set(redactValue(v.Elem()))
+		return p
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s.Index(i).Set(redactValue(v.Index(i)))
+		}
+		return s
+	case reflect.Array:
+		a := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			a.Index(i).Set(redactValue(v.Index(i)))
+		}
+		return a
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			m.SetMapIndex(key, redactValue(v.MapIndex(key)))
+		}
+		return m
+	case reflect.Struct:
+		s := reflect.New(v.Type()).Elem()
+		s.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			field := s.Field(i)
+			if !field.CanSet() {
+				continue
+			}
+			if isPII(v.Type().Field(i)) {
+				field.Set(mask(field))
+			} else {
+				field.Set(redactValue(field))
+			}
+		}
+		return s
+	}
+	return v
+}
+
+func mask(v reflect.Value) reflect.Value {
+	switch {
+	case v.Kind() == reflect.String:
+		m := reflect.New(v.Type()).Elem()
+		m.SetString(Mask)
+		return m
+	case v.Kind() == reflect.Ptr && v.IsNil():
+		return v
+	case v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.String:
+		p := reflect.New(v.Type().Elem())
+		p.Elem().SetString(Mask)
+		return p
+	}
+	return reflect.Zero(v.Type())
+}
+
+// PIIColumns returns the columns of the fields tagged `pii:"true"` of the models, their gorm column or their snake
+// cased name.
+func PIIColumns(models ...interface{}) []string {
+	var columns []string
+	for _, m := range models {
+		t := reflect.TypeOf(m)
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if field := t.Field(i); isPII(field) {
+				columns = append(columns, columnName(field))
+			}
+		}
+	}
+	return columns
+}
+
+func columnName(field reflect.StructField) string {
+	for _, setting := range strings.Split(field.Tag.Get("gorm"), ";") {
+		if kv := strings.SplitN(setting, ":", 2); len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "column") {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	var b strings.Builder
+	for i, r := range field.Name {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToLower(b.String())
+}
@@ -0,0 +1,90 @@
+package log
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+type contact struct {
+	Email *string `pii:"true"`
+	Kind  string
+}
+
+type person struct {
+	ID       int
+	Name     string `pii:"true"`
+	Age      int    `pii:"true"`
+	Contacts []contact
+	Parent   *person
+	secret   string
+}
+
+func TestRedact(t *testing.T) {
+	email := "a@example.com"
+	p := person{ID: 1, Name: "alice", Age: 30, Contacts: []contact{{Email: &email, Kind: "work"}, {Kind: "home"}},
+		Parent: &person{Name: "bob"}, secret: "s"}
+
+	redacted := Redact(p).(person)
+	assert.Equal(t, redacted.Name, Mask)
+	assert.Equal(t, redacted.Age, 0)
+	assert.Equal(t, *redacted.Contacts[0].Email, Mask)
+	assert.Equal(t, redacted.Contacts[0].Kind, "work")
+	assert.Assert(t, redacted.Contacts[1].Email == nil)
+	assert.Equal(t, redacted.Parent.Name, Mask)
+	assert.Equal(t, redacted.secret, "s")
+
+	// the original is left as it is
+	assert.Equal(t, p.Name, "alice")
+	assert.Equal(t, *p.Contacts[0].Email, email)
+	assert.Equal(t, p.Parent.Name, "bob")
+
+	assert.Equal(t, Redact("alice"), "alice")
+	assert.Equal(t, Sprintf("user %+v: %s, %d", person{ID: 1, Name: "alice"}, Sensitive{Value: "bob"}, 2),
+		"user {ID:1 Name:*** Age:0 Contacts:[] Parent:<nil> secret:}: ***, 2")
+}
+
+func TestLogger_RedactsFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := NewLogger(zap.New(core))
+
+	logger.Info("created", zap.Any("user", person{ID: 1, Name: "alice"}), zap.Any("name", Sensitive{Value: "alice"}))
+
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, fields["user"].(person).Name, Mask)
+	assert.Equal(t, fields["user"].(person).ID, 1)
+}
+
+func TestSQLLogger_FormatSQL(t *testing.T) {
+	l := NewSQLLogger(nil, []string{"name", "email"})
+	email := "a@example.com"
+	at := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		sql  string
+		vars []interface{}
+		want string
+	}{
+		{
+			"INSERT INTO `users` (`name`,`gender`,`email`) VALUES (?,?,?)",
+			[]interface{}{"alice", "FEMALE", &email},
+			"INSERT INTO `users` (`name`,`gender`,`email`) VALUES ('***','FEMALE','***')",
+		},
+		{
+			"UPDATE `users` SET `email` = ?, `email_verified_at` = ?  WHERE (id = ?)",
+			[]interface{}{email, at, 2},
+			"UPDATE `users` SET `email` = '***', `email_verified_at` = '2021-01-02 03:04:05'  WHERE (id = 2)",
+		},
+		{
+			"SELECT * FROM `users`  WHERE (`users`.`name` = ?) AND (id IN (?,?)) AND (email IS NULL OR email <> ?) AND (name LIKE ?)",
+			[]interface{}{"alice", 1, 2, nil, "a%"},
+			"SELECT * FROM `users`  WHERE (`users`.`name` = '***') AND (id IN (1,2)) AND (email IS NULL OR email <> NULL) AND (name LIKE '***')",
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, l.FormatSQL(tt.sql, tt.vars), tt.want)
+	}
+}
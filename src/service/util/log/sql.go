@@ -0,0 +1,142 @@
+package log
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"go.uber.org/zap"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SQLLogger prints the logs of gorm, the SQL of debug mode with the values of PII columns masked.
+type SQLLogger struct {
+	log     *Logger
+	columns map[string]bool
+}
+
+// NewSQLLogger masks the values bound to the columns, see PIIColumns.
+func NewSQLLogger(log *Logger, piiColumns []string) SQLLogger {
+	columns := map[string]bool{}
+	for _, column := range piiColumns {
+		columns[strings.ToLower(column)] = true
+	}
+	return SQLLogger{log: log, columns: columns}
+}
+
+// Print implements the logger of gorm: values are the level, the source, then the duration, SQL, vars and rows of
+// a statement or the message of other levels.
+func (l SQLLogger) Print(values ...interface{}) {
+	if len(values) < 2 {
+		return
+	}
+	source := zap.Any("source", values[1])
+	if values[0] == "sql" && len(values) == 6 {
+		sql, _ := values[3].(string)
+		vars, _ := values[4].([]interface{})
+		duration, _ := values[2].(time.Duration)
+		l.log.Debug(l.FormatSQL(sql, vars), source, zap.Duration("duration", duration), zap.Any("rows", values[5]))
+		return
+	}
+	msg := fmt.Sprint(values[2:]...)
+	if values[0] == "log" {
+		l.log.Error(msg, source)
+		return
+	}
+	l.log.Debug(msg, source)
+}
+
+// FormatSQL replaces the placeholders of the SQL by the vars, the vars bound to PII columns are masked.
+func (l SQLLogger) FormatSQL(sql string, vars []interface{}) string {
+	columns := placeholderColumns(sql)
+	var b strings.Builder
+	i := 0
+	for _, r := range sql {
+		if r != '?' || i >= len(vars) {
+			b.WriteRune(r)
+			continue
+		}
+		if vars[i] != nil && l.columns[columns[i]] {
+			b.WriteString("'" + Mask + "'")
+		} else {
+			b.WriteString(formatVar(vars[i]))
+		}
+		i++
+	}
+	return b.String()
+}
+
+var (
+	insertColumns      = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+\S+\s*\(([^)]*)\)\s*VALUES`)
+	trailingOperator   = regexp.MustCompile(`(?i)(=|<>|!=|<=|>=|<|>|\bNOT\s+LIKE|\bLIKE|\bNOT\s+IN|\bIN)\s*$`)
+	trailingIdentifier = regexp.MustCompile("[\\w.`]+$")
+)
+
+// placeholderColumns returns the column each placeholder is compared to or assigned to, empty when unknown.
+func placeholderColumns(sql string) []string {
+	var columns []string
+	var inserted []string
+	valuesAt := -1
+	if m := insertColumns.FindStringSubmatchIndex(sql); m != nil {
+		for _, column := range strings.Split(sql[m[2]:m[3]], ",") {
+			inserted = append(inserted, unquoteColumn(column))
+		}
+		valuesAt = m[1]
+	}
+
+	for i, r := range sql {
+		if r != '?' {
+			continue
+		}
+		if valuesAt >= 0 && i >= valuesAt && len(inserted) > 0 {
+			// the values of the rows follow the columns, row after row
+			columns = append(columns, inserted[len(columns)%len(inserted)])
+			continue
+		}
+		columns = append(columns, columnBefore(sql[:i]))
+	}
+	return columns
+}
+
+// columnBefore finds the column of `column = ?` or `column IN (?, ?`.
+func columnBefore(sql string) string {
+	sql = strings.TrimRight(sql, " ?,(")
+	loc := trailingOperator.FindStringIndex(sql)
+	if loc == nil {
+		return ""
+	}
+	return unquoteColumn(trailingIdentifier.FindString(strings.TrimRight(sql[:loc[0]], " ")))
+}
+
+func unquoteColumn(column string) string {
+	column = strings.TrimSpace(column)
+	if i := strings.LastIndex(column, "."); i >= 0 {
+		column = column[i+1:]
+	}
+	return strings.ToLower(strings.Trim(column, "`"))
+}
+
+func formatVar(v interface{}) string {
+	value := reflect.ValueOf(v)
+	if v == nil || (value.Kind() == reflect.Ptr && value.IsNil()) {
+		return "NULL"
+	}
+	switch x := v.(type) {
+	case time.Time:
+		return "'" + x.Format("2006-01-02 15:04:05") + "'"
+	case []byte:
+		return "'<binary>'"
+	case driver.Valuer:
+		if dv, err := x.Value(); err == nil {
+			return formatVar(dv)
+		}
+	}
+	if value.Kind() == reflect.Ptr {
+		return formatVar(value.Elem().Interface())
+	}
+	if value.Kind() == reflect.String {
+		return "'" + value.String() + "'"
+	}
+	return fmt.Sprint(v)
+}
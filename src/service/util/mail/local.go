@@ -0,0 +1,71 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+	"user-service/src/service/util/log"
+)
+
+// LogMailer writes messages to the log, for local development without a mail server.
+type LogMailer struct {
+	log *log.Logger
+}
+
+func NewLogMailer(logger *log.Logger) *LogMailer {
+	return &LogMailer{log: logger}
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	m.log.Info(fmt.Sprintf("mail to %s, subject: %s\n%s", msg.To, msg.Subject, msg.Body))
+	return nil
+}
+
+// FileMailer writes each message to a .eml file that mail clients can open.
+type FileMailer struct {
+	dir   string
+	from  string
+	count uint64
+}
+
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(_ context.Context, msg Message) error {
+	name := fmt.Sprintf("%s-%d.eml", time.Now().Format("20060102T150405"), atomic.AddUint64(&m.count, 1))
+	return ioutil.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0600)
+}
+
+// format builds a plain text RFC 5322 message.
+func format(from string, msg Message) []byte {
+	var b bytes.Buffer
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(msg.Body, "\n", "\r\n", -1))
+	return b.Bytes()
+}
+
+// envelopeAddress strips the display name of "Name <address>".
+func envelopeAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return addr.Address
+	}
+	return from
+}
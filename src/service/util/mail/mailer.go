@@ -0,0 +1,45 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/viper"
+	"user-service/src/service/util/log"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to users, implementations are chosen with mail.driver.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+const (
+	DriverSMTP = "smtp"
+	DriverFile = "file"
+	DriverLog  = "log"
+)
+
+func NewMailerFromConfig(logger *log.Logger) (Mailer, error) {
+	from := viper.GetString("mail.from")
+	switch driver := viper.GetString("mail.driver"); driver {
+	case DriverSMTP:
+		return NewSMTPMailer(SMTPConfig{
+			Host:     viper.GetString("mail.smtp.host"),
+			Port:     viper.GetInt("mail.smtp.port"),
+			Username: viper.GetString("mail.smtp.username"),
+			Password: viper.GetString("mail.smtp.password"),
+			From:     from,
+		}), nil
+	case DriverFile:
+		return NewFileMailer(viper.GetString("mail.file.dir"), from)
+	case DriverLog, "":
+		return NewLogMailer(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %s", driver)
+	}
+}
@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"strconv"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+func NewSMTPMailer(config SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{config: config}
+}
+
+// Send uses STARTTLS when the server offers it, credentials are only sent over TLS or to localhost by net/smtp.
+func (m *SMTPMailer) Send(_ context.Context, msg Message) error {
+	var auth smtp.Auth
+	if len(m.config.Username) > 0 {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+	addr := m.config.Host + ":" + strconv.Itoa(m.config.Port)
+	if err := smtp.SendMail(addr, auth, envelopeAddress(m.config.From), []string{msg.To}, format(m.config.From, msg)); err != nil {
+		return fmt.Errorf("can't send mail to %s: %v", msg.To, err)
+	}
+	return nil
+}
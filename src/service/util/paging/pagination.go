@@ -6,9 +6,11 @@ import (
 )
 
 type Param struct {
-	DB      *gorm.DB
-	Page    int
-	Limit   int
+	DB    *gorm.DB
+	Page  int
+	Limit int
+	// Offset is used instead of Page when set, Page is then the page the offset falls in
+	Offset  int
 	OrderBy []string
 	ShowSQL bool
 }
@@ -48,7 +50,10 @@ func Paging(p *Param, result interface{}) (*Paginator, error) {
 
 	go countRecords(db, result, &count, dbDone)
 
-	if p.Page == 1 {
+	if p.Offset > 0 {
+		offset = p.Offset
+		p.Page = offset/p.Limit + 1
+	} else if p.Page == 1 {
 		offset = 0
 	} else {
 		offset = (p.Page - 1) * p.Limit
@@ -0,0 +1,68 @@
+package password
+
+import (
+	"gotest.tools/assert"
+	"strings"
+	"testing"
+)
+
+func TestHasher(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmBcrypt, AlgorithmArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			h, err := NewHasher(algorithm)
+			assert.NilError(t, err)
+
+			hash, err := h.Hash("Secret123")
+			assert.NilError(t, err)
+			assert.Assert(t, !strings.Contains(hash, "Secret123"))
+			assert.Assert(t, !h.NeedsRehash(hash))
+
+			ok, err := h.Verify(hash, "Secret123")
+			assert.NilError(t, err)
+			assert.Assert(t, ok)
+
+			ok, err = h.Verify(hash, "secret123")
+			assert.NilError(t, err)
+			assert.Assert(t, !ok)
+		})
+	}
+}
+
+func TestHasher_VerifyOtherAlgorithm(t *testing.T) {
+	bcryptHasher, _ := NewHasher(AlgorithmBcrypt)
+	argonHasher, _ := NewHasher(AlgorithmArgon2id)
+
+	hash, err := bcryptHasher.Hash("Secret123")
+	assert.NilError(t, err)
+
+	ok, err := argonHasher.Verify(hash, "Secret123")
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+	assert.Assert(t, argonHasher.NeedsRehash(hash))
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	policy := Policy{MinLength: 8, MaxLength: 16, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+	tests := []struct {
+		name     string
+		password string
+		wantErr  string
+	}{
+		{name: "valid", password: "Lý-Quang-2020"},
+		{name: "too short", password: "Ab1!", wantErr: "password must contain at least 8 characters"},
+		{name: "too long", password: "Abcdefgh1!abcdefgh", wantErr: "password must contain at most 16 bytes"},
+		{name: "too long in bytes", password: "Ýýýýýýýý1!", wantErr: "password must contain at most 16 bytes"},
+		{name: "missing classes", password: "abcdefgh", wantErr: "password must contain an uppercase letter, a digit, a symbol"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.password)
+			if tt.wantErr == "" {
+				assert.NilError(t, err)
+			} else {
+				assert.Error(t, err, tt.wantErr)
+			}
+		})
+	}
+}
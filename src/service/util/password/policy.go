@@ -0,0 +1,72 @@
+package password
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Policy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+func PolicyFromConfig() Policy {
+	return Policy{
+		MinLength:     viper.GetInt("password.policy.min_length"),
+		MaxLength:     viper.GetInt("password.policy.max_length"),
+		RequireUpper:  viper.GetBool("password.policy.require_upper"),
+		RequireLower:  viper.GetBool("password.policy.require_lower"),
+		RequireDigit:  viper.GetBool("password.policy.require_digit"),
+		RequireSymbol: viper.GetBool("password.policy.require_symbol"),
+	}
+}
+
+// Validate returns an error describing every rule the password violates.
+func (p Policy) Validate(password string) error {
+	var violations []string
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("at least %d characters", p.MinLength))
+	}
+	// the maximum is in bytes, bcrypt ignores what follows the first 72
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("at most %d bytes", p.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, "an uppercase letter")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "a lowercase letter")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "a symbol")
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("password must contain %s", strings.Join(violations, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package pii
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"user-service/src/service/util/encryption"
+)
+
+// Columns are the columns of users that can be encrypted.
+var Columns = []string{"name", "email", "phone"}
+
+const envelopePrefix = "enc:v"
+
+// Codec seals the values of the encrypted columns in envelopes: every value is encrypted with its own data key,
+// the data key is encrypted with the current key of the keyring. A sealed value reads
+//
+//	enc:v<key version>:<base64 sealed data key>:<base64 sealed value>
+//
+// so it can be told from a value written before its column was encrypted.
+type Codec struct {
+	keyring *encryption.Keyring
+	columns map[string]bool
+}
+
+func NewCodec(keyring *encryption.Keyring, columns []string) (*Codec, error) {
+	c := &Codec{keyring: keyring, columns: map[string]bool{}}
+	for _, column := range columns {
+		if !isColumn(column) {
+			return nil, fmt.Errorf("column %s can't be encrypted, only %v", column, Columns)
+		}
+		c.columns[column] = true
+	}
+	return c, nil
+}
+
+// Encrypts tells if the values of the column are sealed, a nil codec encrypts nothing.
+func (c *Codec) Encrypts(column string) bool {
+	return c != nil && c.columns[column]
+}
+
+// KeyVersion is the version of the key sealing new values.
+func (c *Codec) KeyVersion() int {
+	version, _ := c.keyring.Current()
+	return version
+}
+
+func (c *Codec) Seal(column, value string) (string, error) {
+	version, kek := c.keyring.Current()
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	box, err := encryption.NewAESGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedKey, err := kek.Seal(dataKey, []byte(column))
+	if err != nil {
+		return "", err
+	}
+	sealed, err := box.Seal([]byte(value), []byte(column))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d:%s:%s", envelopePrefix, version, sealedKey, sealed), nil
+}
+
+// Open returns the value of an envelope, values that aren't sealed are returned as they are.
+func (c *Codec) Open(column, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, envelopePrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.Errorf("invalid envelope in %s", column)
+	}
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return "", errors.Errorf("invalid key version in %s", column)
+	}
+	kek, err := c.keyring.Key(version)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := kek.Open(parts[1], []byte(column))
+	if err != nil {
+		return "", errors.Wrapf(err, "can't open the data key of %s", column)
+	}
+	box, err := encryption.NewAESGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := box.Open(parts[2], []byte(column))
+	if err != nil {
+		return "", errors.Wrapf(err, "can't open %s", column)
+	}
+	return string(plaintext), nil
+}
+
+// Index is the blind index of a value: a keyed hash finding the exact value without revealing it. Values are
+// lower cased as the columns compare case insensitively.
+func (c *Codec) Index(column, value string) string {
+	key := hmac.New(sha256.New, c.keyring.IndexKey())
+	key.Write([]byte(column))
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write([]byte(strings.ToLower(value)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, envelopePrefix)
+}
+
+// IndexColumn is the column of the blind indexes of a column.
+func IndexColumn(column string) string {
+	return column + "_bidx"
+}
+
+func isColumn(column string) bool {
+	for _, c := range Columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package pii
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"reflect"
+)
+
+const (
+	table             = "users"
+	keyVersionColumn  = "key_version"
+	settingCodec      = "pii:codec"
+	instancePlaintext = "pii:plaintext"
+	instanceIndexes   = "pii:indexes"
+)
+
+// Register makes the encryption of users transparent: created and updated values of the encrypted columns are
+// sealed along with their blind indexes, queried users are opened. It returns the db to use, filters on the
+// encrypted columns need Where or Match.
+func Register(db *gorm.DB, codec *Codec) *gorm.DB {
+	db.Callback().Create().Before("gorm:create").Register("pii:seal_create", codec.sealCreate)
+	db.Callback().Create().After("gorm:create").Register("pii:index_create", codec.indexCreate)
+	db.Callback().Update().Before("gorm:update").Register("pii:seal_update", codec.sealUpdate)
+	db.Callback().Query().After("gorm:query").Register("pii:open", codec.openQuery)
+	return db.Set(settingCodec, codec)
+}
+
+// FromDB returns the codec registered on the db, nil if there is none.
+func FromDB(db *gorm.DB) *Codec {
+	if codec, ok := db.Get(settingCodec); ok {
+		return codec.(*Codec)
+	}
+	return nil
+}
+
+// Match returns the column and the value finding a value of a column of users: its blind index when the column
+// is encrypted.
+func Match(db *gorm.DB, column string, value string) (string, interface{}) {
+	codec := FromDB(db)
+	if !codec.Encrypts(column) {
+		return column, value
+	}
+	return IndexColumn(column), codec.Index(column, value)
+}
+
+// Where filters users on the exact value of a column.
+func Where(db *gorm.DB, column string, value string) *gorm.DB {
+	column, match := Match(db, column, value)
+	return db.Where(column+" = ?", match)
+}
+
+// sealCreate seals the fields of a created user, the plaintext is put back by indexCreate once inserted.
+func (c *Codec) sealCreate(scope *gorm.Scope) {
+	if scope.HasError() || scope.TableName() != table {
+		return
+	}
+	plaintext := map[string]string{}
+	indexes := map[string]interface{}{keyVersionColumn: c.KeyVersion()}
+	for column := range c.columns {
+		field, ok := scope.FieldByName(column)
+		if !ok {
+			continue
+		}
+		value, ok := fieldString(field.Field)
+		if !ok {
+			continue
+		}
+		sealed, err := c.Seal(column, value)
+		if err != nil {
+			scope.Err(err)
+			return
+		}
+		setFieldString(field.Field, sealed)
+		plaintext[column] = value
+		indexes[IndexColumn(column)] = c.Index(column, value)
+	}
+	scope.InstanceSet(instancePlaintext, plaintext)
+	scope.InstanceSet(instanceIndexes, indexes)
+}
+
+// indexCreate writes the blind indexes and the key version of a created user, in the transaction of the insert.
+func (c *Codec) indexCreate(scope *gorm.Scope) {
+	plaintext, ok := scope.InstanceGet(instancePlaintext)
+	if !ok {
+		return
+	}
+	for column, value := range plaintext.(map[string]string) {
+		if field, ok := scope.FieldByName(column); ok {
+			setFieldString(field.Field, value)
+		}
+	}
+	if scope.HasError() {
+		return
+	}
+	indexes, _ := scope.InstanceGet(instanceIndexes)
+	scope.Err(scope.NewDB().Table(table).
+		Where(fmt.Sprintf("%s = ?", scope.Quote(scope.PrimaryKey())), scope.PrimaryKeyValue()).
+		UpdateColumns(indexes).Error)
+}
+
+// sealUpdate seals the updated values of the encrypted columns and updates their blind indexes. The key version
+// is only raised when every encrypted column is written, it is the oldest key sealing a column of the user.
+func (c *Codec) sealUpdate(scope *gorm.Scope) {
+	attrs, ok := scope.InstanceGet("gorm:update_attrs")
+	if !ok || scope.HasError() || scope.TableName() != table {
+		return
+	}
+	updates := attrs.(map[string]interface{})
+	sealedAll := true
+	for column := range c.columns {
+		value, ok := updates[column]
+		if !ok {
+			sealedAll = false
+			continue
+		}
+		s, isNull, ok := valueString(value)
+		if !ok {
+			scope.Err(fmt.Errorf("can't encrypt %s from %T", column, value))
+			return
+		}
+		if isNull {
+			updates[IndexColumn(column)] = nil
+			continue
+		}
+		sealed, err := c.Seal(column, s)
+		if err != nil {
+			scope.Err(err)
+			return
+		}
+		updates[column] = sealed
+		updates[IndexColumn(column)] = c.Index(column, s)
+	}
+	if sealedAll {
+		updates[keyVersionColumn] = c.KeyVersion()
+	}
+}
+
+// openQuery opens the sealed columns of queried users, also the columns no longer encrypted.
+func (c *Codec) openQuery(scope *gorm.Scope) {
+	if scope.HasError() || scope.TableName() != table {
+		return
+	}
+	value := scope.IndirectValue()
+	if value.Kind() != reflect.Slice {
+		c.openFields(scope, scope)
+		return
+	}
+	for i := 0; i < value.Len(); i++ {
+		elem := value.Index(i)
+		if elem.Kind() != reflect.Ptr {
+			elem = elem.Addr()
+		}
+		c.openFields(scope, scope.New(elem.Interface()))
+	}
+}
+
+func (c *Codec) openFields(scope *gorm.Scope, user *gorm.Scope) {
+	for _, column := range Columns {
+		field, ok := user.FieldByName(column)
+		if !ok {
+			continue
+		}
+		value, ok := fieldString(field.Field)
+		if !ok || !IsSealed(value) {
+			continue
+		}
+		plaintext, err := c.Open(column, value)
+		if err != nil {
+			scope.Err(err)
+			return
+		}
+		setFieldString(field.Field, plaintext)
+	}
+}
+
+// fieldString reads a string or a string pointer field, false for a nil pointer.
+func fieldString(field reflect.Value) (string, bool) {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return "", false
+		}
+		field = field.Elem()
+	}
+	if field.Kind() != reflect.String {
+		return "", false
+	}
+	return field.String(), true
+}
+
+func setFieldString(field reflect.Value, value string) {
+	if field.Kind() == reflect.Ptr {
+		p := reflect.New(field.Type().Elem())
+		p.Elem().SetString(value)
+		field.Set(p)
+		return
+	}
+	field.SetString(value)
+}
+
+// valueString reads an updated value, ok is false for values that aren't strings.
+func valueString(value interface{}) (s string, isNull bool, ok bool) {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return "", true, true
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", true, true
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.String {
+		return "", false, false
+	}
+	return v.String(), false, true
+}
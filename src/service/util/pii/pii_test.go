@@ -0,0 +1,148 @@
+package pii
+
+import (
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	"gotest.tools/assert"
+	"regexp"
+	"strings"
+	"testing"
+	"user-service/src/service/util/encryption"
+)
+
+type user struct {
+	ID    int
+	Name  string
+	Email *string
+}
+
+func key(b byte) []byte {
+	return []byte(strings.Repeat(string(b), 32))
+}
+
+func newCodec(t *testing.T, current int) *Codec {
+	keyring, err := encryption.NewKeyring(current, map[int][]byte{1: key('1'), 2: key('2')}, key('i'))
+	assert.NilError(t, err)
+	codec, err := NewCodec(keyring, []string{"name", "email"})
+	assert.NilError(t, err)
+	return codec
+}
+
+// sealedArg matches a value sealed by the codec and keeps it.
+type sealedArg struct {
+	codec  *Codec
+	column string
+	want   string
+	value  string
+}
+
+func (a *sealedArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	if !ok {
+		return false
+	}
+	plaintext, err := a.codec.Open(a.column, s)
+	a.value = s
+	return err == nil && IsSealed(s) && plaintext == a.want
+}
+
+func TestCodec(t *testing.T) {
+	old, codec := newCodec(t, 1), newCodec(t, 2)
+
+	sealed, err := old.Seal("name", "alice")
+	assert.NilError(t, err)
+	assert.Assert(t, strings.HasPrefix(sealed, "enc:v1:"))
+	other, _ := old.Seal("name", "alice")
+	assert.Assert(t, sealed != other)
+
+	plaintext, err := codec.Open("name", sealed)
+	assert.NilError(t, err)
+	assert.Equal(t, plaintext, "alice")
+
+	_, err = codec.Open("email", sealed)
+	assert.ErrorContains(t, err, "can't open the data key of email")
+
+	plaintext, err = codec.Open("name", "bob")
+	assert.NilError(t, err)
+	assert.Equal(t, plaintext, "bob")
+
+	assert.Equal(t, codec.Index("name", "Alice"), old.Index("name", "alice"))
+	assert.Assert(t, codec.Index("name", "alice") != codec.Index("email", "alice"))
+	assert.Assert(t, codec.Encrypts("email") && !codec.Encrypts("phone"))
+	assert.Assert(t, !(*Codec)(nil).Encrypts("name"))
+
+	_, err = NewCodec(nil, []string{"gender"})
+	assert.ErrorContains(t, err, "gender can't be encrypted")
+}
+
+func TestRegister(t *testing.T) {
+	sqlDB, mock, _ := sqlmock.New()
+	db, _ := gorm.Open("mysql", sqlDB)
+	codec := newCodec(t, 2)
+	db = Register(db, codec)
+
+	email := "alice@example.com"
+	name := &sealedArg{codec: codec, column: "name", want: "alice"}
+	sealedEmail := &sealedArg{codec: codec, column: "email", want: email}
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `users` (`name`,`email`) VALUES (?,?)")).
+		WithArgs(name, sealedEmail).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `users` SET `email_bidx` = ?, `key_version` = ?, `name_bidx` = ?  WHERE (`id` = ?)")).
+		WithArgs(codec.Index("email", email), 2, codec.Index("name", "alice"), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	created := user{Name: "alice", Email: &email}
+	assert.NilError(t, db.Create(&created).Error)
+	assert.Equal(t, created.Name, "alice")
+	assert.Equal(t, *created.Email, email)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `users`  WHERE (name_bidx = ?)")).
+		WithArgs(codec.Index("name", "ALICE")).
+		WillReturnRows(mock.NewRows([]string{"id", "name", "email"}).
+			AddRow(1, name.value, sealedEmail.value).
+			AddRow(2, "bob", nil))
+	var users []user
+	assert.NilError(t, Where(db, "name", "ALICE").Find(&users).Error)
+	assert.DeepEqual(t, users, []user{{ID: 1, Name: "alice", Email: &email}, {ID: 2, Name: "bob"}})
+
+	name.want = "alicia"
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `users` SET `name` = ?, `name_bidx` = ?  WHERE (id = ?)")).
+		WithArgs(name, codec.Index("name", "alicia"), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NilError(t, db.Model(&user{}).Where("id = ?", 1).Updates(map[string]interface{}{"name": "alicia"}).Error)
+
+	assert.NilError(t, mock.ExpectationsWereMet())
+}
+
+func TestReencrypt(t *testing.T) {
+	sqlDB, mock, _ := sqlmock.New()
+	db, _ := gorm.Open("mysql", sqlDB)
+	old, codec := newCodec(t, 1), newCodec(t, 2)
+	db = Register(db, codec)
+	sealedName, _ := old.Seal("name", "alice")
+	sealedPhone, _ := old.Seal("phone", "+84912345678")
+
+	name := &sealedArg{codec: codec, column: "name", want: "alice"}
+	email := &sealedArg{codec: codec, column: "email", want: "alice@example.com"}
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, email, phone FROM `users`  WHERE (key_version IS NULL OR key_version <> ? OR "+
+		"(name IS NOT NULL AND name NOT LIKE ?) OR (email IS NOT NULL AND email NOT LIKE ?) OR phone LIKE ?) ORDER BY `id` LIMIT 10 FOR UPDATE")).
+		WithArgs(2, "enc:v%", "enc:v%", "enc:v%").
+		WillReturnRows(mock.NewRows([]string{"id", "name", "email", "phone"}).
+			AddRow(1, sealedName, "alice@example.com", sealedPhone))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `users` SET `email` = ?, `email_bidx` = ?, `key_version` = ?, `name` = ?, `name_bidx` = ?, `phone` = ?, `phone_bidx` = ?  WHERE (id = ?)")).
+		WithArgs(email, codec.Index("email", "alice@example.com"), 2, name, codec.Index("name", "alice"), "+84912345678", nil, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	sealed, err := Reencrypt(db, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, sealed, 1)
+	assert.NilError(t, mock.ExpectationsWereMet())
+}
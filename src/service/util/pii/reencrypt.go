@@ -0,0 +1,94 @@
+package pii
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// Reencrypt seals up to batch users with the current key: the users sealed with an older key and the users with
+// values of encrypted columns in plaintext, the columns no longer encrypted are opened. It returns how many users
+// it sealed, 0 once every user is sealed with the current key.
+func Reencrypt(db *gorm.DB, batch int) (int, error) {
+	codec := FromDB(db)
+	if codec == nil {
+		return 0, errors.New("the users aren't encrypted")
+	}
+
+	conditions := []string{fmt.Sprintf("%s IS NULL", keyVersionColumn), fmt.Sprintf("%s <> ?", keyVersionColumn)}
+	args := []interface{}{codec.KeyVersion()}
+	for _, column := range Columns {
+		if codec.Encrypts(column) {
+			conditions = append(conditions, fmt.Sprintf("(%s IS NOT NULL AND %s NOT LIKE ?)", column, column))
+			args = append(args, envelopePrefix+"%")
+		} else {
+			conditions = append(conditions, fmt.Sprintf("%s LIKE ?", column))
+			args = append(args, envelopePrefix+"%")
+		}
+	}
+
+	sealed := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		users, err := lockUsers(tx, strings.Join(conditions, " OR "), args, batch)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			updates := map[string]interface{}{}
+			for i, column := range Columns {
+				if !codec.Encrypts(column) {
+					updates[IndexColumn(column)] = nil
+				}
+				if !user.values[i].Valid {
+					updates[column] = nil
+					continue
+				}
+				if updates[column], err = codec.Open(column, user.values[i].String); err != nil {
+					return errors.Wrapf(err, "user %d", user.id)
+				}
+			}
+			if err := tx.Table(table).Where("id = ?", user.id).Updates(updates).Error; err != nil {
+				return errors.Wrapf(err, "user %d", user.id)
+			}
+			sealed++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sealed, nil
+}
+
+type sealedUser struct {
+	id     int64
+	values []sql.NullString
+}
+
+// lockUsers reads the encrypted columns of the users matching the condition, the rows are locked until the
+// transaction ends so that no update is lost.
+func lockUsers(tx *gorm.DB, condition string, args []interface{}, limit int) ([]sealedUser, error) {
+	rows, err := tx.Table(table).Select(append([]string{"id"}, Columns...)).
+		Where(condition, args...).Order("id").Limit(limit).
+		Set("gorm:query_option", "FOR UPDATE").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []sealedUser
+	for rows.Next() {
+		user := sealedUser{values: make([]sql.NullString, len(Columns))}
+		dest := []interface{}{&user.id}
+		for i := range user.values {
+			dest = append(dest, &user.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,323 @@
+// Package scim parses the filters and attribute paths of SCIM 2.0, see RFC 7644 section 3.4.2.2 and 3.5.2.
+package scim
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	OpEqual          = "eq"
+	OpNotEqual       = "ne"
+	OpContains       = "co"
+	OpStartsWith     = "sw"
+	OpEndsWith       = "ew"
+	OpGreater        = "gt"
+	OpLess           = "lt"
+	OpGreaterOrEqual = "ge"
+	OpLessOrEqual    = "le"
+	OpPresent        = "pr"
+
+	OpAnd = "and"
+	OpOr  = "or"
+)
+
+var ErrInvalidFilter = errors.New("invalid scim filter")
+
+var compareOps = map[string]bool{
+	OpEqual: true, OpNotEqual: true, OpContains: true, OpStartsWith: true, OpEndsWith: true,
+	OpGreater: true, OpLess: true, OpGreaterOrEqual: true, OpLessOrEqual: true,
+}
+
+// Filter is one of AttrExpr, LogExpr, NotExpr or ValuePath.
+type Filter interface {
+	filter()
+}
+
+// AttrExpr compares an attribute, Value is a string, a float64, a bool or nil. It is unset for OpPresent.
+type AttrExpr struct {
+	Path  string
+	Op    string
+	Value interface{}
+}
+
+type LogExpr struct {
+	Op    string
+	Left  Filter
+	Right Filter
+}
+
+type NotExpr struct {
+	Filter Filter
+}
+
+// ValuePath filters the values of a multi-valued attribute, e.g. emails[type eq "work"].
+type ValuePath struct {
+	Path   string
+	Filter Filter
+}
+
+func (AttrExpr) filter()  {}
+func (LogExpr) filter()   {}
+func (NotExpr) filter()   {}
+func (ValuePath) filter() {}
+
+// Path is the target of a PATCH operation, e.g. emails[type eq "work"].value
+type Path struct {
+	Attribute    string
+	Filter       Filter
+	SubAttribute string
+}
+
+func ParseFilter(s string) (Filter, error) {
+	p, err := newParser(s)
+	if err != nil {
+		return nil, err
+	}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, errors.Wrapf(ErrInvalidFilter, "unexpected %q", p.peek().text)
+	}
+	return f, nil
+}
+
+func ParsePath(s string) (*Path, error) {
+	p, err := newParser(s)
+	if err != nil {
+		return nil, err
+	}
+	if p.done() || p.peek().kind != tokenWord {
+		return nil, errors.Wrapf(ErrInvalidFilter, "invalid path %q", s)
+	}
+
+	path := &Path{Attribute: p.next().text}
+	if !p.done() && p.peek().kind == '[' {
+		p.next()
+		if path.Filter, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(']'); err != nil {
+			return nil, err
+		}
+		if !p.done() {
+			// the sub attribute follows the closing bracket as a word starting with a dot
+			sub := p.next()
+			if sub.kind != tokenWord || !strings.HasPrefix(sub.text, ".") || len(sub.text) < 2 {
+				return nil, errors.Wrapf(ErrInvalidFilter, "invalid path %q", s)
+			}
+			path.SubAttribute = sub.text[1:]
+		}
+	}
+	if !p.done() {
+		return nil, errors.Wrapf(ErrInvalidFilter, "invalid path %q", s)
+	}
+	return path, nil
+}
+
+const (
+	tokenWord   = 'w'
+	tokenString = 's'
+)
+
+type token struct {
+	kind byte
+	text string
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func newParser(s string) (*parser, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			tokens = append(tokens, token{kind: c, text: string(c)})
+			i++
+		case c == '"':
+			end := i + 1
+			for ; end < len(s) && s[end] != '"'; end++ {
+				if s[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(s) {
+				return nil, errors.Wrap(ErrInvalidFilter, "unterminated string")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(s[i:end+1]), &value); err != nil {
+				return nil, errors.Wrapf(ErrInvalidFilter, "invalid string %s", s[i:end+1])
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value})
+			i = end + 1
+		default:
+			end := i
+			for ; end < len(s) && !strings.ContainsRune(" \t()[]\"", rune(s[end])); end++ {
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: s[i:end]})
+			i = end
+		}
+	}
+	return &parser{tokens: tokens}, nil
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(kind byte) error {
+	if p.done() || p.peek().kind != kind {
+		return errors.Wrapf(ErrInvalidFilter, "%q is expected", string(kind))
+	}
+	p.next()
+	return nil
+}
+
+// isKeyword compares case-insensitively, like attribute names and operators are.
+func (p *parser) isKeyword(keyword string) bool {
+	return !p.done() && p.peek().kind == tokenWord && strings.EqualFold(p.peek().text, keyword)
+}
+
+// parseOr gives "and" a higher precedence than "or".
+func (p *parser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(OpOr) {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = LogExpr{Op: OpOr, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(OpAnd) {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = LogExpr{Op: OpAnd, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Filter, error) {
+	if p.done() {
+		return nil, errors.Wrap(ErrInvalidFilter, "unexpected end of filter")
+	}
+
+	if p.isKeyword("not") {
+		p.next()
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return NotExpr{Filter: f}, nil
+	}
+
+	if p.peek().kind == '(' {
+		p.next()
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	attr := p.next()
+	if attr.kind != tokenWord {
+		return nil, errors.Wrapf(ErrInvalidFilter, "attribute is expected instead of %q", attr.text)
+	}
+	if !p.done() && p.peek().kind == '[' {
+		p.next()
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(']'); err != nil {
+			return nil, err
+		}
+		return ValuePath{Path: attr.text, Filter: f}, nil
+	}
+
+	if p.done() || p.peek().kind != tokenWord {
+		return nil, errors.Wrapf(ErrInvalidFilter, "operator is expected after %s", attr.text)
+	}
+	op := strings.ToLower(p.next().text)
+	if op == OpPresent {
+		return AttrExpr{Path: attr.text, Op: op}, nil
+	}
+	if !compareOps[op] {
+		return nil, errors.Wrapf(ErrInvalidFilter, "unknown operator %s", op)
+	}
+
+	if p.done() {
+		return nil, errors.Wrapf(ErrInvalidFilter, "value is expected after %s %s", attr.text, op)
+	}
+	value, err := parseValue(p.next())
+	if err != nil {
+		return nil, err
+	}
+	return AttrExpr{Path: attr.text, Op: op, Value: value}, nil
+}
+
+func parseValue(t token) (interface{}, error) {
+	if t.kind == tokenString {
+		return t.text, nil
+	}
+	if t.kind != tokenWord {
+		return nil, errors.Wrapf(ErrInvalidFilter, "value is expected instead of %q", t.text)
+	}
+	switch strings.ToLower(t.text) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	n, err := strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidFilter, "invalid value %s", t.text)
+	}
+	return n, nil
+}
@@ -0,0 +1,87 @@
+package scim
+
+import (
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter   string
+		expected Filter
+	}{
+		{
+			filter:   `userName eq "bjensen"`,
+			expected: AttrExpr{Path: "userName", Op: OpEqual, Value: "bjensen"},
+		},
+		{
+			filter:   `title pr`,
+			expected: AttrExpr{Path: "title", Op: OpPresent},
+		},
+		{
+			filter: `userName Eq "a \"quoted\" name" or active eq true and meta.lastModified gt 1`,
+			expected: LogExpr{
+				Op:   OpOr,
+				Left: AttrExpr{Path: "userName", Op: OpEqual, Value: `a "quoted" name`},
+				Right: LogExpr{
+					Op:    OpAnd,
+					Left:  AttrExpr{Path: "active", Op: OpEqual, Value: true},
+					Right: AttrExpr{Path: "meta.lastModified", Op: OpGreater, Value: float64(1)},
+				},
+			},
+		},
+		{
+			filter: `not (emails co "@example.com") and (userName sw "a" or userName sw "b")`,
+			expected: LogExpr{
+				Op:   OpAnd,
+				Left: NotExpr{Filter: AttrExpr{Path: "emails", Op: OpContains, Value: "@example.com"}},
+				Right: LogExpr{
+					Op:    OpOr,
+					Left:  AttrExpr{Path: "userName", Op: OpStartsWith, Value: "a"},
+					Right: AttrExpr{Path: "userName", Op: OpStartsWith, Value: "b"},
+				},
+			},
+		},
+		{
+			filter: `emails[type eq "work" and value ew "@example.com"]`,
+			expected: ValuePath{Path: "emails", Filter: LogExpr{
+				Op:    OpAnd,
+				Left:  AttrExpr{Path: "type", Op: OpEqual, Value: "work"},
+				Right: AttrExpr{Path: "value", Op: OpEndsWith, Value: "@example.com"},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := ParseFilter(tt.filter)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, f, tt.expected)
+		})
+	}
+}
+
+func TestParseFilter_Invalid(t *testing.T) {
+	for _, filter := range []string{``, `userName`, `userName eq`, `userName is "a"`, `userName eq "a`, `(userName eq "a"`,
+		`userName eq "a" and`, `userName eq "a" "b"`, `emails[value eq "a"`} {
+		_, err := ParseFilter(filter)
+		assert.ErrorContains(t, err, ErrInvalidFilter.Error(), filter)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	path, err := ParsePath(`emails[type eq "work"].value`)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, path, &Path{
+		Attribute:    "emails",
+		Filter:       AttrExpr{Path: "type", Op: OpEqual, Value: "work"},
+		SubAttribute: "value",
+	})
+
+	path, err = ParsePath(`active`)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, path, &Path{Attribute: "active"})
+
+	_, err = ParsePath(`emails[type eq "work"]value`)
+	assert.ErrorContains(t, err, ErrInvalidFilter.Error())
+}
@@ -0,0 +1,60 @@
+package sqlerr
+
+import (
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"regexp"
+	"strings"
+)
+
+// mysqlDuplicateEntry is ER_DUP_ENTRY, a unique key is violated.
+const mysqlDuplicateEntry = 1062
+
+// uniqueMessages find the violated key in the messages of the drivers of gorm which have no error type to check,
+// or whose types aren't imported: PostgreSQL, SQLite and SQL Server.
+var uniqueMessages = []*regexp.Regexp{
+	regexp.MustCompile(`duplicate key value violates unique constraint "([^"]+)"`),
+	regexp.MustCompile(`UNIQUE constraint failed: ([\w.]+)`),
+	regexp.MustCompile(`Violation of UNIQUE KEY constraint '([^']+)'`),
+	regexp.MustCompile(`Cannot insert duplicate key row in object '[^']+' with unique index '([^']+)'`),
+}
+
+var mysqlDuplicateKey = regexp.MustCompile(`for key '([^']+)'$`)
+
+// UniqueViolation tells if err is the violation of a unique key and returns the name of the key as the database
+// gives it, e.g. name, users.name, users_name_key or name_bidx. The name is empty when it can't be found.
+func UniqueViolation(err error) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		if mysqlErr.Number != mysqlDuplicateEntry {
+			return "", false
+		}
+		if m := mysqlDuplicateKey.FindStringSubmatch(mysqlErr.Message); m != nil {
+			return m[1], true
+		}
+		return "", true
+	}
+	for _, re := range uniqueMessages {
+		if m := re.FindStringSubmatch(err.Error()); m != nil {
+			return m[1], true
+		}
+	}
+	return "", false
+}
+
+// KeyColumn returns the column among columns a key is named after: the key name is split on dots and underscores,
+// users_email_key and name_bidx are keys of email and name.
+func KeyColumn(key string, columns ...string) (string, bool) {
+	parts := strings.FieldsFunc(strings.ToLower(key), func(r rune) bool { return r == '.' || r == '_' })
+	for _, column := range columns {
+		for _, part := range parts {
+			if part == column {
+				return column, true
+			}
+		}
+	}
+	return "", false
+}
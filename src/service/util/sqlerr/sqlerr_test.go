@@ -0,0 +1,36 @@
+package sqlerr
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"gotest.tools/assert"
+	"testing"
+)
+
+func TestUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		key    string
+		column string
+		ok     bool
+	}{
+		{"mysql 5.7", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'ql' for key 'name'"}, "name", "name", true},
+		{"mysql 8 blind index", fmt.Errorf("create: %w", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'ab' for key 'users.email_bidx'"}), "users.email_bidx", "email", true},
+		{"mysql other error", &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"}, "", "", false},
+		{"postgres", errors.New(`pq: duplicate key value violates unique constraint "users_name_key"`), "users_name_key", "name", true},
+		{"sqlite", errors.New("UNIQUE constraint failed: users.email"), "users.email", "email", true},
+		{"sql server", errors.New("mssql: Violation of UNIQUE KEY constraint 'UQ_users_name'. Cannot insert duplicate key in object 'dbo.users'."), "UQ_users_name", "name", true},
+		{"other", errors.New("connection refused"), "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, ok := UniqueViolation(tt.err)
+			assert.Equal(t, ok, tt.ok)
+			assert.Equal(t, key, tt.key)
+			column, _ := KeyColumn(key, "name", "email")
+			assert.Equal(t, column, tt.column)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"time"
+)
+
+type Type string
+
+const (
+	TypeAccess  Type = "access"
+	TypeRefresh Type = "refresh"
+	// TypeMFA proves the password was verified, it is exchanged for access and refresh tokens with a second factor.
+	TypeMFA Type = "mfa"
+)
+
+// ScopeMFAEnroll restricts a token to enrolling a second factor.
+const ScopeMFAEnroll = "mfa_enroll"
+
+// ScopeImpersonation marks access tokens issued to an admin acting as the subject, Actor is the admin.
+const ScopeImpersonation = "impersonation"
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// placeholderSecret was shipped as token.secret by earlier configs, tokens signed with it can be forged by anyone.
+const placeholderSecret = "change-me-to-a-random-secret-of-32-chars-or-more"
+
+type Claims struct {
+	jwt.StandardClaims
+	Type  Type   `json:"typ"`
+	Role  string `json:"role,omitempty"`
+	Scope string `json:"scope,omitempty"`
+	// Session is the id of the login session access and refresh tokens belong to, revoking it rejects them.
+	Session string `json:"sid,omitempty"`
+	// Actor is the party acting on behalf of the subject, see RFC 8693 section 4.1.
+	Actor *Actor `json:"act,omitempty"`
+}
+
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+type Issuer struct {
+	issuer     string
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	mfaTTL     time.Duration
+	now        func() time.Time
+}
+
+func NewIssuer(issuer string, secret []byte, accessTTL time.Duration, refreshTTL time.Duration, mfaTTL time.Duration) *Issuer {
+	return &Issuer{
+		issuer:     issuer,
+		secret:     secret,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		mfaTTL:     mfaTTL,
+		now:        time.Now,
+	}
+}
+
+func IssuerFromConfig() (*Issuer, error) {
+	secret := viper.GetString("token.secret")
+	if len(secret) == 0 || secret == placeholderSecret {
+		return nil, errors.New("token.secret must be set to a random secret")
+	}
+	if len(secret) < 32 {
+		return nil, errors.New("token.secret must have at least 32 characters")
+	}
+	return NewIssuer(viper.GetString("token.issuer"), []byte(secret),
+		viper.GetDuration("token.access_ttl"), viper.GetDuration("token.refresh_ttl"), viper.GetDuration("token.mfa_ttl")), nil
+}
+
+func (i *Issuer) TTL(typ Type) time.Duration {
+	switch typ {
+	case TypeRefresh:
+		return i.refreshTTL
+	case TypeMFA:
+		return i.mfaTTL
+	}
+	return i.accessTTL
+}
+
+// Issue signs the claims, the caller sets Subject, Type and the custom claims, the standard claims are filled here.
+// An ExpiresAt set by the caller is kept when it is earlier than the TTL of the type.
+func (i *Issuer) Issue(claims Claims) (string, *Claims, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", nil, err
+	}
+
+	now := i.now()
+	claims.Id = hex.EncodeToString(jti)
+	claims.Issuer = i.issuer
+	claims.IssuedAt = now.Unix()
+	if expiresAt := now.Add(i.TTL(claims.Type)).Unix(); claims.ExpiresAt == 0 || claims.ExpiresAt > expiresAt {
+		claims.ExpiresAt = expiresAt
+	}
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims).SignedString(i.secret)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, &claims, nil
+}
+
+func (i *Issuer) Parse(tokenString string, typ Type) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return i.secret, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+	if claims.Type != typ || claims.Issuer != i.issuer {
+		return nil, errors.Wrap(ErrInvalidToken, "unexpected token type or issuer")
+	}
+	return claims, nil
+}
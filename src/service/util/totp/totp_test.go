@@ -0,0 +1,46 @@
+package totp
+
+import (
+	"encoding/base32"
+	"gotest.tools/assert"
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	// SHA1 test vectors from RFC 6238 appendix B, truncated to 6 digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(secret, Step(time.Unix(tt.unix, 0)))
+		assert.NilError(t, err)
+		assert.Equal(t, got, tt.want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NilError(t, err)
+	now := time.Unix(1600000000, 0)
+	previous, _ := Code(secret, Step(now)-1)
+	old, _ := Code(secret, Step(now)-3)
+
+	step, ok := Validate(secret, previous, now, 1)
+	assert.Assert(t, ok)
+	assert.Equal(t, step, Step(now)-1)
+
+	_, ok = Validate(secret, old, now, 1)
+	assert.Assert(t, !ok)
+
+	_, ok = Validate(secret, "12345", now, 1)
+	assert.Assert(t, !ok)
+}
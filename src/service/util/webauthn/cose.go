@@ -0,0 +1,113 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+	"math/big"
+)
+
+// COSE algorithms supported for credentials, in order of preference.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters, see RFC 8152 section 7 and 13
+const (
+	coseKty = 1
+	coseAlg = 3
+	// -1 is the curve for EC2 and OKP keys, the modulus for RSA keys
+	coseCrvOrN = -1
+	coseXOrE   = -2
+	coseY      = -3
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+type PublicKey struct {
+	Algorithm int
+	Key       crypto.PublicKey
+}
+
+// ParsePublicKey decodes a COSE_Key of one of the SupportedAlgorithms.
+func ParsePublicKey(b []byte) (*PublicKey, error) {
+	var params map[int]interface{}
+	if err := cbor.Unmarshal(b, &params); err != nil {
+		return nil, errors.Wrap(ErrInvalidData, "public key is not a cose key")
+	}
+	kty, _ := coseInt(params[coseKty])
+	alg, _ := coseInt(params[coseAlg])
+
+	switch {
+	case alg == AlgES256 && kty == ktyEC2:
+		crv, _ := coseInt(params[coseCrvOrN])
+		x, okX := params[coseXOrE].([]byte)
+		y, okY := params[coseY].([]byte)
+		if crv != crvP256 || !okX || !okY {
+			return nil, errors.Wrap(ErrInvalidData, "invalid ES256 key")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.Wrap(ErrInvalidData, "ES256 key is not on the curve")
+		}
+		return &PublicKey{Algorithm: alg, Key: key}, nil
+	case alg == AlgEdDSA && kty == ktyOKP:
+		crv, _ := coseInt(params[coseCrvOrN])
+		x, ok := params[coseXOrE].([]byte)
+		if crv != crvEd25519 || !ok || len(x) != ed25519.PublicKeySize {
+			return nil, errors.Wrap(ErrInvalidData, "invalid EdDSA key")
+		}
+		return &PublicKey{Algorithm: alg, Key: ed25519.PublicKey(x)}, nil
+	case alg == AlgRS256 && kty == ktyRSA:
+		n, okN := params[coseCrvOrN].([]byte)
+		e, okE := params[coseXOrE].([]byte)
+		if !okN || !okE || len(e) > 4 {
+			return nil, errors.Wrap(ErrInvalidData, "invalid RS256 key")
+		}
+		return &PublicKey{Algorithm: alg, Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	}
+	return nil, errors.Wrapf(ErrInvalidData, "unsupported key type %d with algorithm %d", kty, alg)
+}
+
+func (k PublicKey) Verify(data []byte, signature []byte) bool {
+	switch key := k.Key.(type) {
+	case *ecdsa.PublicKey:
+		var sig struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(signature, &sig); err != nil || len(rest) > 0 {
+			return false
+		}
+		digest := sha256.Sum256(data)
+		return ecdsa.Verify(key, digest[:], sig.R, sig.S)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+func coseInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int64:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,152 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/pkg/errors"
+)
+
+const (
+	CeremonyCreate = "webauthn.create"
+	CeremonyGet    = "webauthn.get"
+)
+
+// authenticator data flags, see https://www.w3.org/TR/webauthn-2/#flags
+const (
+	FlagUserPresent            byte = 0x01
+	FlagUserVerified           byte = 0x04
+	FlagAttestedCredentialData byte = 0x40
+	FlagExtensionData          byte = 0x80
+)
+
+var ErrInvalidData = errors.New("invalid webauthn data")
+
+// Encoding is used for every binary value exchanged with the browser.
+var Encoding = base64.RawURLEncoding
+
+type Config struct {
+	RPID    string
+	RPName  string
+	Origins []string
+}
+
+type CollectedClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// VerifyClientData checks the ceremony type, the challenge and the origin signed by the authenticator.
+func (c Config) VerifyClientData(raw []byte, ceremony string, challenge string) error {
+	var clientData CollectedClientData
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return errors.Wrap(ErrInvalidData, "client data is not json")
+	}
+	if clientData.Type != ceremony {
+		return errors.Wrapf(ErrInvalidData, "unexpected client data type %s", clientData.Type)
+	}
+	if clientData.Challenge != challenge {
+		return errors.Wrap(ErrInvalidData, "challenge mismatch")
+	}
+	for _, origin := range c.Origins {
+		if origin == clientData.Origin {
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrInvalidData, "origin %s is not allowed", clientData.Origin)
+}
+
+type AuthenticatorData struct {
+	RPIDHash  []byte
+	Flags     byte
+	SignCount uint32
+	// set when FlagAttestedCredentialData is, i.e. at registration
+	AAGUID       []byte
+	CredentialID []byte
+	// PublicKey is the COSE encoded credential public key
+	PublicKey []byte
+}
+
+func (d AuthenticatorData) Has(flag byte) bool {
+	return d.Flags&flag == flag
+}
+
+// ParseAuthenticatorData reads the binary layout described in https://www.w3.org/TR/webauthn-2/#sctn-authenticator-data
+func ParseAuthenticatorData(b []byte) (*AuthenticatorData, error) {
+	if len(b) < 37 {
+		return nil, errors.Wrap(ErrInvalidData, "authenticator data is too short")
+	}
+	data := &AuthenticatorData{
+		RPIDHash:  b[:32],
+		Flags:     b[32],
+		SignCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if !data.Has(FlagAttestedCredentialData) {
+		return data, nil
+	}
+
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, errors.Wrap(ErrInvalidData, "attested credential data is too short")
+	}
+	data.AAGUID = rest[:16]
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, errors.Wrap(ErrInvalidData, "credential id is truncated")
+	}
+	data.CredentialID = rest[:idLen]
+
+	// the public key is followed by the extensions, the decoder tells where it ends
+	var publicKey cbor.RawMessage
+	if err := cbor.NewDecoder(bytes.NewReader(rest[idLen:])).Decode(&publicKey); err != nil {
+		return nil, errors.Wrap(ErrInvalidData, "credential public key is not cbor")
+	}
+	data.PublicKey = publicKey
+	return data, nil
+}
+
+// VerifyAuthenticatorData checks the relying party and that the user was present and verified.
+func (c Config) VerifyAuthenticatorData(data *AuthenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(data.RPIDHash, rpIDHash[:]) {
+		return errors.Wrap(ErrInvalidData, "rp id mismatch")
+	}
+	if !data.Has(FlagUserPresent) || !data.Has(FlagUserVerified) {
+		return errors.Wrap(ErrInvalidData, "user must be present and verified")
+	}
+	return nil
+}
+
+type attestationObject struct {
+	Format   string          `cbor:"fmt"`
+	AttStmt  cbor.RawMessage `cbor:"attStmt"`
+	AuthData []byte          `cbor:"authData"`
+}
+
+// ParseAttestationObject returns the authenticator data of a registration. The attestation statement
+// isn't verified: "none" attestation is requested, the authenticator model is not trusted for anything.
+func ParseAttestationObject(b []byte) (*AuthenticatorData, error) {
+	var obj attestationObject
+	if err := cbor.Unmarshal(b, &obj); err != nil {
+		return nil, errors.Wrap(ErrInvalidData, "attestation object is not cbor")
+	}
+	data, err := ParseAuthenticatorData(obj.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if !data.Has(FlagAttestedCredentialData) {
+		return nil, errors.Wrap(ErrInvalidData, "attestation has no credential")
+	}
+	return data, nil
+}
+
+// SignedData is what the authenticator signs in an assertion.
+func SignedData(authenticatorData []byte, clientDataJSON []byte) []byte {
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	return append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+}
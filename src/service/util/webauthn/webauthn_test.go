@@ -0,0 +1,145 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/fxamacker/cbor/v2"
+	"gotest.tools/assert"
+	"math/big"
+	"testing"
+)
+
+var testConfig = Config{RPID: "example.com", RPName: "Example", Origins: []string{"https://example.com"}}
+
+// authenticator builds what a browser would send for an ES256 passkey.
+type authenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+}
+
+func newAuthenticator(t *testing.T) authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	return authenticator{key: key, credentialID: []byte("credential-1")}
+}
+
+func (a authenticator) coseKey(t *testing.T) []byte {
+	b, err := cbor.Marshal(map[int]interface{}{
+		coseKty:    ktyEC2,
+		coseAlg:    AlgES256,
+		coseCrvOrN: crvP256,
+		coseXOrE:   pad32(a.key.X.Bytes()),
+		coseY:      pad32(a.key.Y.Bytes()),
+	})
+	assert.NilError(t, err)
+	return b
+}
+
+func (a authenticator) authData(t *testing.T, rpID string, flags byte, signCount uint32, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	b := append([]byte{}, rpIDHash[:]...)
+	if attested {
+		flags |= FlagAttestedCredentialData
+	}
+	b = append(b, flags)
+	b = append(b, make([]byte, 4)...)
+	binary.BigEndian.PutUint32(b[33:], signCount)
+	if attested {
+		b = append(b, make([]byte, 16)...)
+		b = append(b, byte(len(a.credentialID)>>8), byte(len(a.credentialID)))
+		b = append(b, a.credentialID...)
+		b = append(b, a.coseKey(t)...)
+	}
+	return b
+}
+
+func pad32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+func clientData(t *testing.T, typ string, challenge string, origin string) []byte {
+	b, err := json.Marshal(CollectedClientData{Type: typ, Challenge: challenge, Origin: origin})
+	assert.NilError(t, err)
+	return b
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	a := newAuthenticator(t)
+
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(t, "example.com", FlagUserPresent|FlagUserVerified, 0, true),
+	})
+	assert.NilError(t, err)
+	registered, err := ParseAttestationObject(attestation)
+	assert.NilError(t, err)
+	assert.NilError(t, testConfig.VerifyAuthenticatorData(registered))
+	assert.DeepEqual(t, registered.CredentialID, a.credentialID)
+
+	publicKey, err := ParsePublicKey(registered.PublicKey)
+	assert.NilError(t, err)
+	assert.Equal(t, publicKey.Algorithm, AlgES256)
+
+	authData := a.authData(t, "example.com", FlagUserPresent|FlagUserVerified, 1, false)
+	clientDataJSON := clientData(t, CeremonyGet, "challenge", "https://example.com")
+	digest := sha256.Sum256(SignedData(authData, clientDataJSON))
+	r, sig, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	assert.NilError(t, err)
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, sig})
+	assert.NilError(t, err)
+
+	assert.NilError(t, testConfig.VerifyClientData(clientDataJSON, CeremonyGet, "challenge"))
+	assert.Assert(t, publicKey.Verify(SignedData(authData, clientDataJSON), signature))
+	// any change of the signed data breaks the signature
+	assert.Assert(t, !publicKey.Verify(SignedData(authData, clientData(t, CeremonyGet, "other", "https://example.com")), signature))
+}
+
+func TestConfig_Verify(t *testing.T) {
+	a := newAuthenticator(t)
+
+	tests := []struct {
+		name       string
+		clientData []byte
+		authData   []byte
+	}{
+		{
+			name:       "wrong ceremony",
+			clientData: clientData(t, CeremonyCreate, "challenge", "https://example.com"),
+		},
+		{
+			name:       "wrong challenge",
+			clientData: clientData(t, CeremonyGet, "other", "https://example.com"),
+		},
+		{
+			name:       "wrong origin",
+			clientData: clientData(t, CeremonyGet, "challenge", "https://evil.com"),
+		},
+		{
+			name:     "wrong rp id",
+			authData: a.authData(t, "evil.com", FlagUserPresent|FlagUserVerified, 0, false),
+		},
+		{
+			name:     "user not verified",
+			authData: a.authData(t, "example.com", FlagUserPresent, 0, false),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.clientData != nil {
+				assert.Assert(t, testConfig.VerifyClientData(tt.clientData, CeremonyGet, "challenge") != nil)
+			}
+			if tt.authData != nil {
+				data, err := ParseAuthenticatorData(tt.authData)
+				assert.NilError(t, err)
+				assert.Assert(t, testConfig.VerifyAuthenticatorData(data) != nil)
+			}
+		})
+	}
+}